@@ -0,0 +1,222 @@
+// Package auth authenticates the callers of the service with the bearer JWT of their calls.
+// Tokens are signed with HMAC secrets or RSA keys, from the ENV, PEM files, or a JWKS file.
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math/big"
+	"strings"
+)
+
+// Key verifies the signature of tokens, either an HMAC secret or an RSA public key
+type Key struct {
+	ID     string
+	Secret []byte
+	Public *rsa.PublicKey
+}
+
+// AdminRole is the role of the callers allowed to act on every Document
+const AdminRole = "admin"
+
+// Caller is the user authenticated by the token of a call
+type Caller struct {
+	UUID   string
+	Roles  []string
+	Groups []string
+	// CertificateSubject of the verified client certificate, empty if the call was not made over mutual TLS
+	CertificateSubject string
+}
+
+// HasRole returns true if the caller has the role.
+func (c *Caller) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// claims of a token, the subject is the uuid of the caller
+type claims struct {
+	jwt.RegisteredClaims
+	Roles  []string `json:"roles,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Authenticator verifies tokens with its keys
+type Authenticator struct {
+	keys    []Key
+	options []jwt.ParserOption
+}
+
+// New returns an Authenticator verifying tokens with the keys,
+// and their issuer and audience if not empty.
+// Returns consts.ErrNoVerifyingKeys, or consts.ErrInvalidVerifyingKey if a key has no secret nor public key.
+func New(keys []Key, issuer string, audience string) (*Authenticator, error) {
+	if len(keys) == 0 {
+		return nil, consts.ErrNoVerifyingKeys
+	}
+	for _, k := range keys {
+		if (len(k.Secret) == 0) == (k.Public == nil) {
+			return nil, consts.ErrInvalidVerifyingKey
+		}
+	}
+	options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		options = append(options, jwt.WithAudience(audience))
+	}
+	return &Authenticator{keys: keys, options: options}, nil
+}
+
+// Authenticate verifies the signature, expiry, issuer, and audience of the token.
+// A token naming its key with "kid" is only verified with that key, otherwise with every key.
+// Returns the caller of the token, or consts.ErrInvalidToken.
+func (a *Authenticator) Authenticate(token string) (*Caller, error) {
+	for _, k := range a.keys {
+		c := &claims{}
+		parsed, err := jwt.ParseWithClaims(token, c, func(t *jwt.Token) (interface{}, error) {
+			if kid, ok := t.Header["kid"].(string); ok && kid != k.ID {
+				return nil, consts.ErrVerifyingKeyUnknown
+			}
+			return k.verifying(t.Method)
+		}, a.options...)
+		if err != nil || !parsed.Valid {
+			continue
+		}
+		// The parser leaves the subject optional
+		if c.Subject == "" {
+			return nil, consts.ErrInvalidToken
+		}
+		return &Caller{UUID: c.Subject, Roles: c.Roles, Groups: c.Groups}, nil
+	}
+	return nil, consts.ErrInvalidToken
+}
+
+// verifying returns the key material for the signing method of a token.
+// An HMAC secret only verifies HS tokens and an RSA key only RS tokens,
+// so a public key is never used as an HMAC secret.
+// Returns consts.ErrInvalidToken for any other method.
+func (k Key) verifying(method jwt.SigningMethod) (interface{}, error) {
+	switch method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(k.Secret) > 0 {
+			return k.Secret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if k.Public != nil {
+			return k.Public, nil
+		}
+	}
+	return nil, consts.ErrInvalidToken
+}
+
+// LoadKeys reads the HMAC secrets as "id:secret" entries, the RSA keys as "id:path" entries
+// to PEM public keys, and the keys of the JWKS file if not empty.
+// Returns the keys, or the first error reading them.
+func LoadKeys(secrets []string, rsaKeys []string, jwksFile string) ([]Key, error) {
+	keys := make([]Key, 0, len(secrets)+len(rsaKeys))
+	for _, entry := range secrets {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, consts.ErrInvalidVerifyingKey
+		}
+		keys = append(keys, Key{ID: parts[0], Secret: []byte(parts[1])})
+	}
+	for _, entry := range rsaKeys {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, consts.ErrInvalidVerifyingKey
+		}
+		data, err := ioutil.ReadFile(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, Key{ID: parts[0], Public: public})
+	}
+	if jwksFile != "" {
+		data, err := ioutil.ReadFile(jwksFile)
+		if err != nil {
+			return nil, err
+		}
+		set, err := ParseJWKS(data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, set...)
+	}
+	return keys, nil
+}
+
+// jwk is a key of a JWKS, only the fields of RSA and symmetric keys
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS reads the RSA ("kty":"RSA") and HMAC ("kty":"oct") keys of a JSON Web Key Set.
+// Keys of other types, or not meant for signatures, are skipped.
+// Returns consts.ErrInvalidVerifyingKey if a key can not be decoded.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make([]Key, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+				return nil, consts.ErrInvalidVerifyingKey
+			}
+			public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			keys = append(keys, Key{ID: k.Kid, Public: public})
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return nil, consts.ErrInvalidVerifyingKey
+			}
+			keys = append(keys, Key{ID: k.Kid, Secret: secret})
+		}
+	}
+	return keys, nil
+}
+
+// callerKey is the context key of the Caller
+type callerKey struct{}
+
+// NewContext returns a copy of ctx carrying the caller.
+func NewContext(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// FromContext returns the caller authenticated for the call of ctx.
+// Returns false for a call exempt from authentication.
+func FromContext(ctx context.Context) (*Caller, bool) {
+	caller, ok := ctx.Value(callerKey{}).(*Caller)
+	return caller, ok && caller != nil
+}
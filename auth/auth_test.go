@@ -0,0 +1,232 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const validUUID = "0000xsnjg0mqjhbf4qx1efd6y3"
+
+// signToken returns a token of the claims signed with the key, naming kid if not empty.
+func signToken(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, c jwt.Claims) string {
+	token := jwt.NewWithClaims(method, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return signed
+}
+
+// validClaims returns claims of a user expiring in an hour.
+func validClaims() *claims {
+	return &claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   validUUID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			Issuer:    "hwsc-user-svc",
+			Audience:  jwt.ClaimStrings{"hwsc-document-svc"},
+		},
+		Roles:  []string{"user"},
+		Groups: []string{"lab-a"},
+	}
+}
+
+func TestNew(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		keys     []Key
+		expected error
+	}{
+		{[]Key{{ID: "k1", Secret: []byte("secret1")}}, nil},
+		{[]Key{{ID: "k1", Public: &private.PublicKey}}, nil},
+		{nil, consts.ErrNoVerifyingKeys},
+		{[]Key{{ID: "k1"}}, consts.ErrInvalidVerifyingKey},
+		{[]Key{{ID: "k1", Secret: []byte("secret1"), Public: &private.PublicKey}}, consts.ErrInvalidVerifyingKey},
+	}
+
+	for _, c := range cases {
+		_, err := New(c.keys, "", "")
+		assert.Equal(t, c.expected, err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustMarshalPublic(t, &private.PublicKey)})
+
+	a, err := New([]Key{
+		{ID: "k1", Secret: []byte("secret1")},
+		{ID: "k2", Public: &private.PublicKey},
+	}, "hwsc-user-svc", "hwsc-document-svc")
+	assert.Nil(t, err)
+
+	expired := validClaims()
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	noExpiry := validClaims()
+	noExpiry.ExpiresAt = nil
+	noSubject := validClaims()
+	noSubject.Subject = ""
+	otherIssuer := validClaims()
+	otherIssuer.Issuer = "someone"
+	otherAudience := validClaims()
+	otherAudience.Audience = jwt.ClaimStrings{"hwsc-file-transaction-svc"}
+
+	cases := []struct {
+		desc     string
+		token    string
+		expected error
+	}{
+		{"HMAC token", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", validClaims()), nil},
+		{"HMAC token naming its key", signToken(t, jwt.SigningMethodHS512, []byte("secret1"), "k1", validClaims()), nil},
+		{"RSA token", signToken(t, jwt.SigningMethodRS256, private, "k2", validClaims()), nil},
+		{"RSA token without kid", signToken(t, jwt.SigningMethodRS384, private, "", validClaims()), nil},
+		{"token naming another key", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "k2", validClaims()),
+			consts.ErrInvalidToken},
+		{"unknown secret", signToken(t, jwt.SigningMethodHS256, []byte("secret2"), "", validClaims()),
+			consts.ErrInvalidToken},
+		{"unknown RSA key", signToken(t, jwt.SigningMethodRS256, other, "", validClaims()), consts.ErrInvalidToken},
+		{"public key used as an HMAC secret", signToken(t, jwt.SigningMethodHS256, publicPEM, "k2", validClaims()),
+			consts.ErrInvalidToken},
+		{"unsigned token", signToken(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()),
+			consts.ErrInvalidToken},
+		{"expired token", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", expired), consts.ErrInvalidToken},
+		{"token without expiry", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", noExpiry),
+			consts.ErrInvalidToken},
+		{"token without subject", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", noSubject),
+			consts.ErrInvalidToken},
+		{"token of another issuer", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", otherIssuer),
+			consts.ErrInvalidToken},
+		{"token for another audience", signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", otherAudience),
+			consts.ErrInvalidToken},
+		{"malformed token", "a.b.c", consts.ErrInvalidToken},
+	}
+
+	for _, c := range cases {
+		caller, err := a.Authenticate(c.token)
+		assert.Equal(t, c.expected, err, c.desc)
+		if c.expected == nil {
+			assert.Equal(t, &Caller{UUID: validUUID, Roles: []string{"user"}, Groups: []string{"lab-a"}}, caller, c.desc)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	pemFile := filepath.Join(dir, "public.pem")
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: mustMarshalPublic(t, &private.PublicKey)})
+	assert.Nil(t, ioutil.WriteFile(pemFile, publicPEM, 0600))
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"j1","use":"sig","n":"%s","e":"%s"},
+		{"kty":"oct","kid":"j2","k":"%s"},
+		{"kty":"RSA","kid":"j3","use":"enc","n":"%s","e":"%s"},
+		{"kty":"EC","kid":"j4"}
+	]}`,
+		base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+		base64.RawURLEncoding.EncodeToString([]byte("secret2")),
+		base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()))
+	assert.Nil(t, ioutil.WriteFile(jwksFile, []byte(jwks), 0600))
+
+	keys, err := LoadKeys([]string{"k1:secret:1"}, []string{"k2:" + pemFile}, jwksFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []Key{
+		{ID: "k1", Secret: []byte("secret:1")},
+		{ID: "k2", Public: &private.PublicKey},
+		{ID: "j1", Public: &private.PublicKey},
+		{ID: "j2", Secret: []byte("secret2")},
+	}, keys)
+
+	cases := []struct {
+		secrets  []string
+		rsaKeys  []string
+		jwksFile string
+		isErr    bool
+	}{
+		{nil, nil, "", false},
+		{[]string{"secret1"}, nil, "", true},
+		{[]string{"k1:"}, nil, "", true},
+		{nil, []string{pemFile}, "", true},
+		{nil, []string{"k2:" + filepath.Join(dir, "missing.pem")}, "", true},
+		{nil, []string{"k2:" + jwksFile}, "", true},
+		{nil, nil, pemFile, true},
+	}
+
+	for _, c := range cases {
+		_, err := LoadKeys(c.secrets, c.rsaKeys, c.jwksFile)
+		assert.Equal(t, c.isErr, err != nil, c)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	cases := []struct {
+		data     string
+		expected error
+	}{
+		{`{"keys":[]}`, nil},
+		{`{"keys":[{"kty":"oct","kid":"k1","k":"!"}]}`, consts.ErrInvalidVerifyingKey},
+		{`{"keys":[{"kty":"oct","kid":"k1"}]}`, consts.ErrInvalidVerifyingKey},
+		{`{"keys":[{"kty":"RSA","kid":"k1","n":"AQAB"}]}`, consts.ErrInvalidVerifyingKey},
+	}
+
+	for _, c := range cases {
+		_, err := ParseJWKS([]byte(c.data))
+		assert.Equal(t, c.expected, err, c.data)
+	}
+}
+
+func TestContext(t *testing.T) {
+	_, ok := FromContext(context.Background())
+	assert.False(t, ok)
+
+	caller := &Caller{UUID: validUUID, Roles: []string{"admin"}}
+	actual, ok := FromContext(NewContext(context.Background(), caller))
+	assert.True(t, ok)
+	assert.Equal(t, caller, actual)
+
+	_, ok = FromContext(NewContext(context.Background(), nil))
+	assert.False(t, ok)
+}
+
+// mustMarshalPublic returns the PKIX encoding of the public key.
+func mustMarshalPublic(t *testing.T, public *rsa.PublicKey) []byte {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	assert.Nil(t, err)
+	return der
+}
+
+func TestHasRole(t *testing.T) {
+	caller := &Caller{UUID: validUUID, Roles: []string{"user", AdminRole}}
+	assert.True(t, caller.HasRole(AdminRole))
+	assert.True(t, caller.HasRole("user"))
+	assert.False(t, caller.HasRole("editor"))
+	assert.False(t, (&Caller{UUID: validUUID}).HasRole(AdminRole))
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/certs"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const (
+	// AuthorizationHeader is the metadata key of the bearer token
+	AuthorizationHeader = "authorization"
+	bearerPrefix        = "bearer "
+)
+
+// UnaryServerInterceptor authenticates unary calls, except the exempt full method names.
+// Returns the interceptor.
+func (a *Authenticator) UnaryServerInterceptor(exempt ...string) grpc.UnaryServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		if exempted[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		authenticated, err := a.authenticateContext(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(authenticated, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming calls, except the exempt full method names.
+// Returns the interceptor.
+func (a *Authenticator) StreamServerInterceptor(exempt ...string) grpc.StreamServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		if exempted[info.FullMethod] {
+			return handler(srv, stream)
+		}
+		authenticated, err := a.authenticateContext(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: authenticated})
+	}
+}
+
+// authenticatedStream is a stream whose context carries the caller
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context carrying the caller.
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateContext authenticates the bearer token in the metadata of the call,
+// and the client certificate of a call over mutual TLS.
+// Returns the context carrying the caller, or a codes.Unauthenticated error.
+func (a *Authenticator) authenticateContext(ctx context.Context, method string) (context.Context, error) {
+	token, err := bearerToken(ctx)
+	if err != nil {
+		log.Info(consts.AuthTag, method, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	caller, err := a.Authenticate(token)
+	if err != nil {
+		log.Info(consts.AuthTag, method, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	caller.CertificateSubject, _ = certs.PeerSubject(ctx)
+	return NewContext(ctx, caller), nil
+}
+
+// bearerToken reads the token of the "authorization: Bearer <token>" metadata.
+// Returns consts.ErrMissingToken if there is none.
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", consts.ErrMissingToken
+	}
+	for _, value := range md.Get(AuthorizationHeader) {
+		if len(value) > len(bearerPrefix) && strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+			if token := strings.TrimSpace(value[len(bearerPrefix):]); token != "" {
+				return token, nil
+			}
+		}
+	}
+	return "", consts.ErrMissingToken
+}
+
+// exemptMethods returns the set of the full method names.
+func exemptMethods(methods []string) map[string]bool {
+	exempted := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		exempted[m] = true
+	}
+	return exempted
+}
@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+const (
+	statusMethod = "/document.DocumentService/GetStatus"
+	deleteMethod = "/document.DocumentService/DeleteDocument"
+)
+
+// fakeStream is a server stream of the context
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+// incoming returns a context of a call with the authorization metadata, none if empty.
+func incoming(authorization string) context.Context {
+	if authorization == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationHeader, authorization))
+}
+
+func TestServerInterceptors(t *testing.T) {
+	a, err := New([]Key{{ID: "k1", Secret: []byte("secret1")}}, "", "")
+	assert.Nil(t, err)
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", validClaims())
+
+	cases := []struct {
+		desc          string
+		method        string
+		authorization string
+		expCode       codes.Code
+		expCaller     bool
+	}{
+		{"bearer token", deleteMethod, "Bearer " + token, codes.OK, true},
+		{"lower case scheme", deleteMethod, "bearer " + token, codes.OK, true},
+		{"no metadata", deleteMethod, "", codes.Unauthenticated, false},
+		{"no bearer token", deleteMethod, "Basic dXNlcjpwYXNz", codes.Unauthenticated, false},
+		{"empty bearer token", deleteMethod, "Bearer ", codes.Unauthenticated, false},
+		{"invalid token", deleteMethod, "Bearer " + token + "x", codes.Unauthenticated, false},
+		{"exempt method without token", statusMethod, "", codes.OK, false},
+		{"exempt method with token", statusMethod, "Bearer " + token, codes.OK, false},
+	}
+
+	unary := a.UnaryServerInterceptor(statusMethod)
+	stream := a.StreamServerInterceptor(statusMethod)
+	for _, c := range cases {
+		var unaryCaller, streamCaller bool
+		_, err := unary(incoming(c.authorization), nil, &grpc.UnaryServerInfo{FullMethod: c.method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				_, unaryCaller = FromContext(ctx)
+				return nil, nil
+			})
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		assert.Equal(t, c.expCaller, unaryCaller, c.desc)
+
+		err = stream(nil, &fakeStream{ctx: incoming(c.authorization)}, &grpc.StreamServerInfo{FullMethod: c.method},
+			func(srv interface{}, s grpc.ServerStream) error {
+				_, streamCaller = FromContext(s.Context())
+				return nil
+			})
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		assert.Equal(t, c.expCaller, streamCaller, c.desc)
+	}
+}
+
+func TestCertificateSubject(t *testing.T) {
+	a, err := New([]Key{{ID: "k1", Secret: []byte("secret1")}}, "", "")
+	assert.Nil(t, err)
+	token := signToken(t, jwt.SigningMethodHS256, []byte("secret1"), "", validClaims())
+	client := &x509.Certificate{Subject: pkix.Name{CommonName: "hwsc-app-gateway-svc"}}
+
+	cases := []struct {
+		desc     string
+		peer     *peer.Peer
+		expected string
+	}{
+		{"mutual TLS", &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{client}},
+		}}}, "CN=hwsc-app-gateway-svc"},
+		{"server TLS", &peer.Peer{AuthInfo: credentials.TLSInfo{}}, ""},
+		{"plaintext", &peer.Peer{}, ""},
+	}
+
+	unary := a.UnaryServerInterceptor()
+	for _, c := range cases {
+		var subject string
+		_, err := unary(peer.NewContext(incoming("Bearer "+token), c.peer), nil,
+			&grpc.UnaryServerInfo{FullMethod: deleteMethod},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				caller, _ := FromContext(ctx)
+				subject = caller.CertificateSubject
+				return nil, nil
+			})
+		assert.Nil(t, err, c.desc)
+		assert.Equal(t, c.expected, subject, c.desc)
+	}
+}
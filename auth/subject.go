@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/certs"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SubjectAuthorizer admits the calls made with a verified client certificate of one of its subjects,
+// and hands the subject of every call to the handlers in the context, whether tokens are checked or not.
+type SubjectAuthorizer struct {
+	subjects map[string]bool
+}
+
+// NewSubjectAuthorizer returns a SubjectAuthorizer admitting the subjects, or every call if there is none.
+func NewSubjectAuthorizer(subjects []string) *SubjectAuthorizer {
+	s := &SubjectAuthorizer{subjects: make(map[string]bool, len(subjects))}
+	for _, subject := range subjects {
+		s.subjects[subject] = true
+	}
+	return s
+}
+
+// UnaryServerInterceptor authorizes unary calls, except the exempt full method names.
+// Returns the interceptor.
+func (s *SubjectAuthorizer) UnaryServerInterceptor(exempt ...string) grpc.UnaryServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		if exempted[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		authorized, err := s.authorizeContext(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(authorized, req)
+	}
+}
+
+// StreamServerInterceptor authorizes streaming calls, except the exempt full method names.
+// Returns the interceptor.
+func (s *SubjectAuthorizer) StreamServerInterceptor(exempt ...string) grpc.StreamServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		if exempted[info.FullMethod] {
+			return handler(srv, stream)
+		}
+		authorized, err := s.authorizeContext(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: authorized})
+	}
+}
+
+// authorizeContext checks the subject of the client certificate of the call against the admitted subjects.
+// Returns the context carrying the subject, or a codes.PermissionDenied error.
+func (s *SubjectAuthorizer) authorizeContext(ctx context.Context, method string) (context.Context, error) {
+	subject, _ := certs.PeerSubject(ctx)
+	if len(s.subjects) > 0 && !s.subjects[subject] {
+		log.Info(consts.AuthTag, method, consts.ErrCertificateSubjectDenied.Error(), subject)
+		return nil, status.Error(codes.PermissionDenied, consts.ErrCertificateSubjectDenied.Error())
+	}
+	return NewSubjectContext(ctx, subject), nil
+}
+
+// subjectKey is the context key of the subject of the client certificate
+type subjectKey struct{}
+
+// NewSubjectContext returns a copy of ctx carrying the subject of the client certificate.
+func NewSubjectContext(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+// SubjectFromContext returns the subject of the verified client certificate of the call of ctx.
+// Returns false if the call was not made over mutual TLS, or was exempt.
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(subjectKey{}).(string)
+	return subject, ok && subject != ""
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+// mutualTLS returns a context of a call over mutual TLS with a client certificate of the common name.
+func mutualTLS(commonName string) context.Context {
+	client := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{client}},
+	}}})
+}
+
+func TestSubjectAuthorizer(t *testing.T) {
+	cases := []struct {
+		desc       string
+		subjects   []string
+		ctx        context.Context
+		method     string
+		expCode    codes.Code
+		expSubject string
+	}{
+		{"any subject", nil, mutualTLS("hwsc-app-gateway-svc"), deleteMethod, codes.OK, "CN=hwsc-app-gateway-svc"},
+		{"any caller without certificate", nil, context.Background(), deleteMethod, codes.OK, ""},
+		{"allowed subject", []string{"CN=hwsc-app-gateway-svc"}, mutualTLS("hwsc-app-gateway-svc"), deleteMethod,
+			codes.OK, "CN=hwsc-app-gateway-svc"},
+		{"other subject", []string{"CN=hwsc-app-gateway-svc"}, mutualTLS("someone"), deleteMethod,
+			codes.PermissionDenied, ""},
+		{"no certificate", []string{"CN=hwsc-app-gateway-svc"}, context.Background(), deleteMethod,
+			codes.PermissionDenied, ""},
+		{"exempt method", []string{"CN=hwsc-app-gateway-svc"}, context.Background(), statusMethod, codes.OK, ""},
+	}
+
+	for _, c := range cases {
+		s := NewSubjectAuthorizer(c.subjects)
+
+		var unarySubject string
+		_, err := s.UnaryServerInterceptor(statusMethod)(c.ctx, nil, &grpc.UnaryServerInfo{FullMethod: c.method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				unarySubject, _ = SubjectFromContext(ctx)
+				return nil, nil
+			})
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		assert.Equal(t, c.expSubject, unarySubject, c.desc)
+
+		var streamSubject string
+		err = s.StreamServerInterceptor(statusMethod)(nil, &fakeStream{ctx: c.ctx},
+			&grpc.StreamServerInfo{FullMethod: c.method},
+			func(srv interface{}, stream grpc.ServerStream) error {
+				streamSubject, _ = SubjectFromContext(stream.Context())
+				return nil
+			})
+		assert.Equal(t, c.expCode, status.Code(err), c.desc)
+		assert.Equal(t, c.expSubject, streamSubject, c.desc)
+	}
+}
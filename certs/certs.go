@@ -0,0 +1,181 @@
+// Package certs serves TLS with a certificate reloaded from its files when they change,
+// and verifies the certificates of clients against a CA bundle for mutual TLS.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileStamp identifies a version of a file
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader holds the server certificate and client CA bundle read from their files
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	stamps    []fileStamp
+}
+
+// New reads the certificate and key files, and the client CA bundle if not empty.
+// Returns consts.ErrMissingKeyPair if the certificate or key file is empty, or the first error reading them.
+func New(certFile string, keyFile string, caFile string) (*Reloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, consts.ErrMissingKeyPair
+	}
+	r := &Reloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files returns the files read by the reloader.
+func (r *Reloader) files() []string {
+	if r.caFile == "" {
+		return []string{r.certFile, r.keyFile}
+	}
+	return []string{r.certFile, r.keyFile, r.caFile}
+}
+
+// Reload reads the files again if one of them changed since the last read.
+// The previous certificate is kept if the files can not be read, so a half written pair is retried later.
+// Returns true if the certificate was reloaded, or the error reading the files.
+func (r *Reloader) Reload() (bool, error) {
+	files := r.files()
+	stamps := make([]fileStamp, len(files))
+	for i, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, err
+		}
+		stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	r.mu.RLock()
+	unchanged := sameStamps(r.stamps, stamps)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		if pool, err = loadCertPool(r.caFile); err != nil {
+			return false, err
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	r.stamps = stamps
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the files every interval in the background, logging the reloads and failures.
+// Does nothing if the interval is 0.
+func (r *Reloader) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Error(consts.TLSTag, "Keeping the previous certificate:", err.Error())
+			} else if reloaded {
+				log.Info(consts.TLSTag, "Reloaded the certificate from", r.certFile)
+			}
+		}
+	}()
+}
+
+// ServerConfig returns the TLS configuration serving the current certificate of the reloader.
+// With a client CA bundle, clients must present a certificate it verifies,
+// unless clientCertOptional only verifies the certificates clients present.
+func (r *Reloader) ServerConfig(clientCertOptional bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				if clientCertOptional {
+					config.ClientAuth = tls.VerifyClientCertIfGiven
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+// PeerSubject returns the subject of the verified client certificate of the call of ctx.
+// Returns false if the call was not made over mutual TLS.
+func PeerSubject(ctx context.Context) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return info.State.VerifiedChains[0][0].Subject.String(), true
+}
+
+// loadCertPool reads the PEM certificates of the CA bundle.
+// Returns consts.ErrInvalidCABundle if the file has no certificate.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, consts.ErrInvalidCABundle
+	}
+	return pool, nil
+}
+
+// sameStamps returns true if both lists identify the same versions of the files.
+func sameStamps(a []fileStamp, b []fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
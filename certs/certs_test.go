@@ -0,0 +1,266 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issued is a generated certificate and its key
+type issued struct {
+	cert    *x509.Certificate
+	key     *rsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// issue generates a certificate of the common name, self-signed if parent is nil, else signed by parent.
+func issue(t *testing.T, commonName string, serial int64, parent *issued) *issued {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"hwsc"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &issued{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+	}
+}
+
+// pair returns the certificate and key of the issued certificate for tls.
+func (i *issued) pair(t *testing.T) tls.Certificate {
+	pair, err := tls.X509KeyPair(i.certPEM, i.keyPEM)
+	assert.Nil(t, err)
+	return pair
+}
+
+// writeFile writes the data to the file, stamping it with the modification time.
+func writeFile(t *testing.T, file string, data []byte, modTime time.Time) {
+	assert.Nil(t, ioutil.WriteFile(file, data, 0600))
+	assert.Nil(t, os.Chtimes(file, modTime, modTime))
+}
+
+// handshake connects a client presenting the certificate, none if nil, to a server of the config.
+// Returns the connection state seen by the server, or the error of the server.
+func handshake(t *testing.T, config *tls.Config, ca *issued, clientCert *tls.Certificate) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if clientCert != nil {
+		// Present the certificate even if the server does not accept its issuer
+		clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return clientCert, nil
+		}
+	}
+	client := tls.Client(clientConn, clientConfig)
+	go func() {
+		_ = client.Handshake()
+		// Read the alerts of the server
+		_, _ = client.Read(make([]byte, 1))
+	}()
+
+	server := tls.Server(serverConn, config)
+	err := server.Handshake()
+	return server.ConnectionState(), err
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := issue(t, "hwsc-ca", 1, nil)
+	server := issue(t, "hwsc-document-svc", 2, ca)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, certFile, server.certPEM, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+	writeFile(t, caFile, ca.certPEM, time.Now())
+
+	cases := []struct {
+		desc     string
+		certFile string
+		keyFile  string
+		caFile   string
+		isErr    bool
+		expected error
+	}{
+		{"server TLS", certFile, keyFile, "", false, nil},
+		{"mutual TLS", certFile, keyFile, caFile, false, nil},
+		{"no certificate", "", keyFile, "", true, consts.ErrMissingKeyPair},
+		{"no key", certFile, "", "", true, consts.ErrMissingKeyPair},
+		{"key of another certificate", caFile, keyFile, "", true, nil},
+		{"missing certificate", filepath.Join(dir, "missing.pem"), keyFile, "", true, nil},
+		{"CA bundle without certificate", certFile, keyFile, keyFile, true, consts.ErrInvalidCABundle},
+	}
+
+	for _, c := range cases {
+		r, err := New(c.certFile, c.keyFile, c.caFile)
+		assert.Equal(t, c.isErr, err != nil, c.desc)
+		assert.Equal(t, c.isErr, r == nil, c.desc)
+		if c.expected != nil {
+			assert.Equal(t, c.expected, err, c.desc)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := issue(t, "hwsc-ca", 1, nil)
+	first := issue(t, "hwsc-document-svc", 2, ca)
+	second := issue(t, "hwsc-document-svc", 3, ca)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, first.certPEM, start)
+	writeFile(t, keyFile, first.keyPEM, start)
+
+	r, err := New(certFile, keyFile, "")
+	assert.Nil(t, err)
+	_, err = handshake(t, r.ServerConfig(false), ca, nil)
+	assert.Nil(t, err)
+
+	reloaded, err := r.Reload()
+	assert.Nil(t, err)
+	assert.False(t, reloaded)
+
+	// A half written pair keeps the previous certificate, and is retried
+	writeFile(t, certFile, second.certPEM, start.Add(time.Second))
+	reloaded, err = r.Reload()
+	assert.NotNil(t, err)
+	assert.False(t, reloaded)
+	assert.Equal(t, first.cert.Raw, r.cert.Certificate[0])
+
+	writeFile(t, keyFile, second.keyPEM, start.Add(time.Second))
+	reloaded, err = r.Reload()
+	assert.Nil(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, second.cert.Raw, r.cert.Certificate[0])
+
+	config, err := r.ServerConfig(false).GetConfigForClient(&tls.ClientHelloInfo{})
+	assert.Nil(t, err)
+	assert.Equal(t, second.cert.Raw, config.Certificates[0].Certificate[0])
+}
+
+func TestServerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := issue(t, "hwsc-ca", 1, nil)
+	other := issue(t, "other-ca", 2, nil)
+	server := issue(t, "hwsc-document-svc", 3, ca)
+	client := issue(t, "hwsc-app-gateway-svc", 4, ca).pair(t)
+	stranger := issue(t, "hwsc-app-gateway-svc", 5, other).pair(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, certFile, server.certPEM, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+	writeFile(t, caFile, ca.certPEM, time.Now())
+
+	serverTLS, err := New(certFile, keyFile, "")
+	assert.Nil(t, err)
+	mutualTLS, err := New(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		desc       string
+		config     *tls.Config
+		clientCert *tls.Certificate
+		isErr      bool
+		verified   bool
+	}{
+		{"server TLS", serverTLS.ServerConfig(false), nil, false, false},
+		{"server TLS ignores client certificates", serverTLS.ServerConfig(false), &client, false, false},
+		{"mutual TLS", mutualTLS.ServerConfig(false), &client, false, true},
+		{"mutual TLS without client certificate", mutualTLS.ServerConfig(false), nil, true, false},
+		{"mutual TLS with unknown client certificate", mutualTLS.ServerConfig(false), &stranger, true, false},
+		{"optional client certificate", mutualTLS.ServerConfig(true), &client, false, true},
+		{"optional client certificate not presented", mutualTLS.ServerConfig(true), nil, false, false},
+		{"optional client certificate unknown", mutualTLS.ServerConfig(true), &stranger, true, false},
+	}
+
+	for _, c := range cases {
+		state, err := handshake(t, c.config, ca, c.clientCert)
+		assert.Equal(t, c.isErr, err != nil, c.desc)
+		assert.Equal(t, c.verified, len(state.VerifiedChains) > 0, c.desc)
+	}
+}
+
+func TestPeerSubject(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := issue(t, "hwsc-ca", 1, nil)
+	server := issue(t, "hwsc-document-svc", 2, ca)
+	client := issue(t, "hwsc-app-gateway-svc", 3, ca).pair(t)
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeFile(t, certFile, server.certPEM, time.Now())
+	writeFile(t, keyFile, server.keyPEM, time.Now())
+	writeFile(t, caFile, ca.certPEM, time.Now())
+
+	r, err := New(certFile, keyFile, caFile)
+	assert.Nil(t, err)
+	state, err := handshake(t, r.ServerConfig(false), ca, &client)
+	assert.Nil(t, err)
+
+	subject, ok := PeerSubject(peer.NewContext(context.Background(),
+		&peer.Peer{AuthInfo: credentials.TLSInfo{State: state}}))
+	assert.True(t, ok)
+	assert.Equal(t, "CN=hwsc-app-gateway-svc,O=hwsc", subject)
+
+	_, ok = PeerSubject(context.Background())
+	assert.False(t, ok)
+	_, ok = PeerSubject(peer.NewContext(context.Background(), &peer.Peer{}))
+	assert.False(t, ok)
+	_, ok = PeerSubject(peer.NewContext(context.Background(),
+		&peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{}}}))
+	assert.False(t, ok)
+}
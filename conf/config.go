@@ -2,18 +2,330 @@ package conf
 
 import (
 	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/logger"
 	"github.com/hwsc-org/hwsc-lib/hosts"
-	"github.com/hwsc-org/hwsc-lib/logger"
 	"github.com/micro/go-config"
 	"github.com/micro/go-config/source/env"
+	"strings"
+	"time"
 )
 
+// URLVerifierConfig configures how file urls are verified
+type URLVerifierConfig struct {
+	// Offline verifies urls without contacting the storage
+	Offline bool
+	// Timeout of a verification
+	Timeout time.Duration
+	// Schemes allowed
+	Schemes []string
+	// Hosts allowed, an entry starting with a dot allows every subdomain, empty allows any host
+	Hosts []string
+	// AllowPrivate allows loopback, private, and link-local addresses
+	AllowPrivate bool
+}
+
+// MediaConfig configures the checks of media files against their Document
+type MediaConfig struct {
+	// RejectSamplingRateMismatch rejects a Document whose SamplingRate disagrees with its audio files,
+	// instead of flagging the files
+	RejectSamplingRateMismatch bool
+	// LocationTolerance in kilometers between the EXIF GPS of an image and the Document coordinates
+	LocationTolerance float64
+	// CaptureTimeTolerance between the EXIF capture time of an image and the Document RecordTimestamp
+	CaptureTimeTolerance time.Duration
+}
+
+// LinkScannerConfig configures the background check of the stored file urls
+type LinkScannerConfig struct {
+	// Interval between two scans, 0 disables the scanner
+	Interval time.Duration
+	// Concurrency bounds the urls checked at once
+	Concurrency int
+	// HostRate bounds the urls checked per second on a single host
+	HostRate float64
+	// LeaseTTL is the lifetime of the lease of a scan, extended while the scan runs
+	LeaseTTL time.Duration
+}
+
+// EmbargoConfig configures the publication of the Documents whose embargo passed
+type EmbargoConfig struct {
+	// Interval between two publication runs, 0 disables the scheduler
+	Interval time.Duration
+}
+
+// URLSignerConfig configures the signed download links of private Documents
+type URLSignerConfig struct {
+	// Keys as "id:secret" entries, every key checks links, empty disables signing
+	Keys []string
+	// CurrentKey is the id of the key signing new links, the first key if empty
+	CurrentKey string
+	// TTL of a signed link
+	TTL time.Duration
+}
+
+// TLSConfig configures the TLS of the gRPC listener
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM certificate and key of the server, both empty serve plaintext
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the PEM bundle verifying client certificates, empty disables mutual TLS
+	ClientCAFile string
+	// ClientCertOptional accepts clients without a certificate, verifying only the presented ones
+	ClientCertOptional bool
+	// ReloadInterval between two checks of the files for changes, 0 disables the reload
+	ReloadInterval time.Duration
+}
+
+// RateLimitConfig configures the token buckets bounding the calls of each caller
+type RateLimitConfig struct {
+	// Rate of calls per second of every method without its own limit, 0 does not limit
+	Rate float64
+	// Burst of calls of every method without its own limit
+	Burst int
+	// Methods limits as "Method=rate:burst" entries
+	Methods []string
+}
+
+// QuotaConfig configures the storage of each user
+type QuotaConfig struct {
+	// MaxDocuments of a user, 0 does not limit
+	MaxDocuments int
+	// MaxFiles across the Documents of a user, 0 does not limit
+	MaxFiles int
+}
+
+// MetricsConfig configures the HTTP endpoint of the Prometheus metrics
+type MetricsConfig struct {
+	// Address the endpoint listens on, empty disables the endpoint, which is not authenticated
+	Address string
+	// URLHosts label the url verifications, the others being counted together
+	URLHosts []string
+}
+
+// TracingConfig configures the export of the OpenTelemetry spans
+type TracingConfig struct {
+	// Exporter of the spans, "otlp" or "none"
+	Exporter string
+	// Endpoint of the OTLP/gRPC collector
+	Endpoint string
+	// Insecure sends the spans to the collector in plaintext
+	Insecure bool
+	// SampleRatio of the traces started by the service, between 0 and 1
+	SampleRatio float64
+}
+
+// LoggingConfig configures the JSON logs
+type LoggingConfig struct {
+	// Level of the least severe logs written, "debug", "info", "warn", or "error"
+	Level string
+	// Redact lists the fields whose values are replaced in logs
+	Redact []string
+	// SuccessSampling logs one of every SuccessSampling successful calls of a method
+	SuccessSampling int
+}
+
+// AuditConfig configures the audit log of Document mutations
+type AuditConfig struct {
+	// Retention of the audit records, 0 keeps them forever
+	Retention time.Duration
+}
+
+// OutboxConfig configures the change events of Documents and their delivery
+type OutboxConfig struct {
+	// Enabled writes a change event to the outbox with every change of a Document, in a transaction
+	// needing MongoDB as a replica set
+	Enabled bool
+	// Interval between the dispatches of the events, 0 disables the dispatcher of this instance
+	Interval time.Duration
+	// BatchSize is the number of events read by a dispatch
+	BatchSize int
+	// Concurrency is the number of Documents whose events are delivered at once
+	Concurrency int
+	// MinBackoff is the wait before the first retry of an event, doubled by every failed attempt
+	MinBackoff time.Duration
+	// MaxBackoff bounds the wait between retries of an event
+	MaxBackoff time.Duration
+	// Timeout bounds the delivery of an event to a sink
+	Timeout time.Duration
+	// Retention of the delivered events, 0 keeps them forever
+	Retention time.Duration
+	// MaxAttempts is the number of failed attempts dead-lettering an event
+	MaxAttempts int
+	// LeaseTTL is the lifetime of the lease of the dispatcher, held by one instance and extended while it dispatches
+	LeaseTTL time.Duration
+	// WebhookURL receives the events in POST requests, empty if none
+	WebhookURL string
+	// WebhookSecret signs the body of the webhook requests, empty if unsigned
+	WebhookSecret string
+	// NATSURL is the NATS server the events are published to, empty if none
+	NATSURL string
+	// NATSSubject prefixes the event type in the subject of the published events
+	NATSSubject string
+	// File the events are appended to as lines of JSON, "-" for stdout, empty if none
+	File string
+}
+
+// WatchConfig configures the streams of Document changes
+type WatchConfig struct {
+	// PollInterval between the reads of the outbox, or of the audit log, if the changes can not be streamed
+	PollInterval time.Duration
+}
+
+// SubscriptionsConfig configures the webhook subscriptions and their deliveries
+type SubscriptionsConfig struct {
+	// Enabled records a delivery for every matching subscription of the change events, needing the outbox
+	Enabled bool
+	// Interval between the dispatches of the deliveries, 0 disables the dispatcher of this instance
+	Interval time.Duration
+	// BatchSize is the number of deliveries attempted by a dispatch
+	BatchSize int
+	// Concurrency is the number of deliveries attempted at once
+	Concurrency int
+	// MaxAttempts of a delivery before it fails
+	MaxAttempts int
+	// MinBackoff is the wait before the first retry of a delivery, doubled by every failed attempt
+	MinBackoff time.Duration
+	// MaxBackoff bounds the wait between retries of a delivery
+	MaxBackoff time.Duration
+	// Timeout bounds an attempt of a delivery
+	Timeout time.Duration
+	// DisableAfter is the number of deliveries failing in a row that disables a subscription
+	DisableAfter int
+	// AllowPrivate allows webhooks on loopback, private, and link-local addresses
+	AllowPrivate bool
+	// Retention of the finished deliveries, 0 keeps them forever
+	Retention time.Duration
+	// LeaseTTL is the lifetime of the lease of the dispatcher, held by one instance and extended while it dispatches
+	LeaseTTL time.Duration
+}
+
+// MigrationsConfig configures the migrations of the Document database
+type MigrationsConfig struct {
+	// Auto applies the pending migrations at startup
+	Auto bool
+	// LockTimeout bounds the wait for the migrations run by another instance
+	LockTimeout time.Duration
+	// LockTTL is the age after which the lock of a crashed instance is taken over, extended while migrating
+	LockTTL time.Duration
+}
+
+// SearchConfig configures the search index mirroring the Documents
+type SearchConfig struct {
+	// URL of the Elasticsearch compatible server, empty disables the search index
+	URL string
+	// Index mirroring the Documents
+	Index string
+	// Username and Password authenticate with basic authentication if the username is set
+	Username string
+	Password string
+	// BatchSize is the number of Documents written by a bulk request of a reindex or repair
+	BatchSize int
+	// Timeout bounds a request to the server
+	Timeout time.Duration
+}
+
+// AuthConfig configures the authentication of callers by their bearer JWT
+type AuthConfig struct {
+	// Disabled lets every call through unauthenticated, only for local development
+	Disabled bool
+	// Secrets as "id:secret" entries verifying HMAC tokens
+	Secrets []string
+	// RSAKeys as "id:path" entries to PEM public keys verifying RSA tokens
+	RSAKeys []string
+	// JWKSFile is the path of a JSON Web Key Set file, empty if none
+	JWKSFile string
+	// Issuer required in tokens, empty accepts any issuer
+	Issuer string
+	// Audience required in tokens, empty accepts any audience
+	Audience string
+	// CertSubjects are the subjects of the client certificates allowed to call, empty allows any caller
+	CertSubjects []string
+}
+
 var (
 	// GRPCHost address and port of gRPC microservice
 	GRPCHost hosts.Host
 
 	// DocumentDB represents the Document database
 	DocumentDB hosts.DocumentDBHost
+
+	// FileRegistryCollection is the collection of the urls referenced by Documents, in the Document database
+	FileRegistryCollection string
+
+	// AuditCollection is the collection of the audit log, in the Document database
+	AuditCollection string
+
+	// OutboxCollection is the collection of the change events, in the Document database
+	OutboxCollection string
+
+	// SubscriptionCollection is the collection of the webhook subscriptions, in the Document database
+	SubscriptionCollection string
+
+	// DeliveryCollection is the collection of the webhook deliveries, in the Document database
+	DeliveryCollection string
+
+	// MigrationLockCollection is the collection of the lock of the migrations, in the Document database
+	MigrationLockCollection string
+
+	// LeaseCollection is the collection of the leases of the background jobs, in the Document database
+	LeaseCollection string
+
+	// UsageCollection is the collection of the counters of what users store, in the Document database
+	UsageCollection string
+
+	// URLVerifier configures the verification of file urls
+	URLVerifier URLVerifierConfig
+
+	// Media configures the checks of media files
+	Media MediaConfig
+
+	// LinkScanner configures the background check of file urls
+	LinkScanner LinkScannerConfig
+
+	// Embargo configures the publication of embargoed Documents
+	Embargo EmbargoConfig
+
+	// URLSigner configures the signed download links
+	URLSigner URLSignerConfig
+
+	// TLS configures the TLS of the gRPC listener
+	TLS TLSConfig
+
+	// Auth configures the authentication of callers
+	Auth AuthConfig
+
+	// RateLimit configures the rate limits of callers
+	RateLimit RateLimitConfig
+
+	// Quota configures the storage quotas of users
+	Quota QuotaConfig
+
+	// Metrics configures the metrics endpoint
+	Metrics MetricsConfig
+
+	// Tracing configures the export of spans
+	Tracing TracingConfig
+
+	// Logging configures the logs
+	Logging LoggingConfig
+
+	// Audit configures the audit log
+	Audit AuditConfig
+
+	// Outbox configures the change events
+	Outbox OutboxConfig
+
+	// Watch configures the streams of Document changes
+	Watch WatchConfig
+
+	// Subscriptions configures the webhook subscriptions
+	Subscriptions SubscriptionsConfig
+
+	// Search configures the search index
+	Search SearchConfig
+
+	// Migrations configures the migrations of the Document database
+	Migrations MigrationsConfig
 )
 
 func init() {
@@ -21,7 +333,7 @@ func init() {
 	conf := config.NewConfig()
 	logger.Info(consts.DocumentServiceTag, "Reading ENV variables")
 	src := env.NewSource(
-		env.WithPrefix("hosts"),
+		env.WithPrefix("hosts", "verifier", "media", "scanner", "embargo", "signer", "tls", "auth", "ratelimit", "quota", "metrics", "tracing", "logging", "audit", "outbox", "watch", "subscriptions", "search", "migrations"),
 	)
 	if err := conf.Load(src); err != nil {
 		logger.Fatal(consts.DocumentServiceTag, "Failed to initialize configuration", err.Error())
@@ -33,4 +345,159 @@ func init() {
 	if err := conf.Get("hosts", "mongodb").Scan(&DocumentDB); err != nil {
 		logger.Fatal(consts.DocumentServiceTag, "Failed to get MongoDB configuration", err.Error())
 	}
+	FileRegistryCollection = conf.Get("hosts", "mongodb", "registry").String(DocumentDB.Collection + "-registry")
+	AuditCollection = conf.Get("hosts", "mongodb", "audit").String(DocumentDB.Collection + "-audit")
+	OutboxCollection = conf.Get("hosts", "mongodb", "outbox").String(DocumentDB.Collection + "-outbox")
+	SubscriptionCollection = conf.Get("hosts", "mongodb", "subscriptions").String(DocumentDB.Collection + "-subscriptions")
+	DeliveryCollection = conf.Get("hosts", "mongodb", "deliveries").String(DocumentDB.Collection + "-deliveries")
+	MigrationLockCollection = conf.Get("hosts", "mongodb", "migrationlock").String(DocumentDB.Collection + "-migration-lock")
+	LeaseCollection = conf.Get("hosts", "mongodb", "leases").String(DocumentDB.Collection + "-leases")
+	UsageCollection = conf.Get("hosts", "mongodb", "usage").String(DocumentDB.Collection + "-usage")
+	URLVerifier = URLVerifierConfig{
+		Offline:      conf.Get("verifier", "offline").Bool(false),
+		Timeout:      conf.Get("verifier", "timeout").Duration(10 * time.Second),
+		Schemes:      splitList(conf.Get("verifier", "schemes").String(""), []string{"http", "https"}),
+		Hosts:        splitList(conf.Get("verifier", "hosts").String(""), nil),
+		AllowPrivate: conf.Get("verifier", "allowprivate").Bool(false),
+	}
+	Media = MediaConfig{
+		RejectSamplingRateMismatch: conf.Get("media", "rejectsamplingrate").Bool(false),
+		LocationTolerance:          conf.Get("media", "locationtolerance").Float64(10),
+		CaptureTimeTolerance:       conf.Get("media", "capturetimetolerance").Duration(24 * time.Hour),
+	}
+	LinkScanner = LinkScannerConfig{
+		Interval:    conf.Get("scanner", "interval").Duration(24 * time.Hour),
+		Concurrency: conf.Get("scanner", "concurrency").Int(4),
+		HostRate:    conf.Get("scanner", "hostrate").Float64(5),
+		LeaseTTL:    positiveDuration(conf.Get("scanner", "leasettl").Duration(time.Minute), time.Minute),
+	}
+	Embargo = EmbargoConfig{
+		Interval: conf.Get("embargo", "interval").Duration(time.Minute),
+	}
+	URLSigner = URLSignerConfig{
+		Keys:       splitList(conf.Get("signer", "keys").String(""), nil),
+		CurrentKey: conf.Get("signer", "currentkey").String(""),
+		TTL:        conf.Get("signer", "ttl").Duration(15 * time.Minute),
+	}
+	TLS = TLSConfig{
+		CertFile:           conf.Get("tls", "certfile").String(""),
+		KeyFile:            conf.Get("tls", "keyfile").String(""),
+		ClientCAFile:       conf.Get("tls", "clientcafile").String(""),
+		ClientCertOptional: conf.Get("tls", "clientcertoptional").Bool(false),
+		ReloadInterval:     conf.Get("tls", "reloadinterval").Duration(30 * time.Second),
+	}
+	Auth = AuthConfig{
+		Disabled: conf.Get("auth", "disabled").Bool(false),
+		Secrets:  splitList(conf.Get("auth", "secrets").String(""), nil),
+		RSAKeys:  splitList(conf.Get("auth", "rsakeys").String(""), nil),
+		JWKSFile: conf.Get("auth", "jwksfile").String(""),
+		Issuer:   conf.Get("auth", "issuer").String(""),
+		Audience: conf.Get("auth", "audience").String(""),
+		// Subjects hold commas, so they are separated by semicolons
+		CertSubjects: splitListBy(conf.Get("auth", "certsubjects").String(""), ";", nil),
+	}
+	RateLimit = RateLimitConfig{
+		Rate:    conf.Get("ratelimit", "rate").Float64(20),
+		Burst:   conf.Get("ratelimit", "burst").Int(40),
+		Methods: splitList(conf.Get("ratelimit", "methods").String(""), []string{"QueryDocument=5:10", "CreateDocument=2:10"}),
+	}
+	Quota = QuotaConfig{
+		MaxDocuments: conf.Get("quota", "maxdocuments").Int(0),
+		MaxFiles:     conf.Get("quota", "maxfiles").Int(0),
+	}
+	Metrics = MetricsConfig{
+		Address:  conf.Get("metrics", "address").String(""),
+		URLHosts: splitList(conf.Get("metrics", "urlhosts").String(""), URLVerifier.Hosts),
+	}
+	Tracing = TracingConfig{
+		Exporter:    conf.Get("tracing", "exporter").String("none"),
+		Endpoint:    conf.Get("tracing", "endpoint").String("localhost:4317"),
+		Insecure:    conf.Get("tracing", "insecure").Bool(false),
+		SampleRatio: conf.Get("tracing", "sampleratio").Float64(1),
+	}
+	Logging = LoggingConfig{
+		Level:           conf.Get("logging", "level").String("info"),
+		Redact:          splitList(conf.Get("logging", "redact").String(""), logger.DefaultRedact),
+		SuccessSampling: conf.Get("logging", "successsampling").Int(1),
+	}
+	Audit = AuditConfig{
+		Retention: conf.Get("audit", "retention").Duration(0),
+	}
+	Outbox = OutboxConfig{
+		Enabled:       conf.Get("outbox", "enabled").Bool(false),
+		Interval:      conf.Get("outbox", "interval").Duration(time.Second),
+		BatchSize:     conf.Get("outbox", "batchsize").Int(100),
+		Concurrency:   conf.Get("outbox", "concurrency").Int(4),
+		MinBackoff:    conf.Get("outbox", "minbackoff").Duration(time.Second),
+		MaxBackoff:    conf.Get("outbox", "maxbackoff").Duration(5 * time.Minute),
+		Timeout:       conf.Get("outbox", "timeout").Duration(10 * time.Second),
+		Retention:     conf.Get("outbox", "retention").Duration(7 * 24 * time.Hour),
+		MaxAttempts:   conf.Get("outbox", "maxattempts").Int(20),
+		LeaseTTL:      positiveDuration(conf.Get("outbox", "leasettl").Duration(30*time.Second), 30*time.Second),
+		WebhookURL:    conf.Get("outbox", "webhookurl").String(""),
+		WebhookSecret: conf.Get("outbox", "webhooksecret").String(""),
+		NATSURL:       conf.Get("outbox", "natsurl").String(""),
+		NATSSubject:   conf.Get("outbox", "natssubject").String("hwsc.document"),
+		File:          conf.Get("outbox", "file").String(""),
+	}
+	Watch = WatchConfig{
+		PollInterval: conf.Get("watch", "pollinterval").Duration(time.Second),
+	}
+	Subscriptions = SubscriptionsConfig{
+		Enabled:      conf.Get("subscriptions", "enabled").Bool(false),
+		Interval:     conf.Get("subscriptions", "interval").Duration(time.Second),
+		BatchSize:    conf.Get("subscriptions", "batchsize").Int(100),
+		Concurrency:  conf.Get("subscriptions", "concurrency").Int(8),
+		MaxAttempts:  conf.Get("subscriptions", "maxattempts").Int(8),
+		MinBackoff:   conf.Get("subscriptions", "minbackoff").Duration(10 * time.Second),
+		MaxBackoff:   conf.Get("subscriptions", "maxbackoff").Duration(time.Hour),
+		Timeout:      conf.Get("subscriptions", "timeout").Duration(10 * time.Second),
+		DisableAfter: conf.Get("subscriptions", "disableafter").Int(5),
+		AllowPrivate: conf.Get("subscriptions", "allowprivate").Bool(false),
+		Retention:    conf.Get("subscriptions", "retention").Duration(30 * 24 * time.Hour),
+		LeaseTTL:     positiveDuration(conf.Get("subscriptions", "leasettl").Duration(30*time.Second), 30*time.Second),
+	}
+	Search = SearchConfig{
+		URL:       conf.Get("search", "url").String(""),
+		Index:     conf.Get("search", "index").String("hwsc-documents"),
+		Username:  conf.Get("search", "username").String(""),
+		Password:  conf.Get("search", "password").String(""),
+		BatchSize: conf.Get("search", "batchsize").Int(500),
+		Timeout:   conf.Get("search", "timeout").Duration(30 * time.Second),
+	}
+	Migrations = MigrationsConfig{
+		Auto:        conf.Get("migrations", "auto").Bool(false),
+		LockTimeout: conf.Get("migrations", "locktimeout").Duration(time.Minute),
+		LockTTL:     positiveDuration(conf.Get("migrations", "lockttl").Duration(time.Minute), time.Minute),
+	}
+}
+
+// splitList splits a comma separated ENV variable, ignoring empty entries.
+// Returns def if the list is empty.
+func splitList(value string, def []string) []string {
+	return splitListBy(value, ",", def)
+}
+
+// splitListBy splits the value on the separator, dropping blank entries.
+// Returns def if the value has no entry.
+func splitListBy(value string, sep string, def []string) []string {
+	var list []string
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// positiveDuration returns the value of a lifetime, which must be positive.
+// Returns def if the value is 0 or negative.
+func positiveDuration(value time.Duration, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+	return value
 }
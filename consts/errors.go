@@ -51,4 +51,58 @@ var (
 	ErrNoDocumentFound                = errors.New("no document found")
 	ErrMediaType                      = errors.New("invalid media type")
 	ErrEmptyMongoDBURI                = errors.New("empty MongoDB URI")
+	ErrURLSchemeNotAllowed            = errors.New("URL scheme not allowed")
+	ErrURLHostNotAllowed              = errors.New("URL host not allowed")
+	ErrPrivateAddress                 = errors.New("private network address not allowed")
+	ErrContentTypeMismatch            = errors.New("file content does not match the URL extension")
+	ErrUnsupportedAudioFormat         = errors.New("unsupported audio format")
+	ErrMalformedAudioHeader           = errors.New("malformed audio header")
+	ErrSamplingRateMismatch           = errors.New("Document SamplingRate does not match the audio file")
+	ErrNoEXIF                         = errors.New("no EXIF metadata")
+	ErrMalformedEXIF                  = errors.New("malformed EXIF metadata")
+	ErrInvalidSigningKey              = errors.New("signing key needs an id and a secret")
+	ErrSigningKeyUnknown              = errors.New("unknown signing key")
+	ErrSignatureMissing               = errors.New("url is not signed")
+	ErrSignatureInvalid               = errors.New("invalid url signature")
+	ErrSignatureExpired               = errors.New("signed url expired")
+	ErrFileNotFound                   = errors.New("no file with the fuid in the Document")
+	ErrFileAlreadyInMedia             = errors.New("file already has the media type")
+	ErrDuplicateFUID                  = errors.New("duplicate fuid")
+	ErrNoVerifyingKeys                = errors.New("no token verifying keys")
+	ErrInvalidVerifyingKey            = errors.New("invalid token verifying key")
+	ErrVerifyingKeyUnknown            = errors.New("unknown token verifying key")
+	ErrMissingToken                   = errors.New("missing bearer token")
+	ErrInvalidToken                   = errors.New("invalid bearer token")
+	ErrMissingCaller                  = errors.New("no authenticated caller")
+	ErrNotDocumentOwner               = errors.New("caller does not own the Document")
+	ErrAdminRequired                  = errors.New("admin role required")
+	ErrInvalidShare                   = errors.New("share needs exactly one of uuid and group")
+	ErrInvalidShareAccess             = errors.New("invalid share access")
+	ErrShareNotFound                  = errors.New("no share for the uuid or group")
+	ErrEmbargoTimestampPassed         = errors.New("Document EmbargoUntilTimestamp has passed")
+	ErrEmbargoTimestampTooFar         = errors.New("Document EmbargoUntilTimestamp is too far in the future")
+	ErrMissingKeyPair                 = errors.New("TLS needs a certificate and a key file")
+	ErrCertificateSubjectDenied       = errors.New("client certificate subject not allowed")
+	ErrSubjectsWithoutClientCA        = errors.New("allowed certificate subjects need a client CA bundle")
+	ErrInvalidCABundle                = errors.New("no certificate in the CA bundle")
+	ErrInvalidRateLimit               = errors.New("rate limit needs a Method=rate:burst entry")
+	ErrRateLimited                    = errors.New("too many calls, retry later")
+	ErrDocumentQuotaExceeded          = errors.New("Document quota of the user exceeded")
+	ErrFileQuotaExceeded              = errors.New("file quota of the user exceeded")
+	ErrInvalidLogLevel                = errors.New("log level must be debug, info, warn, or error")
+	ErrInvalidAuditTimeRange          = errors.New("audit from_timestamp is after to_timestamp")
+	ErrInvalidAuditLimit              = errors.New("audit limit must not be negative")
+	ErrInvalidResumeToken             = errors.New("invalid resume token")
+	ErrInvalidSubscriptionID          = errors.New("invalid webhook subscription id")
+	ErrInvalidWebhookURL              = errors.New("webhook url must be an absolute http or https url")
+	ErrInvalidEventType               = errors.New("unknown event type")
+	ErrWebhookSecretTooShort          = errors.New("webhook secret must have at least 16 characters")
+	ErrNotSubscriptionOwner           = errors.New("caller does not own the webhook subscription")
+	ErrInvalidDeliveryLimit           = errors.New("delivery limit must not be negative")
+	ErrSearchDisabled                 = errors.New("search index is not configured")
+	ErrInvalidBatchSize               = errors.New("batch size must not be negative")
+	ErrInvalidSearchBatchSize         = errors.New("search batch size must be positive")
+	ErrMigrationLocked                = errors.New("migrations are locked by another instance")
+	ErrMigrationLockLost              = errors.New("lock of the migrations lost, the migrations stopped")
+	ErrSchemaAhead                    = errors.New("schema version is ahead of the migrations of the service")
 )
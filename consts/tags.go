@@ -14,4 +14,37 @@ const (
 	ServiceStateTag               string = "Service State -"
 	MongoDBTag                    string = "MongoDB -"
 	TestTag                       string = "Test -"
+	LinkScannerTag                string = "Link Scanner -"
+	ListBrokenFilesTag            string = "ListBrokenFiles -"
+	FileRegistryTag               string = "File Registry -"
+	ListUnreferencedFilesTag      string = "ListUnreferencedFiles -"
+	MoveFileTag                   string = "MoveFile -"
+	ReplaceFileURLTag             string = "ReplaceFileURL -"
+	ReorderFilesTag               string = "ReorderFiles -"
+	SetPrimaryFileTag             string = "SetPrimaryFile -"
+	AuthTag                       string = "Auth -"
+	GrantShareTag                 string = "GrantShare -"
+	RevokeShareTag                string = "RevokeShare -"
+	ListSharesTag                 string = "ListShares -"
+	SetEmbargoTag                 string = "SetEmbargo -"
+	EmbargoSchedulerTag           string = "Embargo Scheduler -"
+	TLSTag                        string = "TLS -"
+	RateLimitTag                  string = "Rate Limit -"
+	MetricsTag                    string = "Metrics -"
+	TracingTag                    string = "Tracing -"
+	AuditTag                      string = "Audit -"
+	ListAuditRecordsTag           string = "ListAuditRecords -"
+	OutboxTag                     string = "Outbox -"
+	WatchDocumentsTag             string = "WatchDocuments -"
+	CreateWebhookSubscriptionTag  string = "CreateWebhookSubscription -"
+	ListWebhookSubscriptionsTag   string = "ListWebhookSubscriptions -"
+	PauseWebhookSubscriptionTag   string = "PauseWebhookSubscription -"
+	DeleteWebhookSubscriptionTag  string = "DeleteWebhookSubscription -"
+	ListWebhookDeliveriesTag      string = "ListWebhookDeliveries -"
+	SubscriptionsTag              string = "Subscriptions -"
+	SearchTag                     string = "Search -"
+	ReindexDocumentsTag           string = "ReindexDocuments -"
+	CheckSearchIndexTag           string = "CheckSearchIndex -"
+	MigrationsTag                 string = "Migrations -"
+	QuotaTag                      string = "Quota -"
 )
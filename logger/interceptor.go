@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"github.com/google/uuid"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+const (
+	// RequestIDKey is the metadata key of the request ID of a call, sent back in the header of the response
+	RequestIDKey = "x-request-id"
+	// Longest request ID kept from the metadata
+	maxRequestIDLength = 128
+	// Tag of the logs of the finished calls
+	callTag = "gRPC"
+)
+
+// UnaryServerInterceptor logs every finished unary call with its request ID, method, caller, duid, duration,
+// and status code. Successful calls are sampled.
+// Returns the interceptor.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		r := startRequest(ctx, info.FullMethod)
+		r.duid = requestDUID(req)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDKey, r.id))
+		ctx = withRequest(ctx, r)
+
+		start := time.Now()
+		res, err := handler(ctx, req)
+		logCall(ctx, time.Since(start), err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor logs every finished streaming call with its request ID, method, caller, duration,
+// and status code. Successful calls are sampled.
+// Returns the interceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		r := startRequest(stream.Context(), info.FullMethod)
+		_ = stream.SetHeader(metadata.Pairs(RequestIDKey, r.id))
+		ctx := withRequest(stream.Context(), r)
+
+		start := time.Now()
+		err := handler(srv, &loggedStream{ServerStream: stream, ctx: ctx})
+		logCall(ctx, time.Since(start), err)
+		return err
+	}
+}
+
+// loggedStream is a server stream whose context holds the request of the call
+type loggedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the call, holding its request.
+func (s *loggedStream) Context() context.Context {
+	return s.ctx
+}
+
+// startRequest returns the request of a call of the method, keeping the request ID of its metadata if valid.
+func startRequest(ctx context.Context, method string) *request {
+	r := &request{method: method}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDKey); len(ids) > 0 && validRequestID(ids[0]) {
+			r.id = ids[0]
+		}
+	}
+	if r.id == "" {
+		r.id = uuid.New().String()
+	}
+	return r
+}
+
+// validRequestID returns true if the request ID is short and printable, so it is safe to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c > unicode.MaxASCII || !unicode.IsPrint(c) || unicode.IsSpace(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// logCall logs the finished call of ctx at the level of its status code.
+// Only the sampled successful calls are logged.
+func logCall(ctx context.Context, elapsed time.Duration, err error) {
+	code := status.Code(err)
+	if code == codes.OK && !current.Load().sampled(requestOf(ctx).method) {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.Float64("duration_ms", float64(elapsed)/float64(time.Millisecond)),
+		slog.String("code", code.String()),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	Log(ctx, levelOf(code), callTag, "Finished call", attrs...)
+}
+
+// levelOf returns the level of the logs of calls finished with the code,
+// warning for the errors of the caller and error for the failures of the service.
+func levelOf(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// sampled counts a successful call of the method.
+// Returns true if the call is one of every configured number of calls.
+func (s *state) sampled(method string) bool {
+	if s.sampling <= 1 {
+		return true
+	}
+	counter, _ := s.successes.LoadOrStore(method, new(uint64))
+	return (atomic.AddUint64(counter.(*uint64), 1)-1)%s.sampling == 0
+}
+
+// requestDUID returns the duid of the request, or of the Document or file of a DocumentRequest.
+// Returns empty if the request has none.
+func requestDUID(req interface{}) string {
+	if r, ok := req.(interface{ GetData() *pbdoc.Document }); ok && r.GetData() != nil {
+		return r.GetData().GetDuid()
+	}
+	if r, ok := req.(interface {
+		GetFileMetadataParameters() *pbdoc.FileMetadataTransaction
+	}); ok && r.GetFileMetadataParameters() != nil {
+		return r.GetFileMetadataParameters().GetDuid()
+	}
+	if r, ok := req.(interface{ GetDuid() string }); ok {
+		return r.GetDuid()
+	}
+	return ""
+}
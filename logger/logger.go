@@ -0,0 +1,190 @@
+// Package logger writes leveled JSON logs. A log written with the context of a call holds the request ID, method,
+// caller, and duid of the call, and the configured PII fields of the logged values are redacted.
+package logger
+
+import (
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"golang.org/x/net/context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// LevelFatal logs a failure shutting down the service
+	LevelFatal = slog.LevelError + 4
+	// Redacted replaces the value of a redacted field
+	Redacted = "[REDACTED]"
+)
+
+// DefaultRedact are the fields naming the publishers of Documents
+var DefaultRedact = []string{"publisherName", "publishers", "firstName", "lastName"}
+
+// Config configures the logs
+type Config struct {
+	// Level of the least severe logs written, "debug", "info", "warn", or "error"
+	Level string
+	// Redact lists the fields whose values are replaced, matched ignoring case and underscores
+	Redact []string
+	// SuccessSampling logs one of every SuccessSampling successful calls of a method, below 2 logs every call
+	SuccessSampling int
+}
+
+// state is the configured logger
+type state struct {
+	logger   *slog.Logger
+	redact   map[string]bool
+	sampling uint64
+	// Successful calls by method, counting to the next sampled call
+	successes sync.Map
+}
+
+// current state, replaced as a whole by Configure
+var current atomic.Pointer[state]
+
+func init() {
+	if err := Configure(os.Stderr, Config{Level: "info", Redact: DefaultRedact}); err != nil {
+		panic(err)
+	}
+}
+
+// Configure writes the logs of the config to out.
+// Returns consts.ErrInvalidLogLevel if the level is unknown.
+func Configure(out io.Writer, cfg Config) error {
+	var level slog.Level
+	switch strings.ToLower(cfg.Level) {
+	case "debug":
+		level = slog.LevelDebug
+	case "", "info":
+		level = slog.LevelInfo
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return consts.ErrInvalidLogLevel
+	}
+
+	s := &state{redact: make(map[string]bool), sampling: 1}
+	for _, field := range cfg.Redact {
+		s.redact[fieldKey(field)] = true
+	}
+	if cfg.SuccessSampling > 1 {
+		s.sampling = uint64(cfg.SuccessSampling)
+	}
+	s.logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.LevelKey && a.Value.Any() == LevelFatal {
+				return slog.String(slog.LevelKey, "FATAL")
+			}
+			if s.redact[fieldKey(a.Key)] {
+				return slog.String(a.Key, Redacted)
+			}
+			return a
+		},
+	}))
+	current.Store(s)
+	return nil
+}
+
+// fieldKey returns the field name matched by the redacted fields.
+func fieldKey(name string) string {
+	return strings.ToLower(strings.Replace(name, "_", "", -1))
+}
+
+// Debug logs a debugging message, the first arg being the tag of its source.
+func Debug(args ...string) {
+	write(context.Background(), slog.LevelDebug, args)
+}
+
+// Info logs an informational message, the first arg being the tag of its source.
+func Info(args ...string) {
+	write(context.Background(), slog.LevelInfo, args)
+}
+
+// Error logs an error, the first arg being the tag of its source.
+func Error(args ...string) {
+	write(context.Background(), slog.LevelError, args)
+}
+
+// Fatal logs a failure, the first arg being the tag of its source, and shuts down the service.
+func Fatal(args ...string) {
+	write(context.Background(), LevelFatal, args)
+	os.Exit(1)
+}
+
+// write logs the message of the args at the level.
+func write(ctx context.Context, level slog.Level, args []string) {
+	var tag string
+	if len(args) > 0 {
+		tag, args = args[0], args[1:]
+	}
+	Log(ctx, level, tag, strings.Join(args, " "))
+}
+
+// DebugContext logs a debugging message of the call of ctx with the attributes.
+func DebugContext(ctx context.Context, tag string, msg string, attrs ...slog.Attr) {
+	Log(ctx, slog.LevelDebug, tag, msg, attrs...)
+}
+
+// InfoContext logs an informational message of the call of ctx with the attributes.
+func InfoContext(ctx context.Context, tag string, msg string, attrs ...slog.Attr) {
+	Log(ctx, slog.LevelInfo, tag, msg, attrs...)
+}
+
+// ErrorContext logs an error of the call of ctx with the attributes.
+func ErrorContext(ctx context.Context, tag string, msg string, attrs ...slog.Attr) {
+	Log(ctx, slog.LevelError, tag, msg, attrs...)
+}
+
+// Log logs the message of the tag at the level, with the request fields of ctx and the attributes.
+func Log(ctx context.Context, level slog.Level, tag string, msg string, attrs ...slog.Attr) {
+	logger := current.Load().logger
+	if !logger.Enabled(ctx, level) {
+		return
+	}
+	fields := make([]slog.Attr, 0, len(attrs)+5)
+	if tag = strings.TrimSuffix(strings.TrimSpace(tag), " -"); tag != "" {
+		fields = append(fields, slog.String("tag", tag))
+	}
+	fields = append(fields, requestOf(ctx).attrs()...)
+	logger.LogAttrs(ctx, level, strings.TrimSpace(msg), append(fields, attrs...)...)
+}
+
+// Value returns the attribute of the key holding v as a JSON object, its redacted fields replaced at any depth.
+func Value(key string, v interface{}) slog.Attr {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return slog.String(key, err.Error())
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return slog.String(key, err.Error())
+	}
+	return slog.Any(key, current.Load().redactValue(decoded))
+}
+
+// redactValue replaces the values of the redacted fields of the decoded JSON value.
+// Returns the value.
+func (s *state) redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for field, nested := range value {
+			if s.redact[fieldKey(field)] {
+				value[field] = Redacted
+			} else {
+				value[field] = s.redactValue(nested)
+			}
+		}
+	case []interface{}:
+		for i, nested := range value {
+			value[i] = s.redactValue(nested)
+		}
+	}
+	return v
+}
@@ -0,0 +1,231 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture configures the logs to be written to the returned buffer.
+func capture(t *testing.T, cfg Config) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	assert.Nil(t, Configure(out, cfg))
+	t.Cleanup(func() {
+		assert.Nil(t, Configure(os.Stderr, Config{Level: "info", Redact: DefaultRedact}))
+	})
+	return out
+}
+
+// entries returns the JSON logs written to out.
+func entries(t *testing.T, out *bytes.Buffer) []map[string]interface{} {
+	var logs []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		assert.Nil(t, json.Unmarshal([]byte(line), &entry), line)
+		logs = append(logs, entry)
+	}
+	return logs
+}
+
+func TestConfigure(t *testing.T) {
+	cases := []struct {
+		level    string
+		expected []string
+		err      error
+	}{
+		{"debug", []string{"DEBUG", "INFO", "ERROR"}, nil},
+		{"", []string{"INFO", "ERROR"}, nil},
+		{"INFO", []string{"INFO", "ERROR"}, nil},
+		{"warn", []string{"ERROR"}, nil},
+		{"error", []string{"ERROR"}, nil},
+		{"verbose", nil, consts.ErrInvalidLogLevel},
+	}
+
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		err := Configure(out, Config{Level: c.level})
+		assert.Equal(t, c.err, err, c.level)
+		if err != nil {
+			continue
+		}
+		Debug("Test -", "debug")
+		Info("Test -", "info")
+		Error("Test -", "error")
+
+		var levels []string
+		for _, entry := range entries(t, out) {
+			levels = append(levels, entry["level"].(string))
+			assert.Equal(t, "Test", entry["tag"], c.level)
+		}
+		assert.Equal(t, c.expected, levels, c.level)
+	}
+	assert.Nil(t, Configure(os.Stderr, Config{Level: "info", Redact: DefaultRedact}))
+}
+
+func TestValue(t *testing.T) {
+	doc := &pbdoc.Document{
+		Duid:          "duid",
+		PublisherName: &pbdoc.Publisher{FirstName: "Lisa", LastName: "Kim"},
+		Description:   "A whale",
+	}
+
+	cases := []struct {
+		redact    []string
+		publisher interface{}
+		desc      interface{}
+	}{
+		{DefaultRedact, Redacted, "A whale"},
+		{[]string{"last_name"}, map[string]interface{}{"first_name": "Lisa", "last_name": Redacted}, "A whale"},
+		{[]string{"description", "PUBLISHERNAME"}, Redacted, Redacted},
+		{nil, map[string]interface{}{"first_name": "Lisa", "last_name": "Kim"}, "A whale"},
+	}
+
+	for _, c := range cases {
+		out := capture(t, Config{Redact: c.redact})
+		InfoContext(context.Background(), "Test -", "Document", Value("document", doc))
+
+		logs := entries(t, out)
+		if !assert.Len(t, logs, 1) {
+			continue
+		}
+		document := logs[0]["document"].(map[string]interface{})
+		assert.Equal(t, "duid", document["duid"])
+		assert.Equal(t, c.publisher, document["publisher_name"], c.redact)
+		assert.Equal(t, c.desc, document["description"], c.redact)
+	}
+}
+
+func TestRedactAttribute(t *testing.T) {
+	out := capture(t, Config{Redact: []string{"caller"}})
+	ctx := withRequest(context.Background(), &request{id: "id", method: "/test", caller: "uuid"})
+	InfoContext(ctx, "Test -", "Call")
+
+	logs := entries(t, out)
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, Redacted, logs[0]["caller"])
+		assert.Equal(t, "id", logs[0]["request_id"])
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	const method = "/document.DocumentService/DeleteDocument"
+	req := &pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: "duid"}}
+
+	cases := []struct {
+		requestID string
+		err       error
+		level     string
+		code      string
+		keepID    bool
+	}{
+		{"trace-1234", nil, "INFO", "OK", true},
+		{"", status.Error(codes.NotFound, "not found"), "WARN", "NotFound", false},
+		{"bad id\n", status.Error(codes.Internal, "failed"), "ERROR", "Internal", false},
+		{strings.Repeat("a", maxRequestIDLength+1), nil, "INFO", "OK", false},
+	}
+
+	for _, c := range cases {
+		out := capture(t, Config{})
+		ctx := context.Background()
+		if c.requestID != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDKey, c.requestID))
+		}
+
+		var requestID string
+		_, err := UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				requestID = RequestID(ctx)
+				SetCaller(ctx, "uuid")
+				InfoContext(ctx, "Test -", "Handling")
+				return nil, c.err
+			})
+		assert.Equal(t, c.err, err)
+
+		if c.keepID {
+			assert.Equal(t, c.requestID, requestID)
+		} else {
+			_, parseErr := uuid.Parse(requestID)
+			assert.Nil(t, parseErr, requestID)
+		}
+
+		logs := entries(t, out)
+		if !assert.Len(t, logs, 2) {
+			continue
+		}
+		for _, entry := range logs {
+			assert.Equal(t, requestID, entry["request_id"])
+			assert.Equal(t, method, entry["method"])
+			assert.Equal(t, "uuid", entry["caller"])
+			assert.Equal(t, "duid", entry["duid"])
+		}
+		call := logs[1]
+		assert.Equal(t, "gRPC", call["tag"])
+		assert.Equal(t, c.level, call["level"])
+		assert.Equal(t, c.code, call["code"])
+		assert.Contains(t, call, "duration_ms")
+		if c.err != nil {
+			assert.Equal(t, status.Convert(c.err).Message(), call["error"])
+		}
+	}
+}
+
+func TestSuccessSampling(t *testing.T) {
+	cases := []struct {
+		sampling int
+		failures bool
+		expected int
+	}{
+		{0, false, 10},
+		{1, false, 10},
+		{3, false, 4},
+		{3, true, 10},
+	}
+
+	for _, c := range cases {
+		out := capture(t, Config{SuccessSampling: c.sampling})
+		var err error
+		if c.failures {
+			err = status.Error(codes.InvalidArgument, "invalid")
+		}
+		for i := 0; i < 10; i++ {
+			_, _ = UnaryServerInterceptor()(context.Background(), nil,
+				&grpc.UnaryServerInfo{FullMethod: "/document.DocumentService/QueryDocument"},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					return nil, err
+				})
+		}
+		assert.Len(t, entries(t, out), c.expected, c.sampling)
+	}
+}
+
+func TestRequestDUID(t *testing.T) {
+	cases := []struct {
+		req  interface{}
+		duid string
+	}{
+		{&pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: "duid"}}, "duid"},
+		{&pbsvc.DocumentRequest{FileMetadataParameters: &pbdoc.FileMetadataTransaction{Duid: "duid"}}, "duid"},
+		{&pbdoc.Document{Duid: "duid"}, "duid"},
+		{&pbsvc.DocumentRequest{}, ""},
+		{nil, ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.duid, requestDUID(c.req))
+	}
+}
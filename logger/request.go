@@ -0,0 +1,78 @@
+package logger
+
+import (
+	"golang.org/x/net/context"
+	"log/slog"
+	"sync"
+)
+
+// request holds the fields of a call, logged with every log of its context
+type request struct {
+	id     string
+	method string
+
+	// The caller and duid are known once the call is authenticated and its Document is made
+	mu     sync.Mutex
+	caller string
+	duid   string
+}
+
+// requestKey is the context key of the request of a call
+type requestKey struct{}
+
+// withRequest returns the context holding the request.
+func withRequest(ctx context.Context, r *request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// requestOf returns the request of the call of ctx, nil if ctx is not a call.
+func requestOf(ctx context.Context) *request {
+	r, _ := ctx.Value(requestKey{}).(*request)
+	return r
+}
+
+// RequestID returns the request ID of the call of ctx, empty if ctx is not a call.
+func RequestID(ctx context.Context) string {
+	if r := requestOf(ctx); r != nil {
+		return r.id
+	}
+	return ""
+}
+
+// SetCaller records the UUID of the authenticated caller of the call of ctx.
+// Does nothing if ctx is not a call.
+func SetCaller(ctx context.Context, uuid string) {
+	if r := requestOf(ctx); r != nil {
+		r.mu.Lock()
+		r.caller = uuid
+		r.mu.Unlock()
+	}
+}
+
+// SetDUID records the duid of the Document of the call of ctx.
+// Does nothing if ctx is not a call.
+func SetDUID(ctx context.Context, duid string) {
+	if r := requestOf(ctx); r != nil {
+		r.mu.Lock()
+		r.duid = duid
+		r.mu.Unlock()
+	}
+}
+
+// attrs returns the non empty fields of the request, none for a nil request.
+func (r *request) attrs() []slog.Attr {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attrs := []slog.Attr{slog.String("request_id", r.id), slog.String("method", r.method)}
+	if r.caller != "" {
+		attrs = append(attrs, slog.String("caller", r.caller))
+	}
+	if r.duid != "" {
+		attrs = append(attrs, slog.String("duid", r.duid))
+	}
+	return attrs
+}
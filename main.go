@@ -1,35 +1,301 @@
 package main
 
 import (
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/certs"
 	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/metrics"
+	"github.com/hwsc-org/hwsc-document-svc/middleware"
+	"github.com/hwsc-org/hwsc-document-svc/ratelimit"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"strings"
 
 	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
 	svc "github.com/hwsc-org/hwsc-document-svc/service"
-	log "github.com/hwsc-org/hwsc-lib/logger"
 )
 
 func main() {
+	// Write the logs as configured
+	if err := log.Configure(os.Stderr, log.Config{
+		Level:           conf.Logging.Level,
+		Redact:          conf.Logging.Redact,
+		SuccessSampling: conf.Logging.SuccessSampling,
+	}); err != nil {
+		log.Fatal(consts.DocumentServiceTag, "Failed to configure the logs:", err.Error())
+	}
+
+	// Run the migrations of the database instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:], os.Stdout))
+	}
 	log.Info(consts.DocumentServiceTag, "hwsc-document-svc initiating...")
 
+	// Apply the pending migrations if configured, and refuse to serve a schema of a newer release
+	if err := svc.CheckMigrations(conf.Migrations.Auto); err != nil {
+		log.Fatal(consts.MigrationsTag, "Failed to check the migrations:", err.Error())
+	}
+
+	// Export the spans of the service, flushing the last ones on exit
+	if shutdown := initTracing(); shutdown != nil {
+		defer shutdown()
+	}
+
 	// Make TCP listener
 	lis, err := net.Listen(conf.GRPCHost.Network, conf.GRPCHost.String())
 	if err != nil {
 		log.Fatal(consts.DocumentServiceTag, "Failed to initialize TCP listener:", err.Error())
 	}
 
-	// Make gRPC server
-	s := grpc.NewServer()
+	// Make gRPC server over TLS if configured, authenticating every call but the status checks
+	s := grpc.NewServer(serverOptions()...)
 
 	// Implement services in /service/service.go
 	// Register service with gRPC server
 	pbsvc.RegisterDocumentServiceServer(s, &svc.Service{})
+	pbext.RegisterDocumentExtensionServiceServer(s, &svc.ExtensionService{})
 	log.Info(consts.DocumentServiceTag, "hwsc-document-svc started at:", conf.GRPCHost.String())
 
+	// Check the stored file urls in the background
+	svc.StartLinkScanner()
+
+	// Publish the Documents whose embargo passed in the background
+	svc.StartEmbargoScheduler()
+
+	// Create the search index mirroring the Documents
+	if err := svc.StartSearchIndexer(); err != nil {
+		log.Fatal(consts.SearchTag, "Failed to start the indexer:", err.Error())
+	}
+
+	// Deliver the change events of Documents in the background
+	if err := svc.StartOutboxDispatcher(); err != nil {
+		log.Fatal(consts.OutboxTag, "Failed to open the event sinks:", err.Error())
+	}
+
+	// Deliver the change events to the webhook subscriptions in the background
+	svc.StartWebhookDispatcher()
+
+	// Serve the metrics over HTTP
+	serveMetrics()
+
 	// Start gRPC server
 	if err := s.Serve(lis); err != nil {
 		log.Fatal(consts.DocumentServiceTag, "Failed to serve:", err.Error())
 	}
 }
+
+// migrateUsage describes the migrate subcommand
+const migrateUsage = `usage: hwsc-document-svc migrate <command>
+  up             applies the pending migrations
+  down [N]       reverts the last N migrations (default 1)
+  status         prints the version of the database and the pending migrations
+  force VERSION  sets the version without migrating and clears the dirty flag, -1 for none`
+
+// runMigrate runs the migrate subcommand with its arguments, printing its result to out.
+// Returns the exit code: 0 on success, 1 if the command failed, 2 if the arguments are invalid.
+func runMigrate(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, migrateUsage)
+		return 2
+	}
+
+	var err error
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = svc.MigrateUp()
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(out, "invalid number of migrations:", args[1])
+				return 2
+			}
+		}
+		err = svc.MigrateDown(steps)
+	case args[0] == "force" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < -1 {
+			fmt.Fprintln(out, "invalid version:", args[1])
+			return 2
+		}
+		err = svc.ForceMigration(version)
+	case args[0] == "status" && len(args) == 1:
+	default:
+		fmt.Fprintln(out, migrateUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(out, "migrate", args[0], "failed:", err.Error())
+		return 1
+	}
+
+	status, err := svc.ReadMigrationStatus()
+	if err != nil {
+		fmt.Fprintln(out, "failed to read the version:", err.Error())
+		return 1
+	}
+	dirty := ""
+	if status.Dirty {
+		dirty = " (dirty)"
+	}
+	fmt.Fprintf(out, "version %d%s, latest %d, %d pending\n", status.Version, dirty, status.Latest, status.Pending)
+	return 0
+}
+
+// exemptMethods are the health and status checks callable without a token
+var exemptMethods = []string{
+	"/document.DocumentService/GetStatus",
+	"/grpc.health.v1.Health/Check",
+	"/grpc.health.v1.Health/Watch",
+}
+
+// serverOptions returns the TLS credentials and the interceptors of the gRPC server,
+// measuring, tracing, and logging every call, and authorizing client certificates and authenticating calls
+// before limiting them by caller and auditing the mutations of Documents.
+func serverOptions() []grpc.ServerOption {
+	var options []grpc.ServerOption
+	creds := transportCredentials()
+	if creds != nil {
+		options = append(options, grpc.Creds(creds))
+	}
+
+	unary := []grpc.UnaryServerInterceptor{
+		metrics.UnaryServerInterceptor(), tracing.UnaryServerInterceptor(), log.UnaryServerInterceptor(),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		metrics.StreamServerInterceptor(), tracing.StreamServerInterceptor(), log.StreamServerInterceptor(),
+	}
+	if authorizer := newSubjectAuthorizer(creds != nil); authorizer != nil {
+		unary = append(unary, authorizer.UnaryServerInterceptor(exemptMethods...))
+		stream = append(stream, authorizer.StreamServerInterceptor(exemptMethods...))
+	}
+	if authenticator := newAuthenticator(); authenticator != nil {
+		unary = append(unary, authenticator.UnaryServerInterceptor(exemptMethods...))
+		stream = append(stream, authenticator.StreamServerInterceptor(exemptMethods...))
+	}
+	if limiter := newLimiter(); limiter != nil {
+		unary = append(unary, limiter.UnaryServerInterceptor(exemptMethods...))
+		stream = append(stream, limiter.StreamServerInterceptor(exemptMethods...))
+	}
+	// Audit the calls mutating Documents once their caller is known
+	unary = append(unary, svc.AuditUnaryServerInterceptor())
+	return append(options,
+		grpc.UnaryInterceptor(middleware.ChainUnary(unary...)),
+		grpc.StreamInterceptor(middleware.ChainStream(stream...)),
+	)
+}
+
+// transportCredentials returns the TLS credentials of the gRPC server, reloading the certificate when its files change.
+// Returns nil if TLS is disabled.
+func transportCredentials() credentials.TransportCredentials {
+	if conf.TLS.CertFile == "" && conf.TLS.KeyFile == "" {
+		log.Info(consts.TLSTag, "TLS disabled, serving plaintext")
+		return nil
+	}
+	reloader, err := certs.New(conf.TLS.CertFile, conf.TLS.KeyFile, conf.TLS.ClientCAFile)
+	if err != nil {
+		log.Fatal(consts.TLSTag, "Failed to load the certificate:", err.Error())
+	}
+	reloader.Watch(conf.TLS.ReloadInterval)
+	if conf.TLS.ClientCAFile != "" {
+		log.Info(consts.TLSTag, "Verifying client certificates with", conf.TLS.ClientCAFile)
+	}
+	return credentials.NewTLS(reloader.ServerConfig(conf.TLS.ClientCertOptional))
+}
+
+// newAuthenticator returns the authenticator of the calls.
+// Returns nil if authentication is disabled.
+func newAuthenticator() *auth.Authenticator {
+	if conf.Auth.Disabled {
+		log.Info(consts.AuthTag, "Authentication disabled, every call is accepted")
+		return nil
+	}
+	keys, err := auth.LoadKeys(conf.Auth.Secrets, conf.Auth.RSAKeys, conf.Auth.JWKSFile)
+	if err != nil {
+		log.Fatal(consts.AuthTag, "Failed to load token verifying keys:", err.Error())
+	}
+	authenticator, err := auth.New(keys, conf.Auth.Issuer, conf.Auth.Audience)
+	if err != nil {
+		log.Fatal(consts.AuthTag, "Failed to initialize authentication:", err.Error())
+	}
+	return authenticator
+}
+
+// newSubjectAuthorizer returns the authorizer of the client certificates of the calls, if tls is set.
+// Returns nil if the calls are served plaintext.
+func newSubjectAuthorizer(tls bool) *auth.SubjectAuthorizer {
+	if len(conf.Auth.CertSubjects) > 0 && (!tls || conf.TLS.ClientCAFile == "") {
+		log.Fatal(consts.AuthTag, consts.ErrSubjectsWithoutClientCA.Error())
+	}
+	if !tls {
+		return nil
+	}
+	if len(conf.Auth.CertSubjects) > 0 {
+		log.Info(consts.AuthTag, "Allowing the client certificates of", strings.Join(conf.Auth.CertSubjects, "; "))
+	}
+	return auth.NewSubjectAuthorizer(conf.Auth.CertSubjects)
+}
+
+// newLimiter returns the rate limiter of the calls by caller.
+// Returns nil if rate limiting is disabled.
+func newLimiter() *ratelimit.Limiter {
+	methods, err := ratelimit.ParseLimits(conf.RateLimit.Methods)
+	if err != nil {
+		log.Fatal(consts.RateLimitTag, "Failed to read the method limits:", err.Error())
+	}
+	if conf.RateLimit.Rate <= 0 && len(methods) == 0 {
+		log.Info(consts.RateLimitTag, "Rate limiting disabled")
+		return nil
+	}
+	return ratelimit.New(ratelimit.Limit{Rate: conf.RateLimit.Rate, Burst: conf.RateLimit.Burst}, methods)
+}
+
+// serveMetrics serves the Prometheus metrics on the configured address in the background.
+// Does nothing if the metrics endpoint is disabled.
+func serveMetrics() {
+	metrics.SetURLHosts(conf.Metrics.URLHosts)
+	if conf.Metrics.Address == "" {
+		log.Info(consts.MetricsTag, "Metrics endpoint disabled")
+		return
+	}
+	lis, err := metrics.Serve(conf.Metrics.Address)
+	if err != nil {
+		log.Fatal(consts.MetricsTag, "Failed to serve the metrics:", err.Error())
+	}
+	log.Info(consts.MetricsTag, "Serving metrics at:", lis.Addr().String()+metrics.Path)
+}
+
+// initTracing installs the configured exporter of the spans of the service.
+// Returns the func flushing the last spans, or nil if tracing is disabled.
+func initTracing() func() {
+	switch conf.Tracing.Exporter {
+	case "", "none":
+		log.Info(consts.TracingTag, "Span export disabled")
+		return nil
+	case "otlp":
+	default:
+		log.Fatal(consts.TracingTag, "Unknown span exporter:", conf.Tracing.Exporter)
+	}
+
+	exporter, err := tracing.NewOTLPExporter(context.Background(), conf.Tracing.Endpoint, conf.Tracing.Insecure)
+	if err != nil {
+		log.Fatal(consts.TracingTag, "Failed to initialize the OTLP exporter:", err.Error())
+	}
+	provider := tracing.Install(exporter, conf.Tracing.SampleRatio)
+	log.Info(consts.TracingTag, "Exporting spans over OTLP to:", conf.Tracing.Endpoint)
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			log.Error(consts.TracingTag, "Failed to flush the spans:", err.Error())
+		}
+	}
+}
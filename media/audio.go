@@ -0,0 +1,140 @@
+// Package media reads the properties of media files from their first bytes.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+)
+
+// AudioInfo is what an audio header tells about the recording
+type AudioInfo struct {
+	// SampleRate in Hertz
+	SampleRate uint32
+	Channels   uint32
+	// BitDepth in bits per sample, 0 for lossy formats
+	BitDepth uint32
+	// Duration in seconds, 0 if the header does not tell
+	Duration float64
+}
+
+// ParseAudioHeader reads the header of a WAV, FLAC, or OGG (Vorbis, Opus) file.
+// size is the length of the whole file, or 0 if unknown.
+// Returns consts.ErrUnsupportedAudioFormat if the format is not recognized,
+// or consts.ErrMalformedAudioHeader if the header is truncated or invalid.
+func ParseAudioHeader(head []byte, size int64) (*AudioInfo, error) {
+	switch {
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		return parseWAV(head, size)
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return parseFLAC(head)
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return parseOGG(head)
+	}
+	return nil, consts.ErrUnsupportedAudioFormat
+}
+
+// parseWAV walks the RIFF chunks for the fmt and data chunks.
+// The duration is estimated from the file size if the data chunk is past the bytes read.
+func parseWAV(head []byte, size int64) (*AudioInfo, error) {
+	var info *AudioInfo
+	var byteRate uint32
+	offset := 12
+	for offset+8 <= len(head) {
+		id := string(head[offset : offset+4])
+		chunkSize := binary.LittleEndian.Uint32(head[offset+4 : offset+8])
+		body := offset + 8
+
+		switch id {
+		case "fmt ":
+			if chunkSize < 16 || body+16 > len(head) {
+				return nil, consts.ErrMalformedAudioHeader
+			}
+			info = &AudioInfo{
+				Channels:   uint32(binary.LittleEndian.Uint16(head[body+2:])),
+				SampleRate: binary.LittleEndian.Uint32(head[body+4:]),
+				BitDepth:   uint32(binary.LittleEndian.Uint16(head[body+14:])),
+			}
+			byteRate = binary.LittleEndian.Uint32(head[body+8:])
+		case "data":
+			if info == nil {
+				return nil, consts.ErrMalformedAudioHeader
+			}
+			if byteRate > 0 {
+				info.Duration = float64(chunkSize) / float64(byteRate)
+			}
+			return validAudioInfo(info)
+		}
+
+		// Chunks are padded to an even size
+		offset = body + int(chunkSize) + int(chunkSize&1)
+	}
+
+	if info == nil {
+		return nil, consts.ErrMalformedAudioHeader
+	}
+	if byteRate > 0 && size > int64(offset) {
+		info.Duration = float64(size-int64(offset)) / float64(byteRate)
+	}
+	return validAudioInfo(info)
+}
+
+// parseFLAC reads the STREAMINFO block, always the first metadata block.
+func parseFLAC(head []byte) (*AudioInfo, error) {
+	const streamInfo = 8
+	if len(head) < streamInfo+18 || head[4]&0x7F != 0 {
+		return nil, consts.ErrMalformedAudioHeader
+	}
+
+	// 20 bits sample rate, 3 bits channels - 1, 5 bits bits per sample - 1, 36 bits total samples
+	packed := binary.BigEndian.Uint64(head[streamInfo+10 : streamInfo+18])
+	info := &AudioInfo{
+		SampleRate: uint32(packed >> 44),
+		Channels:   uint32((packed>>41)&0x07) + 1,
+		BitDepth:   uint32((packed>>36)&0x1F) + 1,
+	}
+	if samples := packed & 0xFFFFFFFFF; samples > 0 && info.SampleRate > 0 {
+		info.Duration = float64(samples) / float64(info.SampleRate)
+	}
+	return validAudioInfo(info)
+}
+
+// parseOGG reads the identification header in the first page.
+// The duration is only known from the last page, so it is not reported.
+func parseOGG(head []byte) (*AudioInfo, error) {
+	if len(head) < 27 {
+		return nil, consts.ErrMalformedAudioHeader
+	}
+	packet := 27 + int(head[26])
+	if packet > len(head) {
+		return nil, consts.ErrMalformedAudioHeader
+	}
+	p := head[packet:]
+
+	switch {
+	case bytes.HasPrefix(p, []byte("\x01vorbis")):
+		if len(p) < 16 {
+			return nil, consts.ErrMalformedAudioHeader
+		}
+		return validAudioInfo(&AudioInfo{
+			Channels:   uint32(p[11]),
+			SampleRate: binary.LittleEndian.Uint32(p[12:16]),
+		})
+	case bytes.HasPrefix(p, []byte("OpusHead")):
+		if len(p) < 16 {
+			return nil, consts.ErrMalformedAudioHeader
+		}
+		return validAudioInfo(&AudioInfo{
+			Channels:   uint32(p[9]),
+			SampleRate: binary.LittleEndian.Uint32(p[12:16]),
+		})
+	}
+	return nil, consts.ErrUnsupportedAudioFormat
+}
+
+func validAudioInfo(info *AudioInfo) (*AudioInfo, error) {
+	if info.SampleRate == 0 || info.Channels == 0 {
+		return nil, consts.ErrMalformedAudioHeader
+	}
+	return info, nil
+}
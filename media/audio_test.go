@@ -0,0 +1,61 @@
+package media
+
+import (
+	"encoding/binary"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// wavHeader builds the header of a PCM WAV file, with an odd sized chunk before the data chunk.
+func wavHeader(sampleRate uint32, channels uint16, bitDepth uint16, dataSize uint32) []byte {
+	le := binary.LittleEndian
+	blockAlign := channels * bitDepth / 8
+
+	head := []byte("RIFF\x00\x00\x00\x00WAVEfmt \x10\x00\x00\x00")
+	head = append(head, make([]byte, 16)...)
+	le.PutUint16(head[20:], 1)
+	le.PutUint16(head[22:], channels)
+	le.PutUint32(head[24:], sampleRate)
+	le.PutUint32(head[28:], sampleRate*uint32(blockAlign))
+	le.PutUint16(head[32:], blockAlign)
+	le.PutUint16(head[34:], bitDepth)
+	head = append(head, []byte("junk\x03\x00\x00\x00abc\x00")...)
+	head = append(head, []byte("data\x00\x00\x00\x00")...)
+	le.PutUint32(head[len(head)-4:], dataSize)
+	return head
+}
+
+func TestParseAudioHeader(t *testing.T) {
+	flac := []byte("fLaC\x80\x00\x00\x22\x10\x00\x10\x00\x00\x00\x00\x00\x00\x00" +
+		"\x0A\xC4\x42\xF0\x00\x01\x58\x88")
+	vorbis := []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x1e\x01vorbis\x00\x00\x00\x00\x02\x80\xbb\x00\x00")
+	opus := []byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x13OpusHead\x01\x01\x38\x01\x80\xbb\x00\x00")
+
+	cases := []struct {
+		desc     string
+		head     []byte
+		size     int64
+		expected *AudioInfo
+		err      error
+	}{
+		{"wav", wavHeader(44100, 2, 16, 176400), 0, &AudioInfo{44100, 2, 16, 1}, nil},
+		{"wav data chunk past head", wavHeader(8000, 1, 16, 0)[:48], 16048, &AudioInfo{8000, 1, 16, 1}, nil},
+		{"wav truncated fmt", wavHeader(8000, 1, 16, 0)[:24], 0, nil, consts.ErrMalformedAudioHeader},
+		{"wav zero sample rate", wavHeader(0, 1, 16, 0), 0, nil, consts.ErrMalformedAudioHeader},
+		{"flac", flac, 0, &AudioInfo{44100, 2, 16, 2}, nil},
+		{"flac truncated", flac[:20], 0, nil, consts.ErrMalformedAudioHeader},
+		{"vorbis", vorbis, 0, &AudioInfo{48000, 2, 0, 0}, nil},
+		{"opus", opus, 0, &AudioInfo{48000, 1, 0, 0}, nil},
+		{"mp3", []byte("ID3\x03\x00\x00\x00\x00"), 0, nil, consts.ErrUnsupportedAudioFormat},
+		{"empty", nil, 0, nil, consts.ErrUnsupportedAudioFormat},
+	}
+
+	for _, c := range cases {
+		info, err := ParseAudioHeader(c.head, c.size)
+		assert.Equal(t, c.err, err, c.desc)
+		assert.Equal(t, c.expected, info, c.desc)
+	}
+}
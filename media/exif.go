@@ -0,0 +1,259 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"strings"
+	"time"
+)
+
+const (
+	// TIFF tags of the EXIF and GPS IFDs read
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+
+	// TIFF field types read
+	typeASCII    = 2
+	typeLong     = 4
+	typeRational = 5
+
+	exifTimeLayout = "2006:01:02 15:04:05"
+	// Bounds the entries of a malformed IFD
+	maxIFDEntries = 512
+)
+
+// ImageInfo is what the EXIF metadata of an image tells about the capture
+type ImageInfo struct {
+	// CaptureTimestamp in seconds, 0 if unknown.
+	// A capture time without offset is taken as UTC.
+	CaptureTimestamp int64
+	// HasLocation is set if the image carries GPS coordinates
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// ParseEXIF reads the capture time and GPS coordinates of a JPEG or TIFF file.
+// Returns consts.ErrNoEXIF if the image carries no EXIF metadata,
+// or consts.ErrMalformedEXIF if the metadata is truncated or invalid.
+func ParseEXIF(head []byte) (*ImageInfo, error) {
+	if bytes.HasPrefix(head, []byte("\xFF\xD8")) {
+		tiff, err := findJPEGExif(head)
+		if err != nil {
+			return nil, err
+		}
+		return parseTIFF(tiff)
+	}
+	if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+		return parseTIFF(head)
+	}
+	return nil, consts.ErrNoEXIF
+}
+
+// findJPEGExif walks the JPEG segments up to the image data for the APP1 EXIF segment.
+// Returns the TIFF structure inside the segment.
+func findJPEGExif(head []byte) ([]byte, error) {
+	offset := 2
+	for offset+4 <= len(head) {
+		if head[offset] != 0xFF {
+			return nil, consts.ErrMalformedEXIF
+		}
+		marker := head[offset+1]
+		// Start of scan, the metadata segments are all before
+		if marker == 0xDA {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(head[offset+2:]))
+		if length < 2 {
+			return nil, consts.ErrMalformedEXIF
+		}
+		segment := offset + 4
+		end := offset + 2 + length
+		if marker == 0xE1 && segment+6 <= len(head) && bytes.Equal(head[segment:segment+6], []byte("Exif\x00\x00")) {
+			// A segment cut by the bytes read still holds the IFDs near its start
+			if end > len(head) {
+				end = len(head)
+			}
+			return head[segment+6 : end], nil
+		}
+		offset = end
+	}
+	return nil, consts.ErrNoEXIF
+}
+
+// tiffReader reads the IFDs of a TIFF structure
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// ifdEntry is a field of an IFD, value holds the inline value or the offset to it
+type ifdEntry struct {
+	fieldType uint16
+	count     uint32
+	value     []byte
+}
+
+func parseTIFF(data []byte) (*ImageInfo, error) {
+	if len(data) < 8 {
+		return nil, consts.ErrMalformedEXIF
+	}
+	r := &tiffReader{data: data}
+	switch string(data[:2]) {
+	case "II":
+		r.order = binary.LittleEndian
+	case "MM":
+		r.order = binary.BigEndian
+	default:
+		return nil, consts.ErrMalformedEXIF
+	}
+	if r.order.Uint16(data[2:]) != 42 {
+		return nil, consts.ErrMalformedEXIF
+	}
+
+	ifd0, err := r.readIFD(r.order.Uint32(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	info := &ImageInfo{}
+
+	var captureTime, offsetTime string
+	if e, ok := ifd0[tagDateTime]; ok {
+		captureTime = r.ascii(e)
+	}
+	if e, ok := ifd0[tagExifIFD]; ok {
+		exif, err := r.readIFD(r.long(e))
+		if err != nil {
+			return nil, err
+		}
+		if e, ok := exif[tagDateTimeOriginal]; ok {
+			captureTime = r.ascii(e)
+		}
+		if e, ok := exif[tagOffsetTimeOriginal]; ok {
+			offsetTime = r.ascii(e)
+		}
+	}
+	info.CaptureTimestamp = parseEXIFTime(captureTime, offsetTime)
+
+	if e, ok := ifd0[tagGPSIFD]; ok {
+		gps, err := r.readIFD(r.long(e))
+		if err != nil {
+			return nil, err
+		}
+		lat, latOK := r.degrees(gps[tagGPSLatitude])
+		lon, lonOK := r.degrees(gps[tagGPSLongitude])
+		if latOK && lonOK {
+			if strings.HasPrefix(r.ascii(gps[tagGPSLatitudeRef]), "S") {
+				lat = -lat
+			}
+			if strings.HasPrefix(r.ascii(gps[tagGPSLongitudeRef]), "W") {
+				lon = -lon
+			}
+			info.HasLocation = true
+			info.Latitude = lat
+			info.Longitude = lon
+		}
+	}
+
+	if info.CaptureTimestamp == 0 && !info.HasLocation {
+		return nil, consts.ErrNoEXIF
+	}
+	return info, nil
+}
+
+// readIFD reads the entries of the IFD at the given offset, by tag.
+func (r *tiffReader) readIFD(offset uint32) (map[uint16]*ifdEntry, error) {
+	if int(offset)+2 > len(r.data) {
+		return nil, consts.ErrMalformedEXIF
+	}
+	count := int(r.order.Uint16(r.data[offset:]))
+	if count > maxIFDEntries || int(offset)+2+count*12 > len(r.data) {
+		return nil, consts.ErrMalformedEXIF
+	}
+
+	entries := make(map[uint16]*ifdEntry, count)
+	for i := 0; i < count; i++ {
+		e := r.data[int(offset)+2+i*12:]
+		entries[r.order.Uint16(e)] = &ifdEntry{
+			fieldType: r.order.Uint16(e[2:]),
+			count:     r.order.Uint32(e[4:]),
+			value:     e[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// valueBytes returns the value of an entry, following the offset of values longer than 4 bytes.
+func (r *tiffReader) valueBytes(e *ifdEntry, size int) []byte {
+	n := int(e.count) * size
+	if n <= 4 {
+		return e.value[:n]
+	}
+	offset := int(r.order.Uint32(e.value))
+	if offset < 0 || offset+n > len(r.data) {
+		return nil
+	}
+	return r.data[offset : offset+n]
+}
+
+func (r *tiffReader) ascii(e *ifdEntry) string {
+	if e == nil || e.fieldType != typeASCII {
+		return ""
+	}
+	return strings.TrimRight(string(r.valueBytes(e, 1)), "\x00 ")
+}
+
+func (r *tiffReader) long(e *ifdEntry) uint32 {
+	if e.fieldType != typeLong || e.count != 1 {
+		return 0
+	}
+	return r.order.Uint32(e.value)
+}
+
+// degrees converts the degrees, minutes, and seconds rationals of a GPS coordinate.
+func (r *tiffReader) degrees(e *ifdEntry) (float64, bool) {
+	if e == nil || e.fieldType != typeRational || e.count != 3 {
+		return 0, false
+	}
+	b := r.valueBytes(e, 8)
+	if b == nil {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num := r.order.Uint32(b[i*8:])
+		den := r.order.Uint32(b[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	return parts[0] + parts[1]/60 + parts[2]/3600, true
+}
+
+// parseEXIFTime parses "2006:01:02 15:04:05" with an optional "+07:00" offset.
+// Returns 0 if the time is missing or invalid.
+func parseEXIFTime(value string, offset string) int64 {
+	if value == "" {
+		return 0
+	}
+	loc := time.UTC
+	if offset != "" {
+		if t, err := time.Parse("-07:00", offset); err == nil {
+			loc = t.Location()
+		}
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, value, loc)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
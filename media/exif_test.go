@@ -0,0 +1,137 @@
+package media
+
+import (
+	"encoding/binary"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+// tiffField is an IFD entry to build, value is written after the IFDs when longer than 4 bytes
+type tiffField struct {
+	tag       uint16
+	fieldType uint16
+	count     uint32
+	value     []byte
+}
+
+// buildEXIF builds a TIFF structure with IFD0 pointing to an EXIF IFD and a GPS IFD.
+func buildEXIF(order binary.ByteOrder, exif []tiffField, gps []tiffField) []byte {
+	ifdSize := func(fields []tiffField) int { return 2 + len(fields)*12 + 4 }
+	ifd0 := []tiffField{
+		{tagExifIFD, typeLong, 1, nil},
+		{tagGPSIFD, typeLong, 1, nil},
+	}
+	exifOffset := 8 + ifdSize(ifd0)
+	gpsOffset := exifOffset + ifdSize(exif)
+	ifd0[0].value = make([]byte, 4)
+	order.PutUint32(ifd0[0].value, uint32(exifOffset))
+	ifd0[1].value = make([]byte, 4)
+	order.PutUint32(ifd0[1].value, uint32(gpsOffset))
+
+	data := make([]byte, 8)
+	if order == binary.LittleEndian {
+		copy(data, "II")
+	} else {
+		copy(data, "MM")
+	}
+	order.PutUint16(data[2:], 42)
+	order.PutUint32(data[4:], 8)
+
+	var values []byte
+	valuesOffset := gpsOffset + ifdSize(gps)
+	for _, fields := range [][]tiffField{ifd0, exif, gps} {
+		ifd := make([]byte, ifdSize(fields))
+		order.PutUint16(ifd, uint16(len(fields)))
+		for i, f := range fields {
+			e := ifd[2+i*12:]
+			order.PutUint16(e, f.tag)
+			order.PutUint16(e[2:], f.fieldType)
+			order.PutUint32(e[4:], f.count)
+			if len(f.value) <= 4 {
+				copy(e[8:], f.value)
+				continue
+			}
+			order.PutUint32(e[8:], uint32(valuesOffset+len(values)))
+			values = append(values, f.value...)
+		}
+		data = append(data, ifd...)
+	}
+	return append(data, values...)
+}
+
+func asciiField(tag uint16, s string) tiffField {
+	return tiffField{tag, typeASCII, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func degreesField(order binary.ByteOrder, tag uint16, deg uint32, min uint32, secHundredths uint32) tiffField {
+	b := make([]byte, 24)
+	for i, r := range [][2]uint32{{deg, 1}, {min, 1}, {secHundredths, 100}} {
+		order.PutUint32(b[i*8:], r[0])
+		order.PutUint32(b[i*8+4:], r[1])
+	}
+	return tiffField{tag, typeRational, 3, b}
+}
+
+// jpegWithEXIF wraps a TIFF structure in the APP1 segment of a JPEG, after an APP0 segment.
+func jpegWithEXIF(tiff []byte) []byte {
+	app0 := []byte("\xFF\xE0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	app1 := []byte("\xFF\xE1\x00\x00Exif\x00\x00")
+	binary.BigEndian.PutUint16(app1[2:], uint16(2+6+len(tiff)))
+	jpeg := append([]byte("\xFF\xD8"), app0...)
+	jpeg = append(jpeg, app1...)
+	jpeg = append(jpeg, tiff...)
+	return append(jpeg, []byte("\xFF\xDA\x00\x02\xFF\xD9")...)
+}
+
+func TestParseEXIF(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		exif := []tiffField{
+			asciiField(tagDateTimeOriginal, "2014:03:13 11:23:13"),
+			asciiField(tagOffsetTimeOriginal, "-07:00"),
+		}
+		gps := []tiffField{
+			asciiField(tagGPSLatitudeRef, "N"),
+			degreesField(order, tagGPSLatitude, 22, 53, 2400),
+			asciiField(tagGPSLongitudeRef, "W"),
+			degreesField(order, tagGPSLongitude, 109, 54, 3600),
+		}
+		tiff := buildEXIF(order, exif, gps)
+
+		for _, head := range [][]byte{tiff, jpegWithEXIF(tiff)} {
+			info, err := ParseEXIF(head)
+			assert.Nil(t, err)
+			// 2014-03-13 18:23:13 UTC
+			assert.Equal(t, int64(1394734993), info.CaptureTimestamp)
+			assert.True(t, info.HasLocation)
+			assert.True(t, math.Abs(info.Latitude-22.89) < 1e-9)
+			assert.True(t, math.Abs(info.Longitude+109.91) < 1e-9)
+		}
+	}
+
+	noOffset := buildEXIF(binary.LittleEndian, []tiffField{asciiField(tagDateTimeOriginal, "2014:03:13 11:23:13")}, nil)
+	info, err := ParseEXIF(noOffset)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1394709793), info.CaptureTimestamp)
+	assert.False(t, info.HasLocation)
+
+	cases := []struct {
+		desc string
+		head []byte
+		err  error
+	}{
+		{"jpeg without exif", []byte("\xFF\xD8\xFF\xE0\x00\x04\x00\x00\xFF\xDA\x00\x02"), consts.ErrNoEXIF},
+		{"empty exif", buildEXIF(binary.LittleEndian, nil, nil), consts.ErrNoEXIF},
+		{"png", []byte("\x89PNG\r\n\x1a\n"), consts.ErrNoEXIF},
+		{"truncated ifd", noOffset[:20], consts.ErrMalformedEXIF},
+		{"bad magic", []byte("II\x2B\x00\x08\x00\x00\x00"), consts.ErrNoEXIF},
+		{"broken segment", []byte("\xFF\xD8\x00\x00\x00\x00"), consts.ErrMalformedEXIF},
+	}
+
+	for _, c := range cases {
+		info, err := ParseEXIF(c.head)
+		assert.Equal(t, c.err, err, c.desc)
+		assert.Nil(t, info, c.desc)
+	}
+}
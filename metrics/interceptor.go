@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// UnaryServerInterceptor counts the unary calls by method and status code, and records their latency.
+// It runs first, so the calls rejected by the other interceptors are counted too.
+// Returns the interceptor.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		start := time.Now()
+		res, err := handler(ctx, req)
+		observeRPC(info.FullMethod, time.Since(start), err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor counts the streaming calls by method and status code, and records their latency.
+// It runs first, so the calls rejected by the other interceptors are counted too.
+// Returns the interceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		start := time.Now()
+		err := handler(srv, stream)
+		observeRPC(info.FullMethod, time.Since(start), err)
+		return err
+	}
+}
+
+// observeRPC records a call of the full method name, ended by err.
+func observeRPC(method string, elapsed time.Duration, err error) {
+	rpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+	rpcDuration.WithLabelValues(method).Observe(elapsed.Seconds())
+}
@@ -0,0 +1,188 @@
+// Package metrics exposes the Prometheus metrics of the service over HTTP:
+// the gRPC calls, the MongoDB commands, the url verifications, the MongoDB reconnects,
+// the wait for Document locks, and the state of the service.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	namespace = "hwsc_document"
+	// Path of the metrics on the HTTP endpoint
+	Path = "/metrics"
+	// OtherHost labels the url verifications of the hosts not set with SetURLHosts
+	OtherHost = "other"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// urlHosts are the hosts labeling the url verifications
+	urlHosts     []string
+	urlHostsLock sync.RWMutex
+
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "requests_total",
+		Help:      "gRPC calls handled, by method and status code.",
+	}, []string{"method", "code"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rpc",
+		Name:      "duration_seconds",
+		Help:      "Latency of the gRPC calls, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	mongoDBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "mongodb",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of the MongoDB commands, by collection and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"collection", "operation"})
+
+	mongoDBErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongodb",
+		Name:      "operation_errors_total",
+		Help:      "MongoDB commands failed, by collection and operation.",
+	}, []string{"collection", "operation"})
+
+	mongoDBReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "mongodb",
+		Name:      "reconnects_total",
+		Help:      "MongoDB clients dialed again by the connection refresh, by result.",
+	}, []string{"result"})
+
+	urlVerificationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "url",
+		Name:      "verification_duration_seconds",
+		Help:      "Latency of the file url verifications, by host.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host"})
+
+	urlVerificationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "url",
+		Name:      "verification_failures_total",
+		Help:      "File url verifications failed, by host.",
+	}, []string{"host"})
+
+	duidLockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "duid",
+		Name:      "lock_wait_seconds",
+		Help:      "Wait for the lock of a Document before changing it.",
+		Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5},
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		rpcRequests, rpcDuration,
+		mongoDBDuration, mongoDBErrors, mongoDBReconnects,
+		urlVerificationDuration, urlVerificationFailures,
+		duidLockWait,
+	)
+}
+
+// Handler returns the HTTP handler of the metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Serve listens on the address and serves the metrics under Path in the background.
+// Returns the listener, or an error if the address can not be listened on.
+func Serve(addr string) (net.Listener, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+	go func() {
+		_ = http.Serve(lis, mux)
+	}()
+	return lis, nil
+}
+
+// RegisterServiceState exposes the state of the service read by state, 0 being available.
+// Returns an error if the state is already exposed.
+func RegisterServiceState(state func() float64) error {
+	return registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "service_state",
+		Help:      "Current state of the service, 0 available and 1 unavailable.",
+	}, state))
+}
+
+// ObserveReconnect counts a MongoDB client dialed again, failed if err is not nil.
+func ObserveReconnect(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	mongoDBReconnects.WithLabelValues(result).Inc()
+}
+
+// SetURLHosts sets the hosts labeling the url verifications, ".example.com" labeling every subdomain as itself.
+// The urls of other hosts are labeled OtherHost, so clients can not grow the label values.
+func SetURLHosts(hosts []string) {
+	urlHostsLock.Lock()
+	defer urlHostsLock.Unlock()
+	urlHosts = make([]string, len(hosts))
+	for i, host := range hosts {
+		urlHosts[i] = strings.ToLower(host)
+	}
+}
+
+// ObserveURLVerification records the latency of the verification of the url, and counts it if it failed.
+func ObserveURLVerification(addr string, elapsed time.Duration, err error) {
+	host := hostLabel(addr)
+	urlVerificationDuration.WithLabelValues(host).Observe(elapsed.Seconds())
+	if err != nil {
+		urlVerificationFailures.WithLabelValues(host).Inc()
+	}
+}
+
+// ObserveLockWait records the wait for the lock of a Document.
+func ObserveLockWait(elapsed time.Duration) {
+	duidLockWait.Observe(elapsed.Seconds())
+}
+
+// hostLabel returns the label of the host of the url, OtherHost for a host not set with SetURLHosts.
+func hostLabel(addr string) string {
+	host := strings.ToLower(urlHost(addr))
+	urlHostsLock.RLock()
+	defer urlHostsLock.RUnlock()
+	for _, h := range urlHosts {
+		if host == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return h
+		}
+	}
+	return OtherHost
+}
+
+// urlHost returns the host of the url without port, empty if the url can not be parsed.
+func urlHost(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// histogram returns the histogram of the metric family with the label values, nil if none was observed.
+func histogram(t *testing.T, name string, labels map[string]string) *dto.Histogram {
+	families, err := registry.Gather()
+	assert.Nil(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			matched := 0
+			for _, pair := range m.GetLabel() {
+				if labels[pair.GetName()] == pair.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) && len(m.GetLabel()) == len(labels) {
+				return m.GetHistogram()
+			}
+		}
+	}
+	return nil
+}
+
+func TestServerInterceptors(t *testing.T) {
+	const method = "/document.DocumentService/QueryDocument"
+	const streamMethod = "/grpc.health.v1.Health/Watch"
+	notFound := status.Error(codes.NotFound, "not found")
+	okBefore := testutil.ToFloat64(rpcRequests.WithLabelValues(method, "OK"))
+	notFoundBefore := testutil.ToFloat64(rpcRequests.WithLabelValues(method, "NotFound"))
+
+	unary := UnaryServerInterceptor()
+	cases := []struct {
+		err error
+	}{
+		{nil},
+		{nil},
+		{notFound},
+	}
+	for _, c := range cases {
+		_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) { return nil, c.err })
+		assert.Equal(t, c.err, err)
+	}
+	assert.Equal(t, okBefore+2, testutil.ToFloat64(rpcRequests.WithLabelValues(method, "OK")))
+	assert.Equal(t, notFoundBefore+1, testutil.ToFloat64(rpcRequests.WithLabelValues(method, "NotFound")))
+	assert.True(t, histogram(t, "hwsc_document_rpc_duration_seconds", map[string]string{"method": method}).
+		GetSampleCount() >= 3)
+
+	stream := StreamServerInterceptor()
+	err := stream(nil, nil, &grpc.StreamServerInfo{FullMethod: streamMethod},
+		func(srv interface{}, stream grpc.ServerStream) error { return errors.New("broken") })
+	assert.EqualError(t, err, "broken")
+	assert.Equal(t, float64(1), testutil.ToFloat64(rpcRequests.WithLabelValues(streamMethod, "Unknown")))
+}
+
+func TestCommandMonitor(t *testing.T) {
+	m := NewCommandMonitor().Monitor()
+	find, err := bson.Marshal(bson.M{"find": "documents-test", "filter": bson.M{}})
+	assert.Nil(t, err)
+	getMore, err := bson.Marshal(bson.M{"getMore": int64(42), "collection": "documents-test"})
+	assert.Nil(t, err)
+	ping, err := bson.Marshal(bson.M{"ping": 1})
+	assert.Nil(t, err)
+
+	cases := []struct {
+		command    bson.Raw
+		name       string
+		failed     bool
+		collection string
+	}{
+		{find, "find", false, "documents-test"},
+		{find, "find", true, "documents-test"},
+		{getMore, "getMore", false, "documents-test"},
+		{ping, "ping", false, ""},
+	}
+
+	for i, c := range cases {
+		errorsBefore := testutil.ToFloat64(mongoDBErrors.WithLabelValues(c.collection, c.name))
+		labels := map[string]string{"collection": c.collection, "operation": c.name}
+		var countBefore uint64
+		if h := histogram(t, "hwsc_document_mongodb_operation_duration_seconds", labels); h != nil {
+			countBefore = h.GetSampleCount()
+		}
+
+		m.Started(context.Background(), &event.CommandStartedEvent{
+			Command: c.command, CommandName: c.name, RequestID: int64(i),
+		})
+		finished := event.CommandFinishedEvent{DurationNanos: int64(time.Millisecond), CommandName: c.name, RequestID: int64(i)}
+		if c.failed {
+			m.Failed(context.Background(), &event.CommandFailedEvent{CommandFinishedEvent: finished, Failure: "failed"})
+		} else {
+			m.Succeeded(context.Background(), &event.CommandSucceededEvent{CommandFinishedEvent: finished})
+		}
+
+		h := histogram(t, "hwsc_document_mongodb_operation_duration_seconds", labels)
+		assert.NotNil(t, h, c.name)
+		assert.Equal(t, countBefore+1, h.GetSampleCount(), c.name)
+		expectedErrors := errorsBefore
+		if c.failed {
+			expectedErrors++
+		}
+		assert.Equal(t, expectedErrors, testutil.ToFloat64(mongoDBErrors.WithLabelValues(c.collection, c.name)), c.name)
+	}
+}
+
+func TestObserveURLVerification(t *testing.T) {
+	defer SetURLHosts(nil)
+	SetURLHosts([]string{"hwscdevstorage.blob.core.windows.net", ".Example.com"})
+	cases := []struct {
+		addr     string
+		err      error
+		host     string
+		failures float64
+	}{
+		{"https://hwscdevstorage.blob.core.windows.net/images/Rotating_earth_large.gif", nil,
+			"hwscdevstorage.blob.core.windows.net", 0},
+		{"https://files.example.com:8443/audio.wav", errors.New("unreachable"), ".example.com", 1},
+		{"https://FILES.EXAMPLE.COM/audio.wav", nil, ".example.com", 0},
+		// Hosts chosen by clients share a label
+		{"https://example.org/audio.wav", errors.New("unreachable"), OtherHost, 1},
+		{"https://notexample.com/audio.wav", nil, OtherHost, 0},
+		{"://broken", errors.New("invalid"), OtherHost, 1},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.host, hostLabel(c.addr), c.addr)
+		before := testutil.ToFloat64(urlVerificationFailures.WithLabelValues(c.host))
+		ObserveURLVerification(c.addr, time.Millisecond, c.err)
+		assert.Equal(t, before+c.failures, testutil.ToFloat64(urlVerificationFailures.WithLabelValues(c.host)), c.addr)
+		assert.NotNil(t, histogram(t, "hwsc_document_url_verification_duration_seconds",
+			map[string]string{"host": c.host}), c.addr)
+	}
+}
+
+func TestObserveReconnect(t *testing.T) {
+	success := testutil.ToFloat64(mongoDBReconnects.WithLabelValues("success"))
+	failure := testutil.ToFloat64(mongoDBReconnects.WithLabelValues("failure"))
+	ObserveReconnect(nil)
+	ObserveReconnect(errors.New("unavailable"))
+	ObserveReconnect(errors.New("unavailable"))
+	assert.Equal(t, success+1, testutil.ToFloat64(mongoDBReconnects.WithLabelValues("success")))
+	assert.Equal(t, failure+2, testutil.ToFloat64(mongoDBReconnects.WithLabelValues("failure")))
+}
+
+func TestServe(t *testing.T) {
+	assert.Nil(t, RegisterServiceState(func() float64 { return 1 }))
+	assert.NotNil(t, RegisterServiceState(func() float64 { return 0 }))
+	ObserveLockWait(time.Millisecond)
+
+	lis, err := Serve("127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+
+	res, err := http.Get("http://" + lis.Addr().String() + Path)
+	assert.Nil(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := ioutil.ReadAll(res.Body)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(body), "hwsc_document_service_state 1\n"))
+	assert.True(t, strings.Contains(string(body), "hwsc_document_duid_lock_wait_seconds_count"))
+	assert.True(t, strings.Contains(string(body), "go_goroutines"))
+
+	_, err = Serve(lis.Addr().String())
+	assert.NotNil(t, err)
+}
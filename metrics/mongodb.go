@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"go.mongodb.org/mongo-driver/event"
+	"golang.org/x/net/context"
+	"sync"
+	"time"
+)
+
+// command is the collection and operation of a started MongoDB command
+type command struct {
+	collection string
+	operation  string
+}
+
+// CommandMonitor records the latency and failures of MongoDB commands, by collection and operation
+type CommandMonitor struct {
+	// Started commands by request id, until they finish
+	started sync.Map
+}
+
+// NewCommandMonitor returns a monitor recording the commands of MongoDB clients.
+func NewCommandMonitor() *CommandMonitor {
+	return &CommandMonitor{}
+}
+
+// Monitor returns the command monitor to set on the options of a MongoDB client.
+func (m *CommandMonitor) Monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(_ context.Context, evt *event.CommandStartedEvent) {
+			m.started.Store(evt.RequestID, commandOf(evt))
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			m.finished(evt.CommandFinishedEvent, false)
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			m.finished(evt.CommandFinishedEvent, true)
+		},
+	}
+}
+
+// finished records the latency of a command, and counts it if it failed.
+func (m *CommandMonitor) finished(evt event.CommandFinishedEvent, failed bool) {
+	cmd := command{operation: evt.CommandName}
+	if started, ok := m.started.Load(evt.RequestID); ok {
+		m.started.Delete(evt.RequestID)
+		cmd = started.(command)
+	}
+	mongoDBDuration.WithLabelValues(cmd.collection, cmd.operation).
+		Observe((time.Duration(evt.DurationNanos) * time.Nanosecond).Seconds())
+	if failed {
+		mongoDBErrors.WithLabelValues(cmd.collection, cmd.operation).Inc()
+	}
+}
+
+// commandOf returns the collection and operation of a started command.
+// The collection is empty for the commands of the database or server, like ping.
+func commandOf(evt *event.CommandStartedEvent) command {
+	key := evt.CommandName
+	if key == "getMore" {
+		// The cursor id is the value of getMore
+		key = "collection"
+	}
+	collection, _ := evt.Command.Lookup(key).StringValueOK()
+	return command{collection: collection, operation: evt.CommandName}
+}
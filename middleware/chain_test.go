@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"testing"
+)
+
+// recordUnary returns an interceptor appending its name to the calls, before and after the handler.
+func recordUnary(name string, calls *[]string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		*calls = append(*calls, name)
+		res, err := handler(ctx, req)
+		*calls = append(*calls, name)
+		return res, err
+	}
+}
+
+// recordStream returns an interceptor appending its name to the calls, before and after the handler.
+func recordStream(name string, calls *[]string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		*calls = append(*calls, name)
+		err := handler(srv, stream)
+		*calls = append(*calls, name)
+		return err
+	}
+}
+
+func TestChainUnary(t *testing.T) {
+	var calls []string
+	chain := ChainUnary(recordUnary("a", &calls), recordUnary("b", &calls))
+	res, err := chain(context.Background(), "req", &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls = append(calls, "handler")
+			return req, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, "req", res)
+	assert.Equal(t, []string{"a", "b", "handler", "b", "a"}, calls)
+
+	// Chains are reusable
+	calls = nil
+	_, err = chain(context.Background(), "req", &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return nil, nil })
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "b", "a"}, calls)
+
+	calls = nil
+	_, err = ChainUnary()(context.Background(), "req", &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			calls = append(calls, "handler")
+			return nil, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"handler"}, calls)
+}
+
+func TestChainStream(t *testing.T) {
+	var calls []string
+	chain := ChainStream(recordStream("a", &calls), recordStream("b", &calls))
+	err := chain(nil, nil, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+		calls = append(calls, "handler")
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "handler", "b", "a"}, calls)
+}
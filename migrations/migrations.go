@@ -0,0 +1,59 @@
+// Package migrations holds the up and down migrations of the Document database, embedded in the binary.
+// The files are templates naming the collections by the fields of Collections, like "{{.Documents}}",
+// so the same migrations apply to the configured collections and to those of the tests.
+package migrations
+
+import (
+	"bytes"
+	"embed"
+	"path"
+	"text/template"
+)
+
+// files are the migration files, named <version>_<title>.<up|down>.json
+//
+//go:embed *.json
+var files embed.FS
+
+// Collections names the collections of the Document database in the migrations
+type Collections struct {
+	Documents     string
+	Registry      string
+	Audit         string
+	Outbox        string
+	Subscriptions string
+	Deliveries    string
+	Usage         string
+}
+
+// Names returns the names of the migration files, sorted.
+// Returns an error if the files can not be read.
+func Names() ([]string, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names, nil
+}
+
+// Read returns the migration file with the name, on the collections.
+// Returns an error if there is no such file, or it names an unknown collection.
+func Read(name string, collections Collections) ([]byte, error) {
+	b, err := files.ReadFile(path.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, collections); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
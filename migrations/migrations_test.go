@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"encoding/json"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+var testCollections = Collections{
+	Documents:     "documents",
+	Registry:      "documents-registry",
+	Audit:         "documents-audit",
+	Outbox:        "documents-outbox",
+	Subscriptions: "documents-subscriptions",
+	Deliveries:    "documents-deliveries",
+	Usage:         "documents-usage",
+}
+
+func TestNames(t *testing.T) {
+	names, err := Names()
+	assert.Nil(t, err)
+
+	// Every version has its up and down migration, numbered from 1
+	assert.NotEmpty(t, names)
+	for i, name := range names {
+		migration, err := source.DefaultParse(name)
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, uint(i/2+1), migration.Version, name)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"001_create_document_collection.up.json", `"create": "documents"`},
+		{"002_create_indices_document_collection.down.json", `"dropIndexes": "documents"`},
+		{"007_create_audit_collection.up.json", `"create": "documents-audit"`},
+		{"007_create_audit_collection.up.json", `"createIndexes": "documents-audit"`},
+		{"010_create_usage_collection.up.json", `"aggregate": "documents"`},
+		{"010_create_usage_collection.up.json", `"$out": "documents-usage"`},
+	}
+
+	for _, c := range cases {
+		b, err := Read(c.name, testCollections)
+		assert.Nil(t, err)
+		assert.Contains(t, string(b), c.expected, c.name)
+	}
+
+	_, err := Read("099_missing.up.json", testCollections)
+	assert.NotNil(t, err)
+}
+
+func TestReadEveryMigration(t *testing.T) {
+	names, err := Names()
+	assert.Nil(t, err)
+
+	// Every file names known collections only, and is a list of commands once rendered
+	for _, name := range names {
+		b, err := Read(name, testCollections)
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+		assert.False(t, strings.Contains(string(b), "{{"), name)
+		var commands []map[string]interface{}
+		assert.Nil(t, json.Unmarshal(b, &commands), name)
+	}
+}
@@ -0,0 +1,313 @@
+// Package outbox delivers the change events of Documents, written to the outbox with the changes themselves,
+// to sinks with at-least-once semantics.
+// Events of a Document are delivered in the order they were written, a failed event holding back the later
+// events of its Document until it is delivered. Failed events are retried with an exponential backoff, until
+// they exhaust their attempts and are dead-lettered.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of the change events
+const (
+	DocumentCreated   = "document.created"
+	DocumentUpdated   = "document.updated"
+	DocumentDeleted   = "document.deleted"
+	DocumentPublished = "document.published"
+	FileAdded         = "file.added"
+	FileDeleted       = "file.deleted"
+	FileUpdated       = "file.updated"
+)
+
+const (
+	// DefaultBatchSize is the number of events read by a dispatch if the config sets none
+	DefaultBatchSize = 100
+	// DefaultMinBackoff is the wait before the first retry if the config sets none
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff bounds the wait between retries if the config sets none
+	DefaultMaxBackoff = 5 * time.Minute
+	// DefaultTimeout bounds the delivery of an event to a sink if the config sets none
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxAttempts is the number of attempts dead-lettering an event if the config sets none
+	DefaultMaxAttempts = 20
+)
+
+// Event is the change of a Document, as written to the outbox
+type Event struct {
+	// ID orders the events, written in the order of the changes
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Type      string             `bson:"type" json:"type"`
+	Duid      string             `bson:"duid" json:"duid"`
+	Fuid      string             `bson:"fuid,omitempty" json:"fuid,omitempty"`
+	RequestID string             `bson:"requestId,omitempty" json:"requestId,omitempty"`
+	Timestamp int64              `bson:"timestamp" json:"timestamp"`
+	// Document as written by the change, or as deleted, in JSON
+	Document json.RawMessage `bson:"document" json:"document"`
+	// Summary of the Document selecting the events in the outbox, not delivered
+	Summary interface{} `bson:"summary,omitempty" json:"-"`
+
+	// Attempts of delivery that failed
+	Attempts int `bson:"attempts" json:"-"`
+	// NextAttemptTimestamp is the unix time of the next attempt, 0 for a new event
+	NextAttemptTimestamp int64 `bson:"nextAttemptTimestamp" json:"-"`
+	// Sinks the event was delivered to, not delivered to again on retries
+	Sinks     []string `bson:"sinks,omitempty" json:"-"`
+	LastError string   `bson:"lastError,omitempty" json:"-"`
+}
+
+// NewEvent returns an event of the type for the Document with the duid, and the file with the fuid if any.
+// The Document is encoded in JSON.
+// Returns an error if the Document fails to encode.
+func NewEvent(eventType string, duid string, fuid string, document interface{}, now time.Time) (*Event, error) {
+	b, err := json.Marshal(document)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:        primitive.NewObjectID(),
+		Type:      eventType,
+		Duid:      duid,
+		Fuid:      fuid,
+		Timestamp: now.Unix(),
+		Document:  b,
+	}, nil
+}
+
+// Store reads and updates the events of the outbox
+type Store interface {
+	// Pending returns at most limit undelivered events due at now, in the order they were written.
+	// An event not due holds back the later events of its Document, which are not returned either.
+	Pending(ctx context.Context, now time.Time, limit int) ([]*Event, error)
+	// Delivered records that the event was delivered to every sink.
+	Delivered(ctx context.Context, event *Event) error
+	// Failed records the failed attempt of the event, with its next attempt and the sinks it was delivered to.
+	Failed(ctx context.Context, event *Event) error
+	// Dead records that the event exhausted its attempts. It is no longer delivered, nor holds back its Document.
+	Dead(ctx context.Context, event *Event) error
+}
+
+// Lease is held by the only instance dispatching the events, so the events of a Document are never
+// delivered by two instances at once
+type Lease interface {
+	// Acquire takes the lease, or extends it if this instance holds it.
+	// Returns false if another instance holds it.
+	Acquire(ctx context.Context) (bool, error)
+	// Keep extends the lease held by this instance until stop is called.
+	// Returns a context canceled once the lease is lost, and the function stopping the renewals.
+	Keep(ctx context.Context) (context.Context, func())
+}
+
+// Config configures a Dispatcher
+type Config struct {
+	// BatchSize is the number of events read by a dispatch
+	BatchSize int
+	// Concurrency is the number of Documents whose events are delivered at once
+	Concurrency int
+	// MinBackoff is the wait before the first retry, doubled by every failed attempt
+	MinBackoff time.Duration
+	// MaxBackoff bounds the wait between retries
+	MaxBackoff time.Duration
+	// Timeout bounds the delivery of an event to a sink
+	Timeout time.Duration
+	// MaxAttempts is the number of failed attempts dead-lettering an event
+	MaxAttempts int
+	// Lease is held while dispatching, nil if a single instance dispatches
+	Lease Lease
+}
+
+// Dispatcher delivers the events of the outbox to its sinks
+type Dispatcher struct {
+	store  Store
+	sinks  []Sink
+	config Config
+	now    func() time.Time
+}
+
+// NewDispatcher returns a Dispatcher delivering the events of the store to every sink.
+// Unset values of the config take their defaults.
+func NewDispatcher(store Store, sinks []Sink, config Config) *Dispatcher {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = DefaultMinBackoff
+	}
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	return &Dispatcher{store: store, sinks: sinks, config: config, now: time.Now}
+}
+
+// Run dispatches the events while holding the lease, then waits for the interval, until the context is done.
+// A dispatch delivering a full batch is followed by the next one without waiting.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		delivered, err := d.dispatchLeased(ctx)
+		if err != nil {
+			log.Error(consts.OutboxTag, err.Error())
+		}
+		wait := interval
+		if delivered == d.config.BatchSize {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// dispatchLeased dispatches a batch while the lease is held, skipping it if another instance holds the lease.
+// Returns the number of events delivered, or an error if the lease or the events could not be read.
+func (d *Dispatcher) dispatchLeased(ctx context.Context) (int, error) {
+	if d.config.Lease == nil {
+		return d.Dispatch(ctx)
+	}
+	held, err := d.config.Lease.Acquire(ctx)
+	if err != nil || !held {
+		return 0, err
+	}
+	leaseCtx, stop := d.config.Lease.Keep(ctx)
+	defer stop()
+	return d.Dispatch(leaseCtx)
+}
+
+// Dispatch delivers a batch of pending events, the Documents in parallel and the events of each in order.
+// Returns the number of events delivered, or an error if they could not be read.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	events, err := d.store.Pending(ctx, d.now(), d.config.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Events grouped by duid, in the order they were written
+	var duids []string
+	byDUID := make(map[string][]*Event)
+	for _, event := range events {
+		if _, ok := byDUID[event.Duid]; !ok {
+			duids = append(duids, event.Duid)
+		}
+		byDUID[event.Duid] = append(byDUID[event.Duid], event)
+	}
+
+	var delivered int64
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, d.config.Concurrency)
+	for _, duid := range duids {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(events []*Event) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			atomic.AddInt64(&delivered, int64(d.dispatchInOrder(ctx, events)))
+		}(byDUID[duid])
+	}
+	wg.Wait()
+	return int(delivered), nil
+}
+
+// dispatchInOrder delivers the events of a Document in order.
+// Stops at the first event not delivered, which holds back the later ones unless it is dead-lettered.
+// Returns the number of events delivered.
+func (d *Dispatcher) dispatchInOrder(ctx context.Context, events []*Event) int {
+	delivered := 0
+	for _, event := range events {
+		now := d.now()
+		if event.NextAttemptTimestamp > now.Unix() {
+			return delivered
+		}
+		if err := d.deliver(ctx, event); err != nil {
+			// A dispatch stopped by the lease or the service is not an attempt of the event
+			if ctx.Err() != nil {
+				return delivered
+			}
+			event.Attempts++
+			event.LastError = err.Error()
+			if event.Attempts >= d.config.MaxAttempts {
+				log.Error(consts.OutboxTag, fmt.Sprintf("Dead-lettering event, id: %s - duid: %s - attempts: %d - err: %s",
+					event.ID.Hex(), event.Duid, event.Attempts, err.Error()))
+				if err := d.store.Dead(ctx, event); err != nil {
+					log.Error(consts.OutboxTag, err.Error())
+					return delivered
+				}
+				continue
+			}
+			event.NextAttemptTimestamp = now.Add(Backoff(event.Attempts, d.config.MinBackoff, d.config.MaxBackoff)).Unix()
+			log.Error(consts.OutboxTag, fmt.Sprintf("Failed to deliver event, id: %s - duid: %s - attempts: %d - err: %s",
+				event.ID.Hex(), event.Duid, event.Attempts, err.Error()))
+			if err := d.store.Failed(ctx, event); err != nil {
+				log.Error(consts.OutboxTag, err.Error())
+			}
+			return delivered
+		}
+		if err := d.store.Delivered(ctx, event); err != nil {
+			// Delivered again by the next dispatch
+			log.Error(consts.OutboxTag, err.Error())
+			return delivered
+		}
+		delivered++
+	}
+	return delivered
+}
+
+// deliver delivers the event to every sink it was not delivered to yet, each within the timeout.
+// Returns the first error of the sinks.
+func (d *Dispatcher) deliver(ctx context.Context, event *Event) error {
+	delivered := make(map[string]bool, len(event.Sinks))
+	for _, name := range event.Sinks {
+		delivered[name] = true
+	}
+
+	var first error
+	for _, sink := range d.sinks {
+		if delivered[sink.Name()] {
+			continue
+		}
+		sinkCtx, cancel := context.WithTimeout(ctx, d.config.Timeout)
+		err := sink.Deliver(sinkCtx, event)
+		cancel()
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("%s: %s", sink.Name(), err.Error())
+			}
+			continue
+		}
+		event.Sinks = append(event.Sinks, sink.Name())
+	}
+	return first
+}
+
+// Backoff returns the wait after the number of failed attempts, min doubled by every attempt after the first.
+// Returns max if the wait would exceed it.
+func Backoff(attempts int, min time.Duration, max time.Duration) time.Duration {
+	wait := min
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	if wait > max {
+		return max
+	}
+	return wait
+}
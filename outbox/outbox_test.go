@@ -0,0 +1,322 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore keeps the events in memory, in the order they were written
+type memStore struct {
+	mu        sync.Mutex
+	events    []*Event
+	delivered map[string]bool
+	dead      map[string]bool
+}
+
+func (s *memStore) Pending(_ context.Context, now time.Time, limit int) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pending []*Event
+	held := make(map[string]bool)
+	for _, event := range s.events {
+		if s.delivered[event.ID.Hex()] || s.dead[event.ID.Hex()] || held[event.Duid] {
+			continue
+		}
+		if event.NextAttemptTimestamp > now.Unix() {
+			held[event.Duid] = true
+			continue
+		}
+		if len(pending) < limit {
+			copied := *event
+			pending = append(pending, &copied)
+		}
+	}
+	return pending, nil
+}
+
+func (s *memStore) Delivered(_ context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered[event.ID.Hex()] = true
+	return nil
+}
+
+func (s *memStore) Failed(_ context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.events {
+		if stored.ID == event.ID {
+			copied := *event
+			s.events[i] = &copied
+		}
+	}
+	return nil
+}
+
+func (s *memStore) Dead(ctx context.Context, event *Event) error {
+	_ = s.Failed(ctx, event)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dead[event.ID.Hex()] = true
+	return nil
+}
+
+// fakeLease is held while free is set, counting the dispatches run under it
+type fakeLease struct {
+	free bool
+	kept int
+}
+
+func (l *fakeLease) Acquire(_ context.Context) (bool, error) { return l.free, nil }
+func (l *fakeLease) Keep(ctx context.Context) (context.Context, func()) {
+	l.kept++
+	return ctx, func() {}
+}
+
+// fakeSink records the events delivered to it, failing the events of the duids set in fail
+type fakeSink struct {
+	name string
+	mu   sync.Mutex
+	got  []*Event
+	fail map[string]bool
+}
+
+func (s *fakeSink) Name() string { return s.name }
+func (s *fakeSink) Deliver(_ context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail[event.Duid] {
+		return errors.New("unavailable")
+	}
+	s.got = append(s.got, event)
+	return nil
+}
+
+// types returns the types of the events of the duid delivered to the sink
+func (s *fakeSink) types(duid string) []string {
+	var types []string
+	for _, event := range s.got {
+		if event.Duid == duid {
+			types = append(types, event.Type)
+		}
+	}
+	return types
+}
+
+func newTestEvent(t *testing.T, eventType string, duid string) *Event {
+	event, err := NewEvent(eventType, duid, "", map[string]string{"duid": duid}, time.Unix(1546300800, 0))
+	assert.Nil(t, err)
+	return event
+}
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{9, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, Backoff(c.attempts, time.Second, time.Minute))
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	store := &memStore{delivered: make(map[string]bool), dead: make(map[string]bool)}
+	for _, e := range []struct{ eventType, duid string }{
+		{DocumentCreated, "a"}, {DocumentCreated, "b"}, {FileAdded, "a"},
+		{DocumentUpdated, "b"}, {DocumentDeleted, "a"},
+	} {
+		store.events = append(store.events, newTestEvent(t, e.eventType, e.duid))
+	}
+	first := &fakeSink{name: "first", fail: map[string]bool{"b": true}}
+	second := &fakeSink{name: "second"}
+	d := NewDispatcher(store, []Sink{first, second}, Config{Concurrency: 2, MinBackoff: time.Minute})
+	now := time.Unix(1546300800, 0)
+	d.now = func() time.Time { return now }
+
+	// The events of a are delivered in order, b is held back after its first event fails on a sink
+	delivered, err := d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, delivered)
+	assert.Equal(t, []string{DocumentCreated, FileAdded, DocumentDeleted}, first.types("a"))
+	assert.Equal(t, []string{DocumentCreated, FileAdded, DocumentDeleted}, second.types("a"))
+	assert.Empty(t, first.types("b"))
+	assert.Equal(t, []string{DocumentCreated}, second.types("b"))
+
+	failed := store.events[1]
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, now.Add(time.Minute).Unix(), failed.NextAttemptTimestamp)
+	assert.Equal(t, []string{"second"}, failed.Sinks)
+	assert.Equal(t, "first: unavailable", failed.LastError)
+
+	// Nothing is retried before the backoff passed
+	first.fail = nil
+	delivered, err = d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+
+	// Once retried, the sinks that got the event do not get it again
+	now = now.Add(time.Minute)
+	delivered, err = d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, delivered)
+	assert.Equal(t, []string{DocumentCreated, DocumentUpdated}, first.types("b"))
+	assert.Equal(t, []string{DocumentCreated, DocumentUpdated}, second.types("b"))
+	assert.Len(t, store.delivered, 5)
+}
+
+func TestDispatchDeadLetter(t *testing.T) {
+	store := &memStore{delivered: make(map[string]bool), dead: make(map[string]bool)}
+	for _, e := range []struct{ eventType, duid string }{
+		{DocumentCreated, "a"}, {DocumentUpdated, "a"}, {DocumentCreated, "b"},
+	} {
+		store.events = append(store.events, newTestEvent(t, e.eventType, e.duid))
+	}
+	sink := &fakeSink{name: "sink", fail: map[string]bool{"a": true}}
+	d := NewDispatcher(store, []Sink{sink}, Config{MinBackoff: time.Minute, MaxAttempts: 2})
+	now := time.Unix(1546300800, 0)
+	d.now = func() time.Time { return now }
+
+	// The failed event of a holds back its Document until its backoff passes
+	delivered, err := d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	pending, err := store.Pending(context.TODO(), now, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	// Its last attempt dead-letters it, so the next event of a is attempted
+	now = now.Add(time.Minute)
+	delivered, err = d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.True(t, store.dead[store.events[0].ID.Hex()])
+	assert.Equal(t, 2, store.events[0].Attempts)
+	assert.Equal(t, 1, store.events[1].Attempts)
+
+	// And delivered once the sink recovers, without the dead event
+	sink.fail = nil
+	now = now.Add(time.Minute)
+	delivered, err = d.Dispatch(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, []string{DocumentUpdated}, sink.types("a"))
+}
+
+func TestDispatchLeased(t *testing.T) {
+	store := &memStore{delivered: make(map[string]bool), dead: make(map[string]bool)}
+	store.events = append(store.events, newTestEvent(t, DocumentCreated, "a"))
+	sink := &fakeSink{name: "sink"}
+	lease := &fakeLease{}
+	d := NewDispatcher(store, []Sink{sink}, Config{Lease: lease})
+
+	// Held by another instance, nothing is dispatched
+	delivered, err := d.dispatchLeased(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+	assert.Equal(t, 0, lease.kept)
+	assert.Empty(t, sink.got)
+
+	lease.free = true
+	delivered, err = d.dispatchLeased(context.TODO())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, delivered)
+	assert.Equal(t, 1, lease.kept)
+}
+
+func TestEventEncoding(t *testing.T) {
+	event := newTestEvent(t, FileAdded, "duid")
+	event.Fuid = "fuid"
+	event.Attempts = 2
+	event.Sinks = []string{"webhook"}
+
+	b, err := json.Marshal(event)
+	assert.Nil(t, err)
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	keys := make([]string, 0, len(decoded))
+	for key := range decoded {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// The delivery state is not sent
+	assert.Equal(t, []string{"document", "duid", "fuid", "id", "timestamp", "type"}, keys)
+	assert.Equal(t, map[string]interface{}{"duid": "duid"}, decoded["document"])
+	assert.Equal(t, event.ID.Hex(), decoded["id"])
+
+	raw, err := bson.Marshal(event)
+	assert.Nil(t, err)
+	stored := &Event{}
+	assert.Nil(t, bson.Unmarshal(raw, stored))
+	assert.Equal(t, event, stored)
+}
+
+func TestWriterSink(t *testing.T) {
+	var buf bytes.Buffer
+	sink := NewWriterSink("test", &buf)
+	first, second := newTestEvent(t, DocumentCreated, "a"), newTestEvent(t, DocumentDeleted, "a")
+
+	assert.Nil(t, sink.Deliver(context.TODO(), first))
+	assert.Nil(t, sink.Deliver(context.TODO(), second))
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if assert.Len(t, lines, 2) {
+		decoded := &Event{}
+		assert.Nil(t, json.Unmarshal(lines[1], decoded))
+		assert.Equal(t, DocumentDeleted, decoded.Type)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	secret := "secret"
+	var status int
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	event := newTestEvent(t, DocumentUpdated, "duid")
+
+	cases := []struct {
+		secret string
+		status int
+		isErr  bool
+	}{
+		{secret, http.StatusNoContent, false},
+		{"", http.StatusOK, false},
+		{secret, http.StatusServiceUnavailable, true},
+	}
+
+	for _, c := range cases {
+		status = c.status
+		err := NewWebhookSink(server.URL, c.secret).Deliver(context.TODO(), event)
+		assert.Equal(t, c.isErr, err != nil)
+		assert.Equal(t, http.MethodPost, got.Method)
+		assert.Equal(t, DocumentUpdated, got.Header.Get(EventTypeHeader))
+		assert.Equal(t, event.ID.Hex(), got.Header.Get(EventIDHeader))
+		if c.secret != "" {
+			assert.Equal(t, Sign([]byte(c.secret), body), got.Header.Get(SignatureHeader))
+		} else {
+			assert.Empty(t, got.Header.Get(SignatureHeader))
+		}
+	}
+}
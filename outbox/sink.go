@@ -0,0 +1,180 @@
+package outbox
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"github.com/nats-io/nats.go"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const (
+	// EventTypeHeader is the header of a webhook request carrying the type of the event
+	EventTypeHeader = "X-Hwsc-Event"
+	// EventIDHeader is the header of a webhook request carrying the id of the event, to drop redeliveries
+	EventIDHeader = "X-Hwsc-Event-Id"
+	// SignatureHeader is the header of a webhook request carrying the "sha256=" HMAC of its body
+	SignatureHeader = "X-Hwsc-Signature"
+)
+
+// Sink receives the events of the outbox
+type Sink interface {
+	// Name tells the sink apart from the others, it is recorded in the events delivered to it.
+	Name() string
+	// Deliver sends the event, returning once it is accepted.
+	Deliver(ctx context.Context, event *Event) error
+}
+
+// WriterSink writes every event as a line of JSON
+type WriterSink struct {
+	name string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// NewWriterSink returns a sink named name writing the events to w.
+func NewWriterSink(name string, w io.Writer) *WriterSink {
+	return &WriterSink{name: name, w: w}
+}
+
+// OpenFileSink returns a sink appending the events to the file at path, or writing them to stdout for "-".
+// Returns an error if the file can not be opened.
+func OpenFileSink(path string) (*WriterSink, error) {
+	if path == "-" {
+		return NewWriterSink("stdout", os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterSink("file", f), nil
+}
+
+// Name returns the name of the sink.
+func (s *WriterSink) Name() string {
+	return s.name
+}
+
+// Deliver writes the event.
+// Returns an error if it is not written.
+func (s *WriterSink) Deliver(_ context.Context, event *Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// WebhookSink posts every event in JSON to a url
+type WebhookSink struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting the events to the url.
+// The body is signed with the secret if it is not empty.
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Transport: tracing.Transport(http.DefaultTransport)},
+	}
+}
+
+// Name returns the name of the sink.
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+// Deliver posts the event.
+// Returns an error if the request fails, or is not answered with a 2xx status.
+func (s *WebhookSink) Deliver(ctx context.Context, event *Event) error {
+	_, err := PostEvent(ctx, s.client, s.url, s.secret, event)
+	return err
+}
+
+// PostEvent posts the event in JSON to the url with the client, signing the body with the secret if it is not empty.
+// Returns the status of the response, 0 if there is none,
+// and an error if the request fails, or is not answered with a 2xx status.
+func PostEvent(ctx context.Context, client *http.Client, url string, secret []byte, event *Event) (int, error) {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, event.Type)
+	req.Header.Set(EventIDHeader, event.ID.Hex())
+	if len(secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(secret, b))
+	}
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// Sign returns the "sha256=" hex HMAC of the body with the secret, as sent in SignatureHeader.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NATSSink publishes every event in JSON to the subject of its type, under a prefix
+type NATSSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// ConnectNATSSink returns a sink publishing the events to the NATS server at the url,
+// on the subject prefix followed by the event type, like "hwsc.document.document.created".
+// Returns an error if the server can not be reached.
+func ConnectNATSSink(url string, subject string) (*NATSSink, error) {
+	conn, err := nats.Connect(url, nats.Name("hwsc-document-svc"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSSink{conn: conn, subject: subject}, nil
+}
+
+// Name returns the name of the sink.
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+// Deliver publishes the event, and waits for the server to receive it.
+// Returns an error if the event is not received before the deadline of the context.
+func (s *NATSSink) Deliver(ctx context.Context, event *Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := s.conn.Publish(s.subject+"."+event.Type, b); err != nil {
+		return err
+	}
+	return s.conn.FlushWithContext(ctx)
+}
@@ -0,0 +1,685 @@
+// Hand-maintained counterpart of document_ext.proto, laid out like protoc-gen-go output.
+// Keep both files in sync until the messages are upstreamed to hwsc-api-blocks.
+// source: document_ext.proto
+
+package ext
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// Access granted by a share of a Document
+type ShareAccess int32
+
+const (
+	// Reads the Document
+	ShareAccess_READ ShareAccess = 0
+	// Reads and edits the Document and its files
+	ShareAccess_WRITE ShareAccess = 1
+)
+
+var ShareAccess_name = map[int32]string{
+	0: "READ",
+	1: "WRITE",
+}
+
+var ShareAccess_value = map[string]int32{
+	"READ":  0,
+	"WRITE": 1,
+}
+
+func (x ShareAccess) String() string {
+	return proto.EnumName(ShareAccess_name, int32(x))
+}
+
+// Change of a Document notified by WatchDocuments
+type DocumentChangeType int32
+
+const (
+	DocumentChangeType_CREATED DocumentChangeType = 0
+	DocumentChangeType_UPDATED DocumentChangeType = 1
+	DocumentChangeType_DELETED DocumentChangeType = 2
+)
+
+var DocumentChangeType_name = map[int32]string{
+	0: "CREATED",
+	1: "UPDATED",
+	2: "DELETED",
+}
+
+var DocumentChangeType_value = map[string]int32{
+	"CREATED": 0,
+	"UPDATED": 1,
+	"DELETED": 2,
+}
+
+func (x DocumentChangeType) String() string {
+	return proto.EnumName(DocumentChangeType_name, int32(x))
+}
+
+// State of a webhook subscription
+type WebhookSubscriptionState int32
+
+const (
+	// Receives the events it subscribed to
+	WebhookSubscriptionState_ACTIVE WebhookSubscriptionState = 0
+	// Paused by its owner, receives no event until resumed
+	WebhookSubscriptionState_PAUSED WebhookSubscriptionState = 1
+	// Disabled after failing persistently, receives no event until resumed
+	WebhookSubscriptionState_DISABLED WebhookSubscriptionState = 2
+)
+
+var WebhookSubscriptionState_name = map[int32]string{
+	0: "ACTIVE",
+	1: "PAUSED",
+	2: "DISABLED",
+}
+
+var WebhookSubscriptionState_value = map[string]int32{
+	"ACTIVE":   0,
+	"PAUSED":   1,
+	"DISABLED": 2,
+}
+
+func (x WebhookSubscriptionState) String() string {
+	return proto.EnumName(WebhookSubscriptionState_name, int32(x))
+}
+
+// State of the delivery of an event to a webhook subscription
+type WebhookDeliveryState int32
+
+const (
+	// Waiting for its first attempt, or its retry
+	WebhookDeliveryState_PENDING WebhookDeliveryState = 0
+	// Accepted by the webhook
+	WebhookDeliveryState_DELIVERED WebhookDeliveryState = 1
+	// Given up after its last attempt failed
+	WebhookDeliveryState_FAILED WebhookDeliveryState = 2
+)
+
+var WebhookDeliveryState_name = map[int32]string{
+	0: "PENDING",
+	1: "DELIVERED",
+	2: "FAILED",
+}
+
+var WebhookDeliveryState_value = map[string]int32{
+	"PENDING":   0,
+	"DELIVERED": 1,
+	"FAILED":    2,
+}
+
+func (x WebhookDeliveryState) String() string {
+	return proto.EnumName(WebhookDeliveryState_name, int32(x))
+}
+
+// Metadata of a single file referenced by a Document url map
+type FileMetadata struct {
+	ContentType string `protobuf:"bytes,1,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty" bson:"contentType"`
+	Size        int64  `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty" bson:"size"`
+	Checksum    string `protobuf:"bytes,3,opt,name=checksum,proto3" json:"checksum,omitempty" bson:"checksum"`
+	// Seconds, audio and video only
+	Duration       float64 `protobuf:"fixed64,4,opt,name=duration,proto3" json:"duration,omitempty" bson:"duration"`
+	Caption        string  `protobuf:"bytes,5,opt,name=caption,proto3" json:"caption,omitempty" bson:"caption"`
+	Credit         string  `protobuf:"bytes,6,opt,name=credit,proto3" json:"credit,omitempty" bson:"credit"`
+	AddedTimestamp int64   `protobuf:"varint,7,opt,name=added_timestamp,json=addedTimestamp,proto3" json:"added_timestamp,omitempty" bson:"addedTimestamp"`
+	// MIME type detected from the first bytes of the file
+	DetectedType string `protobuf:"bytes,8,opt,name=detected_type,json=detectedType,proto3" json:"detected_type,omitempty" bson:"detectedType"`
+	// Hertz, read from the audio header
+	SampleRate uint32 `protobuf:"varint,9,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty" bson:"sampleRate"`
+	Channels   uint32 `protobuf:"varint,10,opt,name=channels,proto3" json:"channels,omitempty" bson:"channels"`
+	// Bits per sample, uncompressed and lossless audio only
+	BitDepth uint32 `protobuf:"varint,11,opt,name=bit_depth,json=bitDepth,proto3" json:"bit_depth,omitempty" bson:"bitDepth"`
+	// Disagreements between the file and the Document, at most one per code
+	Warnings []*FileWarning `protobuf:"bytes,12,rep,name=warnings,proto3" json:"warnings,omitempty" bson:"warnings"`
+	// UNIX capture timestamp in UTC, read from the image EXIF
+	CaptureTimestamp int64 `protobuf:"varint,13,opt,name=capture_timestamp,json=captureTimestamp,proto3" json:"capture_timestamp,omitempty" bson:"captureTimestamp"`
+	// Capture coordinates, read from the image EXIF GPS
+	Location *FileLocation `protobuf:"bytes,14,opt,name=location,proto3" json:"location,omitempty" bson:"location"`
+	// "ok" or "broken", as of the last check of the url
+	LinkStatus string `protobuf:"bytes,15,opt,name=link_status,json=linkStatus,proto3" json:"link_status,omitempty" bson:"linkStatus"`
+	// Why the url is broken
+	LinkError string `protobuf:"bytes,16,opt,name=link_error,json=linkError,proto3" json:"link_error,omitempty" bson:"linkError"`
+	// UNIX timestamp in UTC of the last check of the url
+	LastCheckedTimestamp int64    `protobuf:"varint,17,opt,name=last_checked_timestamp,json=lastCheckedTimestamp,proto3" json:"last_checked_timestamp,omitempty" bson:"lastCheckedTimestamp"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *FileMetadata) Reset()         { *m = FileMetadata{} }
+func (m *FileMetadata) String() string { return proto.CompactTextString(m) }
+func (*FileMetadata) ProtoMessage()    {}
+
+func (m *FileMetadata) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileMetadata.Unmarshal(m, b)
+}
+func (m *FileMetadata) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileMetadata.Marshal(b, m, deterministic)
+}
+func (m *FileMetadata) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileMetadata.Merge(m, src)
+}
+func (m *FileMetadata) XXX_Size() int {
+	return xxx_messageInfo_FileMetadata.Size(m)
+}
+func (m *FileMetadata) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileMetadata.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileMetadata proto.InternalMessageInfo
+
+func (m *FileMetadata) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetChecksum() string {
+	if m != nil {
+		return m.Checksum
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetDuration() float64 {
+	if m != nil {
+		return m.Duration
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetCaption() string {
+	if m != nil {
+		return m.Caption
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetCredit() string {
+	if m != nil {
+		return m.Credit
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetAddedTimestamp() int64 {
+	if m != nil {
+		return m.AddedTimestamp
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetDetectedType() string {
+	if m != nil {
+		return m.DetectedType
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetSampleRate() uint32 {
+	if m != nil {
+		return m.SampleRate
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetChannels() uint32 {
+	if m != nil {
+		return m.Channels
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetBitDepth() uint32 {
+	if m != nil {
+		return m.BitDepth
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetWarnings() []*FileWarning {
+	if m != nil {
+		return m.Warnings
+	}
+	return nil
+}
+
+func (m *FileMetadata) GetCaptureTimestamp() int64 {
+	if m != nil {
+		return m.CaptureTimestamp
+	}
+	return 0
+}
+
+func (m *FileMetadata) GetLocation() *FileLocation {
+	if m != nil {
+		return m.Location
+	}
+	return nil
+}
+
+func (m *FileMetadata) GetLinkStatus() string {
+	if m != nil {
+		return m.LinkStatus
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetLinkError() string {
+	if m != nil {
+		return m.LinkError
+	}
+	return ""
+}
+
+func (m *FileMetadata) GetLastCheckedTimestamp() int64 {
+	if m != nil {
+		return m.LastCheckedTimestamp
+	}
+	return 0
+}
+
+// Coordinates in decimal degrees
+type FileLocation struct {
+	Latitude             float64  `protobuf:"fixed64,1,opt,name=latitude,proto3" json:"latitude,omitempty" bson:"latitude"`
+	Longitude            float64  `protobuf:"fixed64,2,opt,name=longitude,proto3" json:"longitude,omitempty" bson:"longitude"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *FileLocation) Reset()         { *m = FileLocation{} }
+func (m *FileLocation) String() string { return proto.CompactTextString(m) }
+func (*FileLocation) ProtoMessage()    {}
+
+func (m *FileLocation) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileLocation.Unmarshal(m, b)
+}
+func (m *FileLocation) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileLocation.Marshal(b, m, deterministic)
+}
+func (m *FileLocation) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileLocation.Merge(m, src)
+}
+func (m *FileLocation) XXX_Size() int {
+	return xxx_messageInfo_FileLocation.Size(m)
+}
+func (m *FileLocation) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileLocation.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileLocation proto.InternalMessageInfo
+
+func (m *FileLocation) GetLatitude() float64 {
+	if m != nil {
+		return m.Latitude
+	}
+	return 0
+}
+
+func (m *FileLocation) GetLongitude() float64 {
+	if m != nil {
+		return m.Longitude
+	}
+	return 0
+}
+
+// A disagreement between a file and its Document
+type FileWarning struct {
+	// Identifies the check, like "sampling_rate"
+	Code                 string   `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty" bson:"code"`
+	Message              string   `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty" bson:"message"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *FileWarning) Reset()         { *m = FileWarning{} }
+func (m *FileWarning) String() string { return proto.CompactTextString(m) }
+func (*FileWarning) ProtoMessage()    {}
+
+func (m *FileWarning) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileWarning.Unmarshal(m, b)
+}
+func (m *FileWarning) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileWarning.Marshal(b, m, deterministic)
+}
+func (m *FileWarning) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileWarning.Merge(m, src)
+}
+func (m *FileWarning) XXX_Size() int {
+	return xxx_messageInfo_FileWarning.Size(m)
+}
+func (m *FileWarning) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileWarning.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileWarning proto.InternalMessageInfo
+
+func (m *FileWarning) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *FileWarning) GetMessage() string {
+	if m != nil {
+		return m.Message
+	}
+	return ""
+}
+
+// The display order and primary file of a media type
+type FileOrder struct {
+	// In display order
+	Fuids []string `protobuf:"bytes,1,rep,name=fuids,proto3" json:"fuids,omitempty" bson:"fuids"`
+	// Cover or first file to show
+	Primary              string   `protobuf:"bytes,2,opt,name=primary,proto3" json:"primary,omitempty" bson:"primary"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *FileOrder) Reset()         { *m = FileOrder{} }
+func (m *FileOrder) String() string { return proto.CompactTextString(m) }
+func (*FileOrder) ProtoMessage()    {}
+
+func (m *FileOrder) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileOrder.Unmarshal(m, b)
+}
+func (m *FileOrder) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileOrder.Marshal(b, m, deterministic)
+}
+func (m *FileOrder) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileOrder.Merge(m, src)
+}
+func (m *FileOrder) XXX_Size() int {
+	return xxx_messageInfo_FileOrder.Size(m)
+}
+func (m *FileOrder) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileOrder.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileOrder proto.InternalMessageInfo
+
+func (m *FileOrder) GetFuids() []string {
+	if m != nil {
+		return m.Fuids
+	}
+	return nil
+}
+
+func (m *FileOrder) GetPrimary() string {
+	if m != nil {
+		return m.Primary
+	}
+	return ""
+}
+
+// The file orders of a Document, by media type
+type FileOrders struct {
+	Image                *FileOrder `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty" bson:"image"`
+	Audio                *FileOrder `protobuf:"bytes,2,opt,name=audio,proto3" json:"audio,omitempty" bson:"audio"`
+	Video                *FileOrder `protobuf:"bytes,3,opt,name=video,proto3" json:"video,omitempty" bson:"video"`
+	File                 *FileOrder `protobuf:"bytes,4,opt,name=file,proto3" json:"file,omitempty" bson:"file"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-" bson:"-"`
+	XXX_unrecognized     []byte     `json:"-" bson:"-"`
+	XXX_sizecache        int32      `json:"-" bson:"-"`
+}
+
+func (m *FileOrders) Reset()         { *m = FileOrders{} }
+func (m *FileOrders) String() string { return proto.CompactTextString(m) }
+func (*FileOrders) ProtoMessage()    {}
+
+func (m *FileOrders) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileOrders.Unmarshal(m, b)
+}
+func (m *FileOrders) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileOrders.Marshal(b, m, deterministic)
+}
+func (m *FileOrders) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileOrders.Merge(m, src)
+}
+func (m *FileOrders) XXX_Size() int {
+	return xxx_messageInfo_FileOrders.Size(m)
+}
+func (m *FileOrders) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileOrders.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileOrders proto.InternalMessageInfo
+
+func (m *FileOrders) GetImage() *FileOrder {
+	if m != nil {
+		return m.Image
+	}
+	return nil
+}
+
+func (m *FileOrders) GetAudio() *FileOrder {
+	if m != nil {
+		return m.Audio
+	}
+	return nil
+}
+
+func (m *FileOrders) GetVideo() *FileOrder {
+	if m != nil {
+		return m.Video
+	}
+	return nil
+}
+
+func (m *FileOrders) GetFile() *FileOrder {
+	if m != nil {
+		return m.File
+	}
+	return nil
+}
+
+// Grants a user or a group access to a Document, exactly one of uuid and group is set
+type DocumentShare struct {
+	// User granted access
+	Uuid string `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty" bson:"uuid"`
+	// Group granted access, named in the groups claim of tokens
+	Group  string      `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty" bson:"group"`
+	Access ShareAccess `protobuf:"varint,3,opt,name=access,proto3,enum=ext.ShareAccess" json:"access,omitempty" bson:"access"`
+	// UUID of the user granting the share
+	GrantedBy string `protobuf:"bytes,4,opt,name=granted_by,json=grantedBy,proto3" json:"granted_by,omitempty" bson:"grantedBy"`
+	// UNIX timestamp of the grant
+	GrantedTimestamp     int64    `protobuf:"varint,5,opt,name=granted_timestamp,json=grantedTimestamp,proto3" json:"granted_timestamp,omitempty" bson:"grantedTimestamp"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *DocumentShare) Reset()         { *m = DocumentShare{} }
+func (m *DocumentShare) String() string { return proto.CompactTextString(m) }
+func (*DocumentShare) ProtoMessage()    {}
+
+func (m *DocumentShare) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DocumentShare.Unmarshal(m, b)
+}
+func (m *DocumentShare) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DocumentShare.Marshal(b, m, deterministic)
+}
+func (m *DocumentShare) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DocumentShare.Merge(m, src)
+}
+func (m *DocumentShare) XXX_Size() int {
+	return xxx_messageInfo_DocumentShare.Size(m)
+}
+func (m *DocumentShare) XXX_DiscardUnknown() {
+	xxx_messageInfo_DocumentShare.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DocumentShare proto.InternalMessageInfo
+
+func (m *DocumentShare) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *DocumentShare) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+func (m *DocumentShare) GetAccess() ShareAccess {
+	if m != nil {
+		return m.Access
+	}
+	return ShareAccess_READ
+}
+
+func (m *DocumentShare) GetGrantedBy() string {
+	if m != nil {
+		return m.GrantedBy
+	}
+	return ""
+}
+
+func (m *DocumentShare) GetGrantedTimestamp() int64 {
+	if m != nil {
+		return m.GrantedTimestamp
+	}
+	return 0
+}
+
+// Extends lib.Document
+type DocumentExtension struct {
+	// key=fuid
+	FileMetadataMap map[string]*FileMetadata `protobuf:"bytes,22,rep,name=file_metadata_map,json=fileMetadataMap,proto3" json:"file_metadata_map,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3" bson:"fileMetadataMap"`
+	FileOrders      *FileOrders              `protobuf:"bytes,23,opt,name=file_orders,json=fileOrders,proto3" json:"file_orders,omitempty" bson:"fileOrders"`
+	// Unix time until which the Document stays private, 0 if not embargoed
+	EmbargoUntilTimestamp int64 `protobuf:"varint,24,opt,name=embargo_until_timestamp,json=embargoUntilTimestamp,proto3" json:"embargo_until_timestamp,omitempty" bson:"embargoUntilTimestamp"`
+	// Unix time the embargo was lifted and the Document made public, 0 if never
+	PublishedTimestamp   int64    `protobuf:"varint,25,opt,name=published_timestamp,json=publishedTimestamp,proto3" json:"published_timestamp,omitempty" bson:"publishedTimestamp"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-" bson:"-"`
+	XXX_unrecognized     []byte   `json:"-" bson:"-"`
+	XXX_sizecache        int32    `json:"-" bson:"-"`
+}
+
+func (m *DocumentExtension) Reset()         { *m = DocumentExtension{} }
+func (m *DocumentExtension) String() string { return proto.CompactTextString(m) }
+func (*DocumentExtension) ProtoMessage()    {}
+
+func (m *DocumentExtension) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DocumentExtension.Unmarshal(m, b)
+}
+func (m *DocumentExtension) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DocumentExtension.Marshal(b, m, deterministic)
+}
+func (m *DocumentExtension) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DocumentExtension.Merge(m, src)
+}
+func (m *DocumentExtension) XXX_Size() int {
+	return xxx_messageInfo_DocumentExtension.Size(m)
+}
+func (m *DocumentExtension) XXX_DiscardUnknown() {
+	xxx_messageInfo_DocumentExtension.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DocumentExtension proto.InternalMessageInfo
+
+func (m *DocumentExtension) GetFileMetadataMap() map[string]*FileMetadata {
+	if m != nil {
+		return m.FileMetadataMap
+	}
+	return nil
+}
+
+func (m *DocumentExtension) GetFileOrders() *FileOrders {
+	if m != nil {
+		return m.FileOrders
+	}
+	return nil
+}
+
+func (m *DocumentExtension) GetEmbargoUntilTimestamp() int64 {
+	if m != nil {
+		return m.EmbargoUntilTimestamp
+	}
+	return 0
+}
+
+func (m *DocumentExtension) GetPublishedTimestamp() int64 {
+	if m != nil {
+		return m.PublishedTimestamp
+	}
+	return 0
+}
+
+// Extends lib.FileMetadataTransaction
+type FileMetadataTransactionExtension struct {
+	Metadata             *FileMetadata `protobuf:"bytes,6,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-" bson:"-"`
+	XXX_unrecognized     []byte        `json:"-" bson:"-"`
+	XXX_sizecache        int32         `json:"-" bson:"-"`
+}
+
+func (m *FileMetadataTransactionExtension) Reset()         { *m = FileMetadataTransactionExtension{} }
+func (m *FileMetadataTransactionExtension) String() string { return proto.CompactTextString(m) }
+func (*FileMetadataTransactionExtension) ProtoMessage()    {}
+
+func (m *FileMetadataTransactionExtension) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileMetadataTransactionExtension.Unmarshal(m, b)
+}
+func (m *FileMetadataTransactionExtension) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileMetadataTransactionExtension.Marshal(b, m, deterministic)
+}
+func (m *FileMetadataTransactionExtension) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileMetadataTransactionExtension.Merge(m, src)
+}
+func (m *FileMetadataTransactionExtension) XXX_Size() int {
+	return xxx_messageInfo_FileMetadataTransactionExtension.Size(m)
+}
+func (m *FileMetadataTransactionExtension) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileMetadataTransactionExtension.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileMetadataTransactionExtension proto.InternalMessageInfo
+
+func (m *FileMetadataTransactionExtension) GetMetadata() *FileMetadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("ext.ShareAccess", ShareAccess_name, ShareAccess_value)
+	proto.RegisterEnum("ext.DocumentChangeType", DocumentChangeType_name, DocumentChangeType_value)
+	proto.RegisterEnum("ext.WebhookSubscriptionState", WebhookSubscriptionState_name, WebhookSubscriptionState_value)
+	proto.RegisterEnum("ext.WebhookDeliveryState", WebhookDeliveryState_name, WebhookDeliveryState_value)
+	proto.RegisterType((*FileMetadata)(nil), "ext.FileMetadata")
+	proto.RegisterType((*FileWarning)(nil), "ext.FileWarning")
+	proto.RegisterType((*FileLocation)(nil), "ext.FileLocation")
+	proto.RegisterType((*FileOrder)(nil), "ext.FileOrder")
+	proto.RegisterType((*FileOrders)(nil), "ext.FileOrders")
+	proto.RegisterType((*DocumentShare)(nil), "ext.DocumentShare")
+	proto.RegisterType((*DocumentExtension)(nil), "ext.DocumentExtension")
+	proto.RegisterMapType((map[string]*FileMetadata)(nil), "ext.DocumentExtension.FileMetadataMapEntry")
+	proto.RegisterType((*FileMetadataTransactionExtension)(nil), "ext.FileMetadataTransactionExtension")
+}
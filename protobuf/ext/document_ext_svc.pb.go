@@ -0,0 +1,2835 @@
+// Hand-maintained counterpart of document_ext_svc.proto, laid out like protoc-gen-go output.
+// Keep both files in sync until the service is upstreamed to hwsc-api-blocks.
+// source: document_ext_svc.proto
+
+package ext
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	lib "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// Filters the broken files report, every Document if both are empty
+type BrokenFilesRequest struct {
+	// Owner of the Documents
+	Uuid                 string   `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Duid                 string   `protobuf:"bytes,2,opt,name=duid,proto3" json:"duid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *BrokenFilesRequest) Reset()         { *m = BrokenFilesRequest{} }
+func (m *BrokenFilesRequest) String() string { return proto.CompactTextString(m) }
+func (*BrokenFilesRequest) ProtoMessage()    {}
+
+func (m *BrokenFilesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BrokenFilesRequest.Unmarshal(m, b)
+}
+func (m *BrokenFilesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BrokenFilesRequest.Marshal(b, m, deterministic)
+}
+func (m *BrokenFilesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BrokenFilesRequest.Merge(m, src)
+}
+func (m *BrokenFilesRequest) XXX_Size() int {
+	return xxx_messageInfo_BrokenFilesRequest.Size(m)
+}
+func (m *BrokenFilesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_BrokenFilesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BrokenFilesRequest proto.InternalMessageInfo
+
+func (m *BrokenFilesRequest) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *BrokenFilesRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+type BrokenFilesResponse struct {
+	Documents            []*BrokenFilesDocument `protobuf:"bytes,1,rep,name=documents,proto3" json:"documents,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
+	XXX_unrecognized     []byte                 `json:"-"`
+	XXX_sizecache        int32                  `json:"-"`
+}
+
+func (m *BrokenFilesResponse) Reset()         { *m = BrokenFilesResponse{} }
+func (m *BrokenFilesResponse) String() string { return proto.CompactTextString(m) }
+func (*BrokenFilesResponse) ProtoMessage()    {}
+
+func (m *BrokenFilesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BrokenFilesResponse.Unmarshal(m, b)
+}
+func (m *BrokenFilesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BrokenFilesResponse.Marshal(b, m, deterministic)
+}
+func (m *BrokenFilesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BrokenFilesResponse.Merge(m, src)
+}
+func (m *BrokenFilesResponse) XXX_Size() int {
+	return xxx_messageInfo_BrokenFilesResponse.Size(m)
+}
+func (m *BrokenFilesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_BrokenFilesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BrokenFilesResponse proto.InternalMessageInfo
+
+func (m *BrokenFilesResponse) GetDocuments() []*BrokenFilesDocument {
+	if m != nil {
+		return m.Documents
+	}
+	return nil
+}
+
+// The broken files of a Document
+type BrokenFilesDocument struct {
+	Duid                 string        `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Uuid                 string        `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Files                []*BrokenFile `protobuf:"bytes,3,rep,name=files,proto3" json:"files,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *BrokenFilesDocument) Reset()         { *m = BrokenFilesDocument{} }
+func (m *BrokenFilesDocument) String() string { return proto.CompactTextString(m) }
+func (*BrokenFilesDocument) ProtoMessage()    {}
+
+func (m *BrokenFilesDocument) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BrokenFilesDocument.Unmarshal(m, b)
+}
+func (m *BrokenFilesDocument) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BrokenFilesDocument.Marshal(b, m, deterministic)
+}
+func (m *BrokenFilesDocument) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BrokenFilesDocument.Merge(m, src)
+}
+func (m *BrokenFilesDocument) XXX_Size() int {
+	return xxx_messageInfo_BrokenFilesDocument.Size(m)
+}
+func (m *BrokenFilesDocument) XXX_DiscardUnknown() {
+	xxx_messageInfo_BrokenFilesDocument.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BrokenFilesDocument proto.InternalMessageInfo
+
+func (m *BrokenFilesDocument) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *BrokenFilesDocument) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *BrokenFilesDocument) GetFiles() []*BrokenFile {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+// A file whose url failed its last check
+type BrokenFile struct {
+	Fuid                 string       `protobuf:"bytes,1,opt,name=fuid,proto3" json:"fuid,omitempty"`
+	Url                  string       `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	Media                lib.FileType `protobuf:"varint,3,opt,name=media,proto3,enum=lib.FileType" json:"media,omitempty"`
+	LinkError            string       `protobuf:"bytes,4,opt,name=link_error,json=linkError,proto3" json:"link_error,omitempty"`
+	LastCheckedTimestamp int64        `protobuf:"varint,5,opt,name=last_checked_timestamp,json=lastCheckedTimestamp,proto3" json:"last_checked_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *BrokenFile) Reset()         { *m = BrokenFile{} }
+func (m *BrokenFile) String() string { return proto.CompactTextString(m) }
+func (*BrokenFile) ProtoMessage()    {}
+
+func (m *BrokenFile) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_BrokenFile.Unmarshal(m, b)
+}
+func (m *BrokenFile) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_BrokenFile.Marshal(b, m, deterministic)
+}
+func (m *BrokenFile) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_BrokenFile.Merge(m, src)
+}
+func (m *BrokenFile) XXX_Size() int {
+	return xxx_messageInfo_BrokenFile.Size(m)
+}
+func (m *BrokenFile) XXX_DiscardUnknown() {
+	xxx_messageInfo_BrokenFile.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_BrokenFile proto.InternalMessageInfo
+
+func (m *BrokenFile) GetFuid() string {
+	if m != nil {
+		return m.Fuid
+	}
+	return ""
+}
+
+func (m *BrokenFile) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *BrokenFile) GetMedia() lib.FileType {
+	if m != nil {
+		return m.Media
+	}
+	return lib.FileType_FILE
+}
+
+func (m *BrokenFile) GetLinkError() string {
+	if m != nil {
+		return m.LinkError
+	}
+	return ""
+}
+
+func (m *BrokenFile) GetLastCheckedTimestamp() int64 {
+	if m != nil {
+		return m.LastCheckedTimestamp
+	}
+	return 0
+}
+
+// Filters the unreferenced files report
+type UnreferencedFilesRequest struct {
+	// Only lists the urls released at or before this UNIX timestamp, every url if 0
+	ReleasedBefore       int64    `protobuf:"varint,1,opt,name=released_before,json=releasedBefore,proto3" json:"released_before,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UnreferencedFilesRequest) Reset()         { *m = UnreferencedFilesRequest{} }
+func (m *UnreferencedFilesRequest) String() string { return proto.CompactTextString(m) }
+func (*UnreferencedFilesRequest) ProtoMessage()    {}
+
+func (m *UnreferencedFilesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UnreferencedFilesRequest.Unmarshal(m, b)
+}
+func (m *UnreferencedFilesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UnreferencedFilesRequest.Marshal(b, m, deterministic)
+}
+func (m *UnreferencedFilesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UnreferencedFilesRequest.Merge(m, src)
+}
+func (m *UnreferencedFilesRequest) XXX_Size() int {
+	return xxx_messageInfo_UnreferencedFilesRequest.Size(m)
+}
+func (m *UnreferencedFilesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UnreferencedFilesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UnreferencedFilesRequest proto.InternalMessageInfo
+
+func (m *UnreferencedFilesRequest) GetReleasedBefore() int64 {
+	if m != nil {
+		return m.ReleasedBefore
+	}
+	return 0
+}
+
+type UnreferencedFilesResponse struct {
+	Files                []*UnreferencedFile `protobuf:"bytes,1,rep,name=files,proto3" json:"files,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *UnreferencedFilesResponse) Reset()         { *m = UnreferencedFilesResponse{} }
+func (m *UnreferencedFilesResponse) String() string { return proto.CompactTextString(m) }
+func (*UnreferencedFilesResponse) ProtoMessage()    {}
+
+func (m *UnreferencedFilesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UnreferencedFilesResponse.Unmarshal(m, b)
+}
+func (m *UnreferencedFilesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UnreferencedFilesResponse.Marshal(b, m, deterministic)
+}
+func (m *UnreferencedFilesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UnreferencedFilesResponse.Merge(m, src)
+}
+func (m *UnreferencedFilesResponse) XXX_Size() int {
+	return xxx_messageInfo_UnreferencedFilesResponse.Size(m)
+}
+func (m *UnreferencedFilesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_UnreferencedFilesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UnreferencedFilesResponse proto.InternalMessageInfo
+
+func (m *UnreferencedFilesResponse) GetFiles() []*UnreferencedFile {
+	if m != nil {
+		return m.Files
+	}
+	return nil
+}
+
+// A stored url no Document references anymore, safe to delete from the storage
+type UnreferencedFile struct {
+	// Normalized url
+	Url string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	// UNIX timestamp of the removal of the last reference
+	ReleasedTimestamp    int64    `protobuf:"varint,2,opt,name=released_timestamp,json=releasedTimestamp,proto3" json:"released_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *UnreferencedFile) Reset()         { *m = UnreferencedFile{} }
+func (m *UnreferencedFile) String() string { return proto.CompactTextString(m) }
+func (*UnreferencedFile) ProtoMessage()    {}
+
+func (m *UnreferencedFile) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UnreferencedFile.Unmarshal(m, b)
+}
+func (m *UnreferencedFile) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UnreferencedFile.Marshal(b, m, deterministic)
+}
+func (m *UnreferencedFile) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UnreferencedFile.Merge(m, src)
+}
+func (m *UnreferencedFile) XXX_Size() int {
+	return xxx_messageInfo_UnreferencedFile.Size(m)
+}
+func (m *UnreferencedFile) XXX_DiscardUnknown() {
+	xxx_messageInfo_UnreferencedFile.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UnreferencedFile proto.InternalMessageInfo
+
+func (m *UnreferencedFile) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *UnreferencedFile) GetReleasedTimestamp() int64 {
+	if m != nil {
+		return m.ReleasedTimestamp
+	}
+	return 0
+}
+
+// Moves a file to the url map of another media type, keeping its fuid
+type MoveFileRequest struct {
+	Duid string `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Fuid string `protobuf:"bytes,2,opt,name=fuid,proto3" json:"fuid,omitempty"`
+	// Target media type
+	Media                lib.FileType `protobuf:"varint,3,opt,name=media,proto3,enum=lib.FileType" json:"media,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *MoveFileRequest) Reset()         { *m = MoveFileRequest{} }
+func (m *MoveFileRequest) String() string { return proto.CompactTextString(m) }
+func (*MoveFileRequest) ProtoMessage()    {}
+
+func (m *MoveFileRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MoveFileRequest.Unmarshal(m, b)
+}
+func (m *MoveFileRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MoveFileRequest.Marshal(b, m, deterministic)
+}
+func (m *MoveFileRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MoveFileRequest.Merge(m, src)
+}
+func (m *MoveFileRequest) XXX_Size() int {
+	return xxx_messageInfo_MoveFileRequest.Size(m)
+}
+func (m *MoveFileRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_MoveFileRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MoveFileRequest proto.InternalMessageInfo
+
+func (m *MoveFileRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *MoveFileRequest) GetFuid() string {
+	if m != nil {
+		return m.Fuid
+	}
+	return ""
+}
+
+func (m *MoveFileRequest) GetMedia() lib.FileType {
+	if m != nil {
+		return m.Media
+	}
+	return lib.FileType_FILE
+}
+
+// Points a file at another url, keeping its fuid and media type
+type ReplaceFileURLRequest struct {
+	Duid                 string   `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Fuid                 string   `protobuf:"bytes,2,opt,name=fuid,proto3" json:"fuid,omitempty"`
+	Url                  string   `protobuf:"bytes,3,opt,name=url,proto3" json:"url,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReplaceFileURLRequest) Reset()         { *m = ReplaceFileURLRequest{} }
+func (m *ReplaceFileURLRequest) String() string { return proto.CompactTextString(m) }
+func (*ReplaceFileURLRequest) ProtoMessage()    {}
+
+func (m *ReplaceFileURLRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplaceFileURLRequest.Unmarshal(m, b)
+}
+func (m *ReplaceFileURLRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplaceFileURLRequest.Marshal(b, m, deterministic)
+}
+func (m *ReplaceFileURLRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplaceFileURLRequest.Merge(m, src)
+}
+func (m *ReplaceFileURLRequest) XXX_Size() int {
+	return xxx_messageInfo_ReplaceFileURLRequest.Size(m)
+}
+func (m *ReplaceFileURLRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplaceFileURLRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReplaceFileURLRequest proto.InternalMessageInfo
+
+func (m *ReplaceFileURLRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *ReplaceFileURLRequest) GetFuid() string {
+	if m != nil {
+		return m.Fuid
+	}
+	return ""
+}
+
+func (m *ReplaceFileURLRequest) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+// Sets the display order of the files of a media type
+type ReorderFilesRequest struct {
+	Duid  string       `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Media lib.FileType `protobuf:"varint,2,opt,name=media,proto3,enum=lib.FileType" json:"media,omitempty"`
+	// Files shown first, in order, the other files follow in their current order
+	Fuids                []string `protobuf:"bytes,3,rep,name=fuids,proto3" json:"fuids,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReorderFilesRequest) Reset()         { *m = ReorderFilesRequest{} }
+func (m *ReorderFilesRequest) String() string { return proto.CompactTextString(m) }
+func (*ReorderFilesRequest) ProtoMessage()    {}
+
+func (m *ReorderFilesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReorderFilesRequest.Unmarshal(m, b)
+}
+func (m *ReorderFilesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReorderFilesRequest.Marshal(b, m, deterministic)
+}
+func (m *ReorderFilesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReorderFilesRequest.Merge(m, src)
+}
+func (m *ReorderFilesRequest) XXX_Size() int {
+	return xxx_messageInfo_ReorderFilesRequest.Size(m)
+}
+func (m *ReorderFilesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReorderFilesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReorderFilesRequest proto.InternalMessageInfo
+
+func (m *ReorderFilesRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *ReorderFilesRequest) GetMedia() lib.FileType {
+	if m != nil {
+		return m.Media
+	}
+	return lib.FileType_FILE
+}
+
+func (m *ReorderFilesRequest) GetFuids() []string {
+	if m != nil {
+		return m.Fuids
+	}
+	return nil
+}
+
+// Makes a file the primary file of its media type
+type SetPrimaryFileRequest struct {
+	Duid                 string   `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Fuid                 string   `protobuf:"bytes,2,opt,name=fuid,proto3" json:"fuid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SetPrimaryFileRequest) Reset()         { *m = SetPrimaryFileRequest{} }
+func (m *SetPrimaryFileRequest) String() string { return proto.CompactTextString(m) }
+func (*SetPrimaryFileRequest) ProtoMessage()    {}
+
+func (m *SetPrimaryFileRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetPrimaryFileRequest.Unmarshal(m, b)
+}
+func (m *SetPrimaryFileRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetPrimaryFileRequest.Marshal(b, m, deterministic)
+}
+func (m *SetPrimaryFileRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetPrimaryFileRequest.Merge(m, src)
+}
+func (m *SetPrimaryFileRequest) XXX_Size() int {
+	return xxx_messageInfo_SetPrimaryFileRequest.Size(m)
+}
+func (m *SetPrimaryFileRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetPrimaryFileRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetPrimaryFileRequest proto.InternalMessageInfo
+
+func (m *SetPrimaryFileRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *SetPrimaryFileRequest) GetFuid() string {
+	if m != nil {
+		return m.Fuid
+	}
+	return ""
+}
+
+// Grants a user or a group access to a Document, replacing its previous share
+type GrantShareRequest struct {
+	Duid string `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	// Granted by the caller, granted_by and granted_timestamp are ignored
+	Share                *DocumentShare `protobuf:"bytes,2,opt,name=share,proto3" json:"share,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *GrantShareRequest) Reset()         { *m = GrantShareRequest{} }
+func (m *GrantShareRequest) String() string { return proto.CompactTextString(m) }
+func (*GrantShareRequest) ProtoMessage()    {}
+
+func (m *GrantShareRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GrantShareRequest.Unmarshal(m, b)
+}
+func (m *GrantShareRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GrantShareRequest.Marshal(b, m, deterministic)
+}
+func (m *GrantShareRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GrantShareRequest.Merge(m, src)
+}
+func (m *GrantShareRequest) XXX_Size() int {
+	return xxx_messageInfo_GrantShareRequest.Size(m)
+}
+func (m *GrantShareRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GrantShareRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GrantShareRequest proto.InternalMessageInfo
+
+func (m *GrantShareRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *GrantShareRequest) GetShare() *DocumentShare {
+	if m != nil {
+		return m.Share
+	}
+	return nil
+}
+
+// Revokes the share of a user or a group, exactly one of uuid and group is set
+type RevokeShareRequest struct {
+	Duid                 string   `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	Uuid                 string   `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Group                string   `protobuf:"bytes,3,opt,name=group,proto3" json:"group,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeShareRequest) Reset()         { *m = RevokeShareRequest{} }
+func (m *RevokeShareRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeShareRequest) ProtoMessage()    {}
+
+func (m *RevokeShareRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeShareRequest.Unmarshal(m, b)
+}
+func (m *RevokeShareRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeShareRequest.Marshal(b, m, deterministic)
+}
+func (m *RevokeShareRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeShareRequest.Merge(m, src)
+}
+func (m *RevokeShareRequest) XXX_Size() int {
+	return xxx_messageInfo_RevokeShareRequest.Size(m)
+}
+func (m *RevokeShareRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeShareRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeShareRequest proto.InternalMessageInfo
+
+func (m *RevokeShareRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *RevokeShareRequest) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *RevokeShareRequest) GetGroup() string {
+	if m != nil {
+		return m.Group
+	}
+	return ""
+}
+
+type ListSharesRequest struct {
+	Duid                 string   `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListSharesRequest) Reset()         { *m = ListSharesRequest{} }
+func (m *ListSharesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListSharesRequest) ProtoMessage()    {}
+
+func (m *ListSharesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListSharesRequest.Unmarshal(m, b)
+}
+func (m *ListSharesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListSharesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListSharesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListSharesRequest.Merge(m, src)
+}
+func (m *ListSharesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListSharesRequest.Size(m)
+}
+func (m *ListSharesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListSharesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListSharesRequest proto.InternalMessageInfo
+
+func (m *ListSharesRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+// The shares of a Document
+type DocumentShares struct {
+	Duid string `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	// Owner of the Document
+	Uuid                 string           `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Shares               []*DocumentShare `protobuf:"bytes,3,rep,name=shares,proto3" json:"shares,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *DocumentShares) Reset()         { *m = DocumentShares{} }
+func (m *DocumentShares) String() string { return proto.CompactTextString(m) }
+func (*DocumentShares) ProtoMessage()    {}
+
+func (m *DocumentShares) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DocumentShares.Unmarshal(m, b)
+}
+func (m *DocumentShares) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DocumentShares.Marshal(b, m, deterministic)
+}
+func (m *DocumentShares) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DocumentShares.Merge(m, src)
+}
+func (m *DocumentShares) XXX_Size() int {
+	return xxx_messageInfo_DocumentShares.Size(m)
+}
+func (m *DocumentShares) XXX_DiscardUnknown() {
+	xxx_messageInfo_DocumentShares.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DocumentShares proto.InternalMessageInfo
+
+func (m *DocumentShares) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *DocumentShares) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *DocumentShares) GetShares() []*DocumentShare {
+	if m != nil {
+		return m.Shares
+	}
+	return nil
+}
+
+// Keeps a Document private until a date, then publishes it
+type SetEmbargoRequest struct {
+	Duid string `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	// Unix time of the publication, 0 lifts the embargo
+	EmbargoUntilTimestamp int64    `protobuf:"varint,2,opt,name=embargo_until_timestamp,json=embargoUntilTimestamp,proto3" json:"embargo_until_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral  struct{} `json:"-"`
+	XXX_unrecognized      []byte   `json:"-"`
+	XXX_sizecache         int32    `json:"-"`
+}
+
+func (m *SetEmbargoRequest) Reset()         { *m = SetEmbargoRequest{} }
+func (m *SetEmbargoRequest) String() string { return proto.CompactTextString(m) }
+func (*SetEmbargoRequest) ProtoMessage()    {}
+
+func (m *SetEmbargoRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SetEmbargoRequest.Unmarshal(m, b)
+}
+func (m *SetEmbargoRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SetEmbargoRequest.Marshal(b, m, deterministic)
+}
+func (m *SetEmbargoRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SetEmbargoRequest.Merge(m, src)
+}
+func (m *SetEmbargoRequest) XXX_Size() int {
+	return xxx_messageInfo_SetEmbargoRequest.Size(m)
+}
+func (m *SetEmbargoRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_SetEmbargoRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SetEmbargoRequest proto.InternalMessageInfo
+
+func (m *SetEmbargoRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *SetEmbargoRequest) GetEmbargoUntilTimestamp() int64 {
+	if m != nil {
+		return m.EmbargoUntilTimestamp
+	}
+	return 0
+}
+
+// Filters the audit log, every record if all are empty
+type AuditRecordsRequest struct {
+	Duid string `protobuf:"bytes,1,opt,name=duid,proto3" json:"duid,omitempty"`
+	// UUID of the caller
+	Actor string `protobuf:"bytes,2,opt,name=actor,proto3" json:"actor,omitempty"`
+	// Only lists the records at or after this UNIX timestamp, if set
+	FromTimestamp int64 `protobuf:"varint,3,opt,name=from_timestamp,json=fromTimestamp,proto3" json:"from_timestamp,omitempty"`
+	// Only lists the records at or before this UNIX timestamp, if set
+	ToTimestamp int64 `protobuf:"varint,4,opt,name=to_timestamp,json=toTimestamp,proto3" json:"to_timestamp,omitempty"`
+	// Most records listed, 100 if 0, at most 1000
+	Limit                int32    `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AuditRecordsRequest) Reset()         { *m = AuditRecordsRequest{} }
+func (m *AuditRecordsRequest) String() string { return proto.CompactTextString(m) }
+func (*AuditRecordsRequest) ProtoMessage()    {}
+
+func (m *AuditRecordsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuditRecordsRequest.Unmarshal(m, b)
+}
+func (m *AuditRecordsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuditRecordsRequest.Marshal(b, m, deterministic)
+}
+func (m *AuditRecordsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuditRecordsRequest.Merge(m, src)
+}
+func (m *AuditRecordsRequest) XXX_Size() int {
+	return xxx_messageInfo_AuditRecordsRequest.Size(m)
+}
+func (m *AuditRecordsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuditRecordsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuditRecordsRequest proto.InternalMessageInfo
+
+func (m *AuditRecordsRequest) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *AuditRecordsRequest) GetActor() string {
+	if m != nil {
+		return m.Actor
+	}
+	return ""
+}
+
+func (m *AuditRecordsRequest) GetFromTimestamp() int64 {
+	if m != nil {
+		return m.FromTimestamp
+	}
+	return 0
+}
+
+func (m *AuditRecordsRequest) GetToTimestamp() int64 {
+	if m != nil {
+		return m.ToTimestamp
+	}
+	return 0
+}
+
+func (m *AuditRecordsRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+type AuditRecordsResponse struct {
+	// Newest first
+	Records              []*AuditRecord `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *AuditRecordsResponse) Reset()         { *m = AuditRecordsResponse{} }
+func (m *AuditRecordsResponse) String() string { return proto.CompactTextString(m) }
+func (*AuditRecordsResponse) ProtoMessage()    {}
+
+func (m *AuditRecordsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuditRecordsResponse.Unmarshal(m, b)
+}
+func (m *AuditRecordsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuditRecordsResponse.Marshal(b, m, deterministic)
+}
+func (m *AuditRecordsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuditRecordsResponse.Merge(m, src)
+}
+func (m *AuditRecordsResponse) XXX_Size() int {
+	return xxx_messageInfo_AuditRecordsResponse.Size(m)
+}
+func (m *AuditRecordsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuditRecordsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuditRecordsResponse proto.InternalMessageInfo
+
+func (m *AuditRecordsResponse) GetRecords() []*AuditRecord {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+// A call mutating a Document, successful or not
+type AuditRecord struct {
+	Id   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Duid string `protobuf:"bytes,2,opt,name=duid,proto3" json:"duid,omitempty"`
+	// Method of the call
+	Operation string `protobuf:"bytes,3,opt,name=operation,proto3" json:"operation,omitempty"`
+	// UUID of the caller, empty if authentication is disabled
+	Actor string `protobuf:"bytes,4,opt,name=actor,proto3" json:"actor,omitempty"`
+	// Network address of the caller
+	Peer      string `protobuf:"bytes,5,opt,name=peer,proto3" json:"peer,omitempty"`
+	RequestId string `protobuf:"bytes,6,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	// UNIX timestamp of the call
+	Timestamp int64 `protobuf:"varint,7,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// gRPC status code of the call
+	Code  string `protobuf:"bytes,8,opt,name=code,proto3" json:"code,omitempty"`
+	Error string `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	// Document before the call, nil if it did not exist
+	Before *DocumentSummary `protobuf:"bytes,10,opt,name=before,proto3" json:"before,omitempty"`
+	// Document after the call, nil if it was deleted or not written
+	After                *DocumentSummary `protobuf:"bytes,11,opt,name=after,proto3" json:"after,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *AuditRecord) Reset()         { *m = AuditRecord{} }
+func (m *AuditRecord) String() string { return proto.CompactTextString(m) }
+func (*AuditRecord) ProtoMessage()    {}
+
+func (m *AuditRecord) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AuditRecord.Unmarshal(m, b)
+}
+func (m *AuditRecord) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AuditRecord.Marshal(b, m, deterministic)
+}
+func (m *AuditRecord) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AuditRecord.Merge(m, src)
+}
+func (m *AuditRecord) XXX_Size() int {
+	return xxx_messageInfo_AuditRecord.Size(m)
+}
+func (m *AuditRecord) XXX_DiscardUnknown() {
+	xxx_messageInfo_AuditRecord.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AuditRecord proto.InternalMessageInfo
+
+func (m *AuditRecord) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetOperation() string {
+	if m != nil {
+		return m.Operation
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetActor() string {
+	if m != nil {
+		return m.Actor
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetPeer() string {
+	if m != nil {
+		return m.Peer
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetRequestId() string {
+	if m != nil {
+		return m.RequestId
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *AuditRecord) GetCode() string {
+	if m != nil {
+		return m.Code
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *AuditRecord) GetBefore() *DocumentSummary {
+	if m != nil {
+		return m.Before
+	}
+	return nil
+}
+
+func (m *AuditRecord) GetAfter() *DocumentSummary {
+	if m != nil {
+		return m.After
+	}
+	return nil
+}
+
+// The state of a Document recorded by the audit log
+type DocumentSummary struct {
+	// Owner of the Document
+	Uuid     string `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	IsPublic bool   `protobuf:"varint,2,opt,name=is_public,json=isPublic,proto3" json:"is_public,omitempty"`
+	// Files of the Document, sorted
+	Fuids []string `protobuf:"bytes,3,rep,name=fuids,proto3" json:"fuids,omitempty"`
+	// Shares as "uuid:<uuid>=<access>" or "group:<group>=<access>", sorted
+	Shares                []string `protobuf:"bytes,4,rep,name=shares,proto3" json:"shares,omitempty"`
+	EmbargoUntilTimestamp int64    `protobuf:"varint,5,opt,name=embargo_until_timestamp,json=embargoUntilTimestamp,proto3" json:"embargo_until_timestamp,omitempty"`
+	UpdateTimestamp       int64    `protobuf:"varint,6,opt,name=update_timestamp,json=updateTimestamp,proto3" json:"update_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral  struct{} `json:"-"`
+	XXX_unrecognized      []byte   `json:"-"`
+	XXX_sizecache         int32    `json:"-"`
+}
+
+func (m *DocumentSummary) Reset()         { *m = DocumentSummary{} }
+func (m *DocumentSummary) String() string { return proto.CompactTextString(m) }
+func (*DocumentSummary) ProtoMessage()    {}
+
+func (m *DocumentSummary) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DocumentSummary.Unmarshal(m, b)
+}
+func (m *DocumentSummary) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DocumentSummary.Marshal(b, m, deterministic)
+}
+func (m *DocumentSummary) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DocumentSummary.Merge(m, src)
+}
+func (m *DocumentSummary) XXX_Size() int {
+	return xxx_messageInfo_DocumentSummary.Size(m)
+}
+func (m *DocumentSummary) XXX_DiscardUnknown() {
+	xxx_messageInfo_DocumentSummary.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DocumentSummary proto.InternalMessageInfo
+
+func (m *DocumentSummary) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *DocumentSummary) GetIsPublic() bool {
+	if m != nil {
+		return m.IsPublic
+	}
+	return false
+}
+
+func (m *DocumentSummary) GetFuids() []string {
+	if m != nil {
+		return m.Fuids
+	}
+	return nil
+}
+
+func (m *DocumentSummary) GetShares() []string {
+	if m != nil {
+		return m.Shares
+	}
+	return nil
+}
+
+func (m *DocumentSummary) GetEmbargoUntilTimestamp() int64 {
+	if m != nil {
+		return m.EmbargoUntilTimestamp
+	}
+	return 0
+}
+
+func (m *DocumentSummary) GetUpdateTimestamp() int64 {
+	if m != nil {
+		return m.UpdateTimestamp
+	}
+	return 0
+}
+
+// Filters the changes watched, every change the caller may read if all are empty
+type WatchDocumentsRequest struct {
+	// Only the Documents of this owner
+	Uuid string `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	// Only the Documents with these duids
+	Duids []string `protobuf:"bytes,2,rep,name=duids,proto3" json:"duids,omitempty"`
+	// Only the Documents matching these QueryDocument parameters, deletions are not filtered by them
+	Query *lib.QueryTransaction `protobuf:"bytes,3,opt,name=query,proto3" json:"query,omitempty"`
+	// Resumes after the change with this token, or watches from now if empty
+	ResumeToken          string   `protobuf:"bytes,4,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchDocumentsRequest) Reset()         { *m = WatchDocumentsRequest{} }
+func (m *WatchDocumentsRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchDocumentsRequest) ProtoMessage()    {}
+
+func (m *WatchDocumentsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchDocumentsRequest.Unmarshal(m, b)
+}
+func (m *WatchDocumentsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchDocumentsRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchDocumentsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchDocumentsRequest.Merge(m, src)
+}
+func (m *WatchDocumentsRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchDocumentsRequest.Size(m)
+}
+func (m *WatchDocumentsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchDocumentsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchDocumentsRequest proto.InternalMessageInfo
+
+func (m *WatchDocumentsRequest) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *WatchDocumentsRequest) GetDuids() []string {
+	if m != nil {
+		return m.Duids
+	}
+	return nil
+}
+
+func (m *WatchDocumentsRequest) GetQuery() *lib.QueryTransaction {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+func (m *WatchDocumentsRequest) GetResumeToken() string {
+	if m != nil {
+		return m.ResumeToken
+	}
+	return ""
+}
+
+// A change of a Document
+type DocumentChange struct {
+	Type DocumentChangeType `protobuf:"varint,1,opt,name=type,proto3,enum=ext.DocumentChangeType" json:"type,omitempty"`
+	Duid string             `protobuf:"bytes,2,opt,name=duid,proto3" json:"duid,omitempty"`
+	// Document as of the notification, nil if it was deleted
+	Document *lib.Document `protobuf:"bytes,3,opt,name=document,proto3" json:"document,omitempty"`
+	// Type of the change event, like file.added
+	Operation string `protobuf:"bytes,4,opt,name=operation,proto3" json:"operation,omitempty"`
+	// UNIX timestamp of the change
+	Timestamp int64 `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// Resumes the watch after this change
+	ResumeToken          string   `protobuf:"bytes,6,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DocumentChange) Reset()         { *m = DocumentChange{} }
+func (m *DocumentChange) String() string { return proto.CompactTextString(m) }
+func (*DocumentChange) ProtoMessage()    {}
+
+func (m *DocumentChange) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DocumentChange.Unmarshal(m, b)
+}
+func (m *DocumentChange) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DocumentChange.Marshal(b, m, deterministic)
+}
+func (m *DocumentChange) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DocumentChange.Merge(m, src)
+}
+func (m *DocumentChange) XXX_Size() int {
+	return xxx_messageInfo_DocumentChange.Size(m)
+}
+func (m *DocumentChange) XXX_DiscardUnknown() {
+	xxx_messageInfo_DocumentChange.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DocumentChange proto.InternalMessageInfo
+
+func (m *DocumentChange) GetType() DocumentChangeType {
+	if m != nil {
+		return m.Type
+	}
+	return DocumentChangeType_CREATED
+}
+
+func (m *DocumentChange) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *DocumentChange) GetDocument() *lib.Document {
+	if m != nil {
+		return m.Document
+	}
+	return nil
+}
+
+func (m *DocumentChange) GetOperation() string {
+	if m != nil {
+		return m.Operation
+	}
+	return ""
+}
+
+func (m *DocumentChange) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *DocumentChange) GetResumeToken() string {
+	if m != nil {
+		return m.ResumeToken
+	}
+	return ""
+}
+
+// Posts the events of the Documents its owner may read to a url
+type WebhookSubscription struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Owner of the subscription
+	Uuid string `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	// Receives the events in POST requests
+	Url string `protobuf:"bytes,3,opt,name=url,proto3" json:"url,omitempty"`
+	// Types of the events posted, like document.published, every type if empty
+	EventTypes []string `protobuf:"bytes,4,rep,name=event_types,json=eventTypes,proto3" json:"event_types,omitempty"`
+	// Only the Documents matching these QueryDocument parameters, a max_record_timestamp of 0 is unbounded
+	Query *lib.QueryTransaction    `protobuf:"bytes,5,opt,name=query,proto3" json:"query,omitempty"`
+	State WebhookSubscriptionState `protobuf:"varint,6,opt,name=state,proto3,enum=ext.WebhookSubscriptionState" json:"state,omitempty"`
+	// Signs the body of the requests, only returned when the subscription is created
+	Secret string `protobuf:"bytes,7,opt,name=secret,proto3" json:"secret,omitempty"`
+	// Deliveries failed in a row, the subscription is disabled after too many
+	ConsecutiveFailures int32 `protobuf:"varint,8,opt,name=consecutive_failures,json=consecutiveFailures,proto3" json:"consecutive_failures,omitempty"`
+	// Error of the last failed delivery of a disabled subscription
+	DisabledReason       string   `protobuf:"bytes,9,opt,name=disabled_reason,json=disabledReason,proto3" json:"disabled_reason,omitempty"`
+	CreateTimestamp      int64    `protobuf:"varint,10,opt,name=create_timestamp,json=createTimestamp,proto3" json:"create_timestamp,omitempty"`
+	UpdateTimestamp      int64    `protobuf:"varint,11,opt,name=update_timestamp,json=updateTimestamp,proto3" json:"update_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WebhookSubscription) Reset()         { *m = WebhookSubscription{} }
+func (m *WebhookSubscription) String() string { return proto.CompactTextString(m) }
+func (*WebhookSubscription) ProtoMessage()    {}
+
+func (m *WebhookSubscription) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WebhookSubscription.Unmarshal(m, b)
+}
+func (m *WebhookSubscription) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WebhookSubscription.Marshal(b, m, deterministic)
+}
+func (m *WebhookSubscription) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WebhookSubscription.Merge(m, src)
+}
+func (m *WebhookSubscription) XXX_Size() int {
+	return xxx_messageInfo_WebhookSubscription.Size(m)
+}
+func (m *WebhookSubscription) XXX_DiscardUnknown() {
+	xxx_messageInfo_WebhookSubscription.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WebhookSubscription proto.InternalMessageInfo
+
+func (m *WebhookSubscription) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *WebhookSubscription) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+func (m *WebhookSubscription) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *WebhookSubscription) GetEventTypes() []string {
+	if m != nil {
+		return m.EventTypes
+	}
+	return nil
+}
+
+func (m *WebhookSubscription) GetQuery() *lib.QueryTransaction {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+func (m *WebhookSubscription) GetState() WebhookSubscriptionState {
+	if m != nil {
+		return m.State
+	}
+	return WebhookSubscriptionState_ACTIVE
+}
+
+func (m *WebhookSubscription) GetSecret() string {
+	if m != nil {
+		return m.Secret
+	}
+	return ""
+}
+
+func (m *WebhookSubscription) GetConsecutiveFailures() int32 {
+	if m != nil {
+		return m.ConsecutiveFailures
+	}
+	return 0
+}
+
+func (m *WebhookSubscription) GetDisabledReason() string {
+	if m != nil {
+		return m.DisabledReason
+	}
+	return ""
+}
+
+func (m *WebhookSubscription) GetCreateTimestamp() int64 {
+	if m != nil {
+		return m.CreateTimestamp
+	}
+	return 0
+}
+
+func (m *WebhookSubscription) GetUpdateTimestamp() int64 {
+	if m != nil {
+		return m.UpdateTimestamp
+	}
+	return 0
+}
+
+// Subscribes a url to the events of the Documents the caller may read
+type CreateWebhookSubscriptionRequest struct {
+	Url string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
+	// Types of the events posted, every type if empty
+	EventTypes []string `protobuf:"bytes,2,rep,name=event_types,json=eventTypes,proto3" json:"event_types,omitempty"`
+	// Only the Documents matching these QueryDocument parameters, every Document if nil
+	Query *lib.QueryTransaction `protobuf:"bytes,3,opt,name=query,proto3" json:"query,omitempty"`
+	// Signs the body of the requests, generated if empty
+	Secret               string   `protobuf:"bytes,4,opt,name=secret,proto3" json:"secret,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateWebhookSubscriptionRequest) Reset()         { *m = CreateWebhookSubscriptionRequest{} }
+func (m *CreateWebhookSubscriptionRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateWebhookSubscriptionRequest) ProtoMessage()    {}
+
+func (m *CreateWebhookSubscriptionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateWebhookSubscriptionRequest.Unmarshal(m, b)
+}
+func (m *CreateWebhookSubscriptionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateWebhookSubscriptionRequest.Marshal(b, m, deterministic)
+}
+func (m *CreateWebhookSubscriptionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateWebhookSubscriptionRequest.Merge(m, src)
+}
+func (m *CreateWebhookSubscriptionRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateWebhookSubscriptionRequest.Size(m)
+}
+func (m *CreateWebhookSubscriptionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateWebhookSubscriptionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateWebhookSubscriptionRequest proto.InternalMessageInfo
+
+func (m *CreateWebhookSubscriptionRequest) GetUrl() string {
+	if m != nil {
+		return m.Url
+	}
+	return ""
+}
+
+func (m *CreateWebhookSubscriptionRequest) GetEventTypes() []string {
+	if m != nil {
+		return m.EventTypes
+	}
+	return nil
+}
+
+func (m *CreateWebhookSubscriptionRequest) GetQuery() *lib.QueryTransaction {
+	if m != nil {
+		return m.Query
+	}
+	return nil
+}
+
+func (m *CreateWebhookSubscriptionRequest) GetSecret() string {
+	if m != nil {
+		return m.Secret
+	}
+	return ""
+}
+
+// Lists the webhook subscriptions of a user
+type ListWebhookSubscriptionsRequest struct {
+	// Owner of the subscriptions, the caller if empty, admins only for another user
+	Uuid                 string   `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListWebhookSubscriptionsRequest) Reset()         { *m = ListWebhookSubscriptionsRequest{} }
+func (m *ListWebhookSubscriptionsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListWebhookSubscriptionsRequest) ProtoMessage()    {}
+
+func (m *ListWebhookSubscriptionsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListWebhookSubscriptionsRequest.Unmarshal(m, b)
+}
+func (m *ListWebhookSubscriptionsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListWebhookSubscriptionsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListWebhookSubscriptionsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListWebhookSubscriptionsRequest.Merge(m, src)
+}
+func (m *ListWebhookSubscriptionsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListWebhookSubscriptionsRequest.Size(m)
+}
+func (m *ListWebhookSubscriptionsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListWebhookSubscriptionsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListWebhookSubscriptionsRequest proto.InternalMessageInfo
+
+func (m *ListWebhookSubscriptionsRequest) GetUuid() string {
+	if m != nil {
+		return m.Uuid
+	}
+	return ""
+}
+
+// The webhook subscriptions of a user
+type WebhookSubscriptions struct {
+	// Oldest first
+	Subscriptions        []*WebhookSubscription `protobuf:"bytes,1,rep,name=subscriptions,proto3" json:"subscriptions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
+	XXX_unrecognized     []byte                 `json:"-"`
+	XXX_sizecache        int32                  `json:"-"`
+}
+
+func (m *WebhookSubscriptions) Reset()         { *m = WebhookSubscriptions{} }
+func (m *WebhookSubscriptions) String() string { return proto.CompactTextString(m) }
+func (*WebhookSubscriptions) ProtoMessage()    {}
+
+func (m *WebhookSubscriptions) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WebhookSubscriptions.Unmarshal(m, b)
+}
+func (m *WebhookSubscriptions) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WebhookSubscriptions.Marshal(b, m, deterministic)
+}
+func (m *WebhookSubscriptions) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WebhookSubscriptions.Merge(m, src)
+}
+func (m *WebhookSubscriptions) XXX_Size() int {
+	return xxx_messageInfo_WebhookSubscriptions.Size(m)
+}
+func (m *WebhookSubscriptions) XXX_DiscardUnknown() {
+	xxx_messageInfo_WebhookSubscriptions.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WebhookSubscriptions proto.InternalMessageInfo
+
+func (m *WebhookSubscriptions) GetSubscriptions() []*WebhookSubscription {
+	if m != nil {
+		return m.Subscriptions
+	}
+	return nil
+}
+
+// Pauses or resumes a webhook subscription
+type PauseWebhookSubscriptionRequest struct {
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Pauses the subscription if true, resumes it if false, including a disabled one
+	Paused               bool     `protobuf:"varint,2,opt,name=paused,proto3" json:"paused,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PauseWebhookSubscriptionRequest) Reset()         { *m = PauseWebhookSubscriptionRequest{} }
+func (m *PauseWebhookSubscriptionRequest) String() string { return proto.CompactTextString(m) }
+func (*PauseWebhookSubscriptionRequest) ProtoMessage()    {}
+
+func (m *PauseWebhookSubscriptionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PauseWebhookSubscriptionRequest.Unmarshal(m, b)
+}
+func (m *PauseWebhookSubscriptionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PauseWebhookSubscriptionRequest.Marshal(b, m, deterministic)
+}
+func (m *PauseWebhookSubscriptionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PauseWebhookSubscriptionRequest.Merge(m, src)
+}
+func (m *PauseWebhookSubscriptionRequest) XXX_Size() int {
+	return xxx_messageInfo_PauseWebhookSubscriptionRequest.Size(m)
+}
+func (m *PauseWebhookSubscriptionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PauseWebhookSubscriptionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PauseWebhookSubscriptionRequest proto.InternalMessageInfo
+
+func (m *PauseWebhookSubscriptionRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *PauseWebhookSubscriptionRequest) GetPaused() bool {
+	if m != nil {
+		return m.Paused
+	}
+	return false
+}
+
+// Deletes a webhook subscription and its deliveries
+type DeleteWebhookSubscriptionRequest struct {
+	Id                   string   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteWebhookSubscriptionRequest) Reset()         { *m = DeleteWebhookSubscriptionRequest{} }
+func (m *DeleteWebhookSubscriptionRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteWebhookSubscriptionRequest) ProtoMessage()    {}
+
+func (m *DeleteWebhookSubscriptionRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteWebhookSubscriptionRequest.Unmarshal(m, b)
+}
+func (m *DeleteWebhookSubscriptionRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteWebhookSubscriptionRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteWebhookSubscriptionRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteWebhookSubscriptionRequest.Merge(m, src)
+}
+func (m *DeleteWebhookSubscriptionRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteWebhookSubscriptionRequest.Size(m)
+}
+func (m *DeleteWebhookSubscriptionRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteWebhookSubscriptionRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteWebhookSubscriptionRequest proto.InternalMessageInfo
+
+func (m *DeleteWebhookSubscriptionRequest) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+// Lists the deliveries of a webhook subscription
+type ListWebhookDeliveriesRequest struct {
+	SubscriptionId string `protobuf:"bytes,1,opt,name=subscription_id,json=subscriptionId,proto3" json:"subscription_id,omitempty"`
+	// Maximum deliveries listed, 100 if 0
+	Limit                int32    `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListWebhookDeliveriesRequest) Reset()         { *m = ListWebhookDeliveriesRequest{} }
+func (m *ListWebhookDeliveriesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListWebhookDeliveriesRequest) ProtoMessage()    {}
+
+func (m *ListWebhookDeliveriesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListWebhookDeliveriesRequest.Unmarshal(m, b)
+}
+func (m *ListWebhookDeliveriesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListWebhookDeliveriesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListWebhookDeliveriesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListWebhookDeliveriesRequest.Merge(m, src)
+}
+func (m *ListWebhookDeliveriesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListWebhookDeliveriesRequest.Size(m)
+}
+func (m *ListWebhookDeliveriesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListWebhookDeliveriesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListWebhookDeliveriesRequest proto.InternalMessageInfo
+
+func (m *ListWebhookDeliveriesRequest) GetSubscriptionId() string {
+	if m != nil {
+		return m.SubscriptionId
+	}
+	return ""
+}
+
+func (m *ListWebhookDeliveriesRequest) GetLimit() int32 {
+	if m != nil {
+		return m.Limit
+	}
+	return 0
+}
+
+// The delivery of an event to a webhook subscription
+type WebhookDelivery struct {
+	Id             string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	SubscriptionId string `protobuf:"bytes,2,opt,name=subscription_id,json=subscriptionId,proto3" json:"subscription_id,omitempty"`
+	// Sent in the X-Hwsc-Event-Id header of every attempt
+	EventId   string               `protobuf:"bytes,3,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	EventType string               `protobuf:"bytes,4,opt,name=event_type,json=eventType,proto3" json:"event_type,omitempty"`
+	Duid      string               `protobuf:"bytes,5,opt,name=duid,proto3" json:"duid,omitempty"`
+	State     WebhookDeliveryState `protobuf:"varint,6,opt,name=state,proto3,enum=ext.WebhookDeliveryState" json:"state,omitempty"`
+	Attempts  int32                `protobuf:"varint,7,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	// HTTP status of the last attempt, 0 if it got no response
+	ResponseCode         int32  `protobuf:"varint,8,opt,name=response_code,json=responseCode,proto3" json:"response_code,omitempty"`
+	LastError            string `protobuf:"bytes,9,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	CreateTimestamp      int64  `protobuf:"varint,10,opt,name=create_timestamp,json=createTimestamp,proto3" json:"create_timestamp,omitempty"`
+	LastAttemptTimestamp int64  `protobuf:"varint,11,opt,name=last_attempt_timestamp,json=lastAttemptTimestamp,proto3" json:"last_attempt_timestamp,omitempty"`
+	// UNIX timestamp of the retry of a pending delivery
+	NextAttemptTimestamp int64    `protobuf:"varint,12,opt,name=next_attempt_timestamp,json=nextAttemptTimestamp,proto3" json:"next_attempt_timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WebhookDelivery) Reset()         { *m = WebhookDelivery{} }
+func (m *WebhookDelivery) String() string { return proto.CompactTextString(m) }
+func (*WebhookDelivery) ProtoMessage()    {}
+
+func (m *WebhookDelivery) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WebhookDelivery.Unmarshal(m, b)
+}
+func (m *WebhookDelivery) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WebhookDelivery.Marshal(b, m, deterministic)
+}
+func (m *WebhookDelivery) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WebhookDelivery.Merge(m, src)
+}
+func (m *WebhookDelivery) XXX_Size() int {
+	return xxx_messageInfo_WebhookDelivery.Size(m)
+}
+func (m *WebhookDelivery) XXX_DiscardUnknown() {
+	xxx_messageInfo_WebhookDelivery.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WebhookDelivery proto.InternalMessageInfo
+
+func (m *WebhookDelivery) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetSubscriptionId() string {
+	if m != nil {
+		return m.SubscriptionId
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetEventId() string {
+	if m != nil {
+		return m.EventId
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetEventType() string {
+	if m != nil {
+		return m.EventType
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetDuid() string {
+	if m != nil {
+		return m.Duid
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetState() WebhookDeliveryState {
+	if m != nil {
+		return m.State
+	}
+	return WebhookDeliveryState_PENDING
+}
+
+func (m *WebhookDelivery) GetAttempts() int32 {
+	if m != nil {
+		return m.Attempts
+	}
+	return 0
+}
+
+func (m *WebhookDelivery) GetResponseCode() int32 {
+	if m != nil {
+		return m.ResponseCode
+	}
+	return 0
+}
+
+func (m *WebhookDelivery) GetLastError() string {
+	if m != nil {
+		return m.LastError
+	}
+	return ""
+}
+
+func (m *WebhookDelivery) GetCreateTimestamp() int64 {
+	if m != nil {
+		return m.CreateTimestamp
+	}
+	return 0
+}
+
+func (m *WebhookDelivery) GetLastAttemptTimestamp() int64 {
+	if m != nil {
+		return m.LastAttemptTimestamp
+	}
+	return 0
+}
+
+func (m *WebhookDelivery) GetNextAttemptTimestamp() int64 {
+	if m != nil {
+		return m.NextAttemptTimestamp
+	}
+	return 0
+}
+
+// The deliveries of a webhook subscription
+type WebhookDeliveries struct {
+	// Newest first
+	Deliveries           []*WebhookDelivery `protobuf:"bytes,1,rep,name=deliveries,proto3" json:"deliveries,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *WebhookDeliveries) Reset()         { *m = WebhookDeliveries{} }
+func (m *WebhookDeliveries) String() string { return proto.CompactTextString(m) }
+func (*WebhookDeliveries) ProtoMessage()    {}
+
+func (m *WebhookDeliveries) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WebhookDeliveries.Unmarshal(m, b)
+}
+func (m *WebhookDeliveries) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WebhookDeliveries.Marshal(b, m, deterministic)
+}
+func (m *WebhookDeliveries) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WebhookDeliveries.Merge(m, src)
+}
+func (m *WebhookDeliveries) XXX_Size() int {
+	return xxx_messageInfo_WebhookDeliveries.Size(m)
+}
+func (m *WebhookDeliveries) XXX_DiscardUnknown() {
+	xxx_messageInfo_WebhookDeliveries.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WebhookDeliveries proto.InternalMessageInfo
+
+func (m *WebhookDeliveries) GetDeliveries() []*WebhookDelivery {
+	if m != nil {
+		return m.Deliveries
+	}
+	return nil
+}
+
+// Rebuilds the search index from the stored Documents
+type ReindexDocumentsRequest struct {
+	// Documents per bulk request, search_batchsize if 0
+	BatchSize            int32    `protobuf:"varint,1,opt,name=batch_size,json=batchSize,proto3" json:"batch_size,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReindexDocumentsRequest) Reset()         { *m = ReindexDocumentsRequest{} }
+func (m *ReindexDocumentsRequest) String() string { return proto.CompactTextString(m) }
+func (*ReindexDocumentsRequest) ProtoMessage()    {}
+
+func (m *ReindexDocumentsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReindexDocumentsRequest.Unmarshal(m, b)
+}
+func (m *ReindexDocumentsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReindexDocumentsRequest.Marshal(b, m, deterministic)
+}
+func (m *ReindexDocumentsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReindexDocumentsRequest.Merge(m, src)
+}
+func (m *ReindexDocumentsRequest) XXX_Size() int {
+	return xxx_messageInfo_ReindexDocumentsRequest.Size(m)
+}
+func (m *ReindexDocumentsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReindexDocumentsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReindexDocumentsRequest proto.InternalMessageInfo
+
+func (m *ReindexDocumentsRequest) GetBatchSize() int32 {
+	if m != nil {
+		return m.BatchSize
+	}
+	return 0
+}
+
+// The outcome of a full reindex
+type ReindexDocumentsResponse struct {
+	// Documents written to the index
+	Indexed int64 `protobuf:"varint,1,opt,name=indexed,proto3" json:"indexed,omitempty"`
+	// Entries removed from the index, their Document being gone
+	Deleted              int64    `protobuf:"varint,2,opt,name=deleted,proto3" json:"deleted,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReindexDocumentsResponse) Reset()         { *m = ReindexDocumentsResponse{} }
+func (m *ReindexDocumentsResponse) String() string { return proto.CompactTextString(m) }
+func (*ReindexDocumentsResponse) ProtoMessage()    {}
+
+func (m *ReindexDocumentsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReindexDocumentsResponse.Unmarshal(m, b)
+}
+func (m *ReindexDocumentsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReindexDocumentsResponse.Marshal(b, m, deterministic)
+}
+func (m *ReindexDocumentsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReindexDocumentsResponse.Merge(m, src)
+}
+func (m *ReindexDocumentsResponse) XXX_Size() int {
+	return xxx_messageInfo_ReindexDocumentsResponse.Size(m)
+}
+func (m *ReindexDocumentsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReindexDocumentsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReindexDocumentsResponse proto.InternalMessageInfo
+
+func (m *ReindexDocumentsResponse) GetIndexed() int64 {
+	if m != nil {
+		return m.Indexed
+	}
+	return 0
+}
+
+func (m *ReindexDocumentsResponse) GetDeleted() int64 {
+	if m != nil {
+		return m.Deleted
+	}
+	return 0
+}
+
+// Compares the search index with the stored Documents
+type CheckSearchIndexRequest struct {
+	// Writes the missing and stale entries, and removes the extra ones
+	Repair               bool     `protobuf:"varint,1,opt,name=repair,proto3" json:"repair,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CheckSearchIndexRequest) Reset()         { *m = CheckSearchIndexRequest{} }
+func (m *CheckSearchIndexRequest) String() string { return proto.CompactTextString(m) }
+func (*CheckSearchIndexRequest) ProtoMessage()    {}
+
+func (m *CheckSearchIndexRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CheckSearchIndexRequest.Unmarshal(m, b)
+}
+func (m *CheckSearchIndexRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CheckSearchIndexRequest.Marshal(b, m, deterministic)
+}
+func (m *CheckSearchIndexRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CheckSearchIndexRequest.Merge(m, src)
+}
+func (m *CheckSearchIndexRequest) XXX_Size() int {
+	return xxx_messageInfo_CheckSearchIndexRequest.Size(m)
+}
+func (m *CheckSearchIndexRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CheckSearchIndexRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CheckSearchIndexRequest proto.InternalMessageInfo
+
+func (m *CheckSearchIndexRequest) GetRepair() bool {
+	if m != nil {
+		return m.Repair
+	}
+	return false
+}
+
+// The differences between the search index and the stored Documents
+type SearchIndexReport struct {
+	// Stored Documents
+	Documents int64 `protobuf:"varint,1,opt,name=documents,proto3" json:"documents,omitempty"`
+	// Duids of the Documents not indexed
+	Missing []string `protobuf:"bytes,2,rep,name=missing,proto3" json:"missing,omitempty"`
+	// Duids of the Documents whose entry differs from the Document
+	Stale []string `protobuf:"bytes,3,rep,name=stale,proto3" json:"stale,omitempty"`
+	// Duids of the entries with no Document
+	Extra []string `protobuf:"bytes,4,rep,name=extra,proto3" json:"extra,omitempty"`
+	// The differences were repaired
+	Repaired             bool     `protobuf:"varint,5,opt,name=repaired,proto3" json:"repaired,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SearchIndexReport) Reset()         { *m = SearchIndexReport{} }
+func (m *SearchIndexReport) String() string { return proto.CompactTextString(m) }
+func (*SearchIndexReport) ProtoMessage()    {}
+
+func (m *SearchIndexReport) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SearchIndexReport.Unmarshal(m, b)
+}
+func (m *SearchIndexReport) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SearchIndexReport.Marshal(b, m, deterministic)
+}
+func (m *SearchIndexReport) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SearchIndexReport.Merge(m, src)
+}
+func (m *SearchIndexReport) XXX_Size() int {
+	return xxx_messageInfo_SearchIndexReport.Size(m)
+}
+func (m *SearchIndexReport) XXX_DiscardUnknown() {
+	xxx_messageInfo_SearchIndexReport.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SearchIndexReport proto.InternalMessageInfo
+
+func (m *SearchIndexReport) GetDocuments() int64 {
+	if m != nil {
+		return m.Documents
+	}
+	return 0
+}
+
+func (m *SearchIndexReport) GetMissing() []string {
+	if m != nil {
+		return m.Missing
+	}
+	return nil
+}
+
+func (m *SearchIndexReport) GetStale() []string {
+	if m != nil {
+		return m.Stale
+	}
+	return nil
+}
+
+func (m *SearchIndexReport) GetExtra() []string {
+	if m != nil {
+		return m.Extra
+	}
+	return nil
+}
+
+func (m *SearchIndexReport) GetRepaired() bool {
+	if m != nil {
+		return m.Repaired
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterType((*BrokenFilesRequest)(nil), "ext.BrokenFilesRequest")
+	proto.RegisterType((*BrokenFilesResponse)(nil), "ext.BrokenFilesResponse")
+	proto.RegisterType((*BrokenFilesDocument)(nil), "ext.BrokenFilesDocument")
+	proto.RegisterType((*BrokenFile)(nil), "ext.BrokenFile")
+	proto.RegisterType((*UnreferencedFilesRequest)(nil), "ext.UnreferencedFilesRequest")
+	proto.RegisterType((*UnreferencedFilesResponse)(nil), "ext.UnreferencedFilesResponse")
+	proto.RegisterType((*UnreferencedFile)(nil), "ext.UnreferencedFile")
+	proto.RegisterType((*MoveFileRequest)(nil), "ext.MoveFileRequest")
+	proto.RegisterType((*ReplaceFileURLRequest)(nil), "ext.ReplaceFileURLRequest")
+	proto.RegisterType((*ReorderFilesRequest)(nil), "ext.ReorderFilesRequest")
+	proto.RegisterType((*SetPrimaryFileRequest)(nil), "ext.SetPrimaryFileRequest")
+	proto.RegisterType((*GrantShareRequest)(nil), "ext.GrantShareRequest")
+	proto.RegisterType((*RevokeShareRequest)(nil), "ext.RevokeShareRequest")
+	proto.RegisterType((*ListSharesRequest)(nil), "ext.ListSharesRequest")
+	proto.RegisterType((*DocumentShares)(nil), "ext.DocumentShares")
+	proto.RegisterType((*SetEmbargoRequest)(nil), "ext.SetEmbargoRequest")
+	proto.RegisterType((*AuditRecordsRequest)(nil), "ext.AuditRecordsRequest")
+	proto.RegisterType((*AuditRecordsResponse)(nil), "ext.AuditRecordsResponse")
+	proto.RegisterType((*AuditRecord)(nil), "ext.AuditRecord")
+	proto.RegisterType((*DocumentSummary)(nil), "ext.DocumentSummary")
+	proto.RegisterType((*WatchDocumentsRequest)(nil), "ext.WatchDocumentsRequest")
+	proto.RegisterType((*DocumentChange)(nil), "ext.DocumentChange")
+	proto.RegisterType((*WebhookSubscription)(nil), "ext.WebhookSubscription")
+	proto.RegisterType((*CreateWebhookSubscriptionRequest)(nil), "ext.CreateWebhookSubscriptionRequest")
+	proto.RegisterType((*ListWebhookSubscriptionsRequest)(nil), "ext.ListWebhookSubscriptionsRequest")
+	proto.RegisterType((*WebhookSubscriptions)(nil), "ext.WebhookSubscriptions")
+	proto.RegisterType((*PauseWebhookSubscriptionRequest)(nil), "ext.PauseWebhookSubscriptionRequest")
+	proto.RegisterType((*DeleteWebhookSubscriptionRequest)(nil), "ext.DeleteWebhookSubscriptionRequest")
+	proto.RegisterType((*ListWebhookDeliveriesRequest)(nil), "ext.ListWebhookDeliveriesRequest")
+	proto.RegisterType((*WebhookDelivery)(nil), "ext.WebhookDelivery")
+	proto.RegisterType((*WebhookDeliveries)(nil), "ext.WebhookDeliveries")
+	proto.RegisterType((*ReindexDocumentsRequest)(nil), "ext.ReindexDocumentsRequest")
+	proto.RegisterType((*ReindexDocumentsResponse)(nil), "ext.ReindexDocumentsResponse")
+	proto.RegisterType((*CheckSearchIndexRequest)(nil), "ext.CheckSearchIndexRequest")
+	proto.RegisterType((*SearchIndexReport)(nil), "ext.SearchIndexReport")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// DocumentExtensionServiceClient is the client API for DocumentExtensionService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type DocumentExtensionServiceClient interface {
+	// Lists the files whose url failed its last check, by Document
+	ListBrokenFiles(ctx context.Context, in *BrokenFilesRequest, opts ...grpc.CallOption) (*BrokenFilesResponse, error)
+	// Lists the stored urls no Document references anymore
+	ListUnreferencedFiles(ctx context.Context, in *UnreferencedFilesRequest, opts ...grpc.CallOption) (*UnreferencedFilesResponse, error)
+	// Moves a file to the url map of another media type, returns the updated Document
+	MoveFile(ctx context.Context, in *MoveFileRequest, opts ...grpc.CallOption) (*lib.Document, error)
+	// Points a file at another url, returns the updated Document
+	ReplaceFileURL(ctx context.Context, in *ReplaceFileURLRequest, opts ...grpc.CallOption) (*lib.Document, error)
+	// Sets the display order of the files of a media type, returns the updated Document
+	ReorderFiles(ctx context.Context, in *ReorderFilesRequest, opts ...grpc.CallOption) (*lib.Document, error)
+	// Makes a file the primary file of its media type, returns the updated Document
+	SetPrimaryFile(ctx context.Context, in *SetPrimaryFileRequest, opts ...grpc.CallOption) (*lib.Document, error)
+	// Grants a user or a group access to a Document, returns its shares
+	GrantShare(ctx context.Context, in *GrantShareRequest, opts ...grpc.CallOption) (*DocumentShares, error)
+	// Revokes the share of a user or a group, returns the shares of the Document
+	RevokeShare(ctx context.Context, in *RevokeShareRequest, opts ...grpc.CallOption) (*DocumentShares, error)
+	// Lists the shares of a Document
+	ListShares(ctx context.Context, in *ListSharesRequest, opts ...grpc.CallOption) (*DocumentShares, error)
+	// Sets or lifts the embargo of a Document, returns the updated Document
+	SetEmbargo(ctx context.Context, in *SetEmbargoRequest, opts ...grpc.CallOption) (*lib.Document, error)
+	// Lists the audit records of the calls mutating Documents, newest first
+	ListAuditRecords(ctx context.Context, in *AuditRecordsRequest, opts ...grpc.CallOption) (*AuditRecordsResponse, error)
+	// Streams the changes of the Documents the caller may read
+	WatchDocuments(ctx context.Context, in *WatchDocumentsRequest, opts ...grpc.CallOption) (DocumentExtensionService_WatchDocumentsClient, error)
+	// Subscribes a url to the events of the Documents the caller may read, returns the subscription with its secret
+	CreateWebhookSubscription(ctx context.Context, in *CreateWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error)
+	// Lists the webhook subscriptions of a user
+	ListWebhookSubscriptions(ctx context.Context, in *ListWebhookSubscriptionsRequest, opts ...grpc.CallOption) (*WebhookSubscriptions, error)
+	// Pauses or resumes a webhook subscription, returns the updated subscription
+	PauseWebhookSubscription(ctx context.Context, in *PauseWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error)
+	// Deletes a webhook subscription and its deliveries, returns the deleted subscription
+	DeleteWebhookSubscription(ctx context.Context, in *DeleteWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error)
+	// Lists the deliveries of a webhook subscription, newest first
+	ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*WebhookDeliveries, error)
+	// Rebuilds the search index from the stored Documents
+	ReindexDocuments(ctx context.Context, in *ReindexDocumentsRequest, opts ...grpc.CallOption) (*ReindexDocumentsResponse, error)
+	// Compares the search index with the stored Documents, repairing it if requested
+	CheckSearchIndex(ctx context.Context, in *CheckSearchIndexRequest, opts ...grpc.CallOption) (*SearchIndexReport, error)
+}
+
+type documentExtensionServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewDocumentExtensionServiceClient(cc *grpc.ClientConn) DocumentExtensionServiceClient {
+	return &documentExtensionServiceClient{cc}
+}
+
+func (c *documentExtensionServiceClient) ListBrokenFiles(ctx context.Context, in *BrokenFilesRequest, opts ...grpc.CallOption) (*BrokenFilesResponse, error) {
+	out := new(BrokenFilesResponse)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListBrokenFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ListUnreferencedFiles(ctx context.Context, in *UnreferencedFilesRequest, opts ...grpc.CallOption) (*UnreferencedFilesResponse, error) {
+	out := new(UnreferencedFilesResponse)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListUnreferencedFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) MoveFile(ctx context.Context, in *MoveFileRequest, opts ...grpc.CallOption) (*lib.Document, error) {
+	out := new(lib.Document)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/MoveFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ReplaceFileURL(ctx context.Context, in *ReplaceFileURLRequest, opts ...grpc.CallOption) (*lib.Document, error) {
+	out := new(lib.Document)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ReplaceFileURL", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ReorderFiles(ctx context.Context, in *ReorderFilesRequest, opts ...grpc.CallOption) (*lib.Document, error) {
+	out := new(lib.Document)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ReorderFiles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) SetPrimaryFile(ctx context.Context, in *SetPrimaryFileRequest, opts ...grpc.CallOption) (*lib.Document, error) {
+	out := new(lib.Document)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/SetPrimaryFile", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) GrantShare(ctx context.Context, in *GrantShareRequest, opts ...grpc.CallOption) (*DocumentShares, error) {
+	out := new(DocumentShares)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/GrantShare", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) RevokeShare(ctx context.Context, in *RevokeShareRequest, opts ...grpc.CallOption) (*DocumentShares, error) {
+	out := new(DocumentShares)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/RevokeShare", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ListShares(ctx context.Context, in *ListSharesRequest, opts ...grpc.CallOption) (*DocumentShares, error) {
+	out := new(DocumentShares)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListShares", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) SetEmbargo(ctx context.Context, in *SetEmbargoRequest, opts ...grpc.CallOption) (*lib.Document, error) {
+	out := new(lib.Document)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/SetEmbargo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ListAuditRecords(ctx context.Context, in *AuditRecordsRequest, opts ...grpc.CallOption) (*AuditRecordsResponse, error) {
+	out := new(AuditRecordsResponse)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListAuditRecords", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) WatchDocuments(ctx context.Context, in *WatchDocumentsRequest, opts ...grpc.CallOption) (DocumentExtensionService_WatchDocumentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_DocumentExtensionService_serviceDesc.Streams[0], "/ext.DocumentExtensionService/WatchDocuments", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &documentExtensionServiceWatchDocumentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DocumentExtensionService_WatchDocumentsClient interface {
+	Recv() (*DocumentChange, error)
+	grpc.ClientStream
+}
+
+type documentExtensionServiceWatchDocumentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *documentExtensionServiceWatchDocumentsClient) Recv() (*DocumentChange, error) {
+	m := new(DocumentChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *documentExtensionServiceClient) CreateWebhookSubscription(ctx context.Context, in *CreateWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error) {
+	out := new(WebhookSubscription)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/CreateWebhookSubscription", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ListWebhookSubscriptions(ctx context.Context, in *ListWebhookSubscriptionsRequest, opts ...grpc.CallOption) (*WebhookSubscriptions, error) {
+	out := new(WebhookSubscriptions)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListWebhookSubscriptions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) PauseWebhookSubscription(ctx context.Context, in *PauseWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error) {
+	out := new(WebhookSubscription)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/PauseWebhookSubscription", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) DeleteWebhookSubscription(ctx context.Context, in *DeleteWebhookSubscriptionRequest, opts ...grpc.CallOption) (*WebhookSubscription, error) {
+	out := new(WebhookSubscription)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/DeleteWebhookSubscription", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ListWebhookDeliveries(ctx context.Context, in *ListWebhookDeliveriesRequest, opts ...grpc.CallOption) (*WebhookDeliveries, error) {
+	out := new(WebhookDeliveries)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ListWebhookDeliveries", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) ReindexDocuments(ctx context.Context, in *ReindexDocumentsRequest, opts ...grpc.CallOption) (*ReindexDocumentsResponse, error) {
+	out := new(ReindexDocumentsResponse)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/ReindexDocuments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentExtensionServiceClient) CheckSearchIndex(ctx context.Context, in *CheckSearchIndexRequest, opts ...grpc.CallOption) (*SearchIndexReport, error) {
+	out := new(SearchIndexReport)
+	err := c.cc.Invoke(ctx, "/ext.DocumentExtensionService/CheckSearchIndex", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DocumentExtensionServiceServer is the server API for DocumentExtensionService service.
+type DocumentExtensionServiceServer interface {
+	// Lists the files whose url failed its last check, by Document
+	ListBrokenFiles(context.Context, *BrokenFilesRequest) (*BrokenFilesResponse, error)
+	// Lists the stored urls no Document references anymore
+	ListUnreferencedFiles(context.Context, *UnreferencedFilesRequest) (*UnreferencedFilesResponse, error)
+	// Moves a file to the url map of another media type, returns the updated Document
+	MoveFile(context.Context, *MoveFileRequest) (*lib.Document, error)
+	// Points a file at another url, returns the updated Document
+	ReplaceFileURL(context.Context, *ReplaceFileURLRequest) (*lib.Document, error)
+	// Sets the display order of the files of a media type, returns the updated Document
+	ReorderFiles(context.Context, *ReorderFilesRequest) (*lib.Document, error)
+	// Makes a file the primary file of its media type, returns the updated Document
+	SetPrimaryFile(context.Context, *SetPrimaryFileRequest) (*lib.Document, error)
+	// Grants a user or a group access to a Document, returns its shares
+	GrantShare(context.Context, *GrantShareRequest) (*DocumentShares, error)
+	// Revokes the share of a user or a group, returns the shares of the Document
+	RevokeShare(context.Context, *RevokeShareRequest) (*DocumentShares, error)
+	// Lists the shares of a Document
+	ListShares(context.Context, *ListSharesRequest) (*DocumentShares, error)
+	// Sets or lifts the embargo of a Document, returns the updated Document
+	SetEmbargo(context.Context, *SetEmbargoRequest) (*lib.Document, error)
+	// Lists the audit records of the calls mutating Documents, newest first
+	ListAuditRecords(context.Context, *AuditRecordsRequest) (*AuditRecordsResponse, error)
+	// Streams the changes of the Documents the caller may read
+	WatchDocuments(*WatchDocumentsRequest, DocumentExtensionService_WatchDocumentsServer) error
+	// Subscribes a url to the events of the Documents the caller may read, returns the subscription with its secret
+	CreateWebhookSubscription(context.Context, *CreateWebhookSubscriptionRequest) (*WebhookSubscription, error)
+	// Lists the webhook subscriptions of a user
+	ListWebhookSubscriptions(context.Context, *ListWebhookSubscriptionsRequest) (*WebhookSubscriptions, error)
+	// Pauses or resumes a webhook subscription, returns the updated subscription
+	PauseWebhookSubscription(context.Context, *PauseWebhookSubscriptionRequest) (*WebhookSubscription, error)
+	// Deletes a webhook subscription and its deliveries, returns the deleted subscription
+	DeleteWebhookSubscription(context.Context, *DeleteWebhookSubscriptionRequest) (*WebhookSubscription, error)
+	// Lists the deliveries of a webhook subscription, newest first
+	ListWebhookDeliveries(context.Context, *ListWebhookDeliveriesRequest) (*WebhookDeliveries, error)
+	// Rebuilds the search index from the stored Documents
+	ReindexDocuments(context.Context, *ReindexDocumentsRequest) (*ReindexDocumentsResponse, error)
+	// Compares the search index with the stored Documents, repairing it if requested
+	CheckSearchIndex(context.Context, *CheckSearchIndexRequest) (*SearchIndexReport, error)
+}
+
+// UnimplementedDocumentExtensionServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedDocumentExtensionServiceServer struct {
+}
+
+func (*UnimplementedDocumentExtensionServiceServer) ListBrokenFiles(ctx context.Context, req *BrokenFilesRequest) (*BrokenFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBrokenFiles not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ListUnreferencedFiles(ctx context.Context, req *UnreferencedFilesRequest) (*UnreferencedFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUnreferencedFiles not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) MoveFile(ctx context.Context, req *MoveFileRequest) (*lib.Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveFile not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ReplaceFileURL(ctx context.Context, req *ReplaceFileURLRequest) (*lib.Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReplaceFileURL not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ReorderFiles(ctx context.Context, req *ReorderFilesRequest) (*lib.Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReorderFiles not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) SetPrimaryFile(ctx context.Context, req *SetPrimaryFileRequest) (*lib.Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPrimaryFile not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) GrantShare(ctx context.Context, req *GrantShareRequest) (*DocumentShares, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantShare not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) RevokeShare(ctx context.Context, req *RevokeShareRequest) (*DocumentShares, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeShare not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ListShares(ctx context.Context, req *ListSharesRequest) (*DocumentShares, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListShares not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) SetEmbargo(ctx context.Context, req *SetEmbargoRequest) (*lib.Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetEmbargo not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ListAuditRecords(ctx context.Context, req *AuditRecordsRequest) (*AuditRecordsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAuditRecords not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) WatchDocuments(req *WatchDocumentsRequest, srv DocumentExtensionService_WatchDocumentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchDocuments not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) CreateWebhookSubscription(ctx context.Context, req *CreateWebhookSubscriptionRequest) (*WebhookSubscription, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateWebhookSubscription not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ListWebhookSubscriptions(ctx context.Context, req *ListWebhookSubscriptionsRequest) (*WebhookSubscriptions, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookSubscriptions not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) PauseWebhookSubscription(ctx context.Context, req *PauseWebhookSubscriptionRequest) (*WebhookSubscription, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseWebhookSubscription not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) DeleteWebhookSubscription(ctx context.Context, req *DeleteWebhookSubscriptionRequest) (*WebhookSubscription, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteWebhookSubscription not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ListWebhookDeliveries(ctx context.Context, req *ListWebhookDeliveriesRequest) (*WebhookDeliveries, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListWebhookDeliveries not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) ReindexDocuments(ctx context.Context, req *ReindexDocumentsRequest) (*ReindexDocumentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReindexDocuments not implemented")
+}
+func (*UnimplementedDocumentExtensionServiceServer) CheckSearchIndex(ctx context.Context, req *CheckSearchIndexRequest) (*SearchIndexReport, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CheckSearchIndex not implemented")
+}
+
+func RegisterDocumentExtensionServiceServer(s *grpc.Server, srv DocumentExtensionServiceServer) {
+	s.RegisterService(&_DocumentExtensionService_serviceDesc, srv)
+}
+
+func _DocumentExtensionService_ListBrokenFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BrokenFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListBrokenFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListBrokenFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListBrokenFiles(ctx, req.(*BrokenFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ListUnreferencedFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnreferencedFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListUnreferencedFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListUnreferencedFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListUnreferencedFiles(ctx, req.(*UnreferencedFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_MoveFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).MoveFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/MoveFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).MoveFile(ctx, req.(*MoveFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ReplaceFileURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReplaceFileURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ReplaceFileURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ReplaceFileURL",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ReplaceFileURL(ctx, req.(*ReplaceFileURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ReorderFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReorderFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ReorderFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ReorderFiles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ReorderFiles(ctx, req.(*ReorderFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_SetPrimaryFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPrimaryFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).SetPrimaryFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/SetPrimaryFile",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).SetPrimaryFile(ctx, req.(*SetPrimaryFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_GrantShare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).GrantShare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/GrantShare",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).GrantShare(ctx, req.(*GrantShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_RevokeShare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).RevokeShare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/RevokeShare",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).RevokeShare(ctx, req.(*RevokeShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ListShares_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSharesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListShares(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListShares",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListShares(ctx, req.(*ListSharesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_SetEmbargo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetEmbargoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).SetEmbargo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/SetEmbargo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).SetEmbargo(ctx, req.(*SetEmbargoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ListAuditRecords_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuditRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListAuditRecords(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListAuditRecords",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListAuditRecords(ctx, req.(*AuditRecordsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_WatchDocuments_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchDocumentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DocumentExtensionServiceServer).WatchDocuments(m, &documentExtensionServiceWatchDocumentsServer{stream})
+}
+
+type DocumentExtensionService_WatchDocumentsServer interface {
+	Send(*DocumentChange) error
+	grpc.ServerStream
+}
+
+type documentExtensionServiceWatchDocumentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *documentExtensionServiceWatchDocumentsServer) Send(m *DocumentChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DocumentExtensionService_CreateWebhookSubscription_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateWebhookSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).CreateWebhookSubscription(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/CreateWebhookSubscription",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).CreateWebhookSubscription(ctx, req.(*CreateWebhookSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ListWebhookSubscriptions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookSubscriptionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListWebhookSubscriptions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListWebhookSubscriptions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListWebhookSubscriptions(ctx, req.(*ListWebhookSubscriptionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_PauseWebhookSubscription_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseWebhookSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).PauseWebhookSubscription(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/PauseWebhookSubscription",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).PauseWebhookSubscription(ctx, req.(*PauseWebhookSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_DeleteWebhookSubscription_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteWebhookSubscriptionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).DeleteWebhookSubscription(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/DeleteWebhookSubscription",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).DeleteWebhookSubscription(ctx, req.(*DeleteWebhookSubscriptionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ListWebhookDeliveries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListWebhookDeliveriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ListWebhookDeliveries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ListWebhookDeliveries",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ListWebhookDeliveries(ctx, req.(*ListWebhookDeliveriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_ReindexDocuments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReindexDocumentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).ReindexDocuments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/ReindexDocuments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).ReindexDocuments(ctx, req.(*ReindexDocumentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentExtensionService_CheckSearchIndex_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckSearchIndexRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentExtensionServiceServer).CheckSearchIndex(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ext.DocumentExtensionService/CheckSearchIndex",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentExtensionServiceServer).CheckSearchIndex(ctx, req.(*CheckSearchIndexRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _DocumentExtensionService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ext.DocumentExtensionService",
+	HandlerType: (*DocumentExtensionServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListBrokenFiles",
+			Handler:    _DocumentExtensionService_ListBrokenFiles_Handler,
+		},
+		{
+			MethodName: "ListUnreferencedFiles",
+			Handler:    _DocumentExtensionService_ListUnreferencedFiles_Handler,
+		},
+		{
+			MethodName: "MoveFile",
+			Handler:    _DocumentExtensionService_MoveFile_Handler,
+		},
+		{
+			MethodName: "ReplaceFileURL",
+			Handler:    _DocumentExtensionService_ReplaceFileURL_Handler,
+		},
+		{
+			MethodName: "ReorderFiles",
+			Handler:    _DocumentExtensionService_ReorderFiles_Handler,
+		},
+		{
+			MethodName: "SetPrimaryFile",
+			Handler:    _DocumentExtensionService_SetPrimaryFile_Handler,
+		},
+		{
+			MethodName: "GrantShare",
+			Handler:    _DocumentExtensionService_GrantShare_Handler,
+		},
+		{
+			MethodName: "RevokeShare",
+			Handler:    _DocumentExtensionService_RevokeShare_Handler,
+		},
+		{
+			MethodName: "ListShares",
+			Handler:    _DocumentExtensionService_ListShares_Handler,
+		},
+		{
+			MethodName: "SetEmbargo",
+			Handler:    _DocumentExtensionService_SetEmbargo_Handler,
+		},
+		{
+			MethodName: "ListAuditRecords",
+			Handler:    _DocumentExtensionService_ListAuditRecords_Handler,
+		},
+		{
+			MethodName: "CreateWebhookSubscription",
+			Handler:    _DocumentExtensionService_CreateWebhookSubscription_Handler,
+		},
+		{
+			MethodName: "ListWebhookSubscriptions",
+			Handler:    _DocumentExtensionService_ListWebhookSubscriptions_Handler,
+		},
+		{
+			MethodName: "PauseWebhookSubscription",
+			Handler:    _DocumentExtensionService_PauseWebhookSubscription_Handler,
+		},
+		{
+			MethodName: "DeleteWebhookSubscription",
+			Handler:    _DocumentExtensionService_DeleteWebhookSubscription_Handler,
+		},
+		{
+			MethodName: "ListWebhookDeliveries",
+			Handler:    _DocumentExtensionService_ListWebhookDeliveries_Handler,
+		},
+		{
+			MethodName: "ReindexDocuments",
+			Handler:    _DocumentExtensionService_ReindexDocuments_Handler,
+		},
+		{
+			MethodName: "CheckSearchIndex",
+			Handler:    _DocumentExtensionService_CheckSearchIndex_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchDocuments",
+			Handler:       _DocumentExtensionService_WatchDocuments_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "document_ext_svc.proto",
+}
@@ -0,0 +1,219 @@
+// Package ratelimit bounds the calls of each caller with token buckets, per gRPC method.
+// Callers are told by their UUID, or by their address for calls without an authenticated caller.
+package ratelimit
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RetryAfterHeader is the metadata key of the seconds to wait before calling again
+	RetryAfterHeader = "retry-after"
+	// Buckets tracked before the full ones are dropped
+	maxTrackedBuckets = 10000
+)
+
+// Limit is the rate of a token bucket in calls per second, and its burst.
+// A rate of zero or less does not limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimits reads "Method=rate:burst" entries, the method being the name of the RPC without its service.
+// Returns consts.ErrInvalidRateLimit if an entry is malformed.
+func ParseLimits(entries []string) (map[string]Limit, error) {
+	limits := make(map[string]Limit, len(entries))
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, consts.ErrInvalidRateLimit
+		}
+		values := strings.SplitN(parts[1], ":", 2)
+		if len(values) != 2 {
+			return nil, consts.ErrInvalidRateLimit
+		}
+		rate, err := strconv.ParseFloat(values[0], 64)
+		if err != nil || rate < 0 {
+			return nil, consts.ErrInvalidRateLimit
+		}
+		burst, err := strconv.Atoi(values[1])
+		if err != nil || burst < 1 {
+			return nil, consts.ErrInvalidRateLimit
+		}
+		limits[parts[0]] = Limit{Rate: rate, Burst: burst}
+	}
+	return limits, nil
+}
+
+// bucketKey is a bucket of a caller for a method
+type bucketKey struct {
+	caller string
+	method string
+}
+
+// bucket holds the tokens left as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter bounds the calls of each caller with a token bucket per method
+type Limiter struct {
+	def     Limit
+	methods map[string]Limit
+	now     func() time.Time
+
+	lock    sync.Mutex
+	buckets map[bucketKey]*bucket
+}
+
+// New returns a Limiter of the limits by method name, and def for the other methods.
+func New(def Limit, methods map[string]Limit) *Limiter {
+	return &Limiter{
+		def:     def,
+		methods: methods,
+		now:     time.Now,
+		buckets: make(map[bucketKey]*bucket),
+	}
+}
+
+// limit returns the limit of the full method name.
+func (l *Limiter) limit(fullMethod string) Limit {
+	if limit, ok := l.methods[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return limit
+	}
+	return l.def
+}
+
+// Allow takes a token of the bucket of the caller for the full method name.
+// Returns true if the call may proceed, else how long until the next token.
+func (l *Limiter) Allow(caller string, fullMethod string) (bool, time.Duration) {
+	limit := l.limit(fullMethod)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	burst := math.Max(float64(limit.Burst), 1)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := l.now()
+	key := bucketKey{caller: caller, method: fullMethod}
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxTrackedBuckets {
+			l.dropFullBuckets(now)
+		}
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+}
+
+// dropFullBuckets forgets the buckets refilled by now, they would start full again anyway.
+func (l *Limiter) dropFullBuckets(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limit(key.method)
+		if b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// UnaryServerInterceptor limits unary calls, except the exempt full method names.
+// It runs after the authentication interceptor, to tell callers by their UUID.
+// Returns the interceptor.
+func (l *Limiter) UnaryServerInterceptor(exempt ...string) grpc.UnaryServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		if !exempted[info.FullMethod] {
+			if ok, wait := l.Allow(callerKey(ctx), info.FullMethod); !ok {
+				_ = grpc.SetHeader(ctx, retryAfter(wait))
+				return nil, exhausted(ctx, info.FullMethod)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor limits streaming calls, except the exempt full method names.
+// It runs after the authentication interceptor, to tell callers by their UUID.
+// Returns the interceptor.
+func (l *Limiter) StreamServerInterceptor(exempt ...string) grpc.StreamServerInterceptor {
+	exempted := exemptMethods(exempt)
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		if !exempted[info.FullMethod] {
+			if ok, wait := l.Allow(callerKey(stream.Context()), info.FullMethod); !ok {
+				_ = stream.SetHeader(retryAfter(wait))
+				return exhausted(stream.Context(), info.FullMethod)
+			}
+		}
+		return handler(srv, stream)
+	}
+}
+
+// callerKey returns the UUID of the caller of ctx, else the address of its peer without port.
+func callerKey(ctx context.Context) string {
+	if caller, ok := auth.FromContext(ctx); ok {
+		return "uuid:" + caller.UUID
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		return "addr:" + addr
+	}
+	return ""
+}
+
+// retryAfter returns the metadata telling to wait, in whole seconds rounded up.
+func retryAfter(wait time.Duration) metadata.MD {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return metadata.Pairs(RetryAfterHeader, strconv.FormatInt(seconds, 10))
+}
+
+// exhausted logs the limited call.
+// Returns the codes.ResourceExhausted error of the call.
+func exhausted(ctx context.Context, method string) error {
+	log.Info(consts.RateLimitTag, method, callerKey(ctx), consts.ErrRateLimited.Error())
+	return status.Error(codes.ResourceExhausted, consts.ErrRateLimited.Error())
+}
+
+// exemptMethods returns the set of the full method names.
+func exemptMethods(methods []string) map[string]bool {
+	exempted := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		exempted[m] = true
+	}
+	return exempted
+}
@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	statusMethod = "/document.DocumentService/GetStatus"
+	queryMethod  = "/document.DocumentService/QueryDocument"
+	deleteMethod = "/document.DocumentService/DeleteDocument"
+)
+
+// fakeTransportStream records the header of a unary call
+type fakeTransportStream struct {
+	header metadata.MD
+}
+
+func (s *fakeTransportStream) Method() string { return queryMethod }
+func (s *fakeTransportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+func (s *fakeTransportStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+func (s *fakeTransportStream) SetTrailer(md metadata.MD) error { return nil }
+
+// fakeStream is a server stream of the context, recording its header
+type fakeStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *fakeStream) Context() context.Context { return s.ctx }
+func (s *fakeStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+// fakeClock is a clock moved by hand
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestParseLimits(t *testing.T) {
+	cases := []struct {
+		entries  []string
+		expected map[string]Limit
+		err      error
+	}{
+		{nil, map[string]Limit{}, nil},
+		{[]string{"QueryDocument=5:10", "CreateDocument=0.5:1"},
+			map[string]Limit{"QueryDocument": {Rate: 5, Burst: 10}, "CreateDocument": {Rate: 0.5, Burst: 1}}, nil},
+		{[]string{"QueryDocument=0:1"}, map[string]Limit{"QueryDocument": {Rate: 0, Burst: 1}}, nil},
+		{[]string{"QueryDocument"}, nil, consts.ErrInvalidRateLimit},
+		{[]string{"=5:10"}, nil, consts.ErrInvalidRateLimit},
+		{[]string{"QueryDocument=5"}, nil, consts.ErrInvalidRateLimit},
+		{[]string{"QueryDocument=x:10"}, nil, consts.ErrInvalidRateLimit},
+		{[]string{"QueryDocument=-1:10"}, nil, consts.ErrInvalidRateLimit},
+		{[]string{"QueryDocument=5:0"}, nil, consts.ErrInvalidRateLimit},
+	}
+
+	for _, c := range cases {
+		limits, err := ParseLimits(c.entries)
+		assert.Equal(t, c.err, err, c.entries)
+		assert.Equal(t, c.expected, limits, c.entries)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1546300800, 0)}
+	l := New(Limit{Rate: 10, Burst: 2}, map[string]Limit{"QueryDocument": {Rate: 1, Burst: 1}, "GetStatus": {}})
+	l.now = clock.Now
+
+	// The burst is spent, then the bucket refills at the rate
+	for i := 0; i < 2; i++ {
+		ok, _ := l.Allow("uuid:a", deleteMethod)
+		assert.True(t, ok)
+	}
+	ok, wait := l.Allow("uuid:a", deleteMethod)
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	clock.now = clock.now.Add(100 * time.Millisecond)
+	ok, _ = l.Allow("uuid:a", deleteMethod)
+	assert.True(t, ok)
+
+	// Callers and methods have their own buckets
+	ok, _ = l.Allow("uuid:b", deleteMethod)
+	assert.True(t, ok)
+	ok, _ = l.Allow("uuid:a", queryMethod)
+	assert.True(t, ok)
+	ok, wait = l.Allow("uuid:a", queryMethod)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	// A method without rate is not limited
+	for i := 0; i < 5; i++ {
+		ok, _ = l.Allow("uuid:a", statusMethod)
+		assert.True(t, ok)
+	}
+
+	// An idle bucket does not grow past its burst
+	clock.now = clock.now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("uuid:a", deleteMethod)
+		assert.True(t, ok)
+	}
+	ok, _ = l.Allow("uuid:a", deleteMethod)
+	assert.False(t, ok)
+}
+
+func TestDropFullBuckets(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1546300800, 0)}
+	l := New(Limit{Rate: 1, Burst: 1}, nil)
+	l.now = clock.Now
+
+	l.Allow("uuid:a", deleteMethod)
+	clock.now = clock.now.Add(time.Second)
+	l.Allow("uuid:b", deleteMethod)
+	l.dropFullBuckets(clock.now)
+	assert.Equal(t, 1, len(l.buckets))
+	_, ok := l.buckets[bucketKey{caller: "uuid:b", method: deleteMethod}]
+	assert.True(t, ok)
+}
+
+func TestCallerKey(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 50123}
+	cases := []struct {
+		ctx      context.Context
+		expected string
+	}{
+		{auth.NewContext(context.Background(), &auth.Caller{UUID: "0000xsnjg0mqjhbf4qx1efd6y3"}),
+			"uuid:0000xsnjg0mqjhbf4qx1efd6y3"},
+		{peer.NewContext(context.Background(), &peer.Peer{Addr: addr}), "addr:10.0.0.7"},
+		{context.Background(), ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, callerKey(c.ctx))
+	}
+}
+
+func TestServerInterceptors(t *testing.T) {
+	l := New(Limit{Rate: 0.5, Burst: 1}, nil)
+	unary := l.UnaryServerInterceptor(statusMethod)
+	stream := l.StreamServerInterceptor(statusMethod)
+	ctx := auth.NewContext(context.Background(), &auth.Caller{UUID: "0000xsnjg0mqjhbf4qx1efd6y3"})
+	exhausted := status.Error(codes.ResourceExhausted, consts.ErrRateLimited.Error()).Error()
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "res", nil }
+
+	transport := &fakeTransportStream{}
+	callCtx := grpc.NewContextWithServerTransportStream(ctx, transport)
+	res, err := unary(callCtx, nil, &grpc.UnaryServerInfo{FullMethod: queryMethod}, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "res", res)
+	_, err = unary(callCtx, nil, &grpc.UnaryServerInfo{FullMethod: queryMethod}, handler)
+	assert.EqualError(t, err, exhausted)
+	assert.Equal(t, []string{"2"}, transport.header.Get(RetryAfterHeader))
+
+	// Exempt methods are not limited
+	for i := 0; i < 3; i++ {
+		_, err = unary(callCtx, nil, &grpc.UnaryServerInfo{FullMethod: statusMethod}, handler)
+		assert.Nil(t, err)
+	}
+
+	s := &fakeStream{ctx: ctx}
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error { return nil }
+	assert.Nil(t, stream(nil, s, &grpc.StreamServerInfo{FullMethod: deleteMethod}, streamHandler))
+	err = stream(nil, s, &grpc.StreamServerInfo{FullMethod: deleteMethod}, streamHandler)
+	assert.EqualError(t, err, exhausted)
+	assert.Equal(t, []string{"2"}, s.header.Get(RetryAfterHeader))
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, []string{"1"}, retryAfter(0).Get(RetryAfterHeader))
+	assert.Equal(t, []string{"1"}, retryAfter(100*time.Millisecond).Get(RetryAfterHeader))
+	assert.Equal(t, []string{"3"}, retryAfter(2001*time.Millisecond).Get(RetryAfterHeader))
+}
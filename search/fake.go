@@ -0,0 +1,215 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fake is a local HTTP stand-in of Elasticsearch for tests.
+// It serves the requests of a Client on a single index from memory: index creation, bulk requests with
+// external versions, and searches listing the entries sorted by id.
+type Fake struct {
+	*httptest.Server
+	lock    sync.Mutex
+	index   string
+	created bool
+	mapping []byte
+	entries map[string]json.RawMessage
+	// External versions of the ids, kept once their entry is removed
+	versions map[string]int64
+	fail     map[string]bool
+}
+
+// NewFake starts a Fake serving the index, which is not created yet. Close it once done.
+func NewFake(index string) *Fake {
+	f := &Fake{index: index, entries: make(map[string]json.RawMessage), versions: make(map[string]int64),
+		fail: make(map[string]bool)}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Entries returns a copy of the entries of the index, by id.
+func (f *Fake) Entries() map[string]json.RawMessage {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	entries := make(map[string]json.RawMessage, len(f.entries))
+	for id, entry := range f.entries {
+		entries[id] = entry
+	}
+	return entries
+}
+
+// Mapping returns the body of the request creating the index, nil if it was not created by a request.
+func (f *Fake) Mapping() []byte {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.mapping
+}
+
+// Put writes the entry with the id, creating the index.
+func (f *Fake) Put(id string, entry interface{}) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.created = true
+	f.entries[id] = b
+	return nil
+}
+
+// Fail makes the bulk operations of the ids fail, until they are passed again with fail unset.
+func (f *Fake) Fail(fail bool, ids ...string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for _, id := range ids {
+		f.fail[id] = fail
+	}
+}
+
+func (f *Fake) serve(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch {
+	case r.URL.Path == "/"+f.index && r.Method == http.MethodHead:
+		if !f.created {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.URL.Path == "/"+f.index && r.Method == http.MethodPut:
+		if f.created {
+			http.Error(w, `{"error":{"type":"resource_already_exists_exception"}}`, http.StatusBadRequest)
+			return
+		}
+		f.created, f.mapping = true, body
+		fmt.Fprintf(w, `{"acknowledged":true,"index":%q}`, f.index)
+	case r.URL.Path == "/_bulk" && r.Method == http.MethodPost:
+		f.bulk(w, body)
+	case r.URL.Path == "/"+f.index+"/_search" && r.Method == http.MethodPost:
+		f.search(w, body)
+	default:
+		http.Error(w, "unsupported request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+// bulk applies the operations of the NDJSON body, creating the index
+func (f *Fake) bulk(w http.ResponseWriter, body []byte) {
+	type meta struct {
+		Index       string `json:"_index"`
+		ID          string `json:"_id"`
+		Version     int64  `json:"version"`
+		VersionType string `json:"version_type"`
+	}
+	var items []map[string]interface{}
+	errors := false
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 1<<20), 1<<24)
+	for scanner.Scan() {
+		line := map[string]meta{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for action, m := range line {
+			var entry json.RawMessage
+			if action == "index" {
+				if !scanner.Scan() {
+					http.Error(w, "index without entry", http.StatusBadRequest)
+					return
+				}
+				entry = append(json.RawMessage{}, scanner.Bytes()...)
+			}
+			result := map[string]interface{}{"_index": m.Index, "_id": m.ID}
+			switch {
+			case m.Index != f.index:
+				result["status"], result["error"] = http.StatusBadRequest, "unknown index"
+				errors = true
+			case f.fail[m.ID]:
+				result["status"], result["error"] = http.StatusTooManyRequests, "rejected"
+				errors = true
+			case (m.VersionType == External && f.versions[m.ID] >= m.Version) ||
+				(m.VersionType == ExternalGTE && f.versions[m.ID] > m.Version):
+				result["status"], result["error"] = http.StatusConflict, "version_conflict_engine_exception"
+				errors = true
+			case action == "delete":
+				f.setVersion(m.ID, m.VersionType, m.Version)
+				result["status"] = http.StatusOK
+				if _, ok := f.entries[m.ID]; !ok {
+					result["status"] = http.StatusNotFound
+				}
+				delete(f.entries, m.ID)
+			default:
+				f.setVersion(m.ID, m.VersionType, m.Version)
+				f.created = true
+				f.entries[m.ID] = entry
+				result["status"] = http.StatusCreated
+			}
+			items = append(items, map[string]interface{}{action: result})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": errors, "items": items})
+}
+
+// setVersion records the external version of the id, unless the version is internal
+func (f *Fake) setVersion(id string, versionType string, version int64) {
+	if versionType == External || versionType == ExternalGTE {
+		f.versions[id] = version
+	}
+}
+
+// search lists a page of the entries sorted by id, after the id of search_after
+func (f *Fake) search(w http.ResponseWriter, body []byte) {
+	if !f.created {
+		http.Error(w, `{"error":{"type":"index_not_found_exception"}}`, http.StatusNotFound)
+		return
+	}
+	query := &struct {
+		Size        int           `json:"size"`
+		SearchAfter []interface{} `json:"search_after"`
+	}{}
+	if err := json.Unmarshal(body, query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids := make([]string, 0, len(f.entries))
+	for id := range f.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	after := ""
+	if len(query.SearchAfter) > 0 {
+		after = fmt.Sprint(query.SearchAfter[0])
+	}
+	hits := make([]map[string]interface{}, 0)
+	for _, id := range ids {
+		if strings.Compare(id, after) <= 0 || len(hits) == query.Size {
+			continue
+		}
+		source := map[string]interface{}{}
+		_ = json.Unmarshal(f.entries[id], &source)
+		hits = append(hits, map[string]interface{}{
+			"_id":     id,
+			"_source": map[string]interface{}{"checksum": source["checksum"]},
+			"sort":    []string{id},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": hits}})
+}
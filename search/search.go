@@ -0,0 +1,285 @@
+// Package search mirrors Documents into a search index through the Elasticsearch REST API.
+// Entries are written and removed with bulk requests, and listed with their checksums to check the index
+// against the stored Documents.
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultPageSize is the number of entries read by a request listing the index
+const DefaultPageSize = 1000
+
+// Types of the versions of the operations
+const (
+	// External skips the operations whose version is not above the version of the indexed entry
+	External = "external"
+	// ExternalGTE skips the operations whose version is below the version of the indexed entry
+	ExternalGTE = "external_gte"
+)
+
+// Op is an operation of a bulk request
+type Op struct {
+	// ID of the entry, the duid of its Document
+	ID string
+	// Entry written to the index, nil removes the entry with the ID
+	Entry interface{}
+	// Version of the operation, applied with versioning unless 0
+	Version int64
+	// VersionType of the Version, External if empty
+	VersionType string
+}
+
+// BulkError reports the operations of a bulk request that failed
+type BulkError struct {
+	// Failures are the reasons of the failed operations, by id
+	Failures map[string]string
+}
+
+// Error lists the failed operations, sorted by id.
+func (e *BulkError) Error() string {
+	ids := make([]string, 0, len(e.Failures))
+	for id := range e.Failures {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	failures := make([]string, len(ids))
+	for i, id := range ids {
+		failures[i] = id + ": " + e.Failures[id]
+	}
+	return fmt.Sprintf("%d bulk operations failed: %s", len(ids), strings.Join(failures, ", "))
+}
+
+// Client writes the entries of an index
+type Client struct {
+	url      string
+	index    string
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewClient returns a client of the index served at the url, authenticating with the username and password
+// if the username is not empty.
+func NewClient(url string, index string, username string, password string, client *http.Client) *Client {
+	return &Client{
+		url:      strings.TrimRight(url, "/"),
+		index:    index,
+		username: username,
+		password: password,
+		client:   client,
+	}
+}
+
+// Index returns the name of the index.
+func (c *Client) Index() string {
+	return c.index
+}
+
+// EnsureIndex creates the index with the mapping, unless it exists.
+// Returns an error if the index can not be read or created.
+func (c *Client) EnsureIndex(ctx context.Context, mapping []byte) error {
+	status, _, err := c.do(ctx, http.MethodHead, "/"+url.PathEscape(c.index), "", nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("search index answered %d", status)
+	}
+
+	status, body, err := c.do(ctx, http.MethodPut, "/"+url.PathEscape(c.index), "application/json", mapping)
+	if err != nil {
+		return err
+	}
+	// Created meanwhile by another instance
+	if status == http.StatusBadRequest && bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		return nil
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("search index answered %d: %s", status, body)
+	}
+	return nil
+}
+
+// Bulk applies the operations in a single bulk request.
+// Removing an entry that is not indexed succeeds, and so does an operation skipped for its version.
+// Returns a *BulkError if some operations failed, or an error if the request failed.
+func (c *Client) Bulk(ctx context.Context, ops []Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, op := range ops {
+		meta := map[string]interface{}{"_index": c.index, "_id": op.ID}
+		if op.Version != 0 {
+			meta["version"], meta["version_type"] = op.Version, External
+			if op.VersionType != "" {
+				meta["version_type"] = op.VersionType
+			}
+		}
+		if op.Entry == nil {
+			if err := enc.Encode(map[string]interface{}{"delete": meta}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := enc.Encode(map[string]interface{}{"index": meta}); err != nil {
+			return err
+		}
+		if err := enc.Encode(op.Entry); err != nil {
+			return err
+		}
+	}
+
+	status, body, err := c.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("search index answered %d: %s", status, body)
+	}
+
+	res := &struct {
+		Errors bool                         `json:"errors"`
+		Items  []map[string]json.RawMessage `json:"items"`
+	}{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return err
+	}
+	if !res.Errors {
+		return nil
+	}
+	failures := make(map[string]string)
+	for _, item := range res.Items {
+		for action, raw := range item {
+			result := &struct {
+				ID     string          `json:"_id"`
+				Status int             `json:"status"`
+				Error  json.RawMessage `json:"error"`
+			}{}
+			if err := json.Unmarshal(raw, result); err != nil {
+				return err
+			}
+			if action == "delete" && result.Status == http.StatusNotFound {
+				continue
+			}
+			// A later version is indexed
+			if result.Status == http.StatusConflict {
+				continue
+			}
+			if result.Status < 200 || result.Status > 299 {
+				failures[result.ID] = fmt.Sprintf("%s answered %d %s", action, result.Status, result.Error)
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &BulkError{Failures: failures}
+}
+
+// Checksums passes the id and the field named checksum of every entry to fn, sorted by the keyword field of
+// the ids, reading pageSize entries per request, or DefaultPageSize if 0.
+// A missing index has no entries.
+// Returns the error of fn, or an error if the index can not be read.
+func (c *Client) Checksums(ctx context.Context, idField string, pageSize int,
+	fn func(id string, checksum string) error) error {
+
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	var after []interface{}
+	for {
+		query := map[string]interface{}{
+			"size":    pageSize,
+			"_source": []string{"checksum"},
+			"sort":    []map[string]string{{idField: "asc"}},
+		}
+		if after != nil {
+			query["search_after"] = after
+		}
+		b, err := json.Marshal(query)
+		if err != nil {
+			return err
+		}
+		status, body, err := c.do(ctx, http.MethodPost, "/"+url.PathEscape(c.index)+"/_search", "application/json", b)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusNotFound {
+			return nil
+		}
+		if status < 200 || status > 299 {
+			return fmt.Errorf("search index answered %d: %s", status, body)
+		}
+
+		res := &struct {
+			Hits struct {
+				Hits []struct {
+					ID     string `json:"_id"`
+					Source struct {
+						Checksum string `json:"checksum"`
+					} `json:"_source"`
+					Sort []interface{} `json:"sort"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}{}
+		if err := json.Unmarshal(body, res); err != nil {
+			return err
+		}
+		for _, hit := range res.Hits.Hits {
+			if err := fn(hit.ID, hit.Source.Checksum); err != nil {
+				return err
+			}
+			after = hit.Sort
+		}
+		if len(res.Hits.Hits) < pageSize {
+			return nil
+		}
+	}
+}
+
+// do sends a request to the path of the url.
+// Returns the status and body of the response, or an error if the request failed.
+func (c *Client) do(ctx context.Context, method string, path string, contentType string,
+	body []byte) (int, []byte, error) {
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return res.StatusCode, b, nil
+}
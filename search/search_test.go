@@ -0,0 +1,127 @@
+package search
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnsureIndex(t *testing.T) {
+	fake := NewFake("documents")
+	defer fake.Close()
+	client := NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+	mapping := []byte(`{"mappings":{"properties":{"duid":{"type":"keyword"}}}}`)
+
+	// Created once, then left as is
+	assert.Nil(t, client.EnsureIndex(context.TODO(), mapping))
+	assert.Equal(t, mapping, fake.Mapping())
+	assert.Nil(t, client.EnsureIndex(context.TODO(), []byte(`{}`)))
+	assert.Equal(t, mapping, fake.Mapping())
+}
+
+func TestBulk(t *testing.T) {
+	fake := NewFake("documents")
+	defer fake.Close()
+	client := NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+
+	err := client.Bulk(context.TODO(), []Op{
+		{ID: "a", Entry: map[string]string{"duid": "a", "checksum": "1"}},
+		{ID: "b", Entry: map[string]string{"duid": "b", "checksum": "2"}},
+		{ID: "missing"},
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"duid":"a","checksum":"1"}`, string(fake.Entries()["a"]))
+	assert.Len(t, fake.Entries(), 2)
+
+	// Failed operations are reported by id, the others applied
+	fake.Fail(true, "b")
+	err = client.Bulk(context.TODO(), []Op{{ID: "a"}, {ID: "b"}})
+	if assert.IsType(t, &BulkError{}, err) {
+		assert.Equal(t, []string{"b"}, keys(err.(*BulkError).Failures))
+		assert.Contains(t, err.Error(), "1 bulk operations failed: b: delete answered 429")
+	}
+	assert.Len(t, fake.Entries(), 1)
+	assert.Nil(t, client.Bulk(context.TODO(), nil))
+}
+
+func TestBulkVersions(t *testing.T) {
+	fake := NewFake("documents")
+	defer fake.Close()
+	client := NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{{ID: "a", Entry: map[string]string{"v": "2"}, Version: 2}}))
+
+	// Operations not above the indexed version are skipped without failing
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{
+		{ID: "a", Entry: map[string]string{"v": "1"}, Version: 1},
+		{ID: "a", Version: 2},
+	}))
+	assert.JSONEq(t, `{"v":"2"}`, string(fake.Entries()["a"]))
+
+	// A removal keeps its version, so an older write does not restore the entry
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{{ID: "a", Version: 3}}))
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{{ID: "a", Entry: map[string]string{"v": "2"}, Version: 2}}))
+	assert.Empty(t, fake.Entries())
+
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{{ID: "a", Entry: map[string]string{"v": "4"}, Version: 4}}))
+	assert.JSONEq(t, `{"v":"4"}`, string(fake.Entries()["a"]))
+
+	// The same version overwrites the entry with ExternalGTE only
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{
+		{ID: "a", Entry: map[string]string{"v": "4b"}, Version: 4},
+		{ID: "a", Entry: map[string]string{"v": "3"}, Version: 3, VersionType: ExternalGTE},
+	}))
+	assert.JSONEq(t, `{"v":"4"}`, string(fake.Entries()["a"]))
+	assert.Nil(t, client.Bulk(context.TODO(), []Op{
+		{ID: "a", Entry: map[string]string{"v": "4c"}, Version: 4, VersionType: ExternalGTE},
+	}))
+	assert.JSONEq(t, `{"v":"4c"}`, string(fake.Entries()["a"]))
+}
+
+func TestChecksums(t *testing.T) {
+	fake := NewFake("documents")
+	defer fake.Close()
+	client := NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+
+	// A missing index has no entries
+	read := map[string]string{}
+	collect := func(id string, checksum string) error {
+		read[id] = checksum
+		return nil
+	}
+	assert.Nil(t, client.Checksums(context.TODO(), "duid", 2, collect))
+	assert.Empty(t, read)
+
+	expected := map[string]string{}
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("duid-%d", i)
+		expected[id] = fmt.Sprint(i)
+		assert.Nil(t, fake.Put(id, map[string]string{"duid": id, "checksum": fmt.Sprint(i)}))
+	}
+	assert.Nil(t, client.Checksums(context.TODO(), "duid", 2, collect))
+	assert.Equal(t, expected, read)
+}
+
+func TestClientAuthentication(t *testing.T) {
+	var username, password string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ = r.BasicAuth()
+	}))
+	defer server.Close()
+
+	assert.Nil(t, NewClient(server.URL+"/", "documents", "elastic", "secret", http.DefaultClient).
+		EnsureIndex(context.TODO(), nil))
+	assert.Equal(t, "elastic", username)
+	assert.Equal(t, "secret", password)
+}
+
+func keys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
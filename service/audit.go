@@ -0,0 +1,311 @@
+package service
+
+import (
+	"fmt"
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultAuditLimit is the number of audit records listed if the request sets no limit
+	defaultAuditLimit = 100
+	// maxAuditLimit is the most audit records listed by a request
+	maxAuditLimit = 1000
+	// auditWriteTimeout bounds the write of an audit record, which outlives a canceled call
+	auditWriteTimeout = 5 * time.Second
+	// publicationOperation is the operation of the audit records of the publications by the embargo scheduler
+	publicationOperation = "PublishEmbargoedDocument"
+)
+
+// auditedMethods are the operations recorded by the audit log, by full method name
+var auditedMethods = map[string]string{
+	"/document.DocumentService/CreateDocument":     "CreateDocument",
+	"/document.DocumentService/UpdateDocument":     "UpdateDocument",
+	"/document.DocumentService/DeleteDocument":     "DeleteDocument",
+	"/document.DocumentService/AddFileMetadata":    "AddFileMetadata",
+	"/document.DocumentService/DeleteFileMetadata": "DeleteFileMetadata",
+	"/ext.DocumentExtensionService/MoveFile":       "MoveFile",
+	"/ext.DocumentExtensionService/ReplaceFileURL": "ReplaceFileURL",
+	"/ext.DocumentExtensionService/ReorderFiles":   "ReorderFiles",
+	"/ext.DocumentExtensionService/SetPrimaryFile": "SetPrimaryFile",
+	"/ext.DocumentExtensionService/GrantShare":     "GrantShare",
+	"/ext.DocumentExtensionService/RevokeShare":    "RevokeShare",
+	"/ext.DocumentExtensionService/SetEmbargo":     "SetEmbargo",
+}
+
+// auditSummary is the state of a Document recorded by the audit log
+type auditSummary struct {
+	Uuid                  string   `bson:"uuid"`
+	IsPublic              bool     `bson:"isPublic"`
+	Fuids                 []string `bson:"fuids"`
+	Shares                []string `bson:"shares"`
+	EmbargoUntilTimestamp int64    `bson:"embargoUntilTimestamp"`
+	UpdateTimestamp       int64    `bson:"updateTimestamp"`
+}
+
+// auditRecord is an entry of the audit log, never updated once written
+type auditRecord struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	Duid      string             `bson:"duid"`
+	Operation string             `bson:"operation"`
+	Actor     string             `bson:"actor"`
+	Peer      string             `bson:"peer"`
+	RequestID string             `bson:"requestId"`
+	Timestamp int64              `bson:"timestamp"`
+	Code      string             `bson:"code"`
+	Error     string             `bson:"error,omitempty"`
+	Before    *auditSummary      `bson:"before,omitempty"`
+	After     *auditSummary      `bson:"after,omitempty"`
+	// Time MongoDB removes the record at, unset if the records are kept forever
+	ExpireAt *time.Time `bson:"expireAt,omitempty"`
+}
+
+// auditEntry collects the audit record of a call while it runs
+type auditEntry struct {
+	mu     sync.Mutex
+	record auditRecord
+}
+
+// auditKey is the context key of the audit entry of a call
+type auditKey struct{}
+
+// AuditUnaryServerInterceptor records every call mutating a Document in the audit log, successful or not,
+// with its caller, peer address, duid, outcome, and the Document before and after the call.
+// Placed after authentication, so the caller is known.
+// Returns the interceptor.
+func AuditUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		operation, ok := auditedMethods[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		entry := newAuditEntry(ctx, operation, req, time.Now().UTC())
+		res, err := handler(context.WithValue(ctx, auditKey{}, entry), req)
+
+		record := entry.finish(err, conf.Audit.Retention)
+		writeCtx, cancel := context.WithTimeout(context.Background(), auditWriteTimeout)
+		defer cancel()
+		if writeErr := writeAuditRecord(writeCtx, record); writeErr != nil {
+			log.ErrorContext(ctx, consts.AuditTag, "Failed to write the audit record",
+				log.Value("record", record), log.Value("error", writeErr.Error()))
+		}
+		return res, err
+	}
+}
+
+// newAuditEntry starts the audit record of a call of the operation at now.
+// Returns the entry.
+func newAuditEntry(ctx context.Context, operation string, req interface{}, now time.Time) *auditEntry {
+	entry := &auditEntry{record: auditRecord{
+		Duid:      auditDUID(req),
+		Operation: operation,
+		RequestID: log.RequestID(ctx),
+		Timestamp: now.Unix(),
+	}}
+	if caller, ok := auth.FromContext(ctx); ok {
+		entry.record.Actor = caller.UUID
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		entry.record.Peer = p.Addr.String()
+	}
+	return entry
+}
+
+// auditDUID returns the duid of the request, or of the Document or file of a DocumentRequest.
+// Returns empty for a new Document, its duid is recorded once it is written.
+func auditDUID(req interface{}) string {
+	switch r := req.(type) {
+	case *pbsvc.DocumentRequest:
+		if r.GetFileMetadataParameters() != nil {
+			return r.GetFileMetadataParameters().GetDuid()
+		}
+		return r.GetData().GetDuid()
+	case interface{ GetDuid() string }:
+		return r.GetDuid()
+	}
+	return ""
+}
+
+// auditBefore records the Document mutated by the call of ctx, as read before the mutation.
+// Does nothing if the call is not audited.
+func auditBefore(ctx context.Context, record *documentRecord) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.summarize(record, &entry.record.Before)
+	}
+}
+
+// auditAfter records the Document as written by the call of ctx.
+// Does nothing if the call is not audited.
+func auditAfter(ctx context.Context, record *documentRecord) {
+	if entry, ok := ctx.Value(auditKey{}).(*auditEntry); ok {
+		entry.summarize(record, &entry.record.After)
+	}
+}
+
+// auditPublication records the publication of the Document by the embargo scheduler at now.
+// A failed write is logged, the publication stands.
+func auditPublication(ctx context.Context, record *documentRecord, now time.Time) {
+	entry := newAuditEntry(ctx, publicationOperation, nil, now)
+	entry.summarize(record, &entry.record.After)
+	audited := entry.finish(nil, conf.Audit.Retention)
+	if err := writeAuditRecord(ctx, audited); err != nil {
+		log.ErrorContext(ctx, consts.AuditTag, "Failed to write the audit record",
+			log.Value("record", audited), log.Value("error", err.Error()))
+	}
+}
+
+// summarize records the summary of the Document in the field of the entry.
+// A created Document only has a duid once written, so the duid is taken from it if the request had none.
+func (e *auditEntry) summarize(record *documentRecord, field **auditSummary) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	*field = summarizeDocument(record)
+	if e.record.Duid == "" {
+		e.record.Duid = record.GetDuid()
+	}
+}
+
+// finish records the outcome of the call, and the expiry of the record after the retention if set.
+// Returns the audit record to write.
+func (e *auditEntry) finish(err error, retention time.Duration) *auditRecord {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	record := e.record
+	s := status.Convert(err)
+	record.Code = s.Code().String()
+	if err != nil {
+		record.Error = s.Message()
+	}
+	if retention > 0 {
+		expireAt := time.Unix(record.Timestamp, 0).Add(retention).UTC()
+		record.ExpireAt = &expireAt
+	}
+	return &record
+}
+
+// summarizeDocument returns the owner, visibility, files, shares, and embargo of the Document.
+func summarizeDocument(record *documentRecord) *auditSummary {
+	summary := &auditSummary{
+		Uuid:                  record.GetUuid(),
+		IsPublic:              record.GetIsPublic(),
+		Fuids:                 make([]string, 0),
+		Shares:                make([]string, 0),
+		EmbargoUntilTimestamp: record.EmbargoUntilTimestamp,
+		UpdateTimestamp:       record.GetUpdateTimestamp(),
+	}
+	for _, ref := range fileRefURLs(&record.Document) {
+		summary.Fuids = append(summary.Fuids, ref.fuid)
+	}
+	for _, share := range record.Shares {
+		grantee := "uuid:" + share.GetUuid()
+		if share.GetGroup() != "" {
+			grantee = "group:" + share.GetGroup()
+		}
+		summary.Shares = append(summary.Shares, fmt.Sprintf("%s=%s", grantee, share.GetAccess()))
+	}
+	sort.Strings(summary.Shares)
+	return summary
+}
+
+// writeAuditRecord appends the record to the audit log.
+// Returns an error if the record is not written.
+func writeAuditRecord(ctx context.Context, record *auditRecord) error {
+	// A call failing on MongoDB may leave no writer behind
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return err
+	}
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.AuditCollection)
+	_, err := collection.InsertOne(ctx, record)
+	return err
+}
+
+// auditFilter returns the filter of the audit records of the request.
+// Returns an error if the time range or limit of the request is invalid.
+func auditFilter(req *pbext.AuditRecordsRequest) (bson.M, error) {
+	if req.GetLimit() < 0 {
+		return nil, consts.ErrInvalidAuditLimit
+	}
+	from, to := req.GetFromTimestamp(), req.GetToTimestamp()
+	if from > 0 && to > 0 && from > to {
+		return nil, consts.ErrInvalidAuditTimeRange
+	}
+
+	filter := bson.M{}
+	if req.GetDuid() != "" {
+		filter["duid"] = req.GetDuid()
+	}
+	if req.GetActor() != "" {
+		filter["actor"] = req.GetActor()
+	}
+	timestamp := bson.M{}
+	if from > 0 {
+		timestamp["$gte"] = from
+	}
+	if to > 0 {
+		timestamp["$lte"] = to
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+	return filter, nil
+}
+
+// auditLimit returns the number of audit records listed for the requested limit.
+func auditLimit(limit int32) int64 {
+	if limit == 0 {
+		return defaultAuditLimit
+	}
+	if limit > maxAuditLimit {
+		return maxAuditLimit
+	}
+	return int64(limit)
+}
+
+// toProto converts the audit record to its message.
+func (r *auditRecord) toProto() *pbext.AuditRecord {
+	return &pbext.AuditRecord{
+		Id:        r.ID.Hex(),
+		Duid:      r.Duid,
+		Operation: r.Operation,
+		Actor:     r.Actor,
+		Peer:      r.Peer,
+		RequestId: r.RequestID,
+		Timestamp: r.Timestamp,
+		Code:      r.Code,
+		Error:     r.Error,
+		Before:    r.Before.toProto(),
+		After:     r.After.toProto(),
+	}
+}
+
+// toProto converts the summary to its message, nil for a nil summary.
+func (s *auditSummary) toProto() *pbext.DocumentSummary {
+	if s == nil {
+		return nil
+	}
+	return &pbext.DocumentSummary{
+		Uuid:                  s.Uuid,
+		IsPublic:              s.IsPublic,
+		Fuids:                 s.Fuids,
+		Shares:                s.Shares,
+		EmbargoUntilTimestamp: s.EmbargoUntilTimestamp,
+		UpdateTimestamp:       s.UpdateTimestamp,
+	}
+}
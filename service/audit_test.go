@@ -0,0 +1,212 @@
+package service
+
+import (
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAuditDUID(t *testing.T) {
+	cases := []struct {
+		req  interface{}
+		duid string
+	}{
+		{&pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: "duid"}}, "duid"},
+		{&pbsvc.DocumentRequest{FileMetadataParameters: &pbdoc.FileMetadataTransaction{Duid: "duid"}}, "duid"},
+		{&pbsvc.DocumentRequest{}, ""},
+		{&pbext.SetEmbargoRequest{Duid: "duid"}, "duid"},
+		{nil, ""},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.duid, auditDUID(c.req))
+	}
+}
+
+func TestSummarizeDocument(t *testing.T) {
+	record := &documentRecord{
+		Document: pbdoc.Document{
+			Duid:            "duid",
+			Uuid:            ownerUUID,
+			IsPublic:        true,
+			ImageUrlsMap:    map[string]string{"b": "https://hwsc.org/b.jpg"},
+			FileUrlsMap:     map[string]string{"a": "https://hwsc.org/a.pdf"},
+			UpdateTimestamp: 1546300800,
+		},
+		Shares: []*pbext.DocumentShare{
+			{Uuid: otherUUID, Access: pbext.ShareAccess_WRITE},
+			{Group: "lab-a"},
+		},
+		EmbargoUntilTimestamp: 1577836800,
+	}
+
+	assert.Equal(t, &auditSummary{
+		Uuid:                  ownerUUID,
+		IsPublic:              true,
+		Fuids:                 []string{"a", "b"},
+		Shares:                []string{"group:lab-a=READ", "uuid:" + otherUUID + "=WRITE"},
+		EmbargoUntilTimestamp: 1577836800,
+		UpdateTimestamp:       1546300800,
+	}, summarizeDocument(record))
+
+	empty := summarizeDocument(&documentRecord{})
+	assert.Empty(t, empty.Fuids)
+	assert.Empty(t, empty.Shares)
+}
+
+func TestAuditEntry(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 4242}
+	ctx := peer.NewContext(auth.NewContext(context.TODO(), owner), &peer.Peer{Addr: addr})
+	denied := status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error())
+
+	cases := []struct {
+		ctx       context.Context
+		req       interface{}
+		after     *documentRecord
+		err       error
+		retention time.Duration
+		expected  auditRecord
+	}{
+		{ctx, &pbext.SetEmbargoRequest{Duid: "duid"}, nil, denied, 0, auditRecord{
+			Duid: "duid", Operation: "SetEmbargo", Actor: ownerUUID, Peer: "10.0.0.1:4242",
+			Timestamp: now.Unix(), Code: "PermissionDenied", Error: consts.ErrNotDocumentOwner.Error(),
+		}},
+		{context.TODO(), &pbsvc.DocumentRequest{Data: &pbdoc.Document{}},
+			&documentRecord{Document: pbdoc.Document{Duid: "created", Uuid: ownerUUID}}, nil, time.Hour,
+			auditRecord{
+				Duid: "created", Operation: "SetEmbargo", Timestamp: now.Unix(), Code: "OK",
+				After: &auditSummary{Uuid: ownerUUID, Fuids: []string{}, Shares: []string{}},
+			}},
+	}
+
+	for _, c := range cases {
+		entry := newAuditEntry(c.ctx, "SetEmbargo", c.req, now)
+		auditCtx := context.WithValue(c.ctx, auditKey{}, entry)
+		if c.after != nil {
+			auditAfter(auditCtx, c.after)
+		}
+		record := entry.finish(c.err, c.retention)
+
+		if c.retention > 0 {
+			assert.Equal(t, now.Add(c.retention).UTC(), *record.ExpireAt)
+			record.ExpireAt = nil
+		} else {
+			assert.Nil(t, record.ExpireAt)
+		}
+		assert.Equal(t, &c.expected, record)
+	}
+
+	// Calls outside the interceptor are not audited
+	auditBefore(context.TODO(), &documentRecord{})
+}
+
+func TestAuditFilter(t *testing.T) {
+	cases := []struct {
+		req    *pbext.AuditRecordsRequest
+		filter bson.M
+		err    error
+	}{
+		{&pbext.AuditRecordsRequest{}, bson.M{}, nil},
+		{&pbext.AuditRecordsRequest{Duid: "duid", Actor: ownerUUID}, bson.M{"duid": "duid", "actor": ownerUUID}, nil},
+		{&pbext.AuditRecordsRequest{FromTimestamp: 10}, bson.M{"timestamp": bson.M{"$gte": int64(10)}}, nil},
+		{&pbext.AuditRecordsRequest{FromTimestamp: 10, ToTimestamp: 10},
+			bson.M{"timestamp": bson.M{"$gte": int64(10), "$lte": int64(10)}}, nil},
+		{&pbext.AuditRecordsRequest{ToTimestamp: 20}, bson.M{"timestamp": bson.M{"$lte": int64(20)}}, nil},
+		{&pbext.AuditRecordsRequest{FromTimestamp: 20, ToTimestamp: 10}, nil, consts.ErrInvalidAuditTimeRange},
+		{&pbext.AuditRecordsRequest{Limit: -1}, nil, consts.ErrInvalidAuditLimit},
+	}
+
+	for _, c := range cases {
+		filter, err := auditFilter(c.req)
+		assert.Equal(t, c.err, err)
+		assert.Equal(t, c.filter, filter)
+	}
+}
+
+func TestAuditLimit(t *testing.T) {
+	cases := []struct {
+		limit    int32
+		expected int64
+	}{
+		{0, defaultAuditLimit},
+		{1, 1},
+		{maxAuditLimit, maxAuditLimit},
+		{maxAuditLimit + 1, maxAuditLimit},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, auditLimit(c.limit))
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	serviceStateLocker.currentServiceState = available
+	e := ExtensionService{}
+	interceptor := AuditUnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/ext.DocumentExtensionService/ReorderFiles"}
+	reorder := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return e.ReorderFiles(ctx, req.(*pbext.ReorderFilesRequest))
+	}
+	req := &pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE}
+	from := time.Now().UTC().Unix()
+
+	// A refused and a successful mutation are both recorded
+	_, err := interceptor(auth.NewContext(context.TODO(), other), req, info, reorder)
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error()).Error())
+	_, err = interceptor(auth.NewContext(context.TODO(), owner), req, info, reorder)
+	assert.Nil(t, err)
+
+	// Reads are not audited
+	_, err = interceptor(auth.NewContext(context.TODO(), owner), &pbext.ListSharesRequest{Duid: duid},
+		&grpc.UnaryServerInfo{FullMethod: "/ext.DocumentExtensionService/ListShares"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return e.ListShares(ctx, req.(*pbext.ListSharesRequest))
+		})
+	assert.Nil(t, err)
+
+	// Only admins list the audit log
+	_, err = e.ListAuditRecords(auth.NewContext(context.TODO(), owner), &pbext.AuditRecordsRequest{})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrAdminRequired.Error()).Error())
+	_, err = e.ListAuditRecords(auth.NewContext(context.TODO(), admin),
+		&pbext.AuditRecordsRequest{FromTimestamp: 2, ToTimestamp: 1})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrInvalidAuditTimeRange.Error()).Error())
+
+	res, err := e.ListAuditRecords(auth.NewContext(context.TODO(), admin),
+		&pbext.AuditRecordsRequest{Duid: duid, FromTimestamp: from})
+	assert.Nil(t, err)
+	if !assert.Len(t, res.GetRecords(), 2) {
+		return
+	}
+	succeeded, refused := res.GetRecords()[0], res.GetRecords()[1]
+	assert.Equal(t, "ReorderFiles", succeeded.GetOperation())
+	assert.Equal(t, ownerUUID, succeeded.GetActor())
+	assert.Equal(t, codes.OK.String(), succeeded.GetCode())
+	assert.Equal(t, ownerUUID, succeeded.GetBefore().GetUuid())
+	assert.Equal(t, ownerUUID, succeeded.GetAfter().GetUuid())
+	assert.Equal(t, otherUUID, refused.GetActor())
+	assert.Equal(t, codes.PermissionDenied.String(), refused.GetCode())
+	assert.NotNil(t, refused.GetBefore())
+	assert.Nil(t, refused.GetAfter())
+
+	// The actor and limit filter the records
+	res, err = e.ListAuditRecords(auth.NewContext(context.TODO(), admin),
+		&pbext.AuditRecordsRequest{Actor: otherUUID, FromTimestamp: from, Limit: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, res.GetRecords(), 1) {
+		assert.Equal(t, refused.GetId(), res.GetRecords()[0].GetId())
+	}
+}
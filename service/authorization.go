@@ -0,0 +1,136 @@
+package service
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+)
+
+// callerOf returns the authenticated caller of the call, nil if authentication is disabled.
+// Returns consts.ErrMissingCaller if authentication is enabled and the call has no caller.
+func callerOf(ctx context.Context) (*auth.Caller, error) {
+	if caller, ok := auth.FromContext(ctx); ok {
+		tracing.Annotate(ctx, tracing.CallerKey.String(caller.UUID))
+		log.SetCaller(ctx, caller.UUID)
+		return caller, nil
+	}
+	if conf.Auth.Disabled {
+		return nil, nil
+	}
+	return nil, consts.ErrMissingCaller
+}
+
+// isAdmin returns true if the caller may act on every Document,
+// which is every caller if authentication is disabled.
+func isAdmin(caller *auth.Caller) bool {
+	return caller == nil || caller.HasRole(auth.AdminRole)
+}
+
+// authorizeOwner checks that the caller may mutate a Document of the owner.
+// Returns consts.ErrNotDocumentOwner unless the caller is the owner or an admin.
+func authorizeOwner(caller *auth.Caller, owner string) error {
+	if isAdmin(caller) || caller.UUID == owner {
+		return nil
+	}
+	return consts.ErrNotDocumentOwner
+}
+
+// authorizeAdmin checks that the caller is an admin.
+// Returns consts.ErrAdminRequired otherwise.
+func authorizeAdmin(caller *auth.Caller) error {
+	if isAdmin(caller) {
+		return nil
+	}
+	return consts.ErrAdminRequired
+}
+
+// authorizeWriter checks that the caller may edit the Document and its files.
+// Returns consts.ErrNotDocumentOwner unless the caller is the owner, an admin, or shared write access.
+func authorizeWriter(caller *auth.Caller, record *documentRecord) error {
+	if authorizeOwner(caller, record.GetUuid()) == nil {
+		return nil
+	}
+	for _, share := range record.Shares {
+		if share.GetAccess() == pbext.ShareAccess_WRITE && isSharedWith(caller, share) {
+			return nil
+		}
+	}
+	return consts.ErrNotDocumentOwner
+}
+
+// isSharedWith returns true if the share grants the caller, or a group of the caller.
+func isSharedWith(caller *auth.Caller, share *pbext.DocumentShare) bool {
+	if share.GetUuid() != "" {
+		return share.GetUuid() == caller.UUID
+	}
+	for _, group := range caller.Groups {
+		if group == share.GetGroup() {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedWithFilter returns the $elemMatch of the shares granting the caller, or a group of the caller.
+func sharedWithFilter(caller *auth.Caller) bson.M {
+	groups := bson.A{}
+	for _, group := range caller.Groups {
+		groups = append(groups, group)
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"uuid": caller.UUID},
+		bson.M{"group": bson.M{"$in": groups}},
+	}}
+}
+
+// publicFilter returns the filter of the Documents public and not embargoed at the unix time now.
+func publicFilter(now int64) bson.M {
+	return bson.M{"isPublic": true, "embargoUntilTimestamp": bson.M{"$not": bson.M{"$gt": now}}}
+}
+
+// visibilityFilter returns the filter of the Documents the caller may read at the unix time now:
+// public ones, its own, and the ones shared with it.
+// Returns nil if the caller may read every Document.
+func visibilityFilter(caller *auth.Caller, now int64) bson.M {
+	if isAdmin(caller) {
+		return nil
+	}
+	return bson.M{"$or": bson.A{
+		publicFilter(now),
+		bson.M{"uuid": caller.UUID},
+		bson.M{"shares": bson.M{"$elemMatch": sharedWithFilter(caller)}},
+	}}
+}
+
+// readableBy returns true if the caller may read the Document at the unix time now:
+// a public one, its own, or one shared with it.
+func readableBy(caller *auth.Caller, record *documentRecord, now int64) bool {
+	if isAdmin(caller) || caller.UUID == record.GetUuid() || record.isPublicAt(now) {
+		return true
+	}
+	for _, share := range record.Shares {
+		if isSharedWith(caller, share) {
+			return true
+		}
+	}
+	return false
+}
+
+// writableFilter returns the filter of the Documents the caller may edit: its own, and the ones shared for writing.
+// Returns nil if the caller may edit every Document.
+func writableFilter(caller *auth.Caller) bson.M {
+	if isAdmin(caller) {
+		return nil
+	}
+	shared := sharedWithFilter(caller)
+	shared["access"] = pbext.ShareAccess_WRITE
+	return bson.M{"$or": bson.A{
+		bson.M{"uuid": caller.UUID},
+		bson.M{"shares": bson.M{"$elemMatch": shared}},
+	}}
+}
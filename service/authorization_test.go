@@ -0,0 +1,226 @@
+package service
+
+import (
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+const (
+	ownerUUID = "123XXSNJG0MQASDF4QX1EFD6Y3"
+	otherUUID = "0000XSNJG0MQJHBF4QX1EFD6Y3"
+)
+
+var (
+	owner = &auth.Caller{UUID: ownerUUID, Roles: []string{"user"}}
+	other = &auth.Caller{UUID: otherUUID, Roles: []string{"user"}}
+	admin = &auth.Caller{UUID: otherUUID, Roles: []string{auth.AdminRole}}
+)
+
+func TestCallerOf(t *testing.T) {
+	disabled := conf.Auth.Disabled
+	defer func() { conf.Auth.Disabled = disabled }()
+
+	cases := []struct {
+		ctx       context.Context
+		disabled  bool
+		expCaller *auth.Caller
+		expErr    error
+	}{
+		{auth.NewContext(context.TODO(), owner), false, owner, nil},
+		{auth.NewContext(context.TODO(), owner), true, owner, nil},
+		{context.TODO(), true, nil, nil},
+		{context.TODO(), false, nil, consts.ErrMissingCaller},
+	}
+
+	for _, c := range cases {
+		conf.Auth.Disabled = c.disabled
+		caller, err := callerOf(c.ctx)
+		assert.Equal(t, c.expErr, err)
+		assert.Equal(t, c.expCaller, caller)
+	}
+}
+
+func TestAuthorizeOwner(t *testing.T) {
+	cases := []struct {
+		caller   *auth.Caller
+		owner    string
+		expected error
+	}{
+		{owner, ownerUUID, nil},
+		{other, ownerUUID, consts.ErrNotDocumentOwner},
+		{admin, ownerUUID, nil},
+		{nil, ownerUUID, nil},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, authorizeOwner(c.caller, c.owner))
+	}
+
+	assert.Nil(t, authorizeAdmin(admin))
+	assert.Nil(t, authorizeAdmin(nil))
+	assert.Equal(t, consts.ErrAdminRequired, authorizeAdmin(owner))
+}
+
+func TestAuthorizeWriter(t *testing.T) {
+	member := &auth.Caller{UUID: otherUUID, Groups: []string{"lab-a"}}
+	cases := []struct {
+		caller   *auth.Caller
+		shares   []*pbext.DocumentShare
+		expected error
+	}{
+		{owner, nil, nil},
+		{admin, nil, nil},
+		{nil, nil, nil},
+		{other, nil, consts.ErrNotDocumentOwner},
+		{other, []*pbext.DocumentShare{{Uuid: otherUUID, Access: pbext.ShareAccess_WRITE}}, nil},
+		{other, []*pbext.DocumentShare{{Uuid: otherUUID, Access: pbext.ShareAccess_READ}}, consts.ErrNotDocumentOwner},
+		{other, []*pbext.DocumentShare{{Group: "lab-a", Access: pbext.ShareAccess_WRITE}}, consts.ErrNotDocumentOwner},
+		{member, []*pbext.DocumentShare{{Group: "lab-a", Access: pbext.ShareAccess_WRITE}}, nil},
+		{member, []*pbext.DocumentShare{{Group: "lab-b", Access: pbext.ShareAccess_WRITE}}, consts.ErrNotDocumentOwner},
+	}
+
+	for _, c := range cases {
+		record := &documentRecord{Document: pbdoc.Document{Uuid: ownerUUID}, Shares: c.shares}
+		assert.Equal(t, c.expected, authorizeWriter(c.caller, record))
+	}
+}
+
+func TestVisibilityFilter(t *testing.T) {
+	member := &auth.Caller{UUID: otherUUID, Groups: []string{"lab-a"}}
+	assert.Nil(t, visibilityFilter(nil, 1546300800))
+	assert.Nil(t, visibilityFilter(admin, 1546300800))
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"isPublic": true, "embargoUntilTimestamp": bson.M{"$not": bson.M{"$gt": int64(1546300800)}}},
+		bson.M{"uuid": otherUUID},
+		bson.M{"shares": bson.M{"$elemMatch": bson.M{"$or": bson.A{
+			bson.M{"uuid": otherUUID},
+			bson.M{"group": bson.M{"$in": bson.A{"lab-a"}}},
+		}}}},
+	}}, visibilityFilter(member, 1546300800))
+
+	assert.Nil(t, writableFilter(admin))
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"uuid": ownerUUID},
+		bson.M{"shares": bson.M{"$elemMatch": bson.M{
+			"$or": bson.A{
+				bson.M{"uuid": ownerUUID},
+				bson.M{"group": bson.M{"$in": bson.A{}}},
+			},
+			"access": pbext.ShareAccess_WRITE,
+		}}},
+	}}, writableFilter(owner))
+}
+
+func TestDocumentAuthorization(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	serviceStateLocker.currentServiceState = available
+	s := Service{}
+	e := ExtensionService{}
+	denied := status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error()).Error()
+
+	// Mutations by a non-owner are denied, the Document is untouched
+	_, err := s.DeleteDocument(auth.NewContext(context.TODO(), other),
+		&pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: duid}})
+	assert.EqualError(t, err, denied)
+
+	_, err = s.DeleteFileMetadata(auth.NewContext(context.TODO(), other), &pbsvc.DocumentRequest{
+		FileMetadataParameters: &pbdoc.FileMetadataTransaction{
+			Duid:  duid,
+			Fuid:  "00000000-0000-4000-8000-000000000000",
+			Media: pbdoc.FileType_IMAGE,
+		},
+	})
+	assert.EqualError(t, err, denied)
+
+	_, err = e.ReorderFiles(auth.NewContext(context.TODO(), other),
+		&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE})
+	assert.EqualError(t, err, denied)
+
+	_, err = s.CreateDocument(auth.NewContext(context.TODO(), other),
+		&pbsvc.DocumentRequest{Data: &pbdoc.Document{Uuid: ownerUUID}})
+	assert.EqualError(t, err, denied)
+
+	// The owner and admins mutate the Document
+	for _, caller := range []*auth.Caller{owner, admin} {
+		res, err := e.ReorderFiles(auth.NewContext(context.TODO(), caller),
+			&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE})
+		assert.Nil(t, err)
+		assert.Equal(t, duid, res.GetDuid())
+	}
+
+	// Calls without a caller are refused once authentication is enabled
+	conf.Auth.Disabled = false
+	_, err = s.QueryDocument(context.TODO(), &pbsvc.DocumentRequest{})
+	conf.Auth.Disabled = true
+	assert.EqualError(t, err, status.Error(codes.Unauthenticated, consts.ErrMissingCaller.Error()).Error())
+
+	// Broken and unreferenced files of other owners stay hidden
+	broken, err := e.ListBrokenFiles(auth.NewContext(context.TODO(), other), &pbext.BrokenFilesRequest{Uuid: ownerUUID})
+	assert.Nil(t, err)
+	assert.Empty(t, broken.GetDocuments())
+	_, err = e.ListUnreferencedFiles(auth.NewContext(context.TODO(), other), &pbext.UnreferencedFilesRequest{})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrAdminRequired.Error()).Error())
+}
+
+func TestDocumentSharing(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	serviceStateLocker.currentServiceState = available
+	s := Service{}
+	e := ExtensionService{}
+	member := &auth.Caller{UUID: otherUUID, Groups: []string{"lab-a"}}
+	denied := status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error()).Error()
+	reorder := &pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE}
+
+	// Only the owner manages the shares
+	_, err := e.GrantShare(auth.NewContext(context.TODO(), other), &pbext.GrantShareRequest{
+		Duid: duid, Share: &pbext.DocumentShare{Uuid: otherUUID, Access: pbext.ShareAccess_WRITE},
+	})
+	assert.EqualError(t, err, denied)
+	_, err = e.GrantShare(auth.NewContext(context.TODO(), owner), &pbext.GrantShareRequest{Duid: duid})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrInvalidShare.Error()).Error())
+
+	// A read share does not allow edits
+	shares, err := e.GrantShare(auth.NewContext(context.TODO(), owner), &pbext.GrantShareRequest{
+		Duid: duid, Share: &pbext.DocumentShare{Group: "lab-a"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ownerUUID, shares.GetUuid())
+	assert.Equal(t, ownerUUID, shares.GetShares()[0].GetGrantedBy())
+	_, err = e.ReorderFiles(auth.NewContext(context.TODO(), member), reorder)
+	assert.EqualError(t, err, denied)
+
+	// A write share allows edits, not deletion
+	_, err = e.GrantShare(auth.NewContext(context.TODO(), owner), &pbext.GrantShareRequest{
+		Duid: duid, Share: &pbext.DocumentShare{Group: "lab-a", Access: pbext.ShareAccess_WRITE},
+	})
+	assert.Nil(t, err)
+	_, err = e.ReorderFiles(auth.NewContext(context.TODO(), member), reorder)
+	assert.Nil(t, err)
+	_, err = s.DeleteDocument(auth.NewContext(context.TODO(), member),
+		&pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: duid}})
+	assert.EqualError(t, err, denied)
+
+	shares, err = e.ListShares(auth.NewContext(context.TODO(), owner), &pbext.ListSharesRequest{Duid: duid})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(shares.GetShares()))
+	assert.Equal(t, pbext.ShareAccess_WRITE, shares.GetShares()[0].GetAccess())
+
+	// Revoked shares stop granting access
+	shares, err = e.RevokeShare(auth.NewContext(context.TODO(), owner), &pbext.RevokeShareRequest{Duid: duid, Group: "lab-a"})
+	assert.Nil(t, err)
+	assert.Empty(t, shares.GetShares())
+	_, err = e.ReorderFiles(auth.NewContext(context.TODO(), member), reorder)
+	assert.EqualError(t, err, denied)
+	_, err = e.RevokeShare(auth.NewContext(context.TODO(), owner), &pbext.RevokeShareRequest{Duid: duid, Group: "lab-a"})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrShareNotFound.Error()).Error())
+}
@@ -3,7 +3,10 @@ package service
 import (
 	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
-	log "github.com/hwsc-org/hwsc-lib/logger"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/metrics"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/net/context"
@@ -18,6 +21,12 @@ var (
 	mongoDBReader     *mongo.Client
 	mongoDBWriter     *mongo.Client
 	ctxWithTimeout, _ = context.WithTimeout(context.Background(), 5*time.Second)
+
+	// Records the latency and failures of the MongoDB commands of both clients
+	mongoDBMonitor = metrics.NewCommandMonitor()
+
+	// Records the spans of the MongoDB commands of both clients
+	mongoDBTracer = tracing.NewCommandMonitor()
 )
 
 func init() {
@@ -38,7 +47,7 @@ func dialMongoDB(uri *string) (*mongo.Client, error) {
 	if strings.TrimSpace(*uri) == "" {
 		return nil, consts.ErrEmptyMongoDBURI
 	}
-	client, err := mongo.Connect(ctxWithTimeout, options.Client().ApplyURI(*uri))
+	client, err := mongo.Connect(ctxWithTimeout, options.Client().ApplyURI(*uri).SetMonitor(commandMonitor()))
 	if err != nil {
 		return nil, err
 	}
@@ -48,6 +57,28 @@ func dialMongoDB(uri *string) (*mongo.Client, error) {
 	return client, nil
 }
 
+// commandMonitor returns the monitor of the MongoDB commands, recording their metrics and spans.
+func commandMonitor() *event.CommandMonitor {
+	monitors := []*event.CommandMonitor{mongoDBMonitor.Monitor(), mongoDBTracer.Monitor()}
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				m.Started(ctx, evt)
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				m.Succeeded(ctx, evt)
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				m.Failed(ctx, evt)
+			}
+		},
+	}
+}
+
 // disconnectMongoDBClient disconnects a client from MongoDB server.
 // Returns if there is any disconnection error.
 func disconnectMongoDBClient(client *mongo.Client) error {
@@ -57,11 +88,12 @@ func disconnectMongoDBClient(client *mongo.Client) error {
 	return client.Disconnect(ctxWithTimeout)
 }
 
-// refreshMongoDBConnection refreshes a client's connection with MongoDB server.
+// refreshMongoDBConnection refreshes a client's connection with MongoDB server, counting the reconnects.
 // Returns if there is any connection error.
 func refreshMongoDBConnection(client *mongo.Client, uri *string) error {
 	if client == nil {
 		newClient, err := dialMongoDB(uri)
+		metrics.ObserveReconnect(err)
 		if err != nil {
 			return consts.ErrMongoDBUnavailable
 		}
@@ -70,6 +102,7 @@ func refreshMongoDBConnection(client *mongo.Client, uri *string) error {
 	}
 	if err := client.Ping(context.TODO(), nil); err != nil {
 		newClient, err := dialMongoDB(uri)
+		metrics.ObserveReconnect(err)
 		if err != nil {
 			assignMongoDBClient(nil, uri)
 			return consts.ErrMongoDBUnavailable
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// StartEmbargoScheduler publishes the Documents whose embargo passed in the background.
+// Does nothing if the interval is 0.
+func StartEmbargoScheduler() {
+	if conf.Embargo.Interval <= 0 {
+		log.Info(consts.EmbargoSchedulerTag, "Disabled")
+		return
+	}
+	go runEmbargoScheduler(conf.Embargo.Interval)
+	log.Info(consts.EmbargoSchedulerTag, "Started, publishing every", conf.Embargo.Interval.String())
+}
+
+// runEmbargoScheduler publishes the Documents whose embargo passed, then waits for the interval, forever.
+func runEmbargoScheduler(interval time.Duration) {
+	for {
+		published, err := publishEmbargoedDocuments(context.Background(), time.Now().UTC().Unix())
+		if err != nil {
+			log.Error(consts.EmbargoSchedulerTag, err.Error())
+		} else if published > 0 {
+			log.Info(consts.EmbargoSchedulerTag, fmt.Sprintf("Published %d documents", published))
+		}
+		time.Sleep(interval)
+	}
+}
+
+// embargoPassedFilter returns the filter of the Documents whose embargo passed at the unix time now.
+func embargoPassedFilter(now int64) bson.M {
+	return bson.M{"embargoUntilTimestamp": bson.M{"$gt": 0, "$lte": now}}
+}
+
+// publishEmbargoedDocuments makes the Documents whose embargo passed at the unix time now public,
+// lifts their embargo, and records now as their published timestamp.
+// Returns the number of Documents published, or an error if they could not be read or updated.
+func publishEmbargoedDocuments(ctx context.Context, now int64) (int, error) {
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return 0, err
+	}
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	cur, err := collection.Find(ctx, embargoPassedFilter(now), options.Find().SetProjection(bson.M{"duid": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var duids []string
+	for cur.Next(ctx) {
+		record := &documentRecord{}
+		if err = cur.Decode(record); err != nil {
+			break
+		}
+		duids = append(duids, record.GetDuid())
+	}
+	if err == nil {
+		err = cur.Err()
+	}
+	if err := cur.Close(ctx); err != nil {
+		log.Error(consts.EmbargoSchedulerTag, err.Error())
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, duid := range duids {
+		record, err := publishDocument(ctx, collection, duid, now)
+		if err != nil {
+			return published, err
+		}
+		if record != nil {
+			published++
+			auditPublication(ctx, record, time.Unix(now, 0).UTC())
+			log.Info(consts.EmbargoSchedulerTag, fmt.Sprintf("Published document, duid: %s - at: %d", duid, now))
+		}
+	}
+	return published, nil
+}
+
+// publishDocument makes the Document with the duid public if its embargo passed at the unix time now.
+// Returns the published Document, nil if the embargo was changed or lifted meanwhile,
+// or an error if the Document could not be updated.
+func publishDocument(ctx context.Context, collection *mongo.Collection, duid string, now int64) (*documentRecord, error) {
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(duid)
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	filter := embargoPassedFilter(now)
+	filter["duid"] = duid
+	update := bson.M{
+		"$set":   bson.M{"isPublic": true, "publishedTimestamp": now},
+		"$unset": bson.M{"embargoUntilTimestamp": ""},
+	}
+	// option to return the the document after update
+	after := options.After
+	option := &options.FindOneAndUpdateOptions{ReturnDocument: &after}
+	record, err := findAndWriteDocument(ctx, outbox.DocumentPublished, "", func(ctx context.Context) *mongo.SingleResult {
+		return collection.FindOneAndUpdate(ctx, filter, update, option)
+	})
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	return record, err
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestEmbargoPassedFilter(t *testing.T) {
+	assert.Equal(t, bson.M{"embargoUntilTimestamp": bson.M{"$gt": 0, "$lte": int64(1546300800)}},
+		embargoPassedFilter(1546300800))
+}
+
+func TestDocumentEmbargo(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	serviceStateLocker.currentServiceState = available
+	s := Service{}
+	e := ExtensionService{}
+	until := time.Now().UTC().Unix() + 3600
+
+	// Only the owner sets an embargo, in the future
+	_, err := e.SetEmbargo(auth.NewContext(context.TODO(), other),
+		&pbext.SetEmbargoRequest{Duid: duid, EmbargoUntilTimestamp: until})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error()).Error())
+	_, err = e.SetEmbargo(auth.NewContext(context.TODO(), owner),
+		&pbext.SetEmbargoRequest{Duid: duid, EmbargoUntilTimestamp: minTimestamp})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrEmbargoTimestampPassed.Error()).Error())
+
+	doc, err := e.SetEmbargo(auth.NewContext(context.TODO(), owner),
+		&pbext.SetEmbargoRequest{Duid: duid, EmbargoUntilTimestamp: until})
+	assert.Nil(t, err)
+	assert.True(t, doc.GetIsPublic())
+	ext, err := extractDocumentExtension(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, until, ext.GetEmbargoUntilTimestamp())
+
+	// The embargoed Document is private to other users, not to its owner
+	listed := func(caller *auth.Caller) bool {
+		res, err := s.ListUserDocumentCollection(auth.NewContext(context.TODO(), caller),
+			&pbsvc.DocumentRequest{Data: &pbdoc.Document{Uuid: ownerUUID}})
+		if err != nil {
+			return false
+		}
+		for _, d := range res.GetDocumentCollection() {
+			if d.GetDuid() == duid {
+				return true
+			}
+		}
+		return false
+	}
+	assert.False(t, listed(other))
+	assert.True(t, listed(owner))
+
+	// Nothing is published before the embargo passes
+	published, err := publishEmbargoedDocuments(context.TODO(), until-1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, published)
+
+	published, err = publishEmbargoedDocuments(context.TODO(), until)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, published)
+	assert.True(t, listed(other))
+
+	record, err := findDocumentRecord(context.Background(), mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection), duid)
+	assert.Nil(t, err)
+	assert.True(t, record.GetIsPublic())
+	assert.Equal(t, int64(0), record.EmbargoUntilTimestamp)
+	assert.Equal(t, until, record.PublishedTimestamp)
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"github.com/golang/protobuf/proto"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/media"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
+	"strings"
+	"time"
+)
+
+const (
+	// Codes of the file warnings
+	audioHeaderWarning  = "audio_header"
+	samplingRateWarning = "sampling_rate"
+	exifWarning         = "exif"
+	locationWarning     = "location"
+	captureTimeWarning  = "capture_time"
+)
+
+// documentRecord is the MongoDB representation of a Document.
+// It stores the fields that are not part of lib.Document yet next to the Document fields,
+// so documents written before the extension still decode.
+type documentRecord struct {
+	pbdoc.Document  `bson:",inline"`
+	FileMetadataMap map[string]*pbext.FileMetadata `bson:"fileMetadataMap,omitempty" json:"file_metadata_map,omitempty"`
+	FileOrders      *pbext.FileOrders              `bson:"fileOrders,omitempty" json:"file_orders,omitempty"`
+	Shares          []*pbext.DocumentShare         `bson:"shares,omitempty" json:"shares,omitempty"`
+	// Unix time until which the Document stays private, and then published
+	EmbargoUntilTimestamp int64 `bson:"embargoUntilTimestamp,omitempty" json:"embargo_until_timestamp,omitempty"`
+	// Unix time the embargo of the Document was lifted by its publication
+	PublishedTimestamp int64 `bson:"publishedTimestamp,omitempty" json:"published_timestamp,omitempty"`
+}
+
+// newDocumentRecord builds the MongoDB representation of a Document and its file metadata.
+func newDocumentRecord(doc *pbdoc.Document, files map[string]*pbext.FileMetadata) *documentRecord {
+	if files == nil {
+		files = make(map[string]*pbext.FileMetadata)
+	}
+	return &documentRecord{
+		Document:        *doc,
+		FileMetadataMap: files,
+	}
+}
+
+// toDocument converts the record to a Document carrying its extension fields.
+// Returns an error if the extension fails to encode.
+func (r *documentRecord) toDocument() (*pbdoc.Document, error) {
+	r.normalizeFileOrders()
+	doc := r.Document
+	ext := &pbext.DocumentExtension{
+		FileMetadataMap:       r.FileMetadataMap,
+		FileOrders:            r.FileOrders,
+		EmbargoUntilTimestamp: r.EmbargoUntilTimestamp,
+		PublishedTimestamp:    r.PublishedTimestamp,
+	}
+	if err := attachDocumentExtension(&doc, ext); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// toResponseDocument converts the record to a Document for a response, its file urls signed unless it is public.
+// Returns an error if the extension fails to encode.
+func (r *documentRecord) toResponseDocument() (*pbdoc.Document, error) {
+	doc, err := r.toDocument()
+	if err != nil {
+		return nil, err
+	}
+	signDocumentURLs(doc, r.isPublicAt(time.Now().UTC().Unix()), urlSigner, conf.URLSigner.TTL)
+	return doc, nil
+}
+
+// isPublicAt returns true if the Document is public and not embargoed at the unix time now.
+func (r *documentRecord) isPublicAt(now int64) bool {
+	return r.GetIsPublic() && r.EmbargoUntilTimestamp <= now
+}
+
+// attachDocumentExtension encodes the extension as unknown fields of the Document.
+// Clients compiled against an upstreamed lib.Document read them natively, older clients skip them.
+// Returns an error if the extension fails to encode.
+func attachDocumentExtension(doc *pbdoc.Document, ext *pbext.DocumentExtension) error {
+	b, err := proto.Marshal(ext)
+	if err != nil {
+		return err
+	}
+	doc.XXX_unrecognized = b
+	return nil
+}
+
+// extractDocumentExtension decodes the extension sent as unknown fields of the Document.
+// Returns an empty extension if none was sent, or an error if the unknown fields are malformed.
+func extractDocumentExtension(doc *pbdoc.Document) (*pbext.DocumentExtension, error) {
+	ext := &pbext.DocumentExtension{}
+	if len(doc.XXX_unrecognized) == 0 {
+		return ext, nil
+	}
+	if err := proto.Unmarshal(doc.XXX_unrecognized, ext); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// extractFileMetadataTransactionExtension decodes the extension sent as unknown fields of the
+// FileMetadataTransaction.
+// Returns an empty extension if none was sent, or an error if the unknown fields are malformed.
+func extractFileMetadataTransactionExtension(
+	params *pbdoc.FileMetadataTransaction) (*pbext.FileMetadataTransactionExtension, error) {
+
+	ext := &pbext.FileMetadataTransactionExtension{}
+	if len(params.XXX_unrecognized) == 0 {
+		return ext, nil
+	}
+	if err := proto.Unmarshal(params.XXX_unrecognized, ext); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// fileMetadataFromInfo converts what the storage advertises about a file to file metadata.
+// The header of an audio file is read for its sample rate, channels, bit depth, and duration,
+// and the EXIF of an image for its capture time and coordinates.
+func fileMetadataFromInfo(info *verifier.FileInfo) *pbext.FileMetadata {
+	if info == nil {
+		return &pbext.FileMetadata{}
+	}
+	meta := &pbext.FileMetadata{
+		ContentType:  info.ContentType,
+		Size:         info.Size,
+		Checksum:     info.Checksum,
+		DetectedType: info.DetectedType,
+	}
+
+	if len(info.Head) == 0 {
+		return meta
+	}
+	switch {
+	case strings.HasPrefix(info.DetectedType, "audio/"):
+		audio, err := media.ParseAudioHeader(info.Head, info.Size)
+		switch err {
+		case nil:
+			meta.SampleRate = audio.SampleRate
+			meta.Channels = audio.Channels
+			meta.BitDepth = audio.BitDepth
+			meta.Duration = audio.Duration
+		case consts.ErrMalformedAudioHeader:
+			setFileWarning(meta, audioHeaderWarning, err.Error())
+		}
+	case info.DetectedType == "image/jpeg" || info.DetectedType == "image/tiff":
+		image, err := media.ParseEXIF(info.Head)
+		switch err {
+		case nil:
+			meta.CaptureTimestamp = image.CaptureTimestamp
+			if image.HasLocation {
+				meta.Location = &pbext.FileLocation{Latitude: image.Latitude, Longitude: image.Longitude}
+			}
+		case consts.ErrMalformedEXIF:
+			setFileWarning(meta, exifWarning, err.Error())
+		}
+	}
+	return meta
+}
+
+// mergeFileMetadata records the inspected metadata of the file with the given fuid.
+// Descriptive fields (caption, credit) already known are kept, the added timestamp is set once,
+// and the warnings are replaced.
+// Does nothing if files is nil.
+func mergeFileMetadata(files map[string]*pbext.FileMetadata, fuid string, inspected *pbext.FileMetadata) {
+	if files == nil || inspected == nil {
+		return
+	}
+	meta, ok := files[fuid]
+	if !ok || meta == nil {
+		meta = &pbext.FileMetadata{}
+		files[fuid] = meta
+	}
+	if inspected.GetContentType() != "" {
+		meta.ContentType = inspected.GetContentType()
+	}
+	if inspected.GetSize() > 0 {
+		meta.Size = inspected.GetSize()
+	}
+	if inspected.GetChecksum() != "" {
+		meta.Checksum = inspected.GetChecksum()
+	}
+	if inspected.GetDuration() > 0 {
+		meta.Duration = inspected.GetDuration()
+	}
+	if inspected.GetDetectedType() != "" {
+		meta.DetectedType = inspected.GetDetectedType()
+	}
+	if inspected.GetSampleRate() > 0 {
+		meta.SampleRate = inspected.GetSampleRate()
+		meta.Channels = inspected.GetChannels()
+		meta.BitDepth = inspected.GetBitDepth()
+	}
+	if inspected.GetCaptureTimestamp() > 0 {
+		meta.CaptureTimestamp = inspected.GetCaptureTimestamp()
+	}
+	if inspected.GetLocation() != nil {
+		meta.Location = inspected.GetLocation()
+	}
+	if inspected.GetLastCheckedTimestamp() > 0 {
+		meta.LinkStatus = inspected.GetLinkStatus()
+		meta.LinkError = inspected.GetLinkError()
+		meta.LastCheckedTimestamp = inspected.GetLastCheckedTimestamp()
+	}
+	// The file was inspected again, so earlier warnings about its content are stale
+	meta.Warnings = inspected.GetWarnings()
+	if meta.GetAddedTimestamp() == 0 {
+		meta.AddedTimestamp = time.Now().UTC().Unix()
+	}
+}
+
+// overlayFileMetadata applies the metadata declared by a client over the stored metadata.
+// Only the fields a client may declare are taken: caption, credit, and duration.
+func overlayFileMetadata(stored map[string]*pbext.FileMetadata, declared map[string]*pbext.FileMetadata) {
+	for fuid, d := range declared {
+		if d == nil {
+			continue
+		}
+		meta, ok := stored[fuid]
+		if !ok || meta == nil {
+			meta = &pbext.FileMetadata{}
+			stored[fuid] = meta
+		}
+		meta.Caption = d.GetCaption()
+		meta.Credit = d.GetCredit()
+		if d.GetDuration() > 0 {
+			meta.Duration = d.GetDuration()
+		}
+	}
+}
+
+// pruneFileMetadata removes the metadata of files no longer referenced by the Document url maps.
+func pruneFileMetadata(doc *pbdoc.Document, files map[string]*pbext.FileMetadata) {
+	for fuid := range files {
+		if _, ok := doc.GetImageUrlsMap()[fuid]; ok {
+			continue
+		}
+		if _, ok := doc.GetAudioUrlsMap()[fuid]; ok {
+			continue
+		}
+		if _, ok := doc.GetVideoUrlsMap()[fuid]; ok {
+			continue
+		}
+		if _, ok := doc.GetFileUrlsMap()[fuid]; ok {
+			continue
+		}
+		delete(files, fuid)
+	}
+}
+
+// setFileWarning records a warning on the file, replacing an earlier warning with the same code.
+func setFileWarning(meta *pbext.FileMetadata, code string, message string) {
+	for _, w := range meta.GetWarnings() {
+		if w.GetCode() == code {
+			w.Message = message
+			return
+		}
+	}
+	meta.Warnings = append(meta.Warnings, &pbext.FileWarning{Code: code, Message: message})
+}
+
+// clearFileWarning removes the warning with the given code from the file.
+func clearFileWarning(meta *pbext.FileMetadata, code string) {
+	warnings := meta.GetWarnings()[:0]
+	for _, w := range meta.GetWarnings() {
+		if w.GetCode() != code {
+			warnings = append(warnings, w)
+		}
+	}
+	if len(warnings) == 0 {
+		warnings = nil
+	}
+	meta.Warnings = warnings
+}
@@ -0,0 +1,1457 @@
+package service
+
+import (
+	"fmt"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// ExtensionService implements the services for managing document not yet published in hwsc-api-blocks
+type ExtensionService struct{}
+
+// ListBrokenFiles lists the files whose url failed its last check, by Document.
+// Returns the Documents of the owner or duid with at least one broken file, every Document if neither is given.
+func (s *ExtensionService) ListBrokenFiles(ctx context.Context,
+	req *pbext.BrokenFilesRequest) (*pbext.BrokenFilesResponse, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListBrokenFiles service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.InfoContext(ctx, consts.ListBrokenFilesTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	filter := bson.M{"fileMetadataMap": bson.M{"$exists": true}}
+	// The broken files of Documents the caller can not fix stay hidden, even of public Documents
+	for key, value := range writableFilter(caller) {
+		filter[key] = value
+	}
+	if req.GetUuid() != "" {
+		if err := ValidateUUID(req.GetUuid()); err != nil {
+			log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter["uuid"] = req.GetUuid()
+	}
+	if req.GetDuid() != "" {
+		if err := ValidateDUID(req.GetDuid()); err != nil {
+			log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter["duid"] = req.GetDuid()
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"duid": 1}))
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	documents := make([]*pbext.BrokenFilesDocument, 0)
+	for cur.Next(ctx) {
+		record := &documentRecord{}
+		if err := cur.Decode(record); err != nil {
+			log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+
+		if files := brokenFiles(record); len(files) > 0 {
+			documents = append(documents, &pbext.BrokenFilesDocument{
+				Duid:  record.GetDuid(),
+				Uuid:  record.GetUuid(),
+				Files: files,
+			})
+		}
+	}
+
+	if err := cur.Err(); err != nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListBrokenFilesTag, err.Error())
+	}
+
+	return &pbext.BrokenFilesResponse{Documents: documents}, nil
+}
+
+// ListUnreferencedFiles lists the stored urls no Document references anymore, safe to delete from the storage.
+// Returns the urls sorted, released at or before ReleasedBefore if set.
+func (s *ExtensionService) ListUnreferencedFiles(ctx context.Context,
+	req *pbext.UnreferencedFilesRequest) (*pbext.UnreferencedFilesResponse, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListUnreferencedFiles service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.InfoContext(ctx, consts.ListUnreferencedFilesTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := authorizeAdmin(caller); err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	filter := bson.M{"refs": bson.M{"$size": 0}}
+	if req.GetReleasedBefore() > 0 {
+		filter["updateTimestamp"] = bson.M{"$lte": req.GetReleasedBefore()}
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.FileRegistryCollection)
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.M{"url": 1}))
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	files := make([]*pbext.UnreferencedFile, 0)
+	for cur.Next(ctx) {
+		entry := &registryEntry{}
+		if err := cur.Decode(entry); err != nil {
+			log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		files = append(files, &pbext.UnreferencedFile{
+			Url:               entry.URL,
+			ReleasedTimestamp: entry.UpdateTimestamp,
+		})
+	}
+
+	if err := cur.Err(); err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListUnreferencedFilesTag, err.Error())
+	}
+
+	return &pbext.UnreferencedFilesResponse{Files: files}, nil
+}
+
+// MoveFile moves a file to the url map of another media type, keeping its fuid and metadata.
+// The url is validated against the pattern of the target media type, and the checks of the media type run again.
+// Returns the updated Document.
+func (s *ExtensionService) MoveFile(ctx context.Context, req *pbext.MoveFileRequest) (*pbdoc.Document, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting MoveFile service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.MoveFileTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := ValidateFUID(req.GetFuid()); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.GetMedia() > pbdoc.FileType_VIDEO {
+		log.ErrorContext(ctx, consts.MoveFileTag, consts.ErrMediaType.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrMediaType.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, fmt.Sprintf("Document not found, duid: %s - err: %s", req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeWriter(caller, record); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	from, ok := findFileMedia(&record.Document, req.GetFuid())
+	if !ok {
+		log.ErrorContext(ctx, consts.MoveFileTag, consts.ErrFileNotFound.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrFileNotFound.Error())
+	}
+	if from == req.GetMedia() {
+		log.ErrorContext(ctx, consts.MoveFileTag, consts.ErrFileAlreadyInMedia.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrFileAlreadyInMedia.Error())
+	}
+
+	url := fileURLsMap(&record.Document, from)[req.GetFuid()]
+	if err := validateMediaURL(req.GetMedia(), url); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	delete(fileURLsMap(&record.Document, from), req.GetFuid())
+	fileURLsMap(&record.Document, req.GetMedia())[req.GetFuid()] = url
+	record.UpdateTimestamp = time.Now().UTC().Unix()
+
+	// The Document must stay valid once moved, keeping at least an image or audio file
+	if err := validateDocument(ctx, &record.Document, nil); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	clearMediaWarnings(record.FileMetadataMap[req.GetFuid()], from)
+	if err := applyMediaChecks(&record.Document, record.FileMetadataMap, req.GetMedia()); err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	document, err := replaceDocumentRecord(ctx, collection, record, outbox.FileUpdated, req.GetFuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.MoveFileTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.MoveFileTag, fmt.Sprintf("Success moving file in document, duid: %s - fuid: %s - media: %s",
+		document.GetDuid(), req.GetFuid(), req.GetMedia().String()))
+
+	return document, nil
+}
+
+// ReplaceFileURL points a file at another url, keeping its fuid, media type, caption, and credit.
+// The new url is validated and inspected like in AddFileMetadata, the rest of the file metadata is replaced.
+// Returns the updated Document.
+func (s *ExtensionService) ReplaceFileURL(ctx context.Context, req *pbext.ReplaceFileURLRequest) (*pbdoc.Document, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ReplaceFileURL service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if req.GetUrl() == "" {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, consts.ErrInvalidFileMetadataParameters.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidFileMetadataParameters.Error())
+	}
+	// A link returned by the service is checked and stored as the url it was signed from
+	req.Url = signer.Strip(req.GetUrl())
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := ValidateFUID(req.GetFuid()); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Test if the URI is reachable
+	inspected, err := inspectURL(ctx, req.GetUrl())
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeWriter(caller, record); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	media, ok := findFileMedia(&record.Document, req.GetFuid())
+	if !ok {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, consts.ErrFileNotFound.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrFileNotFound.Error())
+	}
+	if err := validateMediaURL(media, req.GetUrl()); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	urls := fileURLsMap(&record.Document, media)
+	oldRef := []registryURL{{fuid: req.GetFuid(), url: normalizeURL(urls[req.GetFuid()])}}
+	newRef := []registryURL{{fuid: req.GetFuid(), url: normalizeURL(req.GetUrl())}}
+	urls[req.GetFuid()] = req.GetUrl()
+
+	// Only what the client declared about the file still holds, the rest describes the old file
+	if record.FileMetadataMap == nil {
+		record.FileMetadataMap = make(map[string]*pbext.FileMetadata)
+	}
+	declared := record.FileMetadataMap[req.GetFuid()]
+	record.FileMetadataMap[req.GetFuid()] = &pbext.FileMetadata{
+		Caption: declared.GetCaption(),
+		Credit:  declared.GetCredit(),
+	}
+	mergeFileMetadata(record.FileMetadataMap, req.GetFuid(), inspected)
+	if err := applyMediaChecks(&record.Document, record.FileMetadataMap, media); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	record.UpdateTimestamp = time.Now().UTC().Unix()
+
+	if err := addFileRefs(ctx, record.GetDuid(), newRef); err != nil {
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	document, err := replaceDocumentRecord(ctx, collection, record, outbox.FileUpdated, req.GetFuid())
+	if err != nil {
+		removeFileRefs(ctx, record.GetDuid(), releasedFileRefURLs(newRef, oldRef))
+		log.ErrorContext(ctx, consts.ReplaceFileURLTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	removeFileRefs(ctx, record.GetDuid(), releasedFileRefURLs(oldRef, newRef))
+
+	log.InfoContext(ctx, consts.ReplaceFileURLTag, fmt.Sprintf("Success replacing file url in document, duid: %s - fuid: %s",
+		document.GetDuid(), req.GetFuid()))
+
+	return document, nil
+}
+
+// ReorderFiles sets the display order of the files of a media type.
+// Returns the updated Document.
+func (s *ExtensionService) ReorderFiles(ctx context.Context, req *pbext.ReorderFilesRequest) (*pbdoc.Document, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ReorderFiles service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.GetMedia() > pbdoc.FileType_VIDEO {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, consts.ErrMediaType.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrMediaType.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeWriter(caller, record); err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if err := record.reorderFiles(req.GetMedia(), req.GetFuids()); err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	record.UpdateTimestamp = time.Now().UTC().Unix()
+
+	document, err := replaceDocumentRecord(ctx, collection, record, outbox.FileUpdated, "")
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReorderFilesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.ReorderFilesTag, fmt.Sprintf("Success reordering files in document, duid: %s - media: %s",
+		document.GetDuid(), req.GetMedia().String()))
+
+	return document, nil
+}
+
+// SetPrimaryFile makes a file the primary file of its media type, like the cover image of the Document.
+// Returns the updated Document.
+func (s *ExtensionService) SetPrimaryFile(ctx context.Context, req *pbext.SetPrimaryFileRequest) (*pbdoc.Document, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting SetPrimaryFile service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := ValidateFUID(req.GetFuid()); err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeWriter(caller, record); err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if err := record.setPrimaryFile(req.GetFuid()); err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	record.UpdateTimestamp = time.Now().UTC().Unix()
+
+	document, err := replaceDocumentRecord(ctx, collection, record, outbox.FileUpdated, req.GetFuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetPrimaryFileTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.SetPrimaryFileTag, fmt.Sprintf("Success setting primary file in document, duid: %s - fuid: %s",
+		document.GetDuid(), req.GetFuid()))
+
+	return document, nil
+}
+
+// GrantShare grants a user or a group read or write access to a Document,
+// replacing the previous share of the user or group.
+// Returns the shares of the Document.
+func (s *ExtensionService) GrantShare(ctx context.Context, req *pbext.GrantShareRequest) (*pbext.DocumentShares, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting GrantShare service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.GrantShareTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := validateShare(req.GetShare()); err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeOwner(caller, record.GetUuid()); err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	share := &pbext.DocumentShare{
+		Uuid:             req.GetShare().GetUuid(),
+		Group:            req.GetShare().GetGroup(),
+		Access:           req.GetShare().GetAccess(),
+		GrantedTimestamp: time.Now().UTC().Unix(),
+	}
+	if caller != nil {
+		share.GrantedBy = caller.UUID
+	}
+	shares := grantShare(record.Shares, share)
+	if err := updateShares(ctx, collection, record, shares); err != nil {
+		log.ErrorContext(ctx, consts.GrantShareTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	record.Shares = shares
+	auditAfter(ctx, record)
+
+	log.InfoContext(ctx, consts.GrantShareTag, fmt.Sprintf("Success granting share of document, duid: %s - uuid: %s - group: %s",
+		record.GetDuid(), share.GetUuid(), share.GetGroup()))
+
+	return &pbext.DocumentShares{Duid: record.GetDuid(), Uuid: record.GetUuid(), Shares: shares}, nil
+}
+
+// RevokeShare revokes the share of a user or a group.
+// Returns the remaining shares of the Document.
+func (s *ExtensionService) RevokeShare(ctx context.Context, req *pbext.RevokeShareRequest) (*pbext.DocumentShares, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting RevokeShare service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.RevokeShareTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeOwner(caller, record.GetUuid()); err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	shares, err := revokeShare(record.Shares, req.GetUuid(), req.GetGroup())
+	if err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := updateShares(ctx, collection, record, shares); err != nil {
+		log.ErrorContext(ctx, consts.RevokeShareTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	record.Shares = shares
+	auditAfter(ctx, record)
+
+	log.InfoContext(ctx, consts.RevokeShareTag, fmt.Sprintf("Success revoking share of document, duid: %s - uuid: %s - group: %s",
+		record.GetDuid(), req.GetUuid(), req.GetGroup()))
+
+	return &pbext.DocumentShares{Duid: record.GetDuid(), Uuid: record.GetUuid(), Shares: shares}, nil
+}
+
+// ListShares lists the users and groups a Document is shared with.
+// Returns the shares of the Document, users first then groups.
+func (s *ExtensionService) ListShares(ctx context.Context, req *pbext.ListSharesRequest) (*pbext.DocumentShares, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListShares service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ListSharesTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+
+	if err := authorizeOwner(caller, record.GetUuid()); err != nil {
+		log.ErrorContext(ctx, consts.ListSharesTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	shares := record.Shares
+	if shares == nil {
+		shares = make([]*pbext.DocumentShare, 0)
+	}
+	return &pbext.DocumentShares{Duid: record.GetDuid(), Uuid: record.GetUuid(), Shares: shares}, nil
+}
+
+// SetEmbargo keeps a Document private until the embargo timestamp, when the embargo scheduler publishes it.
+// An embargo timestamp of 0 lifts the embargo, leaving the Document as public as it is.
+// Returns the updated Document.
+func (s *ExtensionService) SetEmbargo(ctx context.Context, req *pbext.SetEmbargoRequest) (*pbdoc.Document, error) {
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting SetEmbargo service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := ValidateDUID(req.GetDuid()); err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := ValidateEmbargoTimestamp(req.GetEmbargoUntilTimestamp()); err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(req.GetDuid())
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record, err := findDocumentRecord(ctx, collection, req.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+			req.GetDuid(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s", req.GetDuid())
+	}
+	auditBefore(ctx, record)
+
+	if err := authorizeOwner(caller, record.GetUuid()); err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	record.EmbargoUntilTimestamp = req.GetEmbargoUntilTimestamp()
+	record.UpdateTimestamp = time.Now().UTC().Unix()
+
+	document, err := replaceDocumentRecord(ctx, collection, record, outbox.DocumentUpdated, "")
+	if err != nil {
+		log.ErrorContext(ctx, consts.SetEmbargoTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.SetEmbargoTag, fmt.Sprintf("Success setting embargo of document, duid: %s - until: %d",
+		document.GetDuid(), req.GetEmbargoUntilTimestamp()))
+
+	return document, nil
+}
+
+// ListAuditRecords lists the audit records of the calls mutating Documents, filtered by duid, actor, and time range.
+// Returns the records newest first, at most the limit of the request.
+func (s *ExtensionService) ListAuditRecords(ctx context.Context,
+	req *pbext.AuditRecordsRequest) (*pbext.AuditRecordsResponse, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListAuditRecords service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.InfoContext(ctx, consts.ListAuditRecordsTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := authorizeAdmin(caller); err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	filter, err := auditFilter(req)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.AuditCollection)
+	option := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}, {Key: "_id", Value: -1}}).
+		SetLimit(auditLimit(req.GetLimit()))
+	cur, err := collection.Find(ctx, filter, option)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	records := make([]*pbext.AuditRecord, 0)
+	for cur.Next(ctx) {
+		record := &auditRecord{}
+		if err := cur.Decode(record); err != nil {
+			log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		records = append(records, record.toProto())
+	}
+
+	if err := cur.Err(); err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListAuditRecordsTag, err.Error())
+	}
+
+	return &pbext.AuditRecordsResponse{Records: records}, nil
+}
+
+// WatchDocuments streams the changes of the Documents the caller may read, selected by the filters of the request,
+// until the call ends. Changes are the events of the outbox, followed by its change stream and selected by MongoDB,
+// or polled if the change stream can not be opened. Without the outbox, the audit log is polled instead.
+// Each change carries the Document, and a token resuming the watch after it.
+// Returns an error if the request is invalid, or the changes can not be read.
+func (s *ExtensionService) WatchDocuments(req *pbext.WatchDocumentsRequest,
+	stream pbext.DocumentExtensionService_WatchDocumentsServer) error {
+
+	ctx := stream.Context()
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting WatchDocuments service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, consts.ErrServiceUnavailable.Error())
+		return status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, err.Error())
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, consts.ErrNilRequest.Error())
+		return status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, err.Error())
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	filter, err := newWatchFilter(caller, req)
+	if err != nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, err.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	cursor, err := decodeResumeToken(req.GetResumeToken())
+	if err != nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, err.Error())
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if conf.Outbox.Enabled {
+		err = watchOutbox(ctx, filter, cursor, conf.Watch.PollInterval, func(event *watchEvent) error {
+			change, err := changeOf(event, time.Now().UTC().Unix())
+			if err != nil {
+				return err
+			}
+			return stream.Send(change)
+		})
+	} else {
+		audit := newAuditWatchFilter(caller, req)
+		collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+		err = pollAuditLog(ctx, pollAfter(cursor, time.Now()), conf.Watch.PollInterval,
+			func(record *auditRecord) error {
+				change, err := audit.changeOf(ctx, collection, record, time.Now().UTC().Unix())
+				if err != nil || change == nil {
+					return err
+				}
+				return stream.Send(change)
+			})
+	}
+	if err != nil {
+		log.ErrorContext(ctx, consts.WatchDocumentsTag, err.Error())
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+// CreateWebhookSubscription subscribes a url to the change events of the Documents the caller may read,
+// selected by their type and QueryDocument parameters.
+// Returns the subscription with its secret, which is not returned again.
+func (s *ExtensionService) CreateWebhookSubscription(ctx context.Context,
+	req *pbext.CreateWebhookSubscriptionRequest) (*pbext.WebhookSubscription, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting CreateWebhookSubscription service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	record, err := newSubscriptionRecord(caller, req, time.Now().UTC())
+	if err != nil {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.SubscriptionCollection)
+	if _, err := collection.InsertOne(ctx, record); err != nil {
+		log.ErrorContext(ctx, consts.CreateWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.CreateWebhookSubscriptionTag, fmt.Sprintf("Success creating webhook subscription, id: %s - uuid: %s",
+		record.ID.Hex(), record.Uuid))
+
+	subscription := record.toProto()
+	subscription.Secret = record.Secret
+	return subscription, nil
+}
+
+// ListWebhookSubscriptions lists the webhook subscriptions of the caller, or of another user for admins.
+// Returns the subscriptions oldest first, without their secrets.
+func (s *ExtensionService) ListWebhookSubscriptions(ctx context.Context,
+	req *pbext.ListWebhookSubscriptionsRequest) (*pbext.WebhookSubscriptions, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListWebhookSubscriptions service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	owner := req.GetUuid()
+	if owner == "" && caller != nil {
+		owner = caller.UUID
+	}
+	if owner != "" {
+		if err := ValidateUUID(owner); err != nil {
+			log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if err := authorizeSubscriber(caller, owner); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.SubscriptionCollection)
+	cur, err := collection.Find(ctx, bson.M{"uuid": owner}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	subscriptions := make([]*pbext.WebhookSubscription, 0)
+	for cur.Next(ctx) {
+		record := &subscriptionRecord{}
+		if err := cur.Decode(record); err != nil {
+			log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		subscriptions = append(subscriptions, record.toProto())
+	}
+
+	if err := cur.Err(); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookSubscriptionsTag, err.Error())
+	}
+
+	return &pbext.WebhookSubscriptions{Subscriptions: subscriptions}, nil
+}
+
+// PauseWebhookSubscription pauses a webhook subscription, or resumes it, including one disabled after failing.
+// Resuming clears the failures of the subscription, and its pending deliveries are attempted again.
+// Returns the updated subscription.
+func (s *ExtensionService) PauseWebhookSubscription(ctx context.Context,
+	req *pbext.PauseWebhookSubscriptionRequest) (*pbext.WebhookSubscription, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting PauseWebhookSubscription service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	id, err := parseSubscriptionID(req.GetId())
+	if err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.SubscriptionCollection)
+	record, err := findSubscriptionRecord(ctx, collection, id)
+	if err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, fmt.Sprintf("Webhook subscription not found, id: %s - err: %s",
+			req.GetId(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Webhook subscription not found, id: %s", req.GetId())
+	}
+
+	if err := authorizeSubscriber(caller, record.Uuid); err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"state":               pbext.WebhookSubscriptionState_ACTIVE,
+			"consecutiveFailures": 0,
+			"updateTimestamp":     time.Now().UTC().Unix(),
+		},
+		"$unset": bson.M{"disabledReason": ""},
+	}
+	if req.GetPaused() {
+		update = bson.M{"$set": bson.M{
+			"state":           pbext.WebhookSubscriptionState_PAUSED,
+			"updateTimestamp": time.Now().UTC().Unix(),
+		}}
+	}
+	updated := &subscriptionRecord{}
+	err = collection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(updated)
+	if err != nil {
+		log.ErrorContext(ctx, consts.PauseWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.PauseWebhookSubscriptionTag, fmt.Sprintf("Success updating webhook subscription, id: %s - state: %s",
+		updated.ID.Hex(), updated.State.String()))
+
+	return updated.toProto(), nil
+}
+
+// DeleteWebhookSubscription deletes a webhook subscription, and its deliveries.
+// Returns the deleted subscription.
+func (s *ExtensionService) DeleteWebhookSubscription(ctx context.Context,
+	req *pbext.DeleteWebhookSubscriptionRequest) (*pbext.WebhookSubscription, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting DeleteWebhookSubscription service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	id, err := parseSubscriptionID(req.GetId())
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	db := mongoDBWriter.Database(conf.DocumentDB.Name)
+	collection := db.Collection(conf.SubscriptionCollection)
+	record, err := findSubscriptionRecord(ctx, collection, id)
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, fmt.Sprintf("Webhook subscription not found, id: %s - err: %s",
+			req.GetId(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Webhook subscription not found, id: %s", req.GetId())
+	}
+
+	if err := authorizeSubscriber(caller, record.Uuid); err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": id}); err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// Deliveries left behind only expire, the subscription is already gone
+	if _, err := db.Collection(conf.DeliveryCollection).DeleteMany(ctx, bson.M{"subscriptionId": id}); err != nil {
+		log.ErrorContext(ctx, consts.DeleteWebhookSubscriptionTag, err.Error())
+	}
+
+	log.InfoContext(ctx, consts.DeleteWebhookSubscriptionTag, fmt.Sprintf("Success deleting webhook subscription, id: %s",
+		record.ID.Hex()))
+
+	return record.toProto(), nil
+}
+
+// ListWebhookDeliveries lists the deliveries of a webhook subscription, with the outcome of their last attempt.
+// Returns the deliveries newest first, at most the limit of the request.
+func (s *ExtensionService) ListWebhookDeliveries(ctx context.Context,
+	req *pbext.ListWebhookDeliveriesRequest) (*pbext.WebhookDeliveries, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListWebhookDeliveries service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	id, err := parseSubscriptionID(req.GetSubscriptionId())
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.GetLimit() < 0 {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, consts.ErrInvalidDeliveryLimit.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidDeliveryLimit.Error())
+	}
+
+	db := mongoDBReader.Database(conf.DocumentDB.Name)
+	record, err := findSubscriptionRecord(ctx, db.Collection(conf.SubscriptionCollection), id)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, fmt.Sprintf("Webhook subscription not found, id: %s - err: %s",
+			req.GetSubscriptionId(), err.Error()))
+		return nil, status.Errorf(codes.InvalidArgument, "Webhook subscription not found, id: %s",
+			req.GetSubscriptionId())
+	}
+
+	if err := authorizeSubscriber(caller, record.Uuid); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	option := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(deliveryLimit(req.GetLimit()))
+	cur, err := db.Collection(conf.DeliveryCollection).Find(ctx, bson.M{"subscriptionId": id}, option)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	deliveries := make([]*pbext.WebhookDelivery, 0)
+	for cur.Next(ctx) {
+		delivery := &deliveryRecord{}
+		if err := cur.Decode(delivery); err != nil {
+			log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		deliveries = append(deliveries, delivery.toProto())
+	}
+
+	if err := cur.Err(); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListWebhookDeliveriesTag, err.Error())
+	}
+
+	return &pbext.WebhookDeliveries{Deliveries: deliveries}, nil
+}
+
+// ReindexDocuments rewrites the search index from the stored Documents, and removes the entries of the others.
+// Returns the number of entries written and removed.
+func (s *ExtensionService) ReindexDocuments(ctx context.Context,
+	req *pbext.ReindexDocumentsRequest) (*pbext.ReindexDocumentsResponse, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ReindexDocuments service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := authorizeAdmin(caller); err != nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if req.GetBatchSize() < 0 {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, consts.ErrInvalidBatchSize.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidBatchSize.Error())
+	}
+
+	if searchIndex == nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, consts.ErrSearchDisabled.Error())
+		return nil, status.Error(codes.FailedPrecondition, consts.ErrSearchDisabled.Error())
+	}
+
+	if err := searchIndex.EnsureIndex(ctx, []byte(searchMapping)); err != nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	indexed, deleted, err := reindexDocuments(ctx, searchIndex, collection, searchBatchSize(req.GetBatchSize()))
+	if err != nil {
+		log.ErrorContext(ctx, consts.ReindexDocumentsTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.InfoContext(ctx, consts.ReindexDocumentsTag, fmt.Sprintf("Indexed %d Documents, removed %d", indexed, deleted))
+
+	return &pbext.ReindexDocumentsResponse{Indexed: indexed, Deleted: deleted}, nil
+}
+
+// CheckSearchIndex compares the search index with the stored Documents, and repairs it if requested.
+// Returns the Documents missing from the index, stale in it, and indexed but not stored.
+func (s *ExtensionService) CheckSearchIndex(ctx context.Context,
+	req *pbext.CheckSearchIndexRequest) (*pbext.SearchIndexReport, error) {
+
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting CheckSearchIndex service")
+
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, consts.ErrServiceUnavailable.Error())
+		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
+	}
+
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if req == nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, consts.ErrNilRequest.Error())
+		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
+	}
+
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := authorizeAdmin(caller); err != nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	if searchIndex == nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, consts.ErrSearchDisabled.Error())
+		return nil, status.Error(codes.FailedPrecondition, consts.ErrSearchDisabled.Error())
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	report, err := checkSearchIndex(ctx, searchIndex, collection, req.GetRepair(), searchBatchSize(0))
+	if err != nil {
+		log.ErrorContext(ctx, consts.CheckSearchIndexTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.InfoContext(ctx, consts.CheckSearchIndexTag, fmt.Sprintf("Missing %d stale %d extra %d of %d Documents",
+		len(report.GetMissing()), len(report.GetStale()), len(report.GetExtra()), report.GetDocuments()))
+
+	return report, nil
+}
+
+// findDocumentRecord reads the stored Document with the duid.
+// Returns an error if there is no such Document.
+func findDocumentRecord(ctx context.Context, collection *mongo.Collection, duid string) (*documentRecord, error) {
+	record := &documentRecord{}
+	if err := collection.FindOne(ctx, bson.M{"duid": duid}).Decode(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// replaceDocumentRecord writes the record over the stored Document with the same duid, with its change event
+// of the type for the file with the fuid if any, and audits it.
+// Returns the Document as stored, its file urls signed for the response.
+func replaceDocumentRecord(ctx context.Context, collection *mongo.Collection, record *documentRecord,
+	eventType string, fuid string) (*pbdoc.Document, error) {
+	record.normalizeFileOrders()
+
+	// option to return the the document after update
+	after := options.After
+	option := &options.FindOneAndReplaceOptions{ReturnDocument: &after}
+	updated, err := findAndWriteDocument(ctx, eventType, fuid, func(ctx context.Context) *mongo.SingleResult {
+		return collection.FindOneAndReplace(ctx, bson.M{"duid": record.GetDuid()}, record, option)
+	})
+	if err != nil {
+		return nil, err
+	}
+	auditAfter(ctx, updated)
+	return updated.toResponseDocument()
+}
+
+// updateShares replaces the shares of the stored Document of the record, with its change event.
+// Returns an error if the Document can not be updated.
+func updateShares(ctx context.Context, collection *mongo.Collection, record *documentRecord,
+	shares []*pbext.DocumentShare) error {
+	update := bson.M{"$set": bson.M{"shares": shares}}
+	if len(shares) == 0 {
+		update = bson.M{"$unset": bson.M{"shares": ""}}
+	}
+	return withOutbox(ctx, func(ctx context.Context) ([]*outbox.Event, error) {
+		if _, err := collection.UpdateOne(ctx, bson.M{"duid": record.GetDuid()}, update); err != nil {
+			return nil, err
+		}
+		updated := *record
+		updated.Shares = shares
+		return documentEvents(outbox.DocumentUpdated, &updated, "")
+	})
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestMoveFile(t *testing.T) {
+	const (
+		duid     = "1ChHflUo83rzychXywlpg9fkrXd"
+		pngFUID  = "d0918e13-28ad-4019-8105-49bb6e9a7eb0"
+		pngURL   = "https://hwscdevstorage.blob.core.windows.net/images/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.png"
+		unknownF = "00000000-0000-4000-8000-000000000000"
+		// the only audio file of a Document without images
+		lastDUID = "1ChHfmG1Jt1H6miCBdYz4ActR0X"
+		lastFUID = "98563601-032f-4781-8945-f8bdaec4544f"
+	)
+
+	cases := []struct {
+		req         *pbext.MoveFileRequest
+		serverState state
+		expMsg      string
+		isExpErr    bool
+	}{
+		{&pbext.MoveFileRequest{}, unavailable, "rpc error: code = Unavailable desc = service unavailable", true},
+		{nil, available, "rpc error: code = InvalidArgument desc = nil request", true},
+		{&pbext.MoveFileRequest{Duid: "some duid"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document duid", true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: "some fuid"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document fuid", true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: pngFUID, Media: 4}, available,
+			"rpc error: code = InvalidArgument desc = invalid media type", true},
+		{&pbext.MoveFileRequest{Duid: imaginaryDUID, Fuid: pngFUID}, available,
+			"rpc error: code = InvalidArgument desc = Document not found, duid: " + imaginaryDUID, true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: unknownF}, available,
+			"rpc error: code = InvalidArgument desc = no file with the fuid in the Document", true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: pngFUID, Media: pbdoc.FileType_IMAGE}, available,
+			"rpc error: code = InvalidArgument desc = file already has the media type", true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: pngFUID, Media: pbdoc.FileType_AUDIO}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document AudioURL", true},
+		{&pbext.MoveFileRequest{Duid: lastDUID, Fuid: lastFUID, Media: pbdoc.FileType_FILE}, available,
+			"rpc error: code = InvalidArgument desc = " + consts.ErrAtLeastOneImageAudioURL.Error(), true},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: pngFUID, Media: pbdoc.FileType_FILE}, available, "", false},
+		{&pbext.MoveFileRequest{Duid: duid, Fuid: pngFUID, Media: pbdoc.FileType_IMAGE}, available, "", false},
+	}
+
+	for _, c := range cases {
+		serviceStateLocker.currentServiceState = c.serverState
+		s := ExtensionService{}
+		res, err := s.MoveFile(context.TODO(), c.req)
+		if c.isExpErr {
+			assert.EqualError(t, err, c.expMsg)
+			assert.Nil(t, res)
+			continue
+		}
+		assert.Nil(t, err)
+		media, ok := findFileMedia(res, pngFUID)
+		assert.True(t, ok)
+		assert.Equal(t, c.req.GetMedia(), media)
+		assert.Equal(t, pngURL, fileURLsMap(res, media)[pngFUID])
+	}
+}
+
+func TestReplaceFileURL(t *testing.T) {
+	const (
+		duid    = "1ChHflUo83rzychXywlpg9fkrXd"
+		jpgFUID = "3ef96062-5ec0-4bf2-98fd-b93f70df1a8c"
+		jpgURL  = "https://hwscdevstorage.blob.core.windows.net/images/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.jpg"
+	)
+
+	cases := []struct {
+		req         *pbext.ReplaceFileURLRequest
+		serverState state
+		expMsg      string
+	}{
+		{&pbext.ReplaceFileURLRequest{}, unavailable, "rpc error: code = Unavailable desc = service unavailable"},
+		{nil, available, "rpc error: code = InvalidArgument desc = nil request"},
+		{&pbext.ReplaceFileURLRequest{Duid: duid}, available,
+			"rpc error: code = InvalidArgument desc = invalid FileMetadataParameters"},
+		{&pbext.ReplaceFileURLRequest{Duid: "some duid", Url: "https://example.com/a.jpg"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document duid"},
+		{&pbext.ReplaceFileURLRequest{Duid: duid, Fuid: "some fuid", Url: "https://example.com/a.jpg"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document fuid"},
+	}
+
+	for _, c := range cases {
+		serviceStateLocker.currentServiceState = c.serverState
+		s := ExtensionService{}
+		res, err := s.ReplaceFileURL(context.TODO(), c.req)
+		assert.EqualError(t, err, c.expMsg)
+		assert.Nil(t, res)
+	}
+
+	// A link returned by the service is inspected and stored as the url it was signed from
+	urls, err := signer.New([]signer.Key{{ID: "k1", Secret: []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	signed, err := urls.Sign(jpgURL, time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	serviceStateLocker.currentServiceState = available
+	s := ExtensionService{}
+	res, err := s.ReplaceFileURL(context.TODO(), &pbext.ReplaceFileURLRequest{Duid: duid, Fuid: jpgFUID, Url: signed})
+	assert.Nil(t, err)
+	assert.Equal(t, jpgURL, res.GetImageUrlsMap()[jpgFUID])
+}
+
+func TestReorderFilesAndSetPrimaryFile(t *testing.T) {
+	const (
+		duid    = "1ChHflUo83rzychXywlpg9fkrXd"
+		jpgFUID = "3ef96062-5ec0-4bf2-98fd-b93f70df1a8c"
+		pngFUID = "d0918e13-28ad-4019-8105-49bb6e9a7eb0"
+	)
+
+	reorderCases := []struct {
+		req         *pbext.ReorderFilesRequest
+		serverState state
+		expMsg      string
+		expFuids    []string
+	}{
+		{&pbext.ReorderFilesRequest{}, unavailable, "rpc error: code = Unavailable desc = service unavailable", nil},
+		{nil, available, "rpc error: code = InvalidArgument desc = nil request", nil},
+		{&pbext.ReorderFilesRequest{Duid: "some duid"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document duid", nil},
+		{&pbext.ReorderFilesRequest{Duid: duid, Media: 4}, available,
+			"rpc error: code = InvalidArgument desc = invalid media type", nil},
+		{&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE, Fuids: []string{pngFUID, pngFUID}},
+			available, "rpc error: code = InvalidArgument desc = duplicate fuid", nil},
+		{&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_AUDIO, Fuids: []string{pngFUID}},
+			available, "rpc error: code = InvalidArgument desc = no file with the fuid in the Document", nil},
+		{&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE, Fuids: []string{pngFUID}},
+			available, "", []string{pngFUID, jpgFUID}},
+		{&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE, Fuids: []string{jpgFUID, pngFUID}},
+			available, "", []string{jpgFUID, pngFUID}},
+	}
+
+	for _, c := range reorderCases {
+		serviceStateLocker.currentServiceState = c.serverState
+		s := ExtensionService{}
+		res, err := s.ReorderFiles(context.TODO(), c.req)
+		if c.expMsg != "" {
+			assert.EqualError(t, err, c.expMsg)
+			continue
+		}
+		assert.Nil(t, err)
+		ext, err := extractDocumentExtension(res)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expFuids, ext.GetFileOrders().GetImage().GetFuids())
+	}
+
+	primaryCases := []struct {
+		req         *pbext.SetPrimaryFileRequest
+		serverState state
+		expMsg      string
+	}{
+		{&pbext.SetPrimaryFileRequest{}, unavailable, "rpc error: code = Unavailable desc = service unavailable"},
+		{nil, available, "rpc error: code = InvalidArgument desc = nil request"},
+		{&pbext.SetPrimaryFileRequest{Duid: duid, Fuid: "some fuid"}, available,
+			"rpc error: code = InvalidArgument desc = invalid Document fuid"},
+		{&pbext.SetPrimaryFileRequest{Duid: duid, Fuid: "00000000-0000-4000-8000-000000000000"}, available,
+			"rpc error: code = InvalidArgument desc = no file with the fuid in the Document"},
+		{&pbext.SetPrimaryFileRequest{Duid: duid, Fuid: pngFUID}, available, ""},
+		{&pbext.SetPrimaryFileRequest{Duid: duid, Fuid: jpgFUID}, available, ""},
+	}
+
+	for _, c := range primaryCases {
+		serviceStateLocker.currentServiceState = c.serverState
+		s := ExtensionService{}
+		res, err := s.SetPrimaryFile(context.TODO(), c.req)
+		if c.expMsg != "" {
+			assert.EqualError(t, err, c.expMsg)
+			continue
+		}
+		assert.Nil(t, err)
+		ext, err := extractDocumentExtension(res)
+		assert.Nil(t, err)
+		assert.Equal(t, c.req.GetFuid(), ext.GetFileOrders().GetImage().GetPrimary())
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"github.com/golang/protobuf/proto"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestNewDocumentRecord(t *testing.T) {
+	doc := &pbdoc.Document{Duid: "0ujsszwN8NRY24YaXiTIE2VWDTS"}
+
+	record := newDocumentRecord(doc, nil)
+	assert.Equal(t, doc.GetDuid(), record.GetDuid())
+	assert.NotNil(t, record.FileMetadataMap)
+
+	files := map[string]*pbext.FileMetadata{
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686de": {ContentType: "image/png"},
+	}
+	record = newDocumentRecord(doc, files)
+	assert.Equal(t, files, record.FileMetadataMap)
+}
+
+func TestDocumentRecordToDocument(t *testing.T) {
+	files := map[string]*pbext.FileMetadata{
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686de": {ContentType: "audio/wav", Size: 1024, Caption: "humpback"},
+	}
+	record := newDocumentRecord(&pbdoc.Document{Duid: "0ujsszwN8NRY24YaXiTIE2VWDTS"}, files)
+	record.EmbargoUntilTimestamp = 1893456000
+
+	doc, err := record.toDocument()
+	assert.Nil(t, err)
+	assert.Equal(t, record.GetDuid(), doc.GetDuid())
+
+	// Round trip through the wire as a client would
+	b, err := proto.Marshal(doc)
+	assert.Nil(t, err)
+	received := &pbdoc.Document{}
+	assert.Nil(t, proto.Unmarshal(b, received))
+	assert.Equal(t, record.GetDuid(), received.GetDuid())
+
+	ext, err := extractDocumentExtension(received)
+	assert.Nil(t, err)
+	assert.True(t, proto.Equal(files["4ff30392-8ec8-45a4-ba94-5e22c4a686de"],
+		ext.GetFileMetadataMap()["4ff30392-8ec8-45a4-ba94-5e22c4a686de"]))
+	assert.Equal(t, int64(1893456000), ext.GetEmbargoUntilTimestamp())
+}
+
+func TestIsPublicAt(t *testing.T) {
+	cases := []struct {
+		isPublic bool
+		embargo  int64
+		expected bool
+	}{
+		{true, 0, true},
+		{false, 0, false},
+		{true, 1546300800, true},
+		{true, 1546300801, false},
+		{false, 1546300799, false},
+	}
+
+	for _, c := range cases {
+		record := &documentRecord{Document: pbdoc.Document{IsPublic: c.isPublic}, EmbargoUntilTimestamp: c.embargo}
+		assert.Equal(t, c.expected, record.isPublicAt(1546300800), c)
+	}
+}
+
+func TestToResponseDocument(t *testing.T) {
+	defer func(s *signer.Signer) {
+		urlSigner = s
+	}(urlSigner)
+	s, err := signer.New([]signer.Key{{ID: "k1", Secret: []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	urlSigner = s
+
+	const addr = "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"
+	record := newDocumentRecord(&pbdoc.Document{
+		Duid:         "0ujsszwN8NRY24YaXiTIE2VWDTS",
+		ImageUrlsMap: map[string]string{"4ff30392-8ec8-45a4-ba94-5e22c4a686de": addr},
+	}, nil)
+
+	doc, err := record.toResponseDocument()
+	assert.Nil(t, err)
+	assert.Nil(t, s.Verify(doc.GetImageUrlsMap()["4ff30392-8ec8-45a4-ba94-5e22c4a686de"], time.Now()))
+	assert.Equal(t, addr, record.GetImageUrlsMap()["4ff30392-8ec8-45a4-ba94-5e22c4a686de"])
+
+	record.IsPublic = true
+	doc, err = record.toResponseDocument()
+	assert.Nil(t, err)
+	assert.Equal(t, addr, doc.GetImageUrlsMap()["4ff30392-8ec8-45a4-ba94-5e22c4a686de"])
+}
+
+func TestExtractDocumentExtension(t *testing.T) {
+	cases := []struct {
+		desc     string
+		input    *pbdoc.Document
+		expLen   int
+		isExpErr bool
+	}{
+		{"test for no extension", &pbdoc.Document{}, 0, false},
+		{"test for malformed extension", &pbdoc.Document{XXX_unrecognized: []byte{0xff}}, 0, true},
+	}
+
+	for _, c := range cases {
+		ext, err := extractDocumentExtension(c.input)
+		if c.isExpErr {
+			assert.NotNil(t, err, c.desc)
+		} else {
+			assert.Nil(t, err, c.desc)
+			assert.Equal(t, c.expLen, len(ext.GetFileMetadataMap()), c.desc)
+		}
+	}
+
+	doc := &pbdoc.Document{}
+	err := attachDocumentExtension(doc, &pbext.DocumentExtension{
+		FileMetadataMap: map[string]*pbext.FileMetadata{
+			"4ff30392-8ec8-45a4-ba94-5e22c4a686de": {Credit: "Lisa Kim"},
+		},
+	})
+	assert.Nil(t, err)
+	ext, err := extractDocumentExtension(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, "Lisa Kim", ext.GetFileMetadataMap()["4ff30392-8ec8-45a4-ba94-5e22c4a686de"].GetCredit())
+}
+
+func TestExtractFileMetadataTransactionExtension(t *testing.T) {
+	params := &pbdoc.FileMetadataTransaction{}
+	ext, err := extractFileMetadataTransactionExtension(params)
+	assert.Nil(t, err)
+	assert.Nil(t, ext.GetMetadata())
+
+	b, err := proto.Marshal(&pbext.FileMetadataTransactionExtension{
+		Metadata: &pbext.FileMetadata{Caption: "spectrogram", Duration: 2.5},
+	})
+	assert.Nil(t, err)
+	params.XXX_unrecognized = b
+	ext, err = extractFileMetadataTransactionExtension(params)
+	assert.Nil(t, err)
+	assert.Equal(t, "spectrogram", ext.GetMetadata().GetCaption())
+	assert.Equal(t, 2.5, ext.GetMetadata().GetDuration())
+
+	params.XXX_unrecognized = []byte{0xff}
+	_, err = extractFileMetadataTransactionExtension(params)
+	assert.NotNil(t, err)
+}
+
+func TestFileMetadataFromInfo(t *testing.T) {
+	meta := fileMetadataFromInfo(&verifier.FileInfo{
+		ContentType:  "audio/wav",
+		Size:         4096,
+		Checksum:     "d41d8cd98f00b204e9800998ecf8427e",
+		DetectedType: "audio/wav",
+	})
+	assert.Equal(t, "audio/wav", meta.GetContentType())
+	assert.Equal(t, "audio/wav", meta.GetDetectedType())
+	assert.Equal(t, int64(4096), meta.GetSize())
+	assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", meta.GetChecksum())
+
+	meta = fileMetadataFromInfo(nil)
+	assert.Equal(t, "", meta.GetContentType())
+	assert.Equal(t, int64(0), meta.GetSize())
+}
+
+func TestMergeFileMetadata(t *testing.T) {
+	const fuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+
+	// nil map is a no-op
+	mergeFileMetadata(nil, fuid, &pbext.FileMetadata{ContentType: "image/png"})
+
+	files := map[string]*pbext.FileMetadata{
+		fuid: {Caption: "cover", AddedTimestamp: 1539831496},
+	}
+	mergeFileMetadata(files, fuid, &pbext.FileMetadata{ContentType: "image/png", Size: 10, Caption: "ignored"})
+	assert.Equal(t, "image/png", files[fuid].GetContentType())
+	assert.Equal(t, int64(10), files[fuid].GetSize())
+	assert.Equal(t, "cover", files[fuid].GetCaption())
+	assert.Equal(t, int64(1539831496), files[fuid].GetAddedTimestamp())
+
+	const newFuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686df"
+	mergeFileMetadata(files, newFuid, &pbext.FileMetadata{ContentType: "audio/wav", DetectedType: "audio/wav"})
+	assert.Equal(t, "audio/wav", files[newFuid].GetContentType())
+	assert.Equal(t, "audio/wav", files[newFuid].GetDetectedType())
+	assert.NotZero(t, files[newFuid].GetAddedTimestamp())
+}
+
+func TestOverlayFileMetadata(t *testing.T) {
+	const fuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+	stored := map[string]*pbext.FileMetadata{
+		fuid: {ContentType: "image/png", Caption: "old", AddedTimestamp: 1539831496},
+	}
+	declared := map[string]*pbext.FileMetadata{
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686de": {ContentType: "text/html", Caption: "new", Credit: "Lisa Kim", AddedTimestamp: 1},
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686df": nil,
+	}
+
+	overlayFileMetadata(stored, declared)
+	assert.Equal(t, 1, len(stored))
+	assert.Equal(t, "image/png", stored[fuid].GetContentType())
+	assert.Equal(t, "new", stored[fuid].GetCaption())
+	assert.Equal(t, "Lisa Kim", stored[fuid].GetCredit())
+	assert.Equal(t, int64(1539831496), stored[fuid].GetAddedTimestamp())
+}
+
+func TestPruneFileMetadata(t *testing.T) {
+	doc := &pbdoc.Document{
+		ImageUrlsMap: map[string]string{"4ff30392-8ec8-45a4-ba94-5e22c4a686de": "https://a/b.png"},
+		AudioUrlsMap: map[string]string{"4ff30392-8ec8-45a4-ba94-5e22c4a686df": "https://a/b.wav"},
+	}
+	files := map[string]*pbext.FileMetadata{
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686de": {},
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686df": {},
+		"4ff30392-8ec8-45a4-ba94-5e22c4a686d0": {},
+	}
+
+	pruneFileMetadata(doc, files)
+	assert.Equal(t, 2, len(files))
+	_, ok := files["4ff30392-8ec8-45a4-ba94-5e22c4a686d0"]
+	assert.False(t, ok)
+}
+
+func TestFileMetadataFromInfoAudio(t *testing.T) {
+	head, err := ioutil.ReadFile("test_fixtures/audio/tone_8000hz.wav")
+	assert.Nil(t, err)
+
+	meta := fileMetadataFromInfo(&verifier.FileInfo{DetectedType: "audio/wav", Size: int64(len(head)), Head: head})
+	assert.Equal(t, uint32(8000), meta.GetSampleRate())
+	assert.Equal(t, uint32(1), meta.GetChannels())
+	assert.Equal(t, uint32(16), meta.GetBitDepth())
+	assert.Equal(t, 0.25, meta.GetDuration())
+	assert.Nil(t, meta.GetWarnings())
+
+	meta = fileMetadataFromInfo(&verifier.FileInfo{DetectedType: "audio/wav", Head: head[:30]})
+	assert.Equal(t, uint32(0), meta.GetSampleRate())
+	assert.Equal(t, 1, len(meta.GetWarnings()))
+	assert.Equal(t, audioHeaderWarning, meta.GetWarnings()[0].GetCode())
+
+	// Formats without a parser are only recorded
+	meta = fileMetadataFromInfo(&verifier.FileInfo{DetectedType: "audio/mpeg", Head: []byte("ID3\x03\x00")})
+	assert.Equal(t, uint32(0), meta.GetSampleRate())
+	assert.Nil(t, meta.GetWarnings())
+}
+
+func TestFileWarning(t *testing.T) {
+	meta := &pbext.FileMetadata{}
+	setFileWarning(meta, samplingRateWarning, "first")
+	setFileWarning(meta, audioHeaderWarning, "header")
+	setFileWarning(meta, samplingRateWarning, "second")
+	assert.Equal(t, 2, len(meta.GetWarnings()))
+	assert.Equal(t, "second", meta.GetWarnings()[0].GetMessage())
+
+	clearFileWarning(meta, samplingRateWarning)
+	assert.Equal(t, 1, len(meta.GetWarnings()))
+	assert.Equal(t, audioHeaderWarning, meta.GetWarnings()[0].GetCode())
+
+	clearFileWarning(meta, audioHeaderWarning)
+	assert.Nil(t, meta.GetWarnings())
+}
+
+func TestMergeFileMetadataCapture(t *testing.T) {
+	const fuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+	files := map[string]*pbext.FileMetadata{
+		fuid: {Warnings: []*pbext.FileWarning{{Code: locationWarning, Message: "stale"}}},
+	}
+	location := &pbext.FileLocation{Latitude: 22.89, Longitude: -109.91}
+	mergeFileMetadata(files, fuid, &pbext.FileMetadata{CaptureTimestamp: 1394734993, Location: location})
+	assert.Equal(t, int64(1394734993), files[fuid].GetCaptureTimestamp())
+	assert.Equal(t, location, files[fuid].GetLocation())
+	assert.Nil(t, files[fuid].GetWarnings())
+}
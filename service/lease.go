@@ -0,0 +1,115 @@
+package service
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"os"
+	"time"
+)
+
+// duplicateKeyCode is the MongoDB error code of a unique index violation
+const duplicateKeyCode = 11000
+
+// instanceID identifies this process as the owner of leases and locks
+var instanceID = newInstanceID()
+
+// newInstanceID returns the host name and pid of the process.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// acquireLease takes the lease with the name for the owner until the ttl, or extends it if the owner holds it.
+// A lease past its expiry is taken over.
+// Returns false if another owner holds the lease, or an error if the lease can not be written.
+func acquireLease(ctx context.Context, collection *mongo.Collection, name string, owner string,
+	ttl time.Duration) (bool, error) {
+
+	now := time.Now().UTC()
+	filter := bson.M{"_id": name, "$or": []bson.M{
+		{"expireAt": bson.M{"$lte": now}},
+		{"owner": owner},
+	}}
+	update := bson.M{"$set": bson.M{"owner": owner, "expireAt": now.Add(ttl)}}
+	// Held by another owner, the upsert conflicts with its lease
+	_, err := collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err == nil {
+		return true, nil
+	}
+	if isDuplicateKey(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// releaseLease gives up the lease with the name, if the owner still holds it.
+// Returns an error if the lease can not be written.
+func releaseLease(ctx context.Context, collection *mongo.Collection, name string, owner string) error {
+	_, err := collection.DeleteOne(ctx, bson.M{"_id": name, "owner": owner})
+	return err
+}
+
+// keepLease extends the lease held by the owner every third of the ttl, until stop is called.
+// A ttl too short to be renewed loses the lease at once.
+// Returns a context canceled once the lease is lost or can not be extended before it expires,
+// and the function stopping the renewals.
+func keepLease(ctx context.Context, collection *mongo.Collection, name string, owner string,
+	ttl time.Duration) (context.Context, func()) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if ttl/3 <= 0 {
+			cancel()
+			return
+		}
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		expireAt := time.Now().Add(ttl)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			held, err := acquireLease(ctx, collection, name, owner, ttl)
+			switch {
+			case err == nil && held:
+				expireAt = time.Now().Add(ttl)
+			case err == nil || time.Now().After(expireAt):
+				// Taken over, or expired while it could not be extended
+				cancel()
+				return
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		<-stopped
+		cancel()
+	}
+}
+
+// isDuplicateKey returns true if the error is a unique index violation.
+func isDuplicateKey(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, we := range e.WriteErrors {
+			if we.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return e.Code == duplicateKeyCode
+	}
+	return false
+}
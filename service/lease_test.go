@@ -0,0 +1,99 @@
+package service
+
+import (
+	"errors"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestIsDuplicateKey(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: duplicateKeyCode}}}, true},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 121}}}, false},
+		{mongo.CommandError{Code: duplicateKeyCode}, true},
+		{errors.New("E11000 duplicate key error"), false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, isDuplicateKey(c.err), c.err.Error())
+	}
+}
+
+func TestAcquireLease(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+
+	held, err := acquireLease(context.TODO(), collection, "test", "first", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	// Held until released, but extended by its owner
+	held, err = acquireLease(context.TODO(), collection, "test", "second", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, held)
+	held, err = acquireLease(context.TODO(), collection, "test", "first", -time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	// An expired lease is taken over
+	held, err = acquireLease(context.TODO(), collection, "test", "second", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+
+	assert.Nil(t, releaseLease(context.TODO(), collection, "test", "first"))
+	held, err = acquireLease(context.TODO(), collection, "test", "first", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, held)
+	assert.Nil(t, releaseLease(context.TODO(), collection, "test", "second"))
+}
+
+func TestKeepLease(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	ttl := 300 * time.Millisecond
+
+	held, err := acquireLease(context.TODO(), collection, "kept", "first", ttl)
+	assert.Nil(t, err)
+	assert.True(t, held)
+	ctx, stop := keepLease(context.TODO(), collection, "kept", "first", ttl)
+
+	// Extended past its ttl
+	time.Sleep(2 * ttl)
+	held, err = acquireLease(context.TODO(), collection, "kept", "second", ttl)
+	assert.Nil(t, err)
+	assert.False(t, held)
+	assert.Nil(t, ctx.Err())
+
+	// Lost once taken over
+	assert.Nil(t, releaseLease(context.TODO(), collection, "kept", "first"))
+	held, err = acquireLease(context.TODO(), collection, "kept", "second", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * ttl):
+		t.Error("lease not lost")
+	}
+	stop()
+	assert.Nil(t, releaseLease(context.TODO(), collection, "kept", "second"))
+}
+
+func TestKeepLeaseWithoutTTL(t *testing.T) {
+	// Too short to be renewed, the lease is lost at once
+	for _, ttl := range []time.Duration{-time.Minute, 0, 2} {
+		ctx, stop := keepLease(context.TODO(), nil, "kept", "first", ttl)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Error("lease not lost, ttl:", ttl)
+		}
+		stop()
+	}
+}
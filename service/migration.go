@@ -0,0 +1,264 @@
+package service
+
+import (
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mongodb" // registers the mongodb:// database
+	"github.com/golang-migrate/migrate/v4/source"
+	bindata "github.com/golang-migrate/migrate/v4/source/go_bindata"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/migrations"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/net/context"
+	"strconv"
+	"time"
+)
+
+const (
+	// migrationLockID is the id of the lock document of the migrations
+	migrationLockID = "migrations"
+
+	// migrationLockRetry is the wait between two attempts to take the lock
+	migrationLockRetry = time.Second
+)
+
+// MigrationStatus describes the schema of the Document database against the embedded migrations
+type MigrationStatus struct {
+	// Version of the last migration applied, 0 if none was
+	Version uint
+	// Dirty is set if the last migration failed midway, and must be fixed then forced
+	Dirty bool
+	// Latest is the version of the last embedded migration
+	Latest uint
+	// Pending is the number of embedded migrations above the version
+	Pending int
+}
+
+// migrationVersions returns the versions of the embedded migrations, ascending.
+// Returns an error if the migrations can not be read.
+func migrationVersions() ([]uint, error) {
+	names, err := migrations.Names()
+	if err != nil {
+		return nil, err
+	}
+	var versions []uint
+	for _, name := range names {
+		migration, err := source.DefaultParse(name)
+		if err != nil {
+			return nil, err
+		}
+		// Names are sorted, so the up and down of a version follow each other
+		if len(versions) == 0 || versions[len(versions)-1] != migration.Version {
+			versions = append(versions, migration.Version)
+		}
+	}
+	return versions, nil
+}
+
+// migrationCollections returns the configured collections of the Document database.
+func migrationCollections() migrations.Collections {
+	return migrations.Collections{
+		Documents:     conf.DocumentDB.Collection,
+		Registry:      conf.FileRegistryCollection,
+		Audit:         conf.AuditCollection,
+		Outbox:        conf.OutboxCollection,
+		Subscriptions: conf.SubscriptionCollection,
+		Deliveries:    conf.DeliveryCollection,
+		Usage:         conf.UsageCollection,
+	}
+}
+
+// readMigration returns the embedded migration file with the name, on the configured collections.
+// Returns an error if there is no such file.
+func readMigration(name string) ([]byte, error) {
+	return migrations.Read(name, migrationCollections())
+}
+
+// ReadMigrationStatus reads the version of the Document database.
+// Returns the status, or an error if the database can not be read.
+func ReadMigrationStatus() (*MigrationStatus, error) {
+	status := &MigrationStatus{}
+	err := withMigrate(false, func(m *migrate.Migrate) error {
+		version, dirty, err := m.Version()
+		if err != nil && err != migrate.ErrNilVersion {
+			return err
+		}
+		status.Version, status.Dirty = version, dirty
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := migrationVersions()
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version > status.Version {
+			status.Pending++
+		}
+	}
+	if len(versions) > 0 {
+		status.Latest = versions[len(versions)-1]
+	}
+	return status, nil
+}
+
+// MigrateUp applies the pending migrations under the lock.
+// Returns an error if a migration fails, or the lock can not be taken.
+func MigrateUp() error {
+	return withMigrate(true, func(m *migrate.Migrate) error {
+		if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the steps last migrations under the lock.
+// Returns an error if a migration fails, or the lock can not be taken.
+func MigrateDown(steps int) error {
+	return withMigrate(true, func(m *migrate.Migrate) error {
+		if err := m.Steps(-steps); err != nil && err != migrate.ErrNoChange {
+			return err
+		}
+		return nil
+	})
+}
+
+// ForceMigration sets the version of the database without running a migration, and clears the dirty flag,
+// once a failed migration was fixed by hand. A version of -1 marks no migration applied.
+// Returns an error if the version can not be written, or the lock can not be taken.
+func ForceMigration(version int) error {
+	return withMigrate(true, func(m *migrate.Migrate) error {
+		return m.Force(version)
+	})
+}
+
+// CheckMigrations applies the pending migrations if auto is set, then checks the version of the database.
+// Pending migrations and a dirty version are logged.
+// Returns an error if the migrations failed, or consts.ErrSchemaAhead if the database was migrated by a newer
+// release.
+func CheckMigrations(auto bool) error {
+	if auto {
+		if err := MigrateUp(); err != nil {
+			return err
+		}
+	}
+	status, err := ReadMigrationStatus()
+	if err != nil {
+		if auto {
+			return err
+		}
+		log.Error(consts.MigrationsTag, "Failed to read the schema version:", err.Error())
+		return nil
+	}
+
+	version := strconv.FormatUint(uint64(status.Version), 10)
+	switch {
+	case status.Version > status.Latest:
+		log.Error(consts.MigrationsTag, "Schema version", version, "is ahead of the latest migration",
+			strconv.FormatUint(uint64(status.Latest), 10))
+		return consts.ErrSchemaAhead
+	case status.Dirty:
+		log.Error(consts.MigrationsTag, "Schema version", version, "is dirty, fix the failed migration and force it")
+	case status.Pending > 0:
+		log.Error(consts.MigrationsTag, "Schema version", version, "has", strconv.Itoa(status.Pending),
+			"pending migrations")
+	default:
+		log.Info(consts.MigrationsTag, "Schema at version", version)
+	}
+	return nil
+}
+
+// withMigrate runs fn with the embedded migrations on the Document database, under the lock if locked is set.
+// If the lock is lost meanwhile, the migrations stop after the one running.
+// Returns the error of fn, consts.ErrMigrationLockLost if the lock was lost, or an error if the database can not
+// be opened or the lock taken.
+func withMigrate(locked bool, fn func(m *migrate.Migrate) error) error {
+	names, err := migrations.Names()
+	if err != nil {
+		return err
+	}
+	src, err := bindata.WithInstance(bindata.Resource(names, readMigration))
+	if err != nil {
+		return err
+	}
+	m, err := migrate.NewWithSourceInstance("go-bindata", src, conf.DocumentDB.Writer)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		srcErr, dbErr := m.Close()
+		if srcErr != nil {
+			log.Error(consts.MigrationsTag, srcErr.Error())
+		}
+		if dbErr != nil {
+			log.Error(consts.MigrationsTag, dbErr.Error())
+		}
+	}()
+
+	if locked {
+		if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+			return err
+		}
+		collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.MigrationLockCollection)
+		held, unlock, err := lockMigrations(context.Background(), collection, instanceID, conf.Migrations)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-held.Done():
+				m.GracefulStop <- true
+			case <-done:
+			}
+		}()
+		if err := fn(m); err != nil {
+			return err
+		}
+		if held.Err() != nil {
+			return consts.ErrMigrationLockLost
+		}
+		return nil
+	}
+	return fn(m)
+}
+
+// lockMigrations takes the lock of the migrations for the owner, waiting for another instance to release it
+// up to the lock timeout. A lock older than its TTL is taken over, so the lock is extended until it is released.
+// Returns a context canceled once the lock is lost, the function releasing the lock, or
+// consts.ErrMigrationLocked if the lock is still held by another instance after the timeout.
+func lockMigrations(ctx context.Context, collection *mongo.Collection, owner string,
+	config conf.MigrationsConfig) (context.Context, func(), error) {
+
+	deadline := time.Now().Add(config.LockTimeout)
+	for {
+		held, err := acquireLease(ctx, collection, migrationLockID, owner, config.LockTTL)
+		if err != nil {
+			return nil, nil, err
+		}
+		if held {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, nil, consts.ErrMigrationLocked
+		}
+		log.Info(consts.MigrationsTag, "Waiting for the migrations of another instance")
+		time.Sleep(migrationLockRetry)
+	}
+
+	held, stop := keepLease(ctx, collection, migrationLockID, owner, config.LockTTL)
+	return held, func() {
+		stop()
+		if err := releaseLease(ctx, collection, migrationLockID, owner); err != nil {
+			log.Error(consts.MigrationsTag, "Failed to release the lock:", err.Error())
+		}
+	}, nil
+}
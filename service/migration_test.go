@@ -0,0 +1,87 @@
+package service
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/migrations"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigrationVersions(t *testing.T) {
+	names, err := migrations.Names()
+	assert.Nil(t, err)
+	versions, err := migrationVersions()
+	assert.Nil(t, err)
+
+	// Every version has its up and down migration
+	assert.Len(t, names, 2*len(versions))
+	for i, version := range versions {
+		assert.Equal(t, uint(i+1), version)
+	}
+}
+
+func TestReadMigration(t *testing.T) {
+	defer func(collection string, audit string) {
+		conf.DocumentDB.Collection, conf.AuditCollection = collection, audit
+	}(conf.DocumentDB.Collection, conf.AuditCollection)
+	conf.DocumentDB.Collection, conf.AuditCollection = "documents", "documents-audit"
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{"001_create_document_collection.up.json", `"create": "documents"`},
+		{"002_create_indices_document_collection.down.json", `"dropIndexes": "documents"`},
+		{"007_create_audit_collection.up.json", `"create": "documents-audit"`},
+		{"007_create_audit_collection.up.json", `"createIndexes": "documents-audit"`},
+		{"010_create_usage_collection.up.json", `"aggregate": "documents"`},
+	}
+
+	for _, c := range cases {
+		b, err := readMigration(c.name)
+		assert.Nil(t, err)
+		assert.Contains(t, string(b), c.expected, c.name)
+		assert.False(t, strings.Contains(string(b), "{{"), c.name)
+	}
+
+	_, err := readMigration("099_missing.up.json")
+	assert.NotNil(t, err)
+}
+
+func TestLockMigrations(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.MigrationLockCollection)
+	config := conf.MigrationsConfig{LockTimeout: 0, LockTTL: 300 * time.Millisecond}
+
+	held, unlock, err := lockMigrations(context.TODO(), collection, "first", config)
+	assert.Nil(t, err)
+	if !assert.NotNil(t, unlock) {
+		return
+	}
+
+	// Held past its TTL while it is extended, until released
+	time.Sleep(2 * config.LockTTL)
+	_, _, err = lockMigrations(context.TODO(), collection, "second", config)
+	assert.Equal(t, consts.ErrMigrationLocked, err)
+	assert.Nil(t, held.Err())
+	unlock()
+	assert.NotNil(t, held.Err())
+
+	// The lock of a crashed instance is taken over once expired
+	_, err = collection.InsertOne(context.TODO(), bson.M{
+		"_id":      migrationLockID,
+		"owner":    "crashed",
+		"expireAt": time.Now().UTC().Add(-time.Minute),
+	})
+	assert.Nil(t, err)
+	_, unlock, err = lockMigrations(context.TODO(), collection, "third", config)
+	assert.Nil(t, err)
+	if assert.NotNil(t, unlock) {
+		unlock()
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"sort"
+)
+
+// fileOrderOf returns the order of the media type, made if missing.
+// Returns nil for an unknown media type.
+func fileOrderOf(orders *pbext.FileOrders, media pbdoc.FileType) *pbext.FileOrder {
+	var order **pbext.FileOrder
+	switch media {
+	case pbdoc.FileType_FILE:
+		order = &orders.File
+	case pbdoc.FileType_AUDIO:
+		order = &orders.Audio
+	case pbdoc.FileType_IMAGE:
+		order = &orders.Image
+	case pbdoc.FileType_VIDEO:
+		order = &orders.Video
+	default:
+		return nil
+	}
+	if *order == nil {
+		*order = &pbext.FileOrder{}
+	}
+	return *order
+}
+
+// normalizeFileOrders brings the file orders of the record in line with its url maps.
+func (r *documentRecord) normalizeFileOrders() {
+	if r.FileOrders == nil {
+		r.FileOrders = &pbext.FileOrders{}
+	}
+	for _, media := range []pbdoc.FileType{pbdoc.FileType_FILE, pbdoc.FileType_AUDIO,
+		pbdoc.FileType_IMAGE, pbdoc.FileType_VIDEO} {
+
+		order := fileOrderOf(r.FileOrders, media)
+		*order = *normalizeFileOrder(order, fileURLsMap(&r.Document, media), r.FileMetadataMap)
+	}
+}
+
+// normalizeFileOrder drops the files no longer in the url map from the order,
+// and appends the files missing from it, oldest first.
+// A primary no longer in the url map is replaced by the next file of the order,
+// or the first file if there is none after it.
+// Returns the new order, its primary is empty only if the url map is.
+func normalizeFileOrder(order *pbext.FileOrder, urls map[string]string,
+	files map[string]*pbext.FileMetadata) *pbext.FileOrder {
+
+	normalized := &pbext.FileOrder{Fuids: make([]string, 0, len(urls))}
+	listed := make(map[string]bool, len(urls))
+	for _, fuid := range order.GetFuids() {
+		if _, ok := urls[fuid]; ok && !listed[fuid] {
+			normalized.Fuids = append(normalized.Fuids, fuid)
+			listed[fuid] = true
+		}
+	}
+
+	missing := make([]string, 0)
+	for fuid := range urls {
+		if !listed[fuid] {
+			missing = append(missing, fuid)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		ti, tj := files[missing[i]].GetAddedTimestamp(), files[missing[j]].GetAddedTimestamp()
+		if ti != tj {
+			return ti < tj
+		}
+		return missing[i] < missing[j]
+	})
+	normalized.Fuids = append(normalized.Fuids, missing...)
+
+	if _, ok := urls[order.GetPrimary()]; ok {
+		normalized.Primary = order.GetPrimary()
+		return normalized
+	}
+	// Promote the file after the removed primary
+	after := false
+	for _, fuid := range order.GetFuids() {
+		if fuid == order.GetPrimary() {
+			after = true
+		} else if _, ok := urls[fuid]; ok && after {
+			normalized.Primary = fuid
+			return normalized
+		}
+	}
+	if len(normalized.Fuids) > 0 {
+		normalized.Primary = normalized.Fuids[0]
+	}
+	return normalized
+}
+
+// reorderFiles puts the given files first in the order of the media type, in the given order,
+// followed by the other files in their current order.
+// Returns consts.ErrFileNotFound if a file is not of the media type, or consts.ErrDuplicateFUID.
+func (r *documentRecord) reorderFiles(media pbdoc.FileType, fuids []string) error {
+	urls := fileURLsMap(&r.Document, media)
+	if urls == nil {
+		return consts.ErrMediaType
+	}
+	seen := make(map[string]bool, len(fuids))
+	for _, fuid := range fuids {
+		if _, ok := urls[fuid]; !ok {
+			return consts.ErrFileNotFound
+		}
+		if seen[fuid] {
+			return consts.ErrDuplicateFUID
+		}
+		seen[fuid] = true
+	}
+
+	r.normalizeFileOrders()
+	order := fileOrderOf(r.FileOrders, media)
+	reordered := append(make([]string, 0, len(order.Fuids)), fuids...)
+	for _, fuid := range order.GetFuids() {
+		if !seen[fuid] {
+			reordered = append(reordered, fuid)
+		}
+	}
+	order.Fuids = reordered
+	return nil
+}
+
+// setPrimaryFile makes the file the primary of its media type.
+// Returns consts.ErrFileNotFound if the Document has no such file.
+func (r *documentRecord) setPrimaryFile(fuid string) error {
+	media, ok := findFileMedia(&r.Document, fuid)
+	if !ok {
+		return consts.ErrFileNotFound
+	}
+	r.normalizeFileOrders()
+	fileOrderOf(r.FileOrders, media).Primary = fuid
+	return nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizeFileOrder(t *testing.T) {
+	urls := map[string]string{
+		"a": "https://hwscdevstorage.blob.core.windows.net/audios/a.wav",
+		"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav",
+		"c": "https://hwscdevstorage.blob.core.windows.net/audios/c.wav",
+		"d": "https://hwscdevstorage.blob.core.windows.net/audios/d.wav",
+	}
+	files := map[string]*pbext.FileMetadata{
+		"a": {AddedTimestamp: 1546300803},
+		"b": {AddedTimestamp: 1546300801},
+		"d": {AddedTimestamp: 1546300801},
+	}
+
+	cases := []struct {
+		order    *pbext.FileOrder
+		urls     map[string]string
+		expected *pbext.FileOrder
+	}{
+		// files never ordered follow their added time, the first is primary
+		{nil, urls, &pbext.FileOrder{Fuids: []string{"c", "b", "d", "a"}, Primary: "c"}},
+		{&pbext.FileOrder{Fuids: []string{"d", "a"}, Primary: "a"}, urls,
+			&pbext.FileOrder{Fuids: []string{"d", "a", "c", "b"}, Primary: "a"}},
+		// removed and duplicate files are dropped
+		{&pbext.FileOrder{Fuids: []string{"x", "a", "a", "b", "c", "d"}, Primary: "b"}, urls,
+			&pbext.FileOrder{Fuids: []string{"a", "b", "c", "d"}, Primary: "b"}},
+		// a removed primary hands over to the next file
+		{&pbext.FileOrder{Fuids: []string{"a", "x", "x", "c", "b", "d"}, Primary: "x"}, urls,
+			&pbext.FileOrder{Fuids: []string{"a", "c", "b", "d"}, Primary: "c"}},
+		// or to the first file if it was the last
+		{&pbext.FileOrder{Fuids: []string{"b", "a", "x"}, Primary: "x"}, urls,
+			&pbext.FileOrder{Fuids: []string{"b", "a", "c", "d"}, Primary: "b"}},
+		{&pbext.FileOrder{Fuids: []string{"a"}, Primary: "a"}, map[string]string{},
+			&pbext.FileOrder{Fuids: []string{}}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, normalizeFileOrder(c.order, c.urls, files), c.order.String())
+	}
+}
+
+func TestReorderFiles(t *testing.T) {
+	cases := []struct {
+		media    pbdoc.FileType
+		fuids    []string
+		expFuids []string
+		expErr   error
+	}{
+		{pbdoc.FileType_AUDIO, []string{"c", "a"}, []string{"c", "a", "b"}, nil},
+		{pbdoc.FileType_AUDIO, []string{"b", "c", "a"}, []string{"b", "c", "a"}, nil},
+		{pbdoc.FileType_AUDIO, nil, []string{"a", "b", "c"}, nil},
+		{pbdoc.FileType_AUDIO, []string{"c", "x"}, nil, consts.ErrFileNotFound},
+		{pbdoc.FileType_AUDIO, []string{"c", "c"}, nil, consts.ErrDuplicateFUID},
+		{pbdoc.FileType_IMAGE, []string{"c"}, nil, consts.ErrFileNotFound},
+		{4, []string{"c"}, nil, consts.ErrMediaType},
+	}
+
+	for _, c := range cases {
+		record := &documentRecord{
+			Document: pbdoc.Document{
+				AudioUrlsMap: map[string]string{
+					"a": "https://hwscdevstorage.blob.core.windows.net/audios/a.wav",
+					"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav",
+					"c": "https://hwscdevstorage.blob.core.windows.net/audios/c.wav",
+				},
+			},
+			FileOrders: &pbext.FileOrders{Audio: &pbext.FileOrder{Fuids: []string{"a", "b", "c"}, Primary: "b"}},
+		}
+
+		err := record.reorderFiles(c.media, c.fuids)
+		assert.Equal(t, c.expErr, err, c.fuids)
+		if err == nil {
+			assert.Equal(t, c.expFuids, record.FileOrders.GetAudio().GetFuids(), c.fuids)
+			assert.Equal(t, "b", record.FileOrders.GetAudio().GetPrimary(), c.fuids)
+		}
+	}
+}
+
+func TestSetPrimaryFile(t *testing.T) {
+	record := &documentRecord{
+		Document: pbdoc.Document{
+			ImageUrlsMap: map[string]string{
+				"a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg",
+				"b": "https://hwscdevstorage.blob.core.windows.net/images/b.jpg",
+			},
+			FileUrlsMap: map[string]string{"c": "https://hwscdevstorage.blob.core.windows.net/files/c.pdf"},
+		},
+	}
+
+	assert.Nil(t, record.setPrimaryFile("b"))
+	assert.Equal(t, "b", record.FileOrders.GetImage().GetPrimary())
+	assert.Equal(t, []string{"a", "b"}, record.FileOrders.GetImage().GetFuids())
+	assert.Equal(t, "c", record.FileOrders.GetFile().GetPrimary())
+	assert.Equal(t, consts.ErrFileNotFound, record.setPrimaryFile("x"))
+
+	// the primary is returned with the Document
+	delete(record.ImageUrlsMap, "b")
+	doc, err := record.toDocument()
+	assert.Nil(t, err)
+	ext, err := extractDocumentExtension(doc)
+	assert.Nil(t, err)
+	assert.Equal(t, "a", ext.GetFileOrders().GetImage().GetPrimary())
+	assert.Equal(t, []string{"a"}, ext.GetFileOrders().GetImage().GetFuids())
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// outboxLeaseName is the name of the lease of the dispatcher of the outbox
+const outboxLeaseName = "outbox"
+
+// outboxStore keeps the events of the outbox in MongoDB
+type outboxStore struct {
+	// Retention of the delivered events, 0 keeps them forever
+	retention time.Duration
+}
+
+// outboxLease is the lease of the dispatcher of the outbox in the lease collection, held by this instance
+type outboxLease struct {
+	ttl time.Duration
+}
+
+// StartOutboxDispatcher delivers the events of the outbox to the configured sinks in the background.
+// Does nothing if the outbox is disabled, the interval is 0, or no sink is configured.
+// Returns an error if a sink can not be opened.
+func StartOutboxDispatcher() error {
+	if !conf.Outbox.Enabled || conf.Outbox.Interval <= 0 {
+		log.Info(consts.OutboxTag, "Dispatcher disabled")
+		return nil
+	}
+	sinks, err := outboxSinks(conf.Outbox)
+	if err != nil {
+		return err
+	}
+	if conf.Subscriptions.Enabled {
+		sinks = append(sinks, subscriptionSink{})
+	}
+	if searchIndex != nil {
+		sinks = append(sinks, searchSink{client: searchIndex})
+	}
+	if len(sinks) == 0 {
+		log.Info(consts.OutboxTag, "Dispatcher disabled, no sink configured")
+		return nil
+	}
+
+	dispatcher := outbox.NewDispatcher(outboxStore{retention: conf.Outbox.Retention}, sinks, outbox.Config{
+		BatchSize:   conf.Outbox.BatchSize,
+		Concurrency: conf.Outbox.Concurrency,
+		MinBackoff:  conf.Outbox.MinBackoff,
+		MaxBackoff:  conf.Outbox.MaxBackoff,
+		Timeout:     conf.Outbox.Timeout,
+		MaxAttempts: conf.Outbox.MaxAttempts,
+		Lease:       outboxLease{ttl: conf.Outbox.LeaseTTL},
+	})
+	go dispatcher.Run(context.Background(), conf.Outbox.Interval)
+	log.Info(consts.OutboxTag, "Started, dispatching every", conf.Outbox.Interval.String())
+	return nil
+}
+
+// outboxSinks opens the sinks of the config.
+// Returns the sinks, or an error if one can not be opened.
+func outboxSinks(config conf.OutboxConfig) ([]outbox.Sink, error) {
+	var sinks []outbox.Sink
+	if config.WebhookURL != "" {
+		sinks = append(sinks, outbox.NewWebhookSink(config.WebhookURL, config.WebhookSecret))
+	}
+	if config.NATSURL != "" {
+		sink, err := outbox.ConnectNATSSink(config.NATSURL, config.NATSSubject)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if config.File != "" {
+		sink, err := outbox.OpenFileSink(config.File)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// withOutbox runs the write of a Document, then appends the events it returns to the outbox
+// in the same transaction, so an event is recorded if and only if its change is stored.
+// If the outbox is disabled, the write runs alone.
+// Returns the error of the write, or of the transaction.
+func withOutbox(ctx context.Context, write func(ctx context.Context) ([]*outbox.Event, error)) error {
+	if !conf.Outbox.Enabled {
+		_, err := write(ctx)
+		return err
+	}
+
+	return mongoDBWriter.UseSession(ctx, func(sc mongo.SessionContext) error {
+		if err := sc.StartTransaction(); err != nil {
+			return err
+		}
+		events, err := write(sc)
+		if err == nil {
+			err = appendEvents(sc, events)
+		}
+		if err != nil {
+			_ = sc.AbortTransaction(sc)
+			return err
+		}
+		return sc.CommitTransaction(sc)
+	})
+}
+
+// appendEvents writes the events to the outbox.
+// Returns an error if they are not written.
+func appendEvents(ctx context.Context, events []*outbox.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(events))
+	for i, event := range events {
+		event.RequestID = log.RequestID(ctx)
+		docs[i] = event
+	}
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	_, err := collection.InsertMany(ctx, docs)
+	return err
+}
+
+// documentEvents returns the event of the type for the Document as written, and the file with the fuid if any,
+// summarized for the watches.
+// Returns an error if the Document fails to encode.
+func documentEvents(eventType string, record *documentRecord, fuid string) ([]*outbox.Event, error) {
+	event, err := outbox.NewEvent(eventType, record.GetDuid(), fuid, record, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	event.Summary = summarizeForWatch(record)
+	return []*outbox.Event{event}, nil
+}
+
+// findAndWriteDocument runs the write of a Document returning it as stored, and appends its event of the type
+// to the outbox in the same transaction.
+// Returns the Document as stored, or an error if it is not written.
+func findAndWriteDocument(ctx context.Context, eventType string, fuid string,
+	write func(ctx context.Context) *mongo.SingleResult) (*documentRecord, error) {
+
+	record := &documentRecord{}
+	err := withOutbox(ctx, func(ctx context.Context) ([]*outbox.Event, error) {
+		if err := write(ctx).Decode(record); err != nil {
+			return nil, err
+		}
+		return documentEvents(eventType, record, fuid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Pending returns at most limit undelivered events due at now, in the order they were written, without the events
+// of the Documents held back by an event not due. Dead-lettered events are left out.
+func (s outboxStore) Pending(ctx context.Context, now time.Time, limit int) ([]*outbox.Event, error) {
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return nil, err
+	}
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+
+	// Only the failed first event of a Document waits for its next attempt, holding back the later ones
+	held, err := collection.Distinct(ctx, "duid", bson.M{
+		"deliveredAt":          nil,
+		"deadAt":               nil,
+		"nextAttemptTimestamp": bson.M{"$gt": now.Unix()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"deliveredAt": nil, "deadAt": nil}
+	if len(held) > 0 {
+		filter["duid"] = bson.M{"$nin": held}
+	}
+	option := options.Find().SetSort(bson.M{"_id": 1}).SetLimit(int64(limit))
+	cur, err := collection.Find(ctx, filter, option)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var events []*outbox.Event
+	for cur.Next(ctx) {
+		event := &outbox.Event{}
+		if err := cur.Decode(event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, cur.Err()
+}
+
+// Delivered records the delivery of the event, and its expiry after the retention if set.
+func (s outboxStore) Delivered(ctx context.Context, event *outbox.Event) error {
+	now := time.Now().UTC()
+	set := bson.M{"deliveredAt": now, "attempts": event.Attempts, "sinks": event.Sinks}
+	if s.retention > 0 {
+		set["expireAt"] = now.Add(s.retention)
+	}
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": set})
+	return err
+}
+
+// Failed records the failed attempt of the event, its next attempt, and the sinks it was delivered to.
+func (s outboxStore) Failed(ctx context.Context, event *outbox.Event) error {
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{
+		"attempts":             event.Attempts,
+		"nextAttemptTimestamp": event.NextAttemptTimestamp,
+		"sinks":                event.Sinks,
+		"lastError":            event.LastError,
+	}})
+	return err
+}
+
+// Dead records that the event exhausted its attempts, with its last error and the sinks it was delivered to.
+// Dead-lettered events are kept past the retention.
+func (s outboxStore) Dead(ctx context.Context, event *outbox.Event) error {
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": event.ID}, bson.M{"$set": bson.M{
+		"deadAt":    time.Now().UTC(),
+		"attempts":  event.Attempts,
+		"sinks":     event.Sinks,
+		"lastError": event.LastError,
+	}})
+	return err
+}
+
+// Acquire takes the lease of the dispatcher, or extends it if this instance holds it.
+// Returns false if another instance holds it, or an error if the lease can not be written.
+func (l outboxLease) Acquire(ctx context.Context) (bool, error) {
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return false, err
+	}
+	leases := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	return acquireLease(ctx, leases, outboxLeaseName, instanceID, l.ttl)
+}
+
+// Keep extends the lease of the dispatcher until stop is called.
+// Returns a context canceled once the lease is lost, and the function stopping the renewals.
+func (l outboxLease) Keep(ctx context.Context) (context.Context, func()) {
+	leases := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	return keepLease(ctx, leases, outboxLeaseName, instanceID, l.ttl)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"encoding/json"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDocumentEvents(t *testing.T) {
+	record := &documentRecord{
+		Document: pbdoc.Document{Duid: "duid", Uuid: ownerUUID, PublisherName: &pbdoc.Publisher{LastName: "Kim"}},
+		Shares:   []*pbext.DocumentShare{{Group: "lab-a"}},
+		FileMetadataMap: map[string]*pbext.FileMetadata{
+			"fuid": {ContentType: "image/jpeg"},
+		},
+		EmbargoUntilTimestamp: 1577836800,
+	}
+
+	events, err := documentEvents(outbox.FileAdded, record, "fuid")
+	assert.Nil(t, err)
+	if !assert.Len(t, events, 1) {
+		return
+	}
+	assert.Equal(t, outbox.FileAdded, events[0].Type)
+	assert.Equal(t, "duid", events[0].Duid)
+	assert.Equal(t, "fuid", events[0].Fuid)
+
+	// The Document is sent with its extension fields
+	var document map[string]interface{}
+	assert.Nil(t, json.Unmarshal(events[0].Document, &document))
+	assert.Equal(t, "duid", document["duid"])
+	assert.Equal(t, ownerUUID, document["uuid"])
+	assert.Equal(t, float64(1577836800), document["embargo_until_timestamp"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"group": "lab-a"}}, document["shares"])
+	assert.Contains(t, document["file_metadata_map"], "fuid")
+}
+
+func TestOutboxSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "events.jsonl")
+	cases := []struct {
+		config   conf.OutboxConfig
+		expected []string
+		isErr    bool
+	}{
+		{conf.OutboxConfig{}, nil, false},
+		{conf.OutboxConfig{WebhookURL: "https://hwsc.org/events", File: "-"}, []string{"webhook", "stdout"}, false},
+		{conf.OutboxConfig{File: file}, []string{"file"}, false},
+		{conf.OutboxConfig{File: filepath.Join(file, "missing", "events.jsonl")}, nil, true},
+		{conf.OutboxConfig{NATSURL: "nats://127.0.0.1:1"}, nil, true},
+	}
+
+	for _, c := range cases {
+		sinks, err := outboxSinks(c.config)
+		assert.Equal(t, c.isErr, err != nil)
+		var names []string
+		for _, sink := range sinks {
+			names = append(names, sink.Name())
+		}
+		assert.Equal(t, c.expected, names)
+	}
+}
+
+func TestOutboxStorePending(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	defer collection.DeleteMany(context.Background(), bson.M{"duid": bson.M{"$in": bson.A{"held", "dead", "due"}}})
+	now := time.Now().UTC()
+	store := outboxStore{}
+
+	var events []*outbox.Event
+	for _, duid := range []string{"held", "dead", "due", "held", "dead"} {
+		event, err := outbox.NewEvent(outbox.DocumentUpdated, duid, "", map[string]string{"duid": duid}, now)
+		assert.Nil(t, err)
+		events = append(events, event)
+	}
+	assert.Nil(t, appendEvents(context.Background(), events))
+
+	// The failed event of held waits for its next attempt, the dead event of dead no longer holds it back
+	events[0].Attempts, events[0].NextAttemptTimestamp = 1, now.Add(time.Minute).Unix()
+	assert.Nil(t, store.Failed(context.Background(), events[0]))
+	events[1].Attempts = 20
+	assert.Nil(t, store.Dead(context.Background(), events[1]))
+
+	pending, err := store.Pending(context.Background(), now, 10)
+	assert.Nil(t, err)
+	var ids []primitive.ObjectID
+	for _, event := range pending {
+		if event.Duid == "held" || event.Duid == "dead" || event.Duid == "due" {
+			ids = append(ids, event.ID)
+		}
+	}
+	assert.Equal(t, []primitive.ObjectID{events[2].ID, events[4].ID}, ids)
+
+	// Once due, the held Document is delivered in order again
+	pending, err = store.Pending(context.Background(), now.Add(time.Minute), 10)
+	assert.Nil(t, err)
+	ids = nil
+	for _, event := range pending {
+		if event.Duid == "held" {
+			ids = append(ids, event.ID)
+		}
+	}
+	assert.Equal(t, []primitive.ObjectID{events[0].ID, events[3].ID}, ids)
+}
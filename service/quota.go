@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+// storageUsage is what a user stores, counted in the usage collection under the uuid of the user
+type storageUsage struct {
+	Documents int `bson:"documents"`
+	Files     int `bson:"files"`
+}
+
+// countFiles returns the number of files in the url maps of the Document.
+func countFiles(doc *pbdoc.Document) int {
+	return len(doc.GetImageUrlsMap()) + len(doc.GetAudioUrlsMap()) +
+		len(doc.GetVideoUrlsMap()) + len(doc.GetFileUrlsMap())
+}
+
+// usageCollection returns the collection of the counters of what users store.
+func usageCollection() *mongo.Collection {
+	return mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.UsageCollection)
+}
+
+// readStorageUsage reads the counter of what the user stores, zero for a user without one.
+// Returns an error if the counter can not be read.
+func readStorageUsage(ctx context.Context, uuid string) (*storageUsage, error) {
+	usage := &storageUsage{}
+	err := usageCollection().FindOne(ctx, bson.M{"_id": uuid}).Decode(usage)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// usageFilter returns the filter of the counter of the user, only matching while the usage grown by the
+// Documents and files stays within the quota.
+func usageFilter(uuid string, documents int, files int, quota conf.QuotaConfig) bson.M {
+	filter := bson.M{"_id": uuid}
+	if quota.MaxDocuments > 0 && documents > 0 {
+		filter["documents"] = bson.M{"$lte": quota.MaxDocuments - documents}
+	}
+	if quota.MaxFiles > 0 && files > 0 {
+		filter["files"] = bson.M{"$lte": quota.MaxFiles - files}
+	}
+	return filter
+}
+
+// checkQuota checks that the usage grown by the Documents and files stays within the quota.
+// Returns consts.ErrDocumentQuotaExceeded or consts.ErrFileQuotaExceeded otherwise.
+func checkQuota(usage *storageUsage, documents int, files int, quota conf.QuotaConfig) error {
+	if quota.MaxDocuments > 0 && documents > 0 && usage.Documents+documents > quota.MaxDocuments {
+		return consts.ErrDocumentQuotaExceeded
+	}
+	if quota.MaxFiles > 0 && files > 0 && usage.Files+files > quota.MaxFiles {
+		return consts.ErrFileQuotaExceeded
+	}
+	return nil
+}
+
+// enforceQuota checks that the user may store the Documents and files on top of what it stores, to refuse a write
+// before the urls are verified. Only reserveUsage holds concurrent writes within the quota.
+// Returns an error if the quota would be exceeded, or the usage can not be read.
+func enforceQuota(ctx context.Context, uuid string, documents int, files int) error {
+	if conf.Quota.MaxDocuments <= 0 && conf.Quota.MaxFiles <= 0 {
+		return nil
+	}
+	usage, err := readStorageUsage(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	return checkQuota(usage, documents, files, conf.Quota)
+}
+
+// reserveUsage adds the Documents and files to the counter of the user, in an update only matching while the grown
+// usage stays within the quota, so concurrent writes can not exceed it together.
+// The reservation is released with releaseUsage if the write fails.
+// Returns an error if the quota would be exceeded, or the counter can not be written.
+func reserveUsage(ctx context.Context, uuid string, documents int, files int) error {
+	// A new counter may not start over the quota either
+	if err := checkQuota(&storageUsage{}, documents, files, conf.Quota); err != nil {
+		return err
+	}
+	collection := usageCollection()
+	update := bson.M{"$inc": bson.M{"documents": documents, "files": files}}
+	option := options.Update().SetUpsert(true)
+	for {
+		// A counter over the quota does not match, and upserting it conflicts with its uuid
+		_, err := collection.UpdateOne(ctx, usageFilter(uuid, documents, files, conf.Quota), update, option)
+		if !isDuplicateKey(err) {
+			return err
+		}
+		// Retry if the usage was released meanwhile, or the conflict was a concurrent first counter
+		usage, err := readStorageUsage(ctx, uuid)
+		if err != nil {
+			return err
+		}
+		if err := checkQuota(usage, documents, files, conf.Quota); err != nil {
+			return err
+		}
+	}
+}
+
+// releaseUsage removes the Documents and files from the counter of the user, logging a failure.
+// A counter left high refuses writes early, but never lets the quota be exceeded.
+func releaseUsage(ctx context.Context, uuid string, documents int, files int) {
+	if documents == 0 && files == 0 {
+		return
+	}
+	update := bson.M{"$inc": bson.M{"documents": -documents, "files": -files}}
+	if _, err := usageCollection().UpdateOne(ctx, bson.M{"_id": uuid}, update); err != nil {
+		log.ErrorContext(ctx, consts.QuotaTag, fmt.Sprintf("uuid: %s - documents: %d - files: %d - err: %s",
+			uuid, documents, files, err.Error()))
+	}
+}
+
+// quotaCode returns the status code of an error of enforceQuota or reserveUsage.
+func quotaCode(err error) codes.Code {
+	if err == consts.ErrDocumentQuotaExceeded || err == consts.ErrFileQuotaExceeded {
+		return codes.ResourceExhausted
+	}
+	return codes.Internal
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCountFiles(t *testing.T) {
+	doc := &pbdoc.Document{
+		ImageUrlsMap: map[string]string{"a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"},
+		AudioUrlsMap: map[string]string{
+			"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav",
+			"c": "https://hwscdevstorage.blob.core.windows.net/audios/c.wav",
+		},
+		FileUrlsMap: map[string]string{"d": "https://hwscdevstorage.blob.core.windows.net/files/d.pdf"},
+	}
+	assert.Equal(t, 4, countFiles(doc))
+	assert.Equal(t, 0, countFiles(&pbdoc.Document{}))
+}
+
+func TestCheckQuota(t *testing.T) {
+	usage := &storageUsage{Documents: 2, Files: 10}
+	cases := []struct {
+		quota     conf.QuotaConfig
+		documents int
+		files     int
+		expected  error
+	}{
+		{conf.QuotaConfig{}, 100, 100, nil},
+		{conf.QuotaConfig{MaxDocuments: 3, MaxFiles: 12}, 1, 2, nil},
+		{conf.QuotaConfig{MaxDocuments: 3, MaxFiles: 12}, 2, 0, consts.ErrDocumentQuotaExceeded},
+		{conf.QuotaConfig{MaxDocuments: 3, MaxFiles: 12}, 1, 3, consts.ErrFileQuotaExceeded},
+		// Users over their quota may still edit and remove
+		{conf.QuotaConfig{MaxDocuments: 1, MaxFiles: 5}, 0, 0, nil},
+		{conf.QuotaConfig{MaxDocuments: 1, MaxFiles: 5}, 0, -1, nil},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, checkQuota(usage, c.documents, c.files, c.quota), c)
+	}
+}
+
+func TestUsageFilter(t *testing.T) {
+	uuid := "0000XSNJG0MQJHBF4QX1EFD6Y9"
+	quota := conf.QuotaConfig{MaxDocuments: 3, MaxFiles: 12}
+	cases := []struct {
+		quota     conf.QuotaConfig
+		documents int
+		files     int
+		expected  bson.M
+	}{
+		{conf.QuotaConfig{}, 1, 2, bson.M{"_id": uuid}},
+		{quota, 1, 2, bson.M{"_id": uuid, "documents": bson.M{"$lte": 2}, "files": bson.M{"$lte": 10}}},
+		{quota, 0, 2, bson.M{"_id": uuid, "files": bson.M{"$lte": 10}}},
+		// Releases are never refused
+		{quota, -1, -2, bson.M{"_id": uuid}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, usageFilter(uuid, c.documents, c.files, c.quota), c)
+	}
+}
+
+func TestReserveUsage(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	defer func(quota conf.QuotaConfig) { conf.Quota = quota }(conf.Quota)
+	uuid := "0000XSNJG0MQJHBF4QX1EFD6Y8"
+
+	empty, err := readStorageUsage(context.Background(), uuid)
+	assert.Nil(t, err)
+	assert.Equal(t, &storageUsage{}, empty)
+
+	// Concurrent reservations never exceed the quota together
+	conf.Quota = conf.QuotaConfig{MaxDocuments: 3, MaxFiles: 6}
+	var wg sync.WaitGroup
+	var reserved int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := reserveUsage(context.Background(), uuid, 1, 2); err == nil {
+				atomic.AddInt32(&reserved, 1)
+			} else {
+				assert.Equal(t, consts.ErrDocumentQuotaExceeded, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), reserved)
+	usage, err := readStorageUsage(context.Background(), uuid)
+	assert.Nil(t, err)
+	assert.Equal(t, &storageUsage{Documents: 3, Files: 6}, usage)
+
+	// A release makes room again
+	releaseUsage(context.Background(), uuid, 1, 2)
+	assert.Nil(t, reserveUsage(context.Background(), uuid, 0, 2))
+	assert.Equal(t, consts.ErrFileQuotaExceeded, reserveUsage(context.Background(), uuid, 0, 1))
+	assert.Equal(t, consts.ErrFileQuotaExceeded, reserveUsage(context.Background(), "0000XSNJG0MQJHBF4QX1EFD6Y7", 0, 7))
+	releaseUsage(context.Background(), uuid, 2, 6)
+}
+
+func TestDocumentQuota(t *testing.T) {
+	serviceStateLocker.currentServiceState = available
+	s := Service{}
+	defer func(quota conf.QuotaConfig) { conf.Quota = quota }(conf.Quota)
+
+	usage, err := readStorageUsage(context.Background(), ownerUUID)
+	assert.Nil(t, err)
+	assert.True(t, usage.Documents > 0)
+	assert.True(t, usage.Files > 0)
+
+	conf.Quota = conf.QuotaConfig{MaxDocuments: usage.Documents}
+	_, err = s.CreateDocument(context.TODO(), &pbsvc.DocumentRequest{Data: &pbdoc.Document{Uuid: ownerUUID}})
+	assert.EqualError(t, err, status.Error(codes.ResourceExhausted, consts.ErrDocumentQuotaExceeded.Error()).Error())
+
+	conf.Quota = conf.QuotaConfig{MaxFiles: usage.Files}
+	_, err = s.AddFileMetadata(context.TODO(), &pbsvc.DocumentRequest{
+		FileMetadataParameters: &pbdoc.FileMetadataTransaction{
+			Duid:  "1ChHfqAsJRZOlTsg2majVXK7wxT",
+			Url:   "https://hwscdevstorage.blob.core.windows.net/images/Rotating_earth_(large).gif",
+			Media: pbdoc.FileType_IMAGE,
+		},
+	})
+	assert.EqualError(t, err, status.Error(codes.ResourceExhausted, consts.ErrFileQuotaExceeded.Error()).Error())
+
+	// Refused writes leave the usage unchanged
+	after, err := readStorageUsage(context.Background(), ownerUUID)
+	assert.Nil(t, err)
+	assert.Equal(t, usage, after)
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileRef is a file of a Document referencing a url
+type fileRef struct {
+	Duid string `bson:"duid"`
+	Fuid string `bson:"fuid"`
+}
+
+// registryEntry is a url of the file registry with the files referencing it
+type registryEntry struct {
+	URL             string    `bson:"url"`
+	Refs            []fileRef `bson:"refs"`
+	UpdateTimestamp int64     `bson:"updateTimestamp"`
+}
+
+// registryURL is a normalized url referenced by a file
+type registryURL struct {
+	fuid string
+	url  string
+}
+
+// normalizeURL reduces a url to the blob it addresses: lower case scheme and host, without default port,
+// query, or fragment. The path is kept as is, storages are case sensitive.
+// Returns the trimmed url if it can not be parsed.
+func normalizeURL(addr string) string {
+	addr = strings.TrimSpace(addr)
+	u, err := url.Parse(addr)
+	if err != nil || u.Host == "" {
+		return addr
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	return u.String()
+}
+
+// fileRefURLs lists the normalized urls of the files of the Document, sorted by fuid.
+func fileRefURLs(doc *pbdoc.Document) []registryURL {
+	refs := make([]registryURL, 0)
+	for _, urls := range []map[string]string{doc.GetImageUrlsMap(), doc.GetAudioUrlsMap(),
+		doc.GetVideoUrlsMap(), doc.GetFileUrlsMap()} {
+
+		for fuid, addr := range urls {
+			refs = append(refs, registryURL{fuid: fuid, url: normalizeURL(addr)})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].fuid < refs[j].fuid })
+	return refs
+}
+
+// releasedFileRefURLs lists the urls of before that after does not reference anymore,
+// either because the file was removed or because its url changed.
+func releasedFileRefURLs(before []registryURL, after []registryURL) []registryURL {
+	kept := make(map[registryURL]bool)
+	for _, ref := range after {
+		kept[ref] = true
+	}
+	released := make([]registryURL, 0)
+	for _, ref := range before {
+		if !kept[ref] {
+			released = append(released, ref)
+		}
+	}
+	return released
+}
+
+// addFileRefs records that the files of the Document reference their urls.
+// Recording references already known is harmless, so Documents stored before the registry are caught up.
+// Called before writing the Document, so a url in use is never reported unreferenced.
+// Returns the first error of the registry.
+func addFileRefs(ctx context.Context, duid string, refs []registryURL) error {
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.FileRegistryCollection)
+	now := time.Now().UTC().Unix()
+	upsert := options.Update().SetUpsert(true)
+	for _, ref := range refs {
+		filter := bson.M{"url": ref.url}
+		update := bson.M{
+			"$addToSet": bson.M{"refs": fileRef{Duid: duid, Fuid: ref.fuid}},
+			"$set":      bson.M{"updateTimestamp": now},
+		}
+		if _, err := collection.UpdateOne(ctx, filter, update, upsert); err != nil {
+			// A concurrent upsert of the same url collides on the unique index, the entry exists now
+			if _, err := collection.UpdateOne(ctx, filter, update, upsert); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeFileRefs records that the files of the Document do not reference their urls anymore.
+// Called after writing the Document, a failure leaves a stale reference which keeps the url from being deleted.
+// Errors are logged.
+func removeFileRefs(ctx context.Context, duid string, refs []registryURL) {
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.FileRegistryCollection)
+	now := time.Now().UTC().Unix()
+	for _, ref := range refs {
+		// Only the removal of a reference moves the released time of the url
+		filter := bson.M{"url": ref.url, "refs": bson.M{"$elemMatch": bson.M{"duid": duid, "fuid": ref.fuid}}}
+		update := bson.M{
+			"$pull": bson.M{"refs": bson.M{"duid": duid, "fuid": ref.fuid}},
+			"$set":  bson.M{"updateTimestamp": now},
+		}
+		if _, err := collection.UpdateOne(ctx, filter, update); err != nil {
+			log.ErrorContext(ctx, consts.FileRegistryTag, fmt.Sprintf("duid: %s - fuid: %s - err: %s", duid, ref.fuid, err.Error()))
+		}
+	}
+}
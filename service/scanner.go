@@ -0,0 +1,282 @@
+package service
+
+import (
+	"fmt"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Status of a file url, as of its last check
+	linkStatusOK     = "ok"
+	linkStatusBroken = "broken"
+
+	// linkScannerLease is the name of the lease of the scans
+	linkScannerLease = "link-scanner"
+)
+
+// linkCheck is the result of checking the url of a file
+type linkCheck struct {
+	// field is the url map of the file in the Document
+	field     string
+	fuid      string
+	url       string
+	err       error
+	timestamp int64
+}
+
+// documentLinks holds the file urls of a Document being scanned.
+// The worker checking the last of its urls records the results.
+type documentLinks struct {
+	duid      string
+	checks    []linkCheck
+	remaining int32
+}
+
+// linkJob is a file url to check
+type linkJob struct {
+	doc   *documentLinks
+	index int
+}
+
+// linkScanner periodically checks the file urls of every Document
+type linkScanner struct {
+	verifier    verifier.Verifier
+	interval    time.Duration
+	concurrency int
+	leaseTTL    time.Duration
+}
+
+// StartLinkScanner starts checking the file urls of every Document in the background.
+// Does nothing if the scan interval is 0.
+func StartLinkScanner() {
+	if conf.LinkScanner.Interval <= 0 {
+		log.Info(consts.LinkScannerTag, "Disabled")
+		return
+	}
+	concurrency := conf.LinkScanner.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	s := &linkScanner{
+		verifier:    verifier.NewRateLimited(urlVerifier, conf.LinkScanner.HostRate),
+		interval:    conf.LinkScanner.Interval,
+		concurrency: concurrency,
+		leaseTTL:    conf.LinkScanner.LeaseTTL,
+	}
+	go s.run()
+	log.Info(consts.LinkScannerTag, "Started, scanning every", s.interval.String())
+}
+
+// run scans the Documents, then waits for the interval, forever.
+func (s *linkScanner) run() {
+	for {
+		s.scanLeased(context.Background())
+		time.Sleep(s.interval)
+	}
+}
+
+// scanLeased scans the Documents under the lease of the scans, skipping the scan if another instance holds it.
+// The lease is extended while the scan runs, then kept for the interval so the other instances skip their
+// next scan, and the collection is scanned once per interval.
+func (s *linkScanner) scanLeased(ctx context.Context) {
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		log.Error(consts.LinkScannerTag, err.Error())
+		return
+	}
+	leases := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	held, err := acquireLease(ctx, leases, linkScannerLease, instanceID, s.leaseTTL)
+	if err != nil {
+		log.Error(consts.LinkScannerTag, "Failed to take the lease:", err.Error())
+		return
+	}
+	if !held {
+		log.Info(consts.LinkScannerTag, "Scanned by another instance")
+		return
+	}
+
+	scanCtx, stop := keepLease(ctx, leases, linkScannerLease, instanceID, s.leaseTTL)
+	start := time.Now()
+	checked, broken, err := s.scan(scanCtx)
+	stop()
+	if err != nil {
+		log.Error(consts.LinkScannerTag, err.Error())
+	} else {
+		log.Info(consts.LinkScannerTag, fmt.Sprintf("Checked %d urls in %s, %d broken",
+			checked, time.Since(start).String(), broken))
+	}
+
+	if _, err := acquireLease(ctx, leases, linkScannerLease, instanceID, s.interval); err != nil {
+		log.Error(consts.LinkScannerTag, "Failed to keep the lease:", err.Error())
+	}
+}
+
+// scan checks the file urls of every Document once, at most concurrency urls at a time.
+// Returns the number of urls checked and broken, or an error if the Documents could not be read.
+func (s *linkScanner) scan(ctx context.Context) (int, int, error) {
+	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
+		return 0, 0, err
+	}
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return 0, 0, err
+	}
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	projection := bson.M{"duid": 1, "imageUrlsMap": 1, "audioUrlsMap": 1, "videoUrlsMap": 1, "fileUrlsMap": 1}
+	cur, err := collection.Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			log.Error(consts.LinkScannerTag, err.Error())
+		}
+	}()
+
+	var checked, broken int32
+	jobs := make(chan linkJob)
+	var wg sync.WaitGroup
+	wg.Add(s.concurrency)
+	for i := 0; i < s.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				check := &job.doc.checks[job.index]
+				_, check.err = s.verifier.Verify(ctx, check.url)
+				check.timestamp = time.Now().UTC().Unix()
+
+				atomic.AddInt32(&checked, 1)
+				if check.err != nil {
+					atomic.AddInt32(&broken, 1)
+				}
+				if atomic.AddInt32(&job.doc.remaining, -1) == 0 {
+					if err := recordLinkChecks(ctx, job.doc); err != nil {
+						log.Error(consts.LinkScannerTag, fmt.Sprintf("duid: %s - err: %s", job.doc.duid, err.Error()))
+					}
+				}
+			}
+		}()
+	}
+
+	for cur.Next(ctx) {
+		doc := &pbdoc.Document{}
+		if err = cur.Decode(doc); err != nil {
+			break
+		}
+		links := newDocumentLinks(doc)
+		for i := range links.checks {
+			jobs <- linkJob{doc: links, index: i}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err == nil {
+		err = cur.Err()
+	}
+	return int(checked), int(broken), err
+}
+
+// newDocumentLinks lists the file urls of the Document to check.
+func newDocumentLinks(doc *pbdoc.Document) *documentLinks {
+	links := &documentLinks{duid: doc.GetDuid()}
+	for field, urls := range map[string]map[string]string{
+		"imageUrlsMap": doc.GetImageUrlsMap(),
+		"audioUrlsMap": doc.GetAudioUrlsMap(),
+		"videoUrlsMap": doc.GetVideoUrlsMap(),
+		"fileUrlsMap":  doc.GetFileUrlsMap(),
+	} {
+		for fuid, url := range urls {
+			links.checks = append(links.checks, linkCheck{field: field, fuid: fuid, url: url})
+		}
+	}
+	links.remaining = int32(len(links.checks))
+	return links
+}
+
+// recordLinkChecks stores the results of the checks in the file metadata of the Document.
+// Each result is only stored while the Document still has the checked url, so a file deleted or replaced while
+// its url was checked gets no metadata back.
+// Returns an error if the Document could not be updated.
+func recordLinkChecks(ctx context.Context, doc *documentLinks) error {
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(doc.duid)
+	// Unlock before the function exits
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+
+	// Documents written before the file metadata need a map to set the fields in
+	if _, err := collection.UpdateOne(ctx, bson.M{"duid": doc.duid, "fileMetadataMap": nil},
+		bson.M{"$set": bson.M{"fileMetadataMap": bson.M{}}}); err != nil {
+		return err
+	}
+	models := make([]mongo.WriteModel, len(doc.checks))
+	for i, c := range doc.checks {
+		models[i] = mongo.NewUpdateOneModel().
+			SetFilter(linkCheckFilter(doc.duid, c)).
+			SetUpdate(bson.M{"$set": linkCheckUpdate(c)})
+	}
+	_, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// linkCheckFilter returns the filter matching the Document while it still has the checked url.
+func linkCheckFilter(duid string, c linkCheck) bson.M {
+	return bson.M{"duid": duid, c.field + "." + c.fuid: c.url}
+}
+
+// linkCheckUpdate returns the file metadata fields to set for the result of the check.
+func linkCheckUpdate(c linkCheck) bson.M {
+	field := "fileMetadataMap." + c.fuid + "."
+	set := bson.M{
+		field + "linkStatus":           linkStatusOK,
+		field + "linkError":            "",
+		field + "lastCheckedTimestamp": c.timestamp,
+	}
+	if c.err != nil {
+		set[field+"linkStatus"] = linkStatusBroken
+		set[field+"linkError"] = c.err.Error()
+	}
+	return set
+}
+
+// brokenFiles lists the files of the Document whose url failed its last check, sorted by fuid.
+func brokenFiles(record *documentRecord) []*pbext.BrokenFile {
+	files := make([]*pbext.BrokenFile, 0)
+	for media, urls := range map[pbdoc.FileType]map[string]string{
+		pbdoc.FileType_IMAGE: record.GetImageUrlsMap(),
+		pbdoc.FileType_AUDIO: record.GetAudioUrlsMap(),
+		pbdoc.FileType_VIDEO: record.GetVideoUrlsMap(),
+		pbdoc.FileType_FILE:  record.GetFileUrlsMap(),
+	} {
+		for fuid, url := range urls {
+			meta := record.FileMetadataMap[fuid]
+			if meta.GetLinkStatus() != linkStatusBroken {
+				continue
+			}
+			files = append(files, &pbext.BrokenFile{
+				Fuid:                 fuid,
+				Url:                  url,
+				Media:                media,
+				LinkError:            meta.GetLinkError(),
+				LastCheckedTimestamp: meta.GetLastCheckedTimestamp(),
+			})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].GetFuid() < files[j].GetFuid() })
+	return files
+}
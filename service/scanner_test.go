@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestNewDocumentLinks(t *testing.T) {
+	doc := &pbdoc.Document{
+		Duid:         "1ZNbz6RrZL8bjZ8LCNnuQvdMFzh",
+		ImageUrlsMap: map[string]string{"a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"},
+		AudioUrlsMap: map[string]string{"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav"},
+		FileUrlsMap:  map[string]string{"c": "https://hwscdevstorage.blob.core.windows.net/files/c.pdf"},
+	}
+
+	links := newDocumentLinks(doc)
+	assert.Equal(t, doc.GetDuid(), links.duid)
+	assert.Equal(t, int32(3), links.remaining)
+
+	fields := make([]string, 0, len(links.checks))
+	for _, c := range links.checks {
+		fields = append(fields, c.field+"."+c.fuid)
+	}
+	sort.Strings(fields)
+	assert.Equal(t, []string{"audioUrlsMap.b", "fileUrlsMap.c", "imageUrlsMap.a"}, fields)
+
+	assert.Equal(t, int32(0), newDocumentLinks(&pbdoc.Document{}).remaining)
+}
+
+func TestLinkCheckFilter(t *testing.T) {
+	c := linkCheck{field: "imageUrlsMap", fuid: "a", url: "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"}
+	assert.Equal(t, bson.M{
+		"duid":           "1ZNbz6RrZL8bjZ8LCNnuQvdMFzh",
+		"imageUrlsMap.a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg",
+	}, linkCheckFilter("1ZNbz6RrZL8bjZ8LCNnuQvdMFzh", c))
+}
+
+func TestLinkCheckUpdate(t *testing.T) {
+	cases := []struct {
+		check    linkCheck
+		expected bson.M
+	}{
+		{linkCheck{fuid: "a", timestamp: 1546300800}, bson.M{
+			"fileMetadataMap.a.linkStatus":           linkStatusOK,
+			"fileMetadataMap.a.linkError":            "",
+			"fileMetadataMap.a.lastCheckedTimestamp": int64(1546300800),
+		}},
+		{linkCheck{fuid: "b", err: errors.New("not found"), timestamp: 1546300801}, bson.M{
+			"fileMetadataMap.b.linkStatus":           linkStatusBroken,
+			"fileMetadataMap.b.linkError":            "not found",
+			"fileMetadataMap.b.lastCheckedTimestamp": int64(1546300801),
+		}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, linkCheckUpdate(c.check))
+	}
+}
+
+func TestRecordLinkChecks(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	duid := "1ZNbz6RrZL8bjZ8LCNnuQvdMFzh"
+	_, err := collection.InsertOne(context.TODO(), bson.M{
+		"duid":         duid,
+		"imageUrlsMap": bson.M{"a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"},
+	})
+	assert.Nil(t, err)
+	defer func() {
+		_, err := collection.DeleteOne(context.TODO(), bson.M{"duid": duid})
+		assert.Nil(t, err)
+	}()
+
+	// The file b was deleted while its url was checked
+	doc := &documentLinks{duid: duid, checks: []linkCheck{
+		{field: "imageUrlsMap", fuid: "a", url: "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"},
+		{field: "imageUrlsMap", fuid: "b", url: "https://hwscdevstorage.blob.core.windows.net/images/b.jpg"},
+	}}
+	assert.Nil(t, recordLinkChecks(context.TODO(), doc))
+
+	record := &documentRecord{}
+	assert.Nil(t, collection.FindOne(context.TODO(), bson.M{"duid": duid}).Decode(record))
+	assert.Equal(t, linkStatusOK, record.FileMetadataMap["a"].GetLinkStatus())
+	assert.NotContains(t, record.FileMetadataMap, "b")
+}
+
+func TestScanLeased(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	leases := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	held, err := acquireLease(context.TODO(), leases, linkScannerLease, "other", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+	defer func() {
+		assert.Nil(t, releaseLease(context.TODO(), leases, linkScannerLease, "other"))
+	}()
+
+	// Skipped while another instance holds the lease, nothing is checked
+	fake := verifier.NewFake(nil)
+	s := &linkScanner{verifier: fake, interval: time.Hour, concurrency: 1, leaseTTL: time.Minute}
+	s.scanLeased(context.TODO())
+	assert.Empty(t, fake.Calls())
+}
+
+func TestBrokenFiles(t *testing.T) {
+	record := &documentRecord{
+		Document: pbdoc.Document{
+			ImageUrlsMap: map[string]string{
+				"d": "https://hwscdevstorage.blob.core.windows.net/images/d.jpg",
+				"e": "https://hwscdevstorage.blob.core.windows.net/images/e.jpg",
+			},
+			AudioUrlsMap: map[string]string{"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav"},
+			VideoUrlsMap: map[string]string{"c": "https://hwscdevstorage.blob.core.windows.net/videos/c.mp4"},
+			FileUrlsMap:  map[string]string{"a": "https://hwscdevstorage.blob.core.windows.net/files/a.pdf"},
+		},
+		FileMetadataMap: map[string]*pbext.FileMetadata{
+			"a": {LinkStatus: linkStatusBroken, LinkError: "not found", LastCheckedTimestamp: 1546300800},
+			"b": {LinkStatus: linkStatusOK, LastCheckedTimestamp: 1546300800},
+			"c": {LinkStatus: linkStatusBroken, LinkError: "timeout", LastCheckedTimestamp: 1546300801},
+			"e": {},
+			// metadata of a file removed from the maps
+			"f": {LinkStatus: linkStatusBroken},
+		},
+	}
+
+	files := brokenFiles(record)
+	assert.Equal(t, []*pbext.BrokenFile{
+		{
+			Fuid:                 "a",
+			Url:                  "https://hwscdevstorage.blob.core.windows.net/files/a.pdf",
+			Media:                pbdoc.FileType_FILE,
+			LinkError:            "not found",
+			LastCheckedTimestamp: 1546300800,
+		},
+		{
+			Fuid:                 "c",
+			Url:                  "https://hwscdevstorage.blob.core.windows.net/videos/c.mp4",
+			Media:                pbdoc.FileType_VIDEO,
+			LinkError:            "timeout",
+			LastCheckedTimestamp: 1546300801,
+		},
+	}, files)
+
+	assert.Empty(t, brokenFiles(&documentRecord{}))
+}
@@ -0,0 +1,494 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/search"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// searchMapping creates the search index: exact keywords for filters, full text for the description,
+// and a geo point for the location of the Document
+const searchMapping = `{
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "duid": {"type": "keyword"},
+      "uuid": {"type": "keyword"},
+      "publisher_last_name": {"type": "keyword"},
+      "publisher_first_name": {"type": "keyword"},
+      "call_type_name": {"type": "keyword"},
+      "ground_type": {"type": "keyword"},
+      "description": {"type": "text"},
+      "study_site_city": {"type": "keyword"},
+      "study_site_state": {"type": "keyword"},
+      "study_site_province": {"type": "keyword"},
+      "study_site_country": {"type": "keyword"},
+      "ocean": {"type": "keyword"},
+      "sensor_type": {"type": "keyword"},
+      "sensor_name": {"type": "keyword"},
+      "sampling_rate": {"type": "long"},
+      "location": {"type": "geo_point"},
+      "record_timestamp": {"type": "date", "format": "epoch_second"},
+      "create_timestamp": {"type": "date", "format": "epoch_second"},
+      "update_timestamp": {"type": "date", "format": "epoch_second"},
+      "is_public": {"type": "boolean"},
+      "embargo_until_timestamp": {"type": "date", "format": "epoch_second"},
+      "shares": {"type": "keyword"},
+      "files": {
+        "type": "nested",
+        "properties": {
+          "fuid": {"type": "keyword"},
+          "media": {"type": "keyword"},
+          "url": {"type": "keyword", "index": false},
+          "content_type": {"type": "keyword"},
+          "detected_type": {"type": "keyword"},
+          "size": {"type": "long"},
+          "duration": {"type": "double"},
+          "sample_rate": {"type": "long"},
+          "channels": {"type": "long"},
+          "bit_depth": {"type": "long"},
+          "caption": {"type": "text"},
+          "credit": {"type": "keyword"},
+          "capture_timestamp": {"type": "date", "format": "epoch_second"},
+          "location": {"type": "geo_point"}
+        }
+      },
+      "checksum": {"type": "keyword", "index": false}
+    }
+  }
+}`
+
+// searchLocation is a geo point of the search index
+type searchLocation struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// searchFile is a file of a Document in the search index
+type searchFile struct {
+	Fuid             string          `json:"fuid"`
+	Media            string          `json:"media"`
+	URL              string          `json:"url"`
+	ContentType      string          `json:"content_type,omitempty"`
+	DetectedType     string          `json:"detected_type,omitempty"`
+	Size             int64           `json:"size,omitempty"`
+	Duration         float64         `json:"duration,omitempty"`
+	SampleRate       uint32          `json:"sample_rate,omitempty"`
+	Channels         uint32          `json:"channels,omitempty"`
+	BitDepth         uint32          `json:"bit_depth,omitempty"`
+	Caption          string          `json:"caption,omitempty"`
+	Credit           string          `json:"credit,omitempty"`
+	CaptureTimestamp int64           `json:"capture_timestamp,omitempty"`
+	Location         *searchLocation `json:"location,omitempty"`
+}
+
+// searchEntry is a Document flattened for the search index.
+// The status of the file urls is left out, as the link scanner updates it without change events.
+type searchEntry struct {
+	Duid                  string         `json:"duid"`
+	Uuid                  string         `json:"uuid"`
+	PublisherLastName     string         `json:"publisher_last_name,omitempty"`
+	PublisherFirstName    string         `json:"publisher_first_name,omitempty"`
+	CallTypeName          string         `json:"call_type_name,omitempty"`
+	GroundType            string         `json:"ground_type,omitempty"`
+	Description           string         `json:"description,omitempty"`
+	StudySiteCity         string         `json:"study_site_city,omitempty"`
+	StudySiteState        string         `json:"study_site_state,omitempty"`
+	StudySiteProvince     string         `json:"study_site_province,omitempty"`
+	StudySiteCountry      string         `json:"study_site_country,omitempty"`
+	Ocean                 string         `json:"ocean,omitempty"`
+	SensorType            string         `json:"sensor_type,omitempty"`
+	SensorName            string         `json:"sensor_name,omitempty"`
+	SamplingRate          uint32         `json:"sampling_rate,omitempty"`
+	Location              searchLocation `json:"location"`
+	RecordTimestamp       int64          `json:"record_timestamp,omitempty"`
+	CreateTimestamp       int64          `json:"create_timestamp,omitempty"`
+	UpdateTimestamp       int64          `json:"update_timestamp,omitempty"`
+	IsPublic              bool           `json:"is_public"`
+	EmbargoUntilTimestamp int64          `json:"embargo_until_timestamp,omitempty"`
+	// Users as "uuid:<uuid>" and groups as "group:<name>" the Document is shared with
+	Shares []string     `json:"shares,omitempty"`
+	Files  []searchFile `json:"files,omitempty"`
+	// Hex SHA-256 of the entry without its checksum, compared to find the stale entries
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// newSearchEntry flattens the stored Document into its entry of the search index, files sorted by media and fuid.
+// Returns the entry with its checksum, or an error if it fails to encode.
+func newSearchEntry(record *documentRecord) (*searchEntry, error) {
+	entry := &searchEntry{
+		Duid:                  record.GetDuid(),
+		Uuid:                  record.GetUuid(),
+		PublisherLastName:     record.GetPublisherName().GetLastName(),
+		PublisherFirstName:    record.GetPublisherName().GetFirstName(),
+		CallTypeName:          record.GetCallTypeName(),
+		GroundType:            record.GetGroundType(),
+		Description:           record.GetDescription(),
+		StudySiteCity:         record.GetStudySite().GetCity(),
+		StudySiteState:        record.GetStudySite().GetState(),
+		StudySiteProvince:     record.GetStudySite().GetProvince(),
+		StudySiteCountry:      record.GetStudySite().GetCountry(),
+		Ocean:                 record.GetOcean(),
+		SensorType:            record.GetSensorType(),
+		SensorName:            record.GetSensorName(),
+		SamplingRate:          record.GetSamplingRate(),
+		Location:              searchLocation{Lat: float64(record.GetLatitude()), Lon: float64(record.GetLongitude())},
+		RecordTimestamp:       record.GetRecordTimestamp(),
+		CreateTimestamp:       record.GetCreateTimestamp(),
+		UpdateTimestamp:       record.GetUpdateTimestamp(),
+		IsPublic:              record.GetIsPublic(),
+		EmbargoUntilTimestamp: record.EmbargoUntilTimestamp,
+	}
+	for _, share := range record.Shares {
+		if share.GetUuid() != "" {
+			entry.Shares = append(entry.Shares, "uuid:"+share.GetUuid())
+		}
+		if share.GetGroup() != "" {
+			entry.Shares = append(entry.Shares, "group:"+share.GetGroup())
+		}
+	}
+	sort.Strings(entry.Shares)
+
+	media := []struct {
+		name string
+		urls map[string]string
+	}{
+		{"audio", record.GetAudioUrlsMap()},
+		{"file", record.GetFileUrlsMap()},
+		{"image", record.GetImageUrlsMap()},
+		{"video", record.GetVideoUrlsMap()},
+	}
+	for _, m := range media {
+		start := len(entry.Files)
+		for fuid, url := range m.urls {
+			entry.Files = append(entry.Files, newSearchFile(fuid, m.name, url, record.FileMetadataMap[fuid]))
+		}
+		files := entry.Files[start:]
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].Fuid < files[j].Fuid
+		})
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	entry.Checksum = hex.EncodeToString(sum[:])
+	return entry, nil
+}
+
+// newSearchFile returns the entry of the file with the fuid of the media, described by its metadata if any.
+func newSearchFile(fuid string, media string, url string, metadata *pbext.FileMetadata) searchFile {
+	file := searchFile{
+		Fuid:             fuid,
+		Media:            media,
+		URL:              url,
+		ContentType:      metadata.GetContentType(),
+		DetectedType:     metadata.GetDetectedType(),
+		Size:             metadata.GetSize(),
+		Duration:         metadata.GetDuration(),
+		SampleRate:       metadata.GetSampleRate(),
+		Channels:         metadata.GetChannels(),
+		BitDepth:         metadata.GetBitDepth(),
+		Caption:          metadata.GetCaption(),
+		Credit:           metadata.GetCredit(),
+		CaptureTimestamp: metadata.GetCaptureTimestamp(),
+	}
+	if location := metadata.GetLocation(); location != nil {
+		file.Location = &searchLocation{Lat: location.GetLatitude(), Lon: location.GetLongitude()}
+	}
+	return file
+}
+
+// newSearchClient builds the client of the search index from its configuration.
+// Returns nil if no url is configured.
+func newSearchClient(c conf.SearchConfig) *search.Client {
+	if c.URL == "" {
+		return nil
+	}
+	client := &http.Client{Transport: tracing.Transport(http.DefaultTransport), Timeout: c.Timeout}
+	return search.NewClient(c.URL, c.Index, c.Username, c.Password, client)
+}
+
+// StartSearchIndexer creates the search index unless it exists.
+// The index is then kept up to date by the outbox dispatcher.
+// Does nothing if the search index is disabled.
+// Returns an error if the configured batch size is not positive.
+func StartSearchIndexer() error {
+	if searchIndex == nil {
+		log.Info(consts.SearchTag, "Indexer disabled")
+		return nil
+	}
+	if conf.Search.BatchSize <= 0 {
+		return consts.ErrInvalidSearchBatchSize
+	}
+	if !conf.Outbox.Enabled {
+		log.Error(consts.SearchTag, "The outbox is disabled, the index is only updated by a reindex")
+	}
+	if err := searchIndex.EnsureIndex(context.Background(), []byte(searchMapping)); err != nil {
+		log.Error(consts.SearchTag, "Failed to create the index:", err.Error())
+		return nil
+	}
+	log.Info(consts.SearchTag, "Started, mirroring Documents into", searchIndex.Index())
+	return nil
+}
+
+// searchSink applies the change events of the outbox to the search index
+type searchSink struct {
+	client *search.Client
+}
+
+// Name returns the name of the sink.
+func (searchSink) Name() string {
+	return "search"
+}
+
+// Deliver indexes the Document of the event, or removes it if it was deleted, versioned by the event so an event
+// delivered again after a later one, or after a reindex, leaves the entry as is.
+// Returns an error if the Document can not be decoded or the index not written.
+func (s searchSink) Deliver(ctx context.Context, event *outbox.Event) error {
+	version := searchVersion(event.ID)
+	if event.Type == outbox.DocumentDeleted {
+		return s.client.Bulk(ctx, []search.Op{{ID: event.Duid, Version: version}})
+	}
+	record := &documentRecord{}
+	if err := json.Unmarshal(event.Document, record); err != nil {
+		return err
+	}
+	entry, err := newSearchEntry(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Bulk(ctx, []search.Op{{ID: entry.Duid, Entry: entry, Version: version}})
+}
+
+// searchVersion returns the version of the entry written by the event: the time and the counter of its id,
+// in the order the outbox delivers the events of a Document.
+func searchVersion(id primitive.ObjectID) int64 {
+	return int64(binary.BigEndian.Uint32(id[0:4]))<<24 | int64(id[9])<<16 | int64(id[10])<<8 | int64(id[11])
+}
+
+// searchVersionAt returns the version of the entries written from the Documents read from the time t:
+// the version of the last event written before t, and below those written from t on.
+// The entries are written with search.ExternalGTE, so reads of the same second write them again.
+func searchVersionAt(t time.Time) int64 {
+	return t.Unix()<<24 - 1
+}
+
+// searchBatchSize returns the batch size of the request, or the configured one if 0.
+func searchBatchSize(batchSize int32) int {
+	if batchSize > 0 {
+		return int(batchSize)
+	}
+	return conf.Search.BatchSize
+}
+
+// reindexDocuments writes every stored Document to the index, batchSize per bulk request,
+// then removes the entries of the Documents not stored. The entries are versioned by the start of the reindex,
+// so the changes made since are not overwritten.
+// Returns the number of entries written and removed, or an error if the Documents or the index can not be read
+// or written.
+func reindexDocuments(ctx context.Context, client *search.Client, collection *mongo.Collection,
+	batchSize int) (int64, int64, error) {
+
+	version := searchVersionAt(time.Now())
+	stored := make(map[string]bool)
+	var indexed int64
+	err := eachSearchEntry(ctx, collection, bson.M{}, batchSize, func(entries []*searchEntry) error {
+		ops := make([]search.Op, len(entries))
+		for i, entry := range entries {
+			stored[entry.Duid] = true
+			ops[i] = search.Op{ID: entry.Duid, Entry: entry, Version: version, VersionType: search.ExternalGTE}
+		}
+		if err := client.Bulk(ctx, ops); err != nil {
+			return err
+		}
+		indexed += int64(len(entries))
+		return nil
+	})
+	if err != nil {
+		return indexed, 0, err
+	}
+
+	var extra []string
+	err = client.Checksums(ctx, "duid", search.DefaultPageSize, func(id string, checksum string) error {
+		if !stored[id] {
+			extra = append(extra, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return indexed, 0, err
+	}
+	if err := removeSearchEntries(ctx, client, extra, batchSize, version); err != nil {
+		return indexed, 0, err
+	}
+	return indexed, int64(len(extra)), nil
+}
+
+// checkSearchIndex compares the checksums of the stored Documents with the entries of the index,
+// and writes the missing and stale entries and removes the extra ones if repair is set.
+// Documents written during the check may be reported, and are repaired by their change events.
+// The repairs are versioned by the start of the check, so the changes made since are not overwritten.
+// Returns the report, or an error if the Documents or the index can not be read or repaired.
+func checkSearchIndex(ctx context.Context, client *search.Client, collection *mongo.Collection, repair bool,
+	batchSize int) (*pbext.SearchIndexReport, error) {
+
+	version := searchVersionAt(time.Now())
+	stored := make(map[string]string)
+	err := eachSearchEntry(ctx, collection, bson.M{}, batchSize, func(entries []*searchEntry) error {
+		for _, entry := range entries {
+			stored[entry.Duid] = entry.Checksum
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	indexed := make(map[string]string)
+	err = client.Checksums(ctx, "duid", search.DefaultPageSize, func(id string, checksum string) error {
+		indexed[id] = checksum
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report := compareSearchIndex(stored, indexed)
+	if !repair {
+		return report, nil
+	}
+	outdated := append(append([]string{}, report.GetMissing()...), report.GetStale()...)
+	for start := 0; start < len(outdated); start += batchSize {
+		end := start + batchSize
+		if end > len(outdated) {
+			end = len(outdated)
+		}
+		filter := bson.M{"duid": bson.M{"$in": outdated[start:end]}}
+		err := eachSearchEntry(ctx, collection, filter, batchSize, func(entries []*searchEntry) error {
+			ops := make([]search.Op, len(entries))
+			for i, entry := range entries {
+				ops[i] = search.Op{ID: entry.Duid, Entry: entry, Version: version, VersionType: search.ExternalGTE}
+			}
+			return client.Bulk(ctx, ops)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := removeSearchEntries(ctx, client, report.GetExtra(), batchSize, version); err != nil {
+		return nil, err
+	}
+	report.Repaired = true
+	return report, nil
+}
+
+// compareSearchIndex compares the checksums of the stored Documents with those of the index, by duid.
+// Returns the report listing the duids missing from the index, stale in it, and indexed but not stored, sorted.
+func compareSearchIndex(stored map[string]string, indexed map[string]string) *pbext.SearchIndexReport {
+	report := &pbext.SearchIndexReport{
+		Documents: int64(len(stored)),
+		Missing:   make([]string, 0),
+		Stale:     make([]string, 0),
+		Extra:     make([]string, 0),
+	}
+	for duid, checksum := range stored {
+		indexedChecksum, ok := indexed[duid]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, duid)
+		case indexedChecksum != checksum:
+			report.Stale = append(report.Stale, duid)
+		}
+	}
+	for duid := range indexed {
+		if _, ok := stored[duid]; !ok {
+			report.Extra = append(report.Extra, duid)
+		}
+	}
+	sort.Strings(report.Missing)
+	sort.Strings(report.Stale)
+	sort.Strings(report.Extra)
+	return report
+}
+
+// eachSearchEntry passes the entries of the stored Documents matching the filter to fn, batchSize at a time.
+// Returns the error of fn, or an error if the Documents can not be read.
+func eachSearchEntry(ctx context.Context, collection *mongo.Collection, filter bson.M, batchSize int,
+	fn func(entries []*searchEntry) error) error {
+
+	cur, err := collection.Find(ctx, filter, options.Find().SetBatchSize(int32(batchSize)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			log.Error(consts.SearchTag, err.Error())
+		}
+	}()
+
+	entries := make([]*searchEntry, 0, batchSize)
+	for cur.Next(ctx) {
+		record := &documentRecord{}
+		if err := cur.Decode(record); err != nil {
+			return err
+		}
+		entry, err := newSearchEntry(record)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		if len(entries) == batchSize {
+			if err := fn(entries); err != nil {
+				return err
+			}
+			entries = make([]*searchEntry, 0, batchSize)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return fn(entries)
+}
+
+// removeSearchEntries removes the entries of the duids from the index with the version of searchVersionAt,
+// batchSize per bulk request.
+// Returns an error if the index can not be written.
+func removeSearchEntries(ctx context.Context, client *search.Client, duids []string, batchSize int,
+	version int64) error {
+
+	for start := 0; start < len(duids); start += batchSize {
+		end := start + batchSize
+		if end > len(duids) {
+			end = len(duids)
+		}
+		ops := make([]search.Op, 0, end-start)
+		for _, duid := range duids[start:end] {
+			ops = append(ops, search.Op{ID: duid, Version: version, VersionType: search.ExternalGTE})
+		}
+		if err := client.Bulk(ctx, ops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/search"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestSearchRecord() *documentRecord {
+	return &documentRecord{
+		Document: pbdoc.Document{
+			Duid:          "1ZNGnmdBnkrbuIgbcQu7yv7O9Sp",
+			Uuid:          ownerUUID,
+			PublisherName: &pbdoc.Publisher{LastName: "Kim", FirstName: "Lisa"},
+			CallTypeName:  "Wookie",
+			GroundType:    "Ice",
+			Description:   "Recorded at the ice edge",
+			StudySite:     &pbdoc.StudySite{City: "Seattle", State: "Washington", Country: "USA"},
+			Latitude:      47.5,
+			Longitude:     -122.25,
+			ImageUrlsMap:  map[string]string{"b": "https://lab.org/b.jpg", "a": "https://lab.org/a.jpg"},
+			AudioUrlsMap:  map[string]string{"c": "https://lab.org/c.wav"},
+			IsPublic:      true,
+		},
+		FileMetadataMap: map[string]*pbext.FileMetadata{
+			"c": {ContentType: "audio/wav", SampleRate: 48000, LinkStatus: "ok"},
+			"a": {Caption: "Ice edge", Location: &pbext.FileLocation{Latitude: 47.5, Longitude: -122.25}},
+		},
+		Shares: []*pbext.DocumentShare{{Group: "lab-a"}, {Uuid: otherUUID}},
+	}
+}
+
+func TestNewSearchEntry(t *testing.T) {
+	record := newTestSearchRecord()
+	entry, err := newSearchEntry(record)
+	assert.Nil(t, err)
+	assert.Equal(t, "Kim", entry.PublisherLastName)
+	assert.Equal(t, "Seattle", entry.StudySiteCity)
+	assert.Equal(t, searchLocation{Lat: 47.5, Lon: -122.25}, entry.Location)
+	assert.Equal(t, []string{"group:lab-a", "uuid:" + otherUUID}, entry.Shares)
+	if assert.Len(t, entry.Files, 3) {
+		assert.Equal(t, searchFile{Fuid: "c", Media: "audio", URL: "https://lab.org/c.wav",
+			ContentType: "audio/wav", SampleRate: 48000}, entry.Files[0])
+		assert.Equal(t, searchFile{Fuid: "a", Media: "image", URL: "https://lab.org/a.jpg", Caption: "Ice edge",
+			Location: &searchLocation{Lat: 47.5, Lon: -122.25}}, entry.Files[1])
+		assert.Equal(t, "b", entry.Files[2].Fuid)
+	}
+	assert.Len(t, entry.Checksum, 64)
+
+	// The checksum follows the indexed fields only
+	again, err := newSearchEntry(record)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.Checksum, again.Checksum)
+
+	record.FileMetadataMap["c"].LinkStatus = "broken"
+	again, err = newSearchEntry(record)
+	assert.Nil(t, err)
+	assert.Equal(t, entry.Checksum, again.Checksum)
+
+	record.Description = "Recorded under the ice"
+	again, err = newSearchEntry(record)
+	assert.Nil(t, err)
+	assert.NotEqual(t, entry.Checksum, again.Checksum)
+}
+
+func TestCompareSearchIndex(t *testing.T) {
+	cases := []struct {
+		stored   map[string]string
+		indexed  map[string]string
+		expected *pbext.SearchIndexReport
+	}{
+		{map[string]string{}, map[string]string{},
+			&pbext.SearchIndexReport{Missing: []string{}, Stale: []string{}, Extra: []string{}}},
+		{map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "1", "b": "2"},
+			&pbext.SearchIndexReport{Documents: 2, Missing: []string{}, Stale: []string{}, Extra: []string{}}},
+		{map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}, map[string]string{"b": "0", "d": "4", "e": "5"},
+			&pbext.SearchIndexReport{Documents: 4, Missing: []string{"a", "c"}, Stale: []string{"b"},
+				Extra: []string{"e"}}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, compareSearchIndex(c.stored, c.indexed))
+	}
+}
+
+func TestSearchSink(t *testing.T) {
+	fake := search.NewFake("documents")
+	defer fake.Close()
+	sink := searchSink{client: search.NewClient(fake.URL, "documents", "", "", http.DefaultClient)}
+	record := newTestSearchRecord()
+	now := time.Unix(1546300800, 0)
+
+	created, err := outbox.NewEvent(outbox.DocumentCreated, record.GetDuid(), "", record, now)
+	assert.Nil(t, err)
+	assert.Nil(t, sink.Deliver(context.TODO(), created))
+	entry, err := newSearchEntry(record)
+	assert.Nil(t, err)
+	expected, err := json.Marshal(entry)
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(expected), string(fake.Entries()[record.GetDuid()]))
+
+	// A failed write is retried by the outbox
+	fake.Fail(true, record.GetDuid())
+	deleted, err := outbox.NewEvent(outbox.DocumentDeleted, record.GetDuid(), "", record, now)
+	assert.Nil(t, err)
+	assert.IsType(t, &search.BulkError{}, sink.Deliver(context.TODO(), deleted))
+	fake.Fail(false, record.GetDuid())
+	assert.Nil(t, sink.Deliver(context.TODO(), deleted))
+	assert.Empty(t, fake.Entries())
+
+	// An event delivered again after a later one is skipped
+	assert.Nil(t, sink.Deliver(context.TODO(), created))
+	assert.Empty(t, fake.Entries())
+}
+
+func TestSearchVersion(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+	assert.True(t, searchVersion(first) < searchVersion(second))
+
+	// The last event of the previous second, and the first of the second
+	var before, at primitive.ObjectID
+	binary.BigEndian.PutUint32(before[0:4], uint32(now.Unix()-1))
+	before[9], before[10], before[11] = 0xff, 0xff, 0xff
+	binary.BigEndian.PutUint32(at[0:4], uint32(now.Unix()))
+	assert.Equal(t, searchVersion(before), searchVersionAt(now))
+	assert.True(t, searchVersionAt(now) < searchVersion(at))
+}
+
+func TestStartSearchIndexer(t *testing.T) {
+	defer func(index *search.Client, batchSize int) {
+		searchIndex, conf.Search.BatchSize = index, batchSize
+	}(searchIndex, conf.Search.BatchSize)
+
+	fake := search.NewFake("documents")
+	defer fake.Close()
+	searchIndex = search.NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+
+	conf.Search.BatchSize = 0
+	assert.Equal(t, consts.ErrInvalidSearchBatchSize, StartSearchIndexer())
+	assert.Nil(t, fake.Mapping())
+
+	conf.Search.BatchSize = 2
+	assert.Nil(t, StartSearchIndexer())
+	assert.NotNil(t, fake.Mapping())
+}
+
+func TestSearchIndex(t *testing.T) {
+	serviceStateLocker.currentServiceState = available
+	e := ExtensionService{}
+	adminCtx := auth.NewContext(context.TODO(), admin)
+	defer func(index *search.Client) {
+		searchIndex = index
+	}(searchIndex)
+
+	searchIndex = nil
+	_, err := e.ReindexDocuments(adminCtx, &pbext.ReindexDocumentsRequest{})
+	assert.EqualError(t, err, status.Error(codes.FailedPrecondition, consts.ErrSearchDisabled.Error()).Error())
+
+	fake := search.NewFake("documents")
+	defer fake.Close()
+	searchIndex = search.NewClient(fake.URL, "documents", "", "", http.DefaultClient)
+	assert.Nil(t, fake.Put("removed", map[string]string{"duid": "removed"}))
+
+	_, err = e.ReindexDocuments(auth.NewContext(context.TODO(), owner), &pbext.ReindexDocumentsRequest{})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrAdminRequired.Error()).Error())
+	_, err = e.ReindexDocuments(adminCtx, &pbext.ReindexDocumentsRequest{BatchSize: -1})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrInvalidBatchSize.Error()).Error())
+
+	reindexed, err := e.ReindexDocuments(adminCtx, &pbext.ReindexDocumentsRequest{BatchSize: 2})
+	assert.Nil(t, err)
+	assert.NotZero(t, reindexed.GetIndexed())
+	assert.Equal(t, int64(1), reindexed.GetDeleted())
+	assert.Len(t, fake.Entries(), int(reindexed.GetIndexed()))
+
+	report, err := e.CheckSearchIndex(adminCtx, &pbext.CheckSearchIndexRequest{})
+	assert.Nil(t, err)
+	assert.Equal(t, reindexed.GetIndexed(), report.GetDocuments())
+	assert.Empty(t, report.GetMissing())
+	assert.Empty(t, report.GetStale())
+	assert.Empty(t, report.GetExtra())
+
+	// Missing, stale and extra entries are repaired
+	for duid := range fake.Entries() {
+		assert.Nil(t, fake.Put(duid, map[string]string{"duid": duid, "checksum": "stale"}))
+		break
+	}
+	assert.Nil(t, fake.Put("extra", map[string]string{"duid": "extra"}))
+	report, err = e.CheckSearchIndex(adminCtx, &pbext.CheckSearchIndexRequest{Repair: true})
+	assert.Nil(t, err)
+	assert.Len(t, report.GetStale(), 1)
+	assert.Equal(t, []string{"extra"}, report.GetExtra())
+	assert.True(t, report.GetRepaired())
+
+	report, err = e.CheckSearchIndex(adminCtx, &pbext.CheckSearchIndexRequest{})
+	assert.Nil(t, err)
+	assert.Empty(t, report.GetStale())
+	assert.Empty(t, report.GetExtra())
+}
@@ -7,9 +7,16 @@ import (
 	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
 	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
-	log "github.com/hwsc-org/hwsc-lib/logger"
-	"github.com/kylelemons/godebug/pretty"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/metrics"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/search"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
@@ -62,12 +69,41 @@ var (
 
 	// Stores the lock for each duid
 	duidClientLocker sync.Map
+
+	// Verifies the file urls of a document
+	urlVerifier verifier.Verifier
+
+	// Signs the file urls of private documents, nil if signing is disabled
+	urlSigner *signer.Signer
+
+	// Mirrors the Documents, nil if the search index is disabled
+	searchIndex *search.Client
 )
 
 func init() {
 	serviceStateLocker = stateLocker{currentServiceState: available}
 	duidGenerator = duidLocker{}
 	fuidGenerator = fuidLocker{}
+	urlVerifier = newURLVerifier(conf.URLVerifier)
+	searchIndex = newSearchClient(conf.Search)
+
+	var err error
+	if urlSigner, err = newURLSigner(conf.URLSigner); err != nil {
+		log.Fatal(consts.DocumentServiceTag, "Failed to initialize URL signer:", err.Error())
+	}
+	if err := metrics.RegisterServiceState(currentServiceState); err != nil {
+		log.Fatal(consts.DocumentServiceTag, "Failed to expose the service state:", err.Error())
+	}
+}
+
+// currentServiceState returns the state of the service as a metric value.
+func currentServiceState() float64 {
+	// Lock the state for reading
+	serviceStateLocker.lock.RLock()
+	// Unlock the state before function exits
+	defer serviceStateLocker.lock.RUnlock()
+
+	return float64(serviceStateLocker.currentServiceState)
 }
 
 func (s state) String() string {
@@ -76,14 +112,14 @@ func (s state) String() string {
 
 // GetStatus gets the current status of the service.
 func (s *Service) GetStatus(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting GetStatus service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting GetStatus service")
 
 	// Lock the state for reading
 	serviceStateLocker.lock.RLock()
 	// Unlock the state before function exits
 	defer serviceStateLocker.lock.RUnlock()
 
-	log.Info(consts.DocumentServiceTag, "Service State:", serviceStateLocker.currentServiceState.String())
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Service State: "+serviceStateLocker.currentServiceState.String())
 	if serviceStateLocker.currentServiceState == unavailable {
 		return &pbsvc.DocumentResponse{
 			Status:  &pbsvc.DocumentResponse_Code{Code: uint32(codes.Unavailable)},
@@ -93,14 +129,14 @@ func (s *Service) GetStatus(ctx context.Context, req *pbsvc.DocumentRequest) (*p
 
 	// Check MongoDB Clients
 	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
-		log.Error(consts.GetStatusTag, err.Error())
+		log.ErrorContext(ctx, consts.GetStatusTag, err.Error())
 		return &pbsvc.DocumentResponse{
 			Status:  &pbsvc.DocumentResponse_Code{Code: uint32(codes.Unavailable)},
 			Message: codes.Unavailable.String(),
 		}, nil
 	}
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.GetStatusTag, err.Error())
+		log.ErrorContext(ctx, consts.GetStatusTag, err.Error())
 		return &pbsvc.DocumentResponse{
 			Status:  &pbsvc.DocumentResponse_Code{Code: uint32(codes.Unavailable)},
 			Message: codes.Unavailable.String(),
@@ -117,37 +153,58 @@ func (s *Service) GetStatus(ctx context.Context, req *pbsvc.DocumentRequest) (*p
 // CreateDocument creates a document in MongoDB.
 // Returns the Document.
 func (s *Service) CreateDocument(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting CreateDocument service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting CreateDocument service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Info(consts.CreateDocumentTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.InfoContext(ctx, consts.CreateDocumentTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.CreateDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.CreateDocumentTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.CreateDocumentTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	doc := req.GetData()
 	if doc == nil {
-		log.Error(consts.CreateDocumentTag, consts.ErrNilRequestData.Error())
+		log.ErrorContext(ctx, consts.CreateDocumentTag, consts.ErrNilRequestData.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequestData.Error())
 	}
 
+	if err := authorizeOwner(caller, doc.GetUuid()); err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	ext, err := extractDocumentExtension(doc)
+	if err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	files := ext.GetFileMetadataMap()
+	if files == nil {
+		files = make(map[string]*pbext.FileMetadata)
+	}
+
 	doc.Duid = duidGenerator.NewDUID()
+	tracing.Annotate(ctx, tracing.DUIDKey.String(doc.GetDuid()))
+	log.SetDUID(ctx, doc.GetDuid())
 
-	// Get the specific lock if it already exists, else make the lock
-	lock, _ := duidClientLocker.LoadOrStore(doc.GetDuid(), &sync.RWMutex{})
-	// Lock
-	lock.(*sync.RWMutex).Lock()
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(doc.GetDuid())
 	// Unlock before the function exits
-	defer lock.(*sync.RWMutex).Unlock()
+	defer lock.Unlock()
 
 	// Extract image URLS
 	if doc.GetImageUrlsMap() == nil {
@@ -191,26 +248,74 @@ func (s *Service) CreateDocument(ctx context.Context, req *pbsvc.DocumentRequest
 
 	doc.CreateTimestamp = time.Now().UTC().Unix()
 
-	if err := ValidateDocument(doc); err != nil {
-		log.Error(consts.CreateDocumentTag, err.Error())
+	// Only keep the declared metadata of files in the url maps, the rest is inspected from the storage
+	pruneFileMetadata(doc, files)
+	declared := files
+	files = make(map[string]*pbext.FileMetadata)
+	overlayFileMetadata(files, declared)
+
+	// Check the quota before the urls are verified
+	if err := enforceQuota(ctx, doc.GetUuid(), 1, countFiles(doc)); err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(quotaCode(err), err.Error())
+	}
+
+	if err := validateDocument(ctx, doc, files); err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := ValidateEmbargoTimestamp(ext.GetEmbargoUntilTimestamp()); err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	log.Error(consts.CreateDocumentTag, pretty.Sprint(doc))
-	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	log.DebugContext(ctx, consts.CreateDocumentTag, "Document to insert", log.Value("document", doc))
+
+	// Reserve the Document in the usage of its owner within the quota, released unless it is written
+	if err := reserveUsage(ctx, doc.GetUuid(), 1, countFiles(doc)); err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(quotaCode(err), err.Error())
+	}
 
-	res, err := collection.InsertOne(context.Background(), doc)
+	refs := fileRefURLs(doc)
+	if err := addFileRefs(ctx, doc.GetDuid(), refs); err != nil {
+		releaseUsage(ctx, doc.GetUuid(), 1, countFiles(doc))
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	record := newDocumentRecord(doc, files)
+	record.FileOrders = ext.GetFileOrders()
+	record.normalizeFileOrders()
+	record.EmbargoUntilTimestamp = ext.GetEmbargoUntilTimestamp()
+	var res *mongo.InsertOneResult
+	err = withOutbox(ctx, func(ctx context.Context) (events []*outbox.Event, err error) {
+		if res, err = collection.InsertOne(ctx, record); err != nil {
+			return nil, err
+		}
+		return documentEvents(outbox.DocumentCreated, record, "")
+	})
 	if err != nil {
-		log.Error(consts.CreateDocumentTag, err.Error())
+		removeFileRefs(ctx, doc.GetDuid(), refs)
+		releaseUsage(ctx, doc.GetUuid(), 1, countFiles(doc))
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	log.Info(consts.CreateDocumentTag, fmt.Sprintf("inserted document _id: %v", res.InsertedID))
+	auditAfter(ctx, record)
+	log.InfoContext(ctx, consts.CreateDocumentTag, fmt.Sprintf("inserted document _id: %v", res.InsertedID))
+
+	document, err := record.toResponseDocument()
+	if err != nil {
+		log.ErrorContext(ctx, consts.CreateDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
 	return &pbsvc.DocumentResponse{
 		Status:  &pbsvc.DocumentResponse_Code{Code: uint32(codes.OK)},
 		Message: codes.OK.String(),
-		Data:    doc,
+		Data:    document,
 	}, nil
 
 }
@@ -218,79 +323,97 @@ func (s *Service) CreateDocument(ctx context.Context, req *pbsvc.DocumentRequest
 // ListUserDocumentCollection retrieves all the MongoDB documents for a specific user with the given UUID.
 // Returns a collection of Documents.
 func (s *Service) ListUserDocumentCollection(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting ListUserDocumentCollection service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListUserDocumentCollection service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.ListUserDocumentCollectionTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
-		log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.ListUserDocumentCollectionTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	doc := req.GetData()
 	if doc == nil {
-		log.Error(consts.ListUserDocumentCollectionTag, consts.ErrNilRequestData.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, consts.ErrNilRequestData.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequestData.Error())
 	}
 
 	if err := ValidateUUID(doc.GetUuid()); err != nil {
-		log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	// Find all MongoDB documents for the specific uuid
+	now := time.Now().UTC().Unix()
 	filter := bson.M{"uuid": doc.GetUuid()}
-	cur, err := collection.Find(context.Background(), filter)
+	if authorizeOwner(caller, doc.GetUuid()) != nil {
+		for key, value := range visibilityFilter(caller, now) {
+			filter[key] = value
+		}
+	}
+	cur, err := collection.Find(ctx, filter)
 	if err != nil {
-		log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	// Extract the documents
 	documentCollection := make([]*pbdoc.Document, 0)
-	for cur.Next(context.Background()) {
+	for cur.Next(ctx) {
 		if err := cur.Err(); err != nil {
-			log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+			log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
 		// Mutate and retrieve Document
-		document := &pbdoc.Document{}
-		if err := cur.Decode(document); err != nil {
-			log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+		record := &documentRecord{}
+		if err := cur.Decode(record); err != nil {
+			log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		document, err := record.toDocument()
+		if err != nil {
+			log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
+		signDocumentURLs(document, record.isPublicAt(now), urlSigner, conf.URLSigner.TTL)
 		documentCollection = append(documentCollection, document)
-		log.Info(consts.ListUserDocumentCollectionTag, pretty.Sprint(document))
+		log.DebugContext(ctx, consts.ListUserDocumentCollectionTag, "Found document", log.Value("document", document))
 
 	}
 
 	if err := cur.Err(); err != nil {
-		log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := cur.Close(context.Background()); err != nil {
-		log.Error(consts.ListUserDocumentCollectionTag, err.Error())
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, err.Error())
 	}
 
 	if len(documentCollection) == 0 {
-		log.Error(consts.ListUserDocumentCollectionTag, doc.GetUuid())
+		log.ErrorContext(ctx, consts.ListUserDocumentCollectionTag, doc.GetUuid())
 		return nil, status.Errorf(codes.InvalidArgument, "No document for uuid: %s", doc.GetUuid())
 	}
 
-	log.Info(consts.ListUserDocumentCollectionTag, fmt.Sprintf("Success listing documents, uuid: %s", doc.GetUuid()))
+	log.InfoContext(ctx, consts.ListUserDocumentCollectionTag, fmt.Sprintf("Success listing documents, uuid: %s", doc.GetUuid()))
 
 	return &pbsvc.DocumentResponse{
 		Status:             &pbsvc.DocumentResponse_Code{Code: uint32(codes.OK)},
@@ -303,40 +426,77 @@ func (s *Service) ListUserDocumentCollection(ctx context.Context, req *pbsvc.Doc
 // UpdateDocument (completely) updates a MongoDB document with a given DUID, and UUID.
 // Returns the updated Document.
 func (s *Service) UpdateDocument(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting UpdateDocument service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting UpdateDocument service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.UpdateDocumentTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.UpdateDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.UpdateDocumentTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	doc := req.GetData()
 	if doc == nil {
-		log.Error(consts.UpdateDocumentTag, consts.ErrNilRequestData.Error())
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, consts.ErrNilRequestData.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequestData.Error())
 	}
 
 	if doc.GetDuid() == "" {
-		log.Error(consts.UpdateDocumentTag, consts.ErrMissingDUID.Error())
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, consts.ErrMissingDUID.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrMissingDUID.Error())
 	}
 
-	// Get the specific lock if it already exists, else make the lock
-	lock, _ := duidClientLocker.LoadOrStore(doc.GetDuid(), &sync.RWMutex{})
-	// Lock
-	lock.(*sync.RWMutex).Lock()
+	ext, err := extractDocumentExtension(doc)
+	if err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(doc.GetDuid())
 	// Unlock before the function exits
-	defer lock.(*sync.RWMutex).Unlock()
+	defer lock.Unlock()
+
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	filter := bson.M{"duid": doc.GetDuid()}
+
+	// Keep the stored file metadata, a missing document is reported by the replacement below
+	stored := &documentRecord{}
+	if err := collection.FindOne(ctx, filter).Decode(stored); err != nil && err != mongo.ErrNoDocuments {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if stored.GetDuid() != "" {
+		auditBefore(ctx, stored)
+		if err := authorizeWriter(caller, stored); err != nil {
+			log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		// Only an admin hands a Document over to another owner
+		if doc.GetUuid() != stored.GetUuid() && !isAdmin(caller) {
+			log.ErrorContext(ctx, consts.UpdateDocumentTag, consts.ErrNotDocumentOwner.Error())
+			return nil, status.Error(codes.PermissionDenied, consts.ErrNotDocumentOwner.Error())
+		}
+	}
+	files := stored.FileMetadataMap
+	if files == nil {
+		files = make(map[string]*pbext.FileMetadata)
+	}
+	overlayFileMetadata(files, ext.GetFileMetadataMap())
 
 	// Extract image URLS
 	if doc.GetImageUrlsMap() == nil {
@@ -380,41 +540,104 @@ func (s *Service) UpdateDocument(ctx context.Context, req *pbsvc.DocumentRequest
 
 	doc.UpdateTimestamp = time.Now().UTC().Unix()
 
-	if err := ValidateDocument(doc); err != nil {
-		log.Error(consts.UpdateDocumentTag, err.Error())
+	// The owner gains the added files, or the whole Document handed over from the stored owner, who loses it
+	reserved, released := storageUsage{}, storageUsage{}
+	if stored.GetDuid() != "" {
+		switch added := countFiles(doc) - countFiles(&stored.Document); {
+		case doc.GetUuid() != stored.GetUuid():
+			reserved = storageUsage{Documents: 1, Files: countFiles(doc)}
+			released = storageUsage{Documents: 1, Files: countFiles(&stored.Document)}
+		case added > 0:
+			reserved.Files = added
+		default:
+			released.Files = -added
+		}
+	}
+
+	// Check the quota of the owner before the urls are verified
+	if err := enforceQuota(ctx, doc.GetUuid(), reserved.Documents, reserved.Files); err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(quotaCode(err), err.Error())
+	}
+
+	pruneFileMetadata(doc, files)
+	if err := validateDocument(ctx, doc, files); err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := ValidateEmbargoTimestamp(ext.GetEmbargoUntilTimestamp()); err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	log.Info(consts.UpdateDocumentTag, pretty.Sprint(doc))
-	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
+	log.DebugContext(ctx, consts.UpdateDocumentTag, "Document to update", log.Value("document", doc))
+
+	// Reserve what the owner gains within the quota, released unless the Document is written
+	if err := reserveUsage(ctx, doc.GetUuid(), reserved.Documents, reserved.Files); err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(quotaCode(err), err.Error())
+	}
+
+	storedRefs := fileRefURLs(&stored.Document)
+	refs := fileRefURLs(doc)
+	if err := addFileRefs(ctx, doc.GetDuid(), refs); err != nil {
+		releaseUsage(ctx, doc.GetUuid(), reserved.Documents, reserved.Files)
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// Keep the stored file orders and embargo unless new ones are declared, and the stored shares
+	replacement := newDocumentRecord(doc, files)
+	replacement.Shares = stored.Shares
+	replacement.FileOrders = stored.FileOrders
+	if ext.GetFileOrders() != nil {
+		replacement.FileOrders = ext.GetFileOrders()
+	}
+	replacement.normalizeFileOrders()
+	replacement.EmbargoUntilTimestamp = stored.EmbargoUntilTimestamp
+	if ext.GetEmbargoUntilTimestamp() != 0 {
+		replacement.EmbargoUntilTimestamp = ext.GetEmbargoUntilTimestamp()
+	}
+	replacement.PublishedTimestamp = stored.PublishedTimestamp
+
+	// Replace the Document only while the caller may still edit it, keeping its owner unless the caller is an admin
+	if !isAdmin(caller) {
+		filter["uuid"] = doc.GetUuid()
+		for key, value := range writableFilter(caller) {
+			filter[key] = value
+		}
+	}
 
-	filter := bson.M{"duid": doc.GetDuid()}
 	// option to return the the document after update
 	after := options.After
 	option := &options.FindOneAndReplaceOptions{ReturnDocument: &after}
-	result := collection.FindOneAndReplace(context.Background(), filter, doc, option)
+	record, err := findAndWriteDocument(ctx, outbox.DocumentUpdated, "", func(ctx context.Context) *mongo.SingleResult {
+		return collection.FindOneAndReplace(ctx, filter, replacement, option)
+	})
 
 	// Extract the updated MongoDB document
-	if result == nil {
-		log.Info(consts.UpdateDocumentTag, fmt.Sprintf("Document not found, duid: %s - uuid: %s",
-			doc.GetDuid(), doc.GetUuid()))
-
-		return nil, status.Errorf(codes.InvalidArgument,
-			"Document not found, duid: %s - uuid: %s", doc.GetDuid(), doc.GetUuid())
-	}
-
-	document := &pbdoc.Document{}
-	if err := result.Decode(document); err != nil {
-		log.Error(consts.UpdateDocumentTag, fmt.Sprintf("Document not found, duid: %s - uuid: %s - err: %s",
+	if err != nil {
+		removeFileRefs(ctx, doc.GetDuid(), releasedFileRefURLs(refs, storedRefs))
+		releaseUsage(ctx, doc.GetUuid(), reserved.Documents, reserved.Files)
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, fmt.Sprintf("Document not found, duid: %s - uuid: %s - err: %s",
 			doc.GetDuid(), doc.GetUuid(), err.Error()))
 
 		return nil, status.Errorf(codes.InvalidArgument,
 			"Document not found, duid: %s - uuid: %s",
 			doc.GetDuid(), doc.GetUuid())
 	}
+	auditAfter(ctx, record)
+	document, err := record.toResponseDocument()
+	if err != nil {
+		log.ErrorContext(ctx, consts.UpdateDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 
-	log.Info(consts.UpdateDocumentTag, fmt.Sprintf("Updated document: \n%s\n", pretty.Sprint(document)))
-	log.Info(consts.UpdateDocumentTag, fmt.Sprintf("Success updating document, duid: %s - uuid: %s",
+	removeFileRefs(ctx, doc.GetDuid(), releasedFileRefURLs(storedRefs, refs))
+	releaseUsage(ctx, stored.GetUuid(), released.Documents, released.Files)
+
+	log.DebugContext(ctx, consts.UpdateDocumentTag, "Updated document", log.Value("document", document))
+	log.InfoContext(ctx, consts.UpdateDocumentTag, fmt.Sprintf("Success updating document, duid: %s - uuid: %s",
 		doc.GetDuid(), doc.GetUuid()))
 
 	return &pbsvc.DocumentResponse{
@@ -428,71 +651,96 @@ func (s *Service) UpdateDocument(ctx context.Context, req *pbsvc.DocumentRequest
 // DeleteDocument deletes a MongoDB document using DUID.
 // Returns the deleted Document.
 func (s *Service) DeleteDocument(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting DeleteDocument service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting DeleteDocument service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.DeleteDocumentTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.DeleteDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.DeleteDocumentTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	doc := req.GetData()
 	if doc == nil {
-		log.Error(consts.DeleteDocumentTag, consts.ErrNilRequestData.Error())
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, consts.ErrNilRequestData.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequestData.Error())
 	}
 
 	if doc.GetDuid() == "" {
-		log.Error(consts.DeleteDocumentTag, consts.ErrMissingDUID.Error())
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, consts.ErrMissingDUID.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrMissingDUID.Error())
 	}
 
 	if err := ValidateDUID(doc.GetDuid()); err != nil {
-		log.Error(consts.DeleteDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	// Get the specific lock if it already exists, else make the lock
-	lock, _ := duidClientLocker.LoadOrStore(doc.GetDuid(), &sync.RWMutex{})
-	// Lock
-	lock.(*sync.RWMutex).Lock()
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(doc.GetDuid())
 	// Unlock before the function exits
-	defer lock.(*sync.RWMutex).Unlock()
+	defer lock.Unlock()
 
 	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	filter := bson.M{"duid": doc.GetDuid()}
-	result := collection.FindOneAndDelete(context.Background(), filter)
 
-	// Extract the deleted MongoDB document
-	if result == nil {
-		log.Info(consts.DeleteDocumentTag, fmt.Sprintf("Document not found, duid: %s", doc.GetDuid()))
+	// A missing document is reported by the deletion below
+	stored, err := findDocumentRecord(ctx, collection, doc.GetDuid())
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err == nil {
+		auditBefore(ctx, stored)
+		if err := authorizeOwner(caller, stored.GetUuid()); err != nil {
+			log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
 
-		return nil, status.Errorf(codes.InvalidArgument,
-			"Document not found, duid: %s", doc.GetDuid())
+	// Delete the Document only while the caller still owns it, unless the caller is an admin
+	if !isAdmin(caller) {
+		filter["uuid"] = caller.UUID
 	}
+	record, err := findAndWriteDocument(ctx, outbox.DocumentDeleted, "", func(ctx context.Context) *mongo.SingleResult {
+		return collection.FindOneAndDelete(ctx, filter)
+	})
 
-	document := &pbdoc.Document{}
-	if err := result.Decode(document); err != nil {
-		log.Error(consts.DeleteDocumentTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+	// Extract the deleted MongoDB document
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
 			doc.GetDuid(), err.Error()))
 
 		return nil, status.Errorf(codes.InvalidArgument,
 			"Document not found, duid: %s", doc.GetDuid())
 	}
+	document, err := record.toResponseDocument()
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteDocumentTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	removeFileRefs(ctx, document.GetDuid(), fileRefURLs(document))
+	releaseUsage(ctx, document.GetUuid(), 1, countFiles(document))
 
-	log.Info(consts.DeleteDocumentTag, fmt.Sprintf("Deleted document: \n%s\n", pretty.Sprint(document)))
+	log.DebugContext(ctx, consts.DeleteDocumentTag, "Deleted document", log.Value("document", document))
 	// Log duid used for query
-	log.Info(consts.DeleteDocumentTag, fmt.Sprintf("Success deleting document, duid: %s", document.GetDuid()))
+	log.InfoContext(ctx, consts.DeleteDocumentTag, fmt.Sprintf("Success deleting document, duid: %s", document.GetDuid()))
 
 	return &pbsvc.DocumentResponse{
 		Status:  &pbsvc.DocumentResponse_Code{Code: uint32(codes.OK)},
@@ -504,100 +752,116 @@ func (s *Service) DeleteDocument(ctx context.Context, req *pbsvc.DocumentRequest
 // AddFileMetadata adds a new FileMetadata in a MongoDB document using a given url, and DUID.
 // Returns the updated Document.
 func (s *Service) AddFileMetadata(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting AddFileMetadata service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting AddFileMetadata service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.AddFileMetadataTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.AddFileMetadataTag, err.Error())
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.AddFileMetadataTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	fileMetadataParameters := req.GetFileMetadataParameters()
 	if fileMetadataParameters == nil || fileMetadataParameters.GetUrl() == "" ||
 		fileMetadataParameters.GetDuid() == "" {
 
-		log.Error(consts.AddFileMetadataTag, consts.ErrInvalidFileMetadataParameters.Error())
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, consts.ErrInvalidFileMetadataParameters.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidFileMetadataParameters.Error())
 	}
 
 	if err := ValidateDUID(fileMetadataParameters.GetDuid()); err != nil {
-		log.Error(consts.AddFileMetadataTag, err.Error())
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	switch fileMetadataParameters.Media {
-	case pbdoc.FileType_FILE:
-		break
-	case pbdoc.FileType_AUDIO:
-		if !audioRegex.MatchString(fileMetadataParameters.GetUrl()) {
-			log.Error(consts.AddFileMetadataTag, consts.ErrInvalidDocumentAudioURL.Error())
-			return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidDocumentAudioURL.Error())
-		}
-	case pbdoc.FileType_IMAGE:
-		if !imageRegex.MatchString(fileMetadataParameters.GetUrl()) {
-			log.Error(consts.AddFileMetadataTag, consts.ErrInvalidDocumentImageURL.Error())
-			return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidDocumentImageURL.Error())
-		}
-	case pbdoc.FileType_VIDEO:
-		if !videoRegex.MatchString(fileMetadataParameters.GetUrl()) {
-			log.Error(consts.AddFileMetadataTag, consts.ErrInvalidDocumentVideoURL.Error())
-			return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidDocumentVideoURL.Error())
-		}
-	default:
-		return nil, status.Error(codes.InvalidArgument, consts.ErrMediaType.Error())
+	// A link returned by the service is stored as the url it was signed from
+	fileMetadataParameters.Url = signer.Strip(fileMetadataParameters.GetUrl())
+	if err := validateMediaURL(fileMetadataParameters.Media, fileMetadataParameters.GetUrl()); err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ext, err := extractFileMetadataTransactionExtension(fileMetadataParameters)
+	if err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	// Test if the URI is reachable
-	if err := ValidateURL(fileMetadataParameters.GetUrl()); err != nil {
-		log.Error(consts.AddFileMetadataTag, err.Error())
+	inspected, err := inspectURL(ctx, fileMetadataParameters.GetUrl())
+	if err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	log.Info(consts.AddFileMetadataTag, fmt.Sprintf("FileMetadataParameters: \n%v\n",
-		pretty.Sprint(req.GetFileMetadataParameters())))
+	log.InfoContext(ctx, consts.AddFileMetadataTag, "File metadata to add",
+		log.Value("fileMetadataParameters", req.GetFileMetadataParameters()))
 
-	// Get the specific lock if it already exists, else make the lock
-	lock, _ := duidClientLocker.LoadOrStore(fileMetadataParameters.GetDuid(), &sync.RWMutex{})
-	// Lock
-	lock.(*sync.RWMutex).Lock()
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(fileMetadataParameters.GetDuid())
 	// Unlock before the function exits
-	defer lock.(*sync.RWMutex).Unlock()
+	defer lock.Unlock()
 
 	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	filter := bson.M{"duid": fileMetadataParameters.GetDuid()}
-	bsonResult := collection.FindOne(context.Background(), filter)
+	bsonResult := collection.FindOne(ctx, filter)
 	if bsonResult == nil {
-		log.Error(consts.AddFileMetadataTag, consts.ErrNoDocumentFound.Error())
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, consts.ErrNoDocumentFound.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNoDocumentFound.Error())
 	}
-	documentToUpdate := &pbdoc.Document{}
+	documentToUpdate := &documentRecord{}
 	if err := bsonResult.Decode(documentToUpdate); err != nil {
-		log.Error(consts.AddFileMetadataTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
 			fileMetadataParameters.GetDuid(), err.Error()))
 
 		return nil, status.Errorf(codes.InvalidArgument, "Document not found, duid: %s",
 			fileMetadataParameters.GetDuid())
 	}
 
-	log.Info(consts.AddFileMetadataTag, fmt.Sprintf("Document to update: \n%s\n", pretty.Sprint(documentToUpdate)))
+	auditBefore(ctx, documentToUpdate)
+	if err := authorizeWriter(caller, documentToUpdate); err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	log.DebugContext(ctx, consts.AddFileMetadataTag, "Document to update", log.Value("document", documentToUpdate))
 	newFuid := fuidGenerator.NewFUID()
+	if documentToUpdate.FileMetadataMap == nil {
+		documentToUpdate.FileMetadataMap = make(map[string]*pbext.FileMetadata)
+	}
+	overlayFileMetadata(documentToUpdate.FileMetadataMap,
+		map[string]*pbext.FileMetadata{newFuid: ext.GetMetadata()})
+	mergeFileMetadata(documentToUpdate.FileMetadataMap, newFuid, inspected)
 	switch fileMetadataParameters.Media {
 	case pbdoc.FileType_FILE:
 		documentToUpdate.GetFileUrlsMap()[newFuid] = fileMetadataParameters.GetUrl()
 	case pbdoc.FileType_AUDIO:
 		documentToUpdate.GetAudioUrlsMap()[newFuid] = fileMetadataParameters.GetUrl()
+		if err := applySamplingRate(&documentToUpdate.Document, documentToUpdate.FileMetadataMap,
+			conf.Media.RejectSamplingRateMismatch); err != nil {
+
+			log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 	case pbdoc.FileType_IMAGE:
 		documentToUpdate.GetImageUrlsMap()[newFuid] = fileMetadataParameters.GetUrl()
+		applyCaptureChecks(&documentToUpdate.Document, documentToUpdate.FileMetadataMap,
+			conf.Media.LocationTolerance, conf.Media.CaptureTimeTolerance)
 	case pbdoc.FileType_VIDEO:
 		documentToUpdate.GetVideoUrlsMap()[newFuid] = fileMetadataParameters.GetUrl()
 	default:
@@ -605,28 +869,43 @@ func (s *Service) AddFileMetadata(ctx context.Context, req *pbsvc.DocumentReques
 	}
 	documentToUpdate.UpdateTimestamp = time.Now().UTC().Unix()
 
+	// Reserve the file in the usage of the owner within the quota, released unless the Document is written
+	if err := reserveUsage(ctx, documentToUpdate.GetUuid(), 0, 1); err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(quotaCode(err), err.Error())
+	}
+
+	if err := addFileRefs(ctx, documentToUpdate.GetDuid(), fileRefURLs(&documentToUpdate.Document)); err != nil {
+		releaseUsage(ctx, documentToUpdate.GetUuid(), 0, 1)
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	newRef := []registryURL{{fuid: newFuid, url: normalizeURL(fileMetadataParameters.GetUrl())}}
+	documentToUpdate.normalizeFileOrders()
+
 	// option to return the the document after update
 	after := options.After
 	option := &options.FindOneAndReplaceOptions{ReturnDocument: &after}
-	result := collection.FindOneAndReplace(context.Background(), filter, documentToUpdate, option)
+	record, err := findAndWriteDocument(ctx, outbox.FileAdded, newFuid, func(ctx context.Context) *mongo.SingleResult {
+		return collection.FindOneAndReplace(ctx, filter, documentToUpdate, option)
+	})
 
 	// Extract the updated MongoDB document
-	if result == nil {
-		log.Error(consts.AddFileMetadataTag, fmt.Sprintf("Extracting updated document, duid: %s",
-			documentToUpdate.GetDuid()))
-
-		return nil, status.Errorf(codes.Internal,
-			"Extracting updated document duid: %s", documentToUpdate.GetDuid())
+	if err != nil {
+		removeFileRefs(ctx, documentToUpdate.GetDuid(), newRef)
+		releaseUsage(ctx, documentToUpdate.GetUuid(), 0, 1)
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-
-	document := &pbdoc.Document{}
-	if err := result.Decode(document); err != nil {
-		log.Error(consts.AddFileMetadataTag, err.Error())
-		return nil, status.Errorf(codes.Internal, err.Error())
+	auditAfter(ctx, record)
+	document, err := record.toResponseDocument()
+	if err != nil {
+		log.ErrorContext(ctx, consts.AddFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	log.Info(consts.AddFileMetadataTag, fmt.Sprintf("Updated document: \n%s\n", pretty.Sprint(document)))
-	log.Info(consts.AddFileMetadataTag, fmt.Sprintf("Success adding file metadata in document, duid: %s - fuid: %s",
+	log.DebugContext(ctx, consts.AddFileMetadataTag, "Updated document", log.Value("document", document))
+	log.InfoContext(ctx, consts.AddFileMetadataTag, fmt.Sprintf("Success adding file metadata in document, duid: %s - fuid: %s",
 		document.GetDuid(), newFuid))
 
 	return &pbsvc.DocumentResponse{
@@ -639,37 +918,43 @@ func (s *Service) AddFileMetadata(ctx context.Context, req *pbsvc.DocumentReques
 // DeleteFileMetadata deletes a FileMetadata in a MongoDB document using a given FUID, and DUID.
 // Returns the updated Document.
 func (s *Service) DeleteFileMetadata(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting DeleteFileMetadata service")
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting DeleteFileMetadata service")
 
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.DeleteFileMetadataTag, consts.ErrServiceUnavailable.Error())
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
-		log.Error(consts.DeleteFileMetadataTag, err.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.DeleteFileMetadataTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	fileMetadataParameters := req.GetFileMetadataParameters()
 	if fileMetadataParameters == nil || fileMetadataParameters.GetDuid() == "" {
 
-		log.Error(consts.DeleteFileMetadataTag, consts.ErrInvalidFileMetadataParameters.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, consts.ErrInvalidFileMetadataParameters.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrInvalidFileMetadataParameters.Error())
 	}
 
 	if err := ValidateDUID(fileMetadataParameters.GetDuid()); err != nil {
-		log.Error(consts.DeleteFileMetadataTag, err.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	if err := ValidateFUID(fileMetadataParameters.GetFuid()); err != nil {
-		log.Error(consts.DeleteFileMetadataTag, err.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
@@ -677,35 +962,41 @@ func (s *Service) DeleteFileMetadata(ctx context.Context, req *pbsvc.DocumentReq
 		return nil, status.Error(codes.InvalidArgument, consts.ErrMediaType.Error())
 	}
 
-	log.Info(consts.DeleteFileMetadataTag, fmt.Sprintf("FileMetadataParameters: \n%v\n",
-		pretty.Sprint(req.GetFileMetadataParameters())))
+	log.InfoContext(ctx, consts.DeleteFileMetadataTag, "File metadata to delete",
+		log.Value("fileMetadataParameters", req.GetFileMetadataParameters()))
 
-	// Get the specific lock if it already exists, else make the lock
-	lock, _ := duidClientLocker.LoadOrStore(fileMetadataParameters.GetDuid(), &sync.RWMutex{})
-	// Lock
-	lock.(*sync.RWMutex).Lock()
+	// Lock the duid, timing the wait for its lock
+	lock := lockDUID(fileMetadataParameters.GetDuid())
 	// Unlock before the function exits
-	defer lock.(*sync.RWMutex).Unlock()
+	defer lock.Unlock()
 
 	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	filter := bson.M{"duid": fileMetadataParameters.GetDuid()}
-	bsonResult := collection.FindOne(context.Background(), filter)
+	bsonResult := collection.FindOne(ctx, filter)
 	if bsonResult == nil {
-		log.Error(consts.DeleteFileMetadataTag, consts.ErrNoDocumentFound.Error())
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, consts.ErrNoDocumentFound.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNoDocumentFound.Error())
 	}
-	documentToUpdate := &pbdoc.Document{}
+	documentToUpdate := &documentRecord{}
 	if err := bsonResult.Decode(documentToUpdate); err != nil {
-		log.Error(consts.DeleteFileMetadataTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, fmt.Sprintf("Document not found, duid: %s - err: %s",
 			fileMetadataParameters.GetDuid(), err.Error()))
 
 		return nil, status.Errorf(codes.InvalidArgument,
 			"Document not found, duid: %s", fileMetadataParameters.GetDuid())
 	}
 
-	log.Info(consts.DeleteFileMetadataTag, fmt.Sprintf("Document to update: \n%s\n", pretty.Sprint(documentToUpdate)))
+	auditBefore(ctx, documentToUpdate)
+	if err := authorizeWriter(caller, documentToUpdate); err != nil {
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	log.DebugContext(ctx, consts.DeleteFileMetadataTag, "Document to update", log.Value("document", documentToUpdate))
 
+	storedRefs := fileRefURLs(&documentToUpdate.Document)
+	storedFiles := countFiles(&documentToUpdate.Document)
 	switch fileMetadataParameters.Media {
 	case pbdoc.FileType_FILE:
 		delete(documentToUpdate.GetFileUrlsMap(), fileMetadataParameters.GetFuid())
@@ -718,30 +1009,36 @@ func (s *Service) DeleteFileMetadata(ctx context.Context, req *pbsvc.DocumentReq
 	default:
 		return nil, status.Error(codes.InvalidArgument, consts.ErrMediaType.Error())
 	}
+	pruneFileMetadata(&documentToUpdate.Document, documentToUpdate.FileMetadataMap)
+	// A deleted primary hands over to the next file
+	documentToUpdate.normalizeFileOrders()
 	documentToUpdate.UpdateTimestamp = time.Now().UTC().Unix()
 
 	// option to return the the document after update
 	after := options.After
 	option := &options.FindOneAndReplaceOptions{ReturnDocument: &after}
-	result := collection.FindOneAndReplace(context.Background(), filter, documentToUpdate, option)
+	record, err := findAndWriteDocument(ctx, outbox.FileDeleted, fileMetadataParameters.GetFuid(),
+		func(ctx context.Context) *mongo.SingleResult {
+			return collection.FindOneAndReplace(ctx, filter, documentToUpdate, option)
+		})
 
 	// Extract the updated MongoDB document
-	if result == nil {
-		log.Error(consts.DeleteFileMetadataTag, fmt.Sprintf("Extracting updated document, duid: %s",
-			documentToUpdate.GetDuid()))
-
-		return nil, status.Errorf(codes.Internal,
-			"Extracting updated document duid: %s", documentToUpdate.GetDuid())
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-
-	document := &pbdoc.Document{}
-	if err := result.Decode(document); err != nil {
-		log.Error(consts.DeleteFileMetadataTag, err.Error())
-		return nil, status.Errorf(codes.Internal, err.Error())
+	auditAfter(ctx, record)
+	document, err := record.toResponseDocument()
+	if err != nil {
+		log.ErrorContext(ctx, consts.DeleteFileMetadataTag, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	log.Info(consts.DeleteFileMetadataTag, fmt.Sprintf("Updated document: \n%s\n", pretty.Sprint(document)))
-	log.Info(consts.DeleteFileMetadataTag, fmt.Sprintf("Success deleting file metadata in document, duid: %s - fuid: %s",
+	removeFileRefs(ctx, document.GetDuid(), releasedFileRefURLs(storedRefs, fileRefURLs(document)))
+	releaseUsage(ctx, document.GetUuid(), 0, storedFiles-countFiles(document))
+
+	log.DebugContext(ctx, consts.DeleteFileMetadataTag, "Updated document", log.Value("document", document))
+	log.InfoContext(ctx, consts.DeleteFileMetadataTag, fmt.Sprintf("Success deleting file metadata in document, duid: %s - fuid: %s",
 		document.GetDuid(), fileMetadataParameters.GetFuid()))
 
 	return &pbsvc.DocumentResponse{
@@ -755,31 +1052,40 @@ func (s *Service) DeleteFileMetadata(ctx context.Context, req *pbsvc.DocumentReq
 // ListDistinctFieldValues list all the unique fields values required for the front-end drop-down filter
 // Returns the QueryTransaction.
 func (s *Service) ListDistinctFieldValues(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting ListDistinctFieldValues service")
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.ListDistinctFieldValuesTag, consts.ErrServiceUnavailable.Error())
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting ListDistinctFieldValues service")
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
-		log.Error(consts.ListDistinctFieldValuesTag, err.Error())
+		log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.ListDistinctFieldValuesTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	// Get distinct using field names in distinctSearchFieldNames
 	distinctResult := make([][]interface{}, len(distinctSearchFieldNames))
+	var filter interface{} = &bson.D{}
+	if visible := visibilityFilter(caller, time.Now().UTC().Unix()); visible != nil {
+		filter = visible
+	}
 	for i := 0; i < len(distinctSearchFieldNames); i++ {
-		doc := &bson.D{}
-		result, err := collection.Distinct(context.Background(), distinctSearchFieldNames[i], doc)
+		result, err := collection.Distinct(ctx, distinctSearchFieldNames[i], filter)
 		if err != nil {
-			log.Error(consts.ListDistinctFieldValuesTag, err.Error())
+			log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 		distinctResult[i] = result
@@ -793,13 +1099,13 @@ func (s *Service) ListDistinctFieldValues(ctx context.Context, req *pbsvc.Docume
 		if err := extractDistinctResults(queryResult,
 			fieldName, distinctResult[distinctResultFieldIndices[fieldName]]); err != nil {
 
-			log.Error(consts.ListDistinctFieldValuesTag, err.Error())
+			log.ErrorContext(ctx, consts.ListDistinctFieldValuesTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 	}
 
-	log.Info(consts.ListDistinctFieldValuesTag, fmt.Sprintf("Distinct values: \n%v\n", pretty.Sprint(queryResult)))
-	log.Info(consts.ListDistinctFieldValuesTag, "Success listing distinct field values")
+	log.DebugContext(ctx, consts.ListDistinctFieldValuesTag, "Distinct values", log.Value("values", queryResult))
+	log.InfoContext(ctx, consts.ListDistinctFieldValuesTag, "Success listing distinct field values")
 	return &pbsvc.DocumentResponse{
 		Status:       &pbsvc.DocumentResponse_Code{Code: uint32(codes.OK)},
 		Message:      codes.OK.String(),
@@ -811,82 +1117,98 @@ func (s *Service) ListDistinctFieldValues(ctx context.Context, req *pbsvc.Docume
 // QueryDocument queries the MongoDB server with the given query parameters.
 // Returns a collection of Documents.
 func (s *Service) QueryDocument(ctx context.Context, req *pbsvc.DocumentRequest) (*pbsvc.DocumentResponse, error) {
-	log.Info(consts.DocumentServiceTag, "Requesting QueryDocument service")
-	if ok := isStateAvailable(); !ok {
-		log.Error(consts.QueryDocumentTag, consts.ErrServiceUnavailable.Error())
+	log.InfoContext(ctx, consts.DocumentServiceTag, "Requesting QueryDocument service")
+	if ok := isStateAvailable(ctx); !ok {
+		log.ErrorContext(ctx, consts.QueryDocumentTag, consts.ErrServiceUnavailable.Error())
 		return nil, status.Error(codes.Unavailable, consts.ErrServiceUnavailable.Error())
 	}
 
 	if err := refreshMongoDBConnection(mongoDBReader, &conf.DocumentDB.Reader); err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	if req == nil {
-		log.Error(consts.QueryDocumentTag, consts.ErrNilRequest.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, consts.ErrNilRequest.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilRequest.Error())
 	}
 
+	caller, err := callerOf(ctx)
+	if err != nil {
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
 	queryParams := req.GetQueryParameters()
 	if queryParams == nil {
-		log.Error(consts.QueryDocumentTag, consts.ErrNilQueryArgs.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, consts.ErrNilQueryArgs.Error())
 		return nil, status.Error(codes.InvalidArgument, consts.ErrNilQueryArgs.Error())
 	}
 
 	if err := ValidateRecordTimestamp(queryParams.MinRecordTimestamp); err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	if err := ValidateRecordTimestamp(queryParams.MaxRecordTimestamp); err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	log.Info(consts.QueryDocumentTag, fmt.Sprintf("QueryParameters contains:\n %s", pretty.Sprint(queryParams)))
+	log.InfoContext(ctx, consts.QueryDocumentTag, "Query parameters", log.Value("queryParameters", queryParams))
 	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.DocumentDB.Collection)
 
 	pipeline, err := buildAggregatePipeline(queryParams)
 	if err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
-	cur, err := collection.Aggregate(context.Background(), pipeline)
+	now := time.Now().UTC().Unix()
+	if visible := visibilityFilter(caller, now); visible != nil {
+		pipeline = append(pipeline, bson.M{"$match": visible})
+	}
+	cur, err := collection.Aggregate(ctx, pipeline)
 	if err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	// Extract the documents
 	documentCollection := make([]*pbdoc.Document, 0)
-	for cur.Next(context.Background()) {
+	for cur.Next(ctx) {
 		if err := cur.Err(); err != nil {
-			log.Error(consts.QueryDocumentTag, err.Error())
+			log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
 		// Mutate and retrieve Document
-		document := &pbdoc.Document{}
-		if err := cur.Decode(document); err != nil {
-			log.Error(consts.QueryDocumentTag, err.Error())
+		record := &documentRecord{}
+		if err := cur.Decode(record); err != nil {
+			log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		document, err := record.toDocument()
+		if err != nil {
+			log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 			return nil, status.Error(codes.Internal, err.Error())
 		}
 
+		signDocumentURLs(document, record.isPublicAt(now), urlSigner, conf.URLSigner.TTL)
 		documentCollection = append(documentCollection, document)
-		log.Info(consts.QueryDocumentTag, fmt.Sprintf("document: \n%s\n", pretty.Sprint(document)))
+		log.DebugContext(ctx, consts.QueryDocumentTag, "Found document", log.Value("document", document))
 
 	}
 
 	if err := cur.Err(); err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	if err := cur.Close(context.Background()); err != nil {
-		log.Error(consts.QueryDocumentTag, err.Error())
+	if err := cur.Close(ctx); err != nil {
+		log.ErrorContext(ctx, consts.QueryDocumentTag, err.Error())
 	}
 
-	log.Info(consts.QueryDocumentTag, "Success querying documents")
+	log.InfoContext(ctx, consts.QueryDocumentTag, "Success querying documents")
 	return &pbsvc.DocumentResponse{
 		Status:             &pbsvc.DocumentResponse_Code{Code: uint32(codes.OK)},
 		Message:            codes.OK.String(),
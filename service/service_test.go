@@ -1,22 +1,27 @@
 package service
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/mongodb"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang/protobuf/proto"
 	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
 	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
 	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
-	"github.com/hwsc-org/hwsc-lib/logger"
+	"github.com/hwsc-org/hwsc-document-svc/logger"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
 	"github.com/ory/dockertest"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"io/ioutil"
 	"math/rand"
+	"mime"
 	"os"
+	"path"
 	"strconv"
 	"testing"
 	"time"
@@ -38,14 +43,70 @@ var (
 	randCity            = randStringBytes(13)
 	randProvince        = randStringBytes(13)
 	numDocumentFixtures = 32
+
+	// Verifies the file urls of the tests without contacting the storage
+	testVerifier = newTestVerifier()
 )
 
+// testStorage serves the files of the tests
+const testStorage = "https://hwscdevstorage.blob.core.windows.net"
+
+// reachableTestURLs are the files of the storage used by the tests, any other url is unreachable
+var reachableTestURLs = []string{
+	testStorage + "/audios/Milad Hosseini - Deli Asheghetam [128].mp3",
+	testStorage + "/audios/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_GunshotMaybe_CaboMexico_Tag_Bprobe_20140307_130640_8000_3_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_SqueakAscendingShriek_CaboMexico_Tag_Acousonde_20140210_093123_8000_2_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_Squelch_CaboMexico_Tag_Acousonde_20140210_ 105232_8000_4_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_Unknown13_CaboMexico_Tag_Bprobe_20150324_142637_8000_3_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_WhupAscendingShriek_CaboMexico_Tag_Acousonde_20140306_142331_8000_3_BreedingMigrating.wav",
+	testStorage + "/audios/Seger_Wookie_CaboMexico_Tag_Acousonde_20140210_113355_800_3_BreedingMigrating.wav",
+	testStorage + "/audios/a.wav",
+	testStorage + "/audios/b.wav",
+	testStorage + "/audios/c.wav",
+	testStorage + "/audios/d.wav",
+	testStorage + "/audios/error.wav",
+	testStorage + "/audios/pusheen.mp3",
+	testStorage + "/audios/tone_44100hz.flac",
+	testStorage + "/audios/tone_8000hz.wav",
+	testStorage + "/files/a.pdf",
+	testStorage + "/files/a.tif",
+	testStorage + "/files/c.pdf",
+	testStorage + "/files/c.tif",
+	testStorage + "/files/d.pdf",
+	testStorage + "/images/A.jpg",
+	testStorage + "/images/Rotating_earth_(large).gif",
+	testStorage + "/images/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.jpg",
+	testStorage + "/images/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.png",
+	testStorage + "/images/Seger_GunshotMaybe_CaboMexico_Tag_Bprobe_20140307_130640_8000_3_BreedingMigrating.png",
+	testStorage + "/images/Seger_SqueakAscendingShriek_CaboMexico_Tag_Acousonde_20140210_093123_8000_2_BreedingMigrating.jpg",
+	testStorage + "/images/Seger_Squelch_CaboMexico_Tag_Acousonde_20140210_ 105232_8000_4_BreedingMigrating.jpg",
+	testStorage + "/images/Seger_Unknown13_CaboMexico_Tag_Bprobe_20150324_142637_8000_3_BreedingMigrating.jpg",
+	testStorage + "/images/Seger_Unknown13_CaboMexico_Tag_Bprobe_20150324_142637_8000_3_BreedingMigrating.png",
+	testStorage + "/images/Seger_WhupAscendingShriek_CaboMexico_Tag_Acousonde_20140306_142331_8000_3_BreedingMigrating.jpg",
+	testStorage + "/images/Seger_Wookie_CaboMexico_Tag_Acousonde_20140210_113355_800_3_BreedingMigrating.jpg",
+	testStorage + "/images/a.jpg",
+	testStorage + "/images/b.jpg",
+	testStorage + "/images/cabo_gps.jpg",
+	testStorage + "/images/d.jpg",
+	testStorage + "/images/e.jpg",
+	testStorage + "/images/hulkgif.png",
+	testStorage + "/images/pusheen.jpg",
+	testStorage + "/videos/a.mp4",
+	testStorage + "/videos/c.mp4",
+	testStorage + "/videos/d.mp4",
+	testStorage + "/videos/pusheen.mp4",
+	testStorage + "/videos/videoplayback.mp4",
+	testStorage + "/videos/videoplayback.wmv",
+}
+
 const (
 	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
 
 func TestMain(t *testing.M) {
 	logger.Info(consts.TestTag, "Initializing Test, this should ONLY print during unit tests")
+	urlVerifier = testVerifier
 	pool, err := dockertest.NewPool("")
 	if err != nil {
 		logger.Fatal(consts.TestTag, err.Error())
@@ -74,11 +135,8 @@ func TestMain(t *testing.M) {
 	}); err != nil {
 		logger.Fatal(consts.TestTag, err.Error())
 	}
-	m, err := migrate.New("file://test_fixtures/mongodb", conf.DocumentDB.Writer)
-	if err != nil {
-		logger.Fatal(consts.TestTag, err.Error())
-	}
-	if err := m.Up(); err != nil {
+	// Apply the embedded migrations, like the service at startup
+	if err := MigrateUp(); err != nil {
 		logger.Fatal(consts.TestTag, err.Error())
 	}
 
@@ -105,6 +163,10 @@ func TestMain(t *testing.M) {
 		if err != nil {
 			logger.Fatal(consts.TestTag, err.Error(), doc.String())
 		}
+		// Count the fixtures in the usage of their owners, as migrated
+		if err := reserveUsage(context.Background(), doc.GetUuid(), 1, countFiles(doc)); err != nil {
+			logger.Fatal(consts.TestTag, err.Error(), doc.String())
+		}
 		count++
 	}
 	logger.Info(consts.TestTag, "Documents inserted #", strconv.Itoa(count))
@@ -119,6 +181,8 @@ func TestMain(t *testing.M) {
 	if err != nil {
 		logger.Fatal(consts.TestTag, err.Error())
 	}
+	// Handlers are called without the authentication interceptor
+	conf.Auth.Disabled = true
 	code := t.Run()
 
 	// You can't defer this because os.Exit doesn't care for defer
@@ -155,6 +219,35 @@ func TestGetStatus(t *testing.T) {
 	}
 }
 
+func TestHandlerLogsRequest(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, logger.Configure(&out, logger.Config{Level: "info"}))
+	defer func() {
+		assert.Nil(t, logger.Configure(os.Stderr, logger.Config{Level: "info", Redact: logger.DefaultRedact}))
+	}()
+	serviceStateLocker.currentServiceState = unavailable
+	defer func() { serviceStateLocker.currentServiceState = available }()
+
+	const method = "/document.DocumentService/DeleteDocument"
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(logger.RequestIDKey, "trace-1234"))
+	s := Service{}
+	_, err := logger.UnaryServerInterceptor()(ctx, &pbsvc.DocumentRequest{}, &grpc.UnaryServerInfo{FullMethod: method},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return s.DeleteDocument(ctx, req.(*pbsvc.DocumentRequest))
+		})
+	assert.NotNil(t, err)
+
+	// The logs of the handler carry the request, like the log of the finished call
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 4)
+	for _, line := range lines {
+		entry := map[string]interface{}{}
+		assert.Nil(t, json.Unmarshal(line, &entry))
+		assert.Equal(t, "trace-1234", entry["request_id"], string(line))
+		assert.Equal(t, method, entry["method"], string(line))
+	}
+}
+
 func TestCreateDocument(t *testing.T) {
 	cases := []struct {
 		req         *pbsvc.DocumentRequest
@@ -757,6 +850,39 @@ func TestAddFileMetadata(t *testing.T) {
 	}
 }
 
+func TestAddFileMetadataExtension(t *testing.T) {
+	serviceStateLocker.currentServiceState = available
+	b, err := proto.Marshal(&pbext.FileMetadataTransactionExtension{
+		Metadata: &pbext.FileMetadata{Caption: "some caption", Credit: "some credit"},
+	})
+	assert.Nil(t, err)
+	params := &pbdoc.FileMetadataTransaction{
+		Url:   "https://hwscdevstorage.blob.core.windows.net/images/pusheen.jpg",
+		Duid:  "1ChHfqfi3kYysH02mJbB2GDMxWr",
+		Media: pbdoc.FileType_IMAGE,
+	}
+	params.XXX_unrecognized = b
+
+	s := Service{}
+	res, err := s.AddFileMetadata(context.TODO(), &pbsvc.DocumentRequest{FileMetadataParameters: params})
+	assert.Nil(t, err)
+	ext, err := extractDocumentExtension(res.GetData())
+	assert.Nil(t, err)
+
+	var fuid string
+	for k, v := range res.GetData().GetImageUrlsMap() {
+		if v == params.GetUrl() {
+			fuid = k
+		}
+	}
+	meta := ext.GetFileMetadataMap()[fuid]
+	assert.NotNil(t, meta)
+	assert.Equal(t, "some caption", meta.GetCaption())
+	assert.Equal(t, "some credit", meta.GetCredit())
+	assert.NotEqual(t, "", meta.GetContentType())
+	assert.NotZero(t, meta.GetAddedTimestamp())
+}
+
 func TestDeleteFileMetadata(t *testing.T) {
 	cases := []struct {
 		req         *pbsvc.DocumentRequest
@@ -884,3 +1010,13 @@ func TestDeleteFileMetadata(t *testing.T) {
 
 	}
 }
+
+// newTestVerifier returns a fake verifier where only the reachableTestURLs are reachable,
+// advertising the content type of their extension.
+func newTestVerifier() *verifier.Fake {
+	files := make(map[string]*verifier.FileInfo, len(reachableTestURLs))
+	for _, addr := range reachableTestURLs {
+		files[addr] = &verifier.FileInfo{ContentType: mime.TypeByExtension(path.Ext(addr))}
+	}
+	return verifier.NewFake(files)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"sort"
+	"strings"
+)
+
+// validateShare checks that the share grants a valid access to exactly one user or group.
+// Returns consts.ErrInvalidShare, consts.ErrInvalidShareAccess, or the error of the uuid.
+func validateShare(share *pbext.DocumentShare) error {
+	if share == nil || (share.GetUuid() == "") == (strings.TrimSpace(share.GetGroup()) == "") {
+		return consts.ErrInvalidShare
+	}
+	if share.GetUuid() != "" {
+		if err := ValidateUUID(share.GetUuid()); err != nil {
+			return err
+		}
+	}
+	if _, ok := pbext.ShareAccess_name[int32(share.GetAccess())]; !ok {
+		return consts.ErrInvalidShareAccess
+	}
+	return nil
+}
+
+// sameGrantee returns true if both shares grant the same user or group.
+func sameGrantee(a *pbext.DocumentShare, uuid string, group string) bool {
+	if a.GetUuid() != "" {
+		return a.GetUuid() == uuid
+	}
+	return uuid == "" && a.GetGroup() == group
+}
+
+// grantShare adds the share, replacing the previous share of the same user or group.
+// Returns the shares, users first then groups, each sorted.
+func grantShare(shares []*pbext.DocumentShare, share *pbext.DocumentShare) []*pbext.DocumentShare {
+	share.Group = strings.TrimSpace(share.GetGroup())
+	granted := make([]*pbext.DocumentShare, 0, len(shares)+1)
+	for _, s := range shares {
+		if !sameGrantee(s, share.GetUuid(), share.GetGroup()) {
+			granted = append(granted, s)
+		}
+	}
+	granted = append(granted, share)
+	sort.Slice(granted, func(i, j int) bool {
+		if granted[i].GetUuid() != granted[j].GetUuid() {
+			// users sort before groups, whose uuid is empty
+			if granted[i].GetUuid() == "" || granted[j].GetUuid() == "" {
+				return granted[j].GetUuid() == ""
+			}
+			return granted[i].GetUuid() < granted[j].GetUuid()
+		}
+		return granted[i].GetGroup() < granted[j].GetGroup()
+	})
+	return granted
+}
+
+// revokeShare removes the share of the user or group.
+// Returns the remaining shares, or consts.ErrShareNotFound.
+func revokeShare(shares []*pbext.DocumentShare, uuid string, group string) ([]*pbext.DocumentShare, error) {
+	group = strings.TrimSpace(group)
+	if (uuid == "") == (group == "") {
+		return nil, consts.ErrInvalidShare
+	}
+	remaining := make([]*pbext.DocumentShare, 0, len(shares))
+	for _, s := range shares {
+		if !sameGrantee(s, uuid, group) {
+			remaining = append(remaining, s)
+		}
+	}
+	if len(remaining) == len(shares) {
+		return nil, consts.ErrShareNotFound
+	}
+	return remaining, nil
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateShare(t *testing.T) {
+	cases := []struct {
+		share    *pbext.DocumentShare
+		expected error
+	}{
+		{&pbext.DocumentShare{Uuid: otherUUID}, nil},
+		{&pbext.DocumentShare{Group: "lab-a", Access: pbext.ShareAccess_WRITE}, nil},
+		{nil, consts.ErrInvalidShare},
+		{&pbext.DocumentShare{}, consts.ErrInvalidShare},
+		{&pbext.DocumentShare{Group: " "}, consts.ErrInvalidShare},
+		{&pbext.DocumentShare{Uuid: otherUUID, Group: "lab-a"}, consts.ErrInvalidShare},
+		{&pbext.DocumentShare{Uuid: "some uuid"}, consts.ErrInvalidDocumentUUID},
+		{&pbext.DocumentShare{Group: "lab-a", Access: 2}, consts.ErrInvalidShareAccess},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, validateShare(c.share), c.share.String())
+	}
+}
+
+func TestGrantShare(t *testing.T) {
+	shares := grantShare(nil, &pbext.DocumentShare{Group: " lab-b "})
+	shares = grantShare(shares, &pbext.DocumentShare{Uuid: otherUUID})
+	shares = grantShare(shares, &pbext.DocumentShare{Group: "lab-a"})
+	shares = grantShare(shares, &pbext.DocumentShare{Uuid: ownerUUID})
+	// replaces the share of the same user
+	shares = grantShare(shares, &pbext.DocumentShare{Uuid: otherUUID, Access: pbext.ShareAccess_WRITE})
+
+	assert.Equal(t, []*pbext.DocumentShare{
+		{Uuid: otherUUID, Access: pbext.ShareAccess_WRITE},
+		{Uuid: ownerUUID},
+		{Group: "lab-a"},
+		{Group: "lab-b"},
+	}, shares)
+}
+
+func TestRevokeShare(t *testing.T) {
+	shares := []*pbext.DocumentShare{{Uuid: otherUUID}, {Group: "lab-a"}}
+
+	cases := []struct {
+		uuid     string
+		group    string
+		expected []*pbext.DocumentShare
+		expErr   error
+	}{
+		{otherUUID, "", []*pbext.DocumentShare{{Group: "lab-a"}}, nil},
+		{"", " lab-a", []*pbext.DocumentShare{{Uuid: otherUUID}}, nil},
+		{ownerUUID, "", nil, consts.ErrShareNotFound},
+		{"", "lab-b", nil, consts.ErrShareNotFound},
+		{"", "", nil, consts.ErrInvalidShare},
+		{otherUUID, "lab-a", nil, consts.ErrInvalidShare},
+	}
+
+	for _, c := range cases {
+		remaining, err := revokeShare(shares, c.uuid, c.group)
+		assert.Equal(t, c.expErr, err)
+		assert.Equal(t, c.expected, remaining)
+	}
+}
@@ -0,0 +1,606 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minWebhookSecretLength is the shortest secret a subscription may set
+	minWebhookSecretLength = 16
+	// webhookSecretBytes is the number of random bytes of a generated secret
+	webhookSecretBytes = 32
+	// defaultDeliveryLimit is the number of deliveries listed if the request sets no limit
+	defaultDeliveryLimit = 100
+	// maxDeliveryLimit is the most deliveries listed by a request
+	maxDeliveryLimit = 1000
+	// defaultWebhookBatchSize is the number of deliveries attempted by a dispatch if the config sets none
+	defaultWebhookBatchSize = 100
+	// webhookDispatcherLease is the name of the lease of the dispatcher of the deliveries
+	webhookDispatcherLease = "webhook-dispatcher"
+)
+
+// eventTypes are the types of the change events a subscription may select
+var eventTypes = map[string]bool{
+	outbox.DocumentCreated:   true,
+	outbox.DocumentUpdated:   true,
+	outbox.DocumentDeleted:   true,
+	outbox.DocumentPublished: true,
+	outbox.FileAdded:         true,
+	outbox.FileDeleted:       true,
+	outbox.FileUpdated:       true,
+}
+
+// subscriptionRecord is the MongoDB representation of a webhook subscription
+type subscriptionRecord struct {
+	ID   primitive.ObjectID `bson:"_id"`
+	Uuid string             `bson:"uuid"`
+	// Groups of the owner when subscribing, deciding the Documents shared with it that it receives
+	Groups     []string                       `bson:"groups"`
+	URL        string                         `bson:"url"`
+	Secret     string                         `bson:"secret"`
+	EventTypes []string                       `bson:"eventTypes"`
+	Query      *pbdoc.QueryTransaction        `bson:"query,omitempty"`
+	State      pbext.WebhookSubscriptionState `bson:"state"`
+	// Deliveries failed in a row
+	ConsecutiveFailures int    `bson:"consecutiveFailures"`
+	DisabledReason      string `bson:"disabledReason,omitempty"`
+	CreateTimestamp     int64  `bson:"createTimestamp"`
+	UpdateTimestamp     int64  `bson:"updateTimestamp"`
+}
+
+// deliveryRecord is the MongoDB representation of the delivery of an event to a webhook subscription
+type deliveryRecord struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	SubscriptionID primitive.ObjectID `bson:"subscriptionId"`
+	EventID        primitive.ObjectID `bson:"eventId"`
+	// Event posted by every attempt
+	Event                *outbox.Event              `bson:"event"`
+	State                pbext.WebhookDeliveryState `bson:"state"`
+	Attempts             int                        `bson:"attempts"`
+	ResponseCode         int                        `bson:"responseCode"`
+	LastError            string                     `bson:"lastError,omitempty"`
+	CreateTimestamp      int64                      `bson:"createTimestamp"`
+	LastAttemptTimestamp int64                      `bson:"lastAttemptTimestamp"`
+	NextAttemptTimestamp int64                      `bson:"nextAttemptTimestamp"`
+	// Removal of a finished delivery after the retention, unset if deliveries are kept forever
+	ExpireAt *time.Time `bson:"expireAt,omitempty"`
+}
+
+// subscriptionSink records a delivery for every subscription of the events of the outbox
+type subscriptionSink struct{}
+
+// newSubscriptionRecord returns the subscription of the caller described by the request, at the time now.
+// Generates its secret if the request sets none.
+// Returns an error if the url, an event type, the query, or the secret of the request is invalid.
+func newSubscriptionRecord(caller *auth.Caller, req *pbext.CreateWebhookSubscriptionRequest,
+	now time.Time) (*subscriptionRecord, error) {
+
+	if err := validateWebhookURL(req.GetUrl()); err != nil {
+		return nil, err
+	}
+	types := make([]string, 0, len(req.GetEventTypes()))
+	for _, eventType := range req.GetEventTypes() {
+		if !eventTypes[eventType] {
+			return nil, consts.ErrInvalidEventType
+		}
+		types = append(types, eventType)
+	}
+	if query := req.GetQuery(); query != nil {
+		min, max := query.GetMinRecordTimestamp(), query.GetMaxRecordTimestamp()
+		if min < 0 || max < 0 || (max > 0 && min > max) {
+			return nil, consts.ErrInvalidDocumentRecordTimestamp
+		}
+	}
+	secret := req.GetSecret()
+	if secret == "" {
+		b := make([]byte, webhookSecretBytes)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	if len(secret) < minWebhookSecretLength {
+		return nil, consts.ErrWebhookSecretTooShort
+	}
+
+	record := &subscriptionRecord{
+		ID:              primitive.NewObjectID(),
+		URL:             req.GetUrl(),
+		Secret:          secret,
+		EventTypes:      types,
+		Query:           req.GetQuery(),
+		State:           pbext.WebhookSubscriptionState_ACTIVE,
+		CreateTimestamp: now.Unix(),
+		UpdateTimestamp: now.Unix(),
+	}
+	if caller != nil {
+		record.Uuid, record.Groups = caller.UUID, caller.Groups
+	}
+	return record, nil
+}
+
+// validateWebhookURL validates the url of a subscription against the policy of the webhooks.
+// Returns an error if it is not an absolute http or https url, or its address is not allowed.
+func validateWebhookURL(addr string) error {
+	u, err := url.ParseRequestURI(addr)
+	if err != nil || u.Host == "" {
+		return consts.ErrInvalidWebhookURL
+	}
+	if err := webhookPolicy().CheckURL(u); err != nil {
+		if err == consts.ErrURLSchemeNotAllowed {
+			return consts.ErrInvalidWebhookURL
+		}
+		return err
+	}
+	return nil
+}
+
+// webhookPolicy returns the policy restricting the addresses of the webhooks.
+func webhookPolicy() verifier.Policy {
+	return verifier.Policy{Schemes: []string{"http", "https"}, AllowPrivate: conf.Subscriptions.AllowPrivate}
+}
+
+// parseSubscriptionID returns the ObjectID of a subscription id.
+// Returns consts.ErrInvalidSubscriptionID if the id is malformed.
+func parseSubscriptionID(id string) (primitive.ObjectID, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, consts.ErrInvalidSubscriptionID
+	}
+	return oid, nil
+}
+
+// findSubscriptionRecord returns the subscription with the id.
+// Returns an error if it is not found.
+func findSubscriptionRecord(ctx context.Context, collection *mongo.Collection,
+	id primitive.ObjectID) (*subscriptionRecord, error) {
+
+	record := &subscriptionRecord{}
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// authorizeSubscriber checks that the caller may manage a subscription of the owner.
+// Returns consts.ErrNotSubscriptionOwner unless the caller is the owner or an admin.
+func authorizeSubscriber(caller *auth.Caller, owner string) error {
+	if isAdmin(caller) || caller.UUID == owner {
+		return nil
+	}
+	return consts.ErrNotSubscriptionOwner
+}
+
+// deliveryLimit returns the number of deliveries listed for the requested limit.
+func deliveryLimit(limit int32) int64 {
+	if limit == 0 {
+		return defaultDeliveryLimit
+	}
+	if limit > maxDeliveryLimit {
+		return maxDeliveryLimit
+	}
+	return int64(limit)
+}
+
+// caller returns the owner of the subscription as a caller, nil if it was created without authentication.
+// The owner has no role, so a subscription never reads the Documents of others as an admin, a role that
+// may be revoked after subscribing.
+func (r *subscriptionRecord) caller() *auth.Caller {
+	if r.Uuid == "" {
+		return nil
+	}
+	return &auth.Caller{UUID: r.Uuid, Groups: r.Groups}
+}
+
+// selects returns true if the subscription receives the event of the Document:
+// it subscribed to the type of the event, its owner may read the Document when the event happened,
+// and the Document matches its query.
+func (r *subscriptionRecord) selects(event *outbox.Event, record *documentRecord) bool {
+	if len(r.EventTypes) > 0 && !matchesAny(r.EventTypes, event.Type) {
+		return false
+	}
+	return readableBy(r.caller(), record, event.Timestamp) && queryMatches(r.Query, &record.Document)
+}
+
+// queryMatches returns true if the Document matches the QueryDocument parameters, as buildAggregatePipeline does.
+// Every Document matches a nil query, and a MaxRecordTimestamp of 0 is unbounded.
+func queryMatches(query *pbdoc.QueryTransaction, doc *pbdoc.Document) bool {
+	if query == nil {
+		return true
+	}
+	lastNames, firstNames := extractPublishersFields(query.GetPublishers())
+	cities, states, provinces, countries := extractStudySitesFields(query.GetStudySites())
+	publisher, site := doc.GetPublisherName(), doc.GetStudySite()
+
+	if !matchesAny(lastNames, publisher.GetLastName()) || !matchesAny(firstNames, publisher.GetFirstName()) ||
+		!matchesAny(cities, site.GetCity()) || !matchesAny(states, site.GetState()) ||
+		!matchesAny(provinces, site.GetProvince()) || !matchesAny(countries, site.GetCountry()) ||
+		!matchesAny(query.GetCallTypeNames(), doc.GetCallTypeName()) ||
+		!matchesAny(query.GetGroundTypes(), doc.GetGroundType()) ||
+		!matchesAny(query.GetSensorTypes(), doc.GetSensorType()) ||
+		!matchesAny(query.GetSensorNames(), doc.GetSensorName()) {
+		return false
+	}
+	if doc.GetRecordTimestamp() < query.GetMinRecordTimestamp() {
+		return false
+	}
+	if max := query.GetMaxRecordTimestamp(); max > 0 && doc.GetRecordTimestamp() > max {
+		return false
+	}
+	return true
+}
+
+// matchesAny returns true if the value is one of the values, or there are no values.
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// newDeliveryRecord returns the pending delivery of the event to the subscription, created at the time now,
+// posting the Document in JSON as projected by webhookDocument.
+func newDeliveryRecord(subscription *subscriptionRecord, event *outbox.Event, document json.RawMessage,
+	now time.Time) *deliveryRecord {
+
+	// The delivery state of the outbox is not posted
+	posted := &outbox.Event{
+		ID:        event.ID,
+		Type:      event.Type,
+		Duid:      event.Duid,
+		Fuid:      event.Fuid,
+		RequestID: event.RequestID,
+		Timestamp: event.Timestamp,
+		Document:  document,
+	}
+	return &deliveryRecord{
+		ID:              primitive.NewObjectID(),
+		SubscriptionID:  subscription.ID,
+		EventID:         event.ID,
+		Event:           posted,
+		State:           pbext.WebhookDeliveryState_PENDING,
+		CreateTimestamp: now.Unix(),
+	}
+}
+
+// webhookDocument returns the Document of an event as posted to the webhooks, in JSON: without its shares,
+// which only the owner of the Document may list, and with its file urls signed if it is not public at the unix
+// time now, or left as is if sign is false.
+// Returns an error if the Document fails to decode or encode.
+func webhookDocument(document json.RawMessage, now int64, sign bool) (json.RawMessage, error) {
+	record := &documentRecord{}
+	if err := json.Unmarshal(document, record); err != nil {
+		return nil, err
+	}
+	record.Shares = nil
+	if sign {
+		signDocumentURLs(&record.Document, record.isPublicAt(now), urlSigner, conf.URLSigner.TTL)
+	}
+	return json.Marshal(record)
+}
+
+// recordAttempt records an attempt of the delivery at the time now, answered with the status code, or failing
+// with err. A failed attempt is retried after a backoff, until the attempts of the config are exhausted.
+// Returns true if the delivery failed for good.
+func (d *deliveryRecord) recordAttempt(code int, err error, now time.Time, config conf.SubscriptionsConfig) bool {
+	d.Attempts++
+	d.ResponseCode = code
+	d.LastAttemptTimestamp = now.Unix()
+	d.NextAttemptTimestamp = 0
+	if err == nil {
+		d.State = pbext.WebhookDeliveryState_DELIVERED
+		d.LastError = ""
+	} else {
+		d.LastError = err.Error()
+		if d.Attempts < config.MaxAttempts {
+			d.NextAttemptTimestamp = now.Add(outbox.Backoff(d.Attempts, config.MinBackoff, config.MaxBackoff)).Unix()
+			return false
+		}
+		d.State = pbext.WebhookDeliveryState_FAILED
+	}
+	if config.Retention > 0 {
+		expireAt := now.Add(config.Retention)
+		d.ExpireAt = &expireAt
+	}
+	return d.State == pbext.WebhookDeliveryState_FAILED
+}
+
+// Name returns the name of the sink.
+func (subscriptionSink) Name() string {
+	return "subscriptions"
+}
+
+// Deliver records a pending delivery of the event for every active subscription selecting it.
+// A delivery already recorded for the subscription is kept, so the event may be delivered to the sink again.
+// Returns an error if the subscriptions can not be read, or a delivery is not recorded.
+func (subscriptionSink) Deliver(ctx context.Context, event *outbox.Event) error {
+	record := &documentRecord{}
+	if err := json.Unmarshal(event.Document, record); err != nil {
+		return err
+	}
+
+	subscriptions := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.SubscriptionCollection)
+	cur, err := subscriptions.Find(ctx, bson.M{
+		"state": pbext.WebhookSubscriptionState_ACTIVE,
+		"$or":   bson.A{bson.M{"eventTypes": event.Type}, bson.M{"eventTypes": bson.A{}}},
+	})
+	if err != nil {
+		return err
+	}
+	defer cur.Close(context.Background())
+
+	// The urls are signed by each attempt, so a retry does not post expired links
+	document, err := webhookDocument(event.Document, 0, false)
+	if err != nil {
+		return err
+	}
+
+	deliveries := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.DeliveryCollection)
+	now := time.Now().UTC()
+	for cur.Next(ctx) {
+		subscription := &subscriptionRecord{}
+		if err := cur.Decode(subscription); err != nil {
+			return err
+		}
+		if !subscription.selects(event, record) {
+			continue
+		}
+		delivery := newDeliveryRecord(subscription, event, document, now)
+		_, err := deliveries.UpdateOne(ctx,
+			bson.M{"subscriptionId": delivery.SubscriptionID, "eventId": delivery.EventID},
+			bson.M{"$setOnInsert": delivery}, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+// StartWebhookDispatcher attempts the pending webhook deliveries in the background.
+// Does nothing if subscriptions are disabled, or the interval is 0.
+func StartWebhookDispatcher() {
+	if !conf.Subscriptions.Enabled || conf.Subscriptions.Interval <= 0 {
+		log.Info(consts.SubscriptionsTag, "Dispatcher disabled")
+		return
+	}
+	if !conf.Outbox.Enabled {
+		log.Error(consts.SubscriptionsTag, "The outbox is disabled, no delivery is recorded")
+	}
+
+	config := webhookDispatcherConfig(conf.Subscriptions)
+	client := verifier.NewClient(webhookPolicy(), config.Timeout)
+	go func() {
+		for {
+			attempted, err := dispatchDeliveriesLeased(context.Background(), client, config)
+			if err != nil {
+				log.Error(consts.SubscriptionsTag, err.Error())
+			}
+			// A dispatch attempting a full batch is followed by the next one without waiting
+			if attempted < config.BatchSize {
+				time.Sleep(config.Interval)
+			}
+		}
+	}()
+	log.Info(consts.SubscriptionsTag, "Started, dispatching every", config.Interval.String())
+}
+
+// webhookDispatcherConfig returns the config with the defaults of the dispatcher for its unset batch size
+// and concurrency.
+func webhookDispatcherConfig(config conf.SubscriptionsConfig) conf.SubscriptionsConfig {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultWebhookBatchSize
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	return config
+}
+
+// dispatchDeliveriesLeased attempts a batch of deliveries while the lease of the dispatcher is held, so a delivery
+// is never attempted by two instances at once. Skips the batch if another instance holds the lease.
+// Returns the number of deliveries attempted, or an error if the lease or the deliveries could not be read.
+func dispatchDeliveriesLeased(ctx context.Context, client *http.Client, config conf.SubscriptionsConfig) (int, error) {
+	if err := refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer); err != nil {
+		return 0, err
+	}
+	leases := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.LeaseCollection)
+	held, err := acquireLease(ctx, leases, webhookDispatcherLease, instanceID, config.LeaseTTL)
+	if err != nil || !held {
+		return 0, err
+	}
+	leaseCtx, stop := keepLease(ctx, leases, webhookDispatcherLease, instanceID, config.LeaseTTL)
+	defer stop()
+	return dispatchDeliveries(leaseCtx, client, config)
+}
+
+// dispatchDeliveries attempts a batch of the due deliveries of the active subscriptions, in parallel,
+// with the config of webhookDispatcherConfig.
+// Returns the number of deliveries attempted, or an error if they could not be read.
+func dispatchDeliveries(ctx context.Context, client *http.Client, config conf.SubscriptionsConfig) (int, error) {
+	db := mongoDBWriter.Database(conf.DocumentDB.Name)
+
+	// Deliveries of paused and disabled subscriptions wait for them to be resumed
+	cur, err := db.Collection(conf.SubscriptionCollection).Find(ctx,
+		bson.M{"state": pbext.WebhookSubscriptionState_ACTIVE})
+	if err != nil {
+		return 0, err
+	}
+	active := make(map[primitive.ObjectID]*subscriptionRecord)
+	ids := bson.A{}
+	for cur.Next(ctx) {
+		subscription := &subscriptionRecord{}
+		if err := cur.Decode(subscription); err != nil {
+			cur.Close(ctx)
+			return 0, err
+		}
+		active[subscription.ID] = subscription
+		ids = append(ids, subscription.ID)
+	}
+	cur.Close(ctx)
+	if len(ids) == 0 {
+		return 0, cur.Err()
+	}
+
+	option := options.Find().SetSort(bson.D{{Key: "nextAttemptTimestamp", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(int64(config.BatchSize))
+	cur, err = db.Collection(conf.DeliveryCollection).Find(ctx, bson.M{
+		"state":                pbext.WebhookDeliveryState_PENDING,
+		"subscriptionId":       bson.M{"$in": ids},
+		"nextAttemptTimestamp": bson.M{"$lte": time.Now().UTC().Unix()},
+	}, option)
+	if err != nil {
+		return 0, err
+	}
+	var deliveries []*deliveryRecord
+	for cur.Next(ctx) {
+		delivery := &deliveryRecord{}
+		if err := cur.Decode(delivery); err != nil {
+			cur.Close(ctx)
+			return 0, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	cur.Close(ctx)
+
+	var attempted int64
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, config.Concurrency)
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery *deliveryRecord) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := attemptDelivery(ctx, client, active[delivery.SubscriptionID], delivery, config); err != nil {
+				log.Error(consts.SubscriptionsTag, err.Error())
+			}
+			atomic.AddInt64(&attempted, 1)
+		}(delivery)
+	}
+	wg.Wait()
+	return int(attempted), cur.Err()
+}
+
+// attemptDelivery posts the event of the delivery to the webhook of the subscription, and records the outcome.
+// A successful delivery resets the failures of the subscription, a delivery failing for good counts one,
+// disabling the subscription once they reach the limit of the config.
+// Returns an error if the outcome is not recorded.
+func attemptDelivery(ctx context.Context, client *http.Client, subscription *subscriptionRecord,
+	delivery *deliveryRecord, config conf.SubscriptionsConfig) error {
+
+	now := time.Now().UTC()
+	posted := *delivery.Event
+	document, err := webhookDocument(posted.Document, now.Unix(), true)
+	if err != nil {
+		return err
+	}
+	posted.Document = document
+
+	postCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+	code, err := outbox.PostEvent(postCtx, client, subscription.URL, []byte(subscription.Secret), &posted)
+	cancel()
+	failed := delivery.recordAttempt(code, err, now, config)
+	if err != nil {
+		log.Error(consts.SubscriptionsTag, fmt.Sprintf("Failed to deliver event, id: %s - subscription: %s - "+
+			"attempts: %d - err: %s", delivery.EventID.Hex(), subscription.ID.Hex(), delivery.Attempts, err.Error()))
+	}
+
+	db := mongoDBWriter.Database(conf.DocumentDB.Name)
+	if _, err := db.Collection(conf.DeliveryCollection).ReplaceOne(ctx, bson.M{"_id": delivery.ID},
+		delivery); err != nil {
+		return err
+	}
+
+	subscriptions := db.Collection(conf.SubscriptionCollection)
+	switch {
+	case delivery.State == pbext.WebhookDeliveryState_DELIVERED && subscription.ConsecutiveFailures > 0:
+		_, err := subscriptions.UpdateOne(ctx, bson.M{"_id": subscription.ID},
+			bson.M{"$set": bson.M{"consecutiveFailures": 0}})
+		return err
+	case failed:
+		updated := &subscriptionRecord{}
+		err := subscriptions.FindOneAndUpdate(ctx, bson.M{"_id": subscription.ID},
+			bson.M{"$inc": bson.M{"consecutiveFailures": 1}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(updated)
+		if err == mongo.ErrNoDocuments {
+			// Deleted since
+			return nil
+		}
+		if err != nil || updated.ConsecutiveFailures < config.DisableAfter {
+			return err
+		}
+		log.Info(consts.SubscriptionsTag, fmt.Sprintf("Disabling subscription, id: %s - failures: %d",
+			subscription.ID.Hex(), updated.ConsecutiveFailures))
+		_, err = subscriptions.UpdateOne(ctx,
+			bson.M{"_id": subscription.ID, "state": pbext.WebhookSubscriptionState_ACTIVE},
+			bson.M{"$set": bson.M{
+				"state":           pbext.WebhookSubscriptionState_DISABLED,
+				"disabledReason":  delivery.LastError,
+				"updateTimestamp": now.Unix(),
+			}})
+		return err
+	}
+	return nil
+}
+
+// toProto converts the subscription to its message, without its secret.
+func (r *subscriptionRecord) toProto() *pbext.WebhookSubscription {
+	return &pbext.WebhookSubscription{
+		Id:                  r.ID.Hex(),
+		Uuid:                r.Uuid,
+		Url:                 r.URL,
+		EventTypes:          r.EventTypes,
+		Query:               r.Query,
+		State:               r.State,
+		ConsecutiveFailures: int32(r.ConsecutiveFailures),
+		DisabledReason:      r.DisabledReason,
+		CreateTimestamp:     r.CreateTimestamp,
+		UpdateTimestamp:     r.UpdateTimestamp,
+	}
+}
+
+// toProto converts the delivery to its message.
+func (d *deliveryRecord) toProto() *pbext.WebhookDelivery {
+	delivery := &pbext.WebhookDelivery{
+		Id:                   d.ID.Hex(),
+		SubscriptionId:       d.SubscriptionID.Hex(),
+		EventId:              d.EventID.Hex(),
+		State:                d.State,
+		Attempts:             int32(d.Attempts),
+		ResponseCode:         int32(d.ResponseCode),
+		LastError:            d.LastError,
+		CreateTimestamp:      d.CreateTimestamp,
+		LastAttemptTimestamp: d.LastAttemptTimestamp,
+		NextAttemptTimestamp: d.NextAttemptTimestamp,
+	}
+	if d.Event != nil {
+		delivery.EventType, delivery.Duid = d.Event.Type, d.Event.Duid
+	}
+	return delivery
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewSubscriptionRecord(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	cases := []struct {
+		req *pbext.CreateWebhookSubscriptionRequest
+		err error
+	}{
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "https://lab.org/hooks"}, nil},
+		{&pbext.CreateWebhookSubscriptionRequest{
+			Url:        "https://lab.org/hooks",
+			EventTypes: []string{outbox.DocumentPublished},
+			Query:      &pbdoc.QueryTransaction{GroundTypes: []string{"Ice"}},
+			Secret:     "0123456789abcdef",
+		}, nil},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "lab.org/hooks"}, consts.ErrInvalidWebhookURL},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "ftp://lab.org/hooks"}, consts.ErrInvalidWebhookURL},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "http://127.0.0.1/hooks"}, consts.ErrPrivateAddress},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "https://lab.org/hooks", EventTypes: []string{"document"}},
+			consts.ErrInvalidEventType},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "https://lab.org/hooks",
+			Query: &pbdoc.QueryTransaction{MinRecordTimestamp: 2, MaxRecordTimestamp: 1}},
+			consts.ErrInvalidDocumentRecordTimestamp},
+		{&pbext.CreateWebhookSubscriptionRequest{Url: "https://lab.org/hooks", Secret: "short"},
+			consts.ErrWebhookSecretTooShort},
+	}
+
+	for _, c := range cases {
+		record, err := newSubscriptionRecord(owner, c.req, now)
+		assert.Equal(t, c.err, err, c.req.String())
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, ownerUUID, record.Uuid)
+		assert.Equal(t, pbext.WebhookSubscriptionState_ACTIVE, record.State)
+		assert.NotNil(t, record.EventTypes)
+		assert.Equal(t, now.Unix(), record.CreateTimestamp)
+		if c.req.GetSecret() != "" {
+			assert.Equal(t, c.req.GetSecret(), record.Secret)
+		} else {
+			assert.Len(t, record.Secret, 2*webhookSecretBytes)
+		}
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	doc := &pbdoc.Document{
+		PublisherName:   &pbdoc.Publisher{LastName: "Kim", FirstName: "Lisa"},
+		StudySite:       &pbdoc.StudySite{City: "Seattle", State: "Washington", Country: "USA"},
+		CallTypeName:    "Wookie",
+		GroundType:      "Wookie",
+		SensorType:      "Wookie",
+		SensorName:      "Wookie",
+		RecordTimestamp: 1514764800,
+	}
+	cases := []struct {
+		query    *pbdoc.QueryTransaction
+		expected bool
+	}{
+		{nil, true},
+		{&pbdoc.QueryTransaction{}, true},
+		{&pbdoc.QueryTransaction{Publishers: []*pbdoc.Publisher{{LastName: "Kim"}}}, true},
+		{&pbdoc.QueryTransaction{Publishers: []*pbdoc.Publisher{{LastName: "Lee"}}}, false},
+		{&pbdoc.QueryTransaction{StudySites: []*pbdoc.StudySite{{City: "Seattle"}, {City: "Portland"}}}, true},
+		{&pbdoc.QueryTransaction{StudySites: []*pbdoc.StudySite{{Country: "Canada"}}}, false},
+		{&pbdoc.QueryTransaction{CallTypeNames: []string{"Wookie"}, GroundTypes: []string{"Wookie"}}, true},
+		{&pbdoc.QueryTransaction{SensorNames: []string{"Ewok"}}, false},
+		{&pbdoc.QueryTransaction{MinRecordTimestamp: 1514764800}, true},
+		{&pbdoc.QueryTransaction{MinRecordTimestamp: 1514764801}, false},
+		{&pbdoc.QueryTransaction{MaxRecordTimestamp: 1514764799}, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, queryMatches(c.query, doc), c.query.String())
+	}
+}
+
+func TestSubscriptionSelects(t *testing.T) {
+	public := &documentRecord{Document: pbdoc.Document{Uuid: ownerUUID, IsPublic: true}}
+	private := &documentRecord{Document: pbdoc.Document{Uuid: ownerUUID}}
+	shared := &documentRecord{
+		Document: pbdoc.Document{Uuid: ownerUUID},
+		Shares:   []*pbext.DocumentShare{{Group: "lab-a"}},
+	}
+	embargoed := &documentRecord{Document: pbdoc.Document{Uuid: ownerUUID, IsPublic: true},
+		EmbargoUntilTimestamp: 1546300900}
+	published := &outbox.Event{Type: outbox.DocumentPublished, Timestamp: 1546300800}
+	byAdmin, err := newSubscriptionRecord(admin, &pbext.CreateWebhookSubscriptionRequest{Url: "https://lab.org/hooks"},
+		time.Unix(1546300800, 0))
+	assert.Nil(t, err)
+	cases := []struct {
+		subscription *subscriptionRecord
+		record       *documentRecord
+		expected     bool
+	}{
+		{&subscriptionRecord{Uuid: otherUUID}, public, true},
+		{&subscriptionRecord{Uuid: otherUUID, EventTypes: []string{outbox.DocumentPublished}}, public, true},
+		{&subscriptionRecord{Uuid: otherUUID, EventTypes: []string{outbox.DocumentDeleted}}, public, false},
+		{&subscriptionRecord{Uuid: otherUUID}, private, false},
+		{&subscriptionRecord{Uuid: ownerUUID}, private, true},
+		{byAdmin, private, false},
+		{&subscriptionRecord{}, private, true},
+		{&subscriptionRecord{Uuid: otherUUID, Groups: []string{"lab-a"}}, shared, true},
+		{&subscriptionRecord{Uuid: otherUUID}, embargoed, false},
+		{&subscriptionRecord{Uuid: otherUUID, Query: &pbdoc.QueryTransaction{GroundTypes: []string{"Ice"}}},
+			public, false},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.subscription.selects(published, c.record))
+	}
+}
+
+func TestWebhookDocument(t *testing.T) {
+	defer func(s *signer.Signer) {
+		urlSigner = s
+	}(urlSigner)
+	s, err := signer.New([]signer.Key{{ID: "k1", Secret: []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	urlSigner = s
+
+	const (
+		addr = "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"
+		fuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+	)
+	record := &documentRecord{
+		Document: pbdoc.Document{Uuid: ownerUUID, ImageUrlsMap: map[string]string{fuid: addr}},
+		Shares:   []*pbext.DocumentShare{{Uuid: otherUUID, Access: pbext.ShareAccess_READ}},
+	}
+	b, err := json.Marshal(record)
+	assert.Nil(t, err)
+
+	// Recorded without the shares, and signed when posted
+	recorded, err := webhookDocument(b, 0, false)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(recorded), otherUUID)
+	assert.Contains(t, string(recorded), addr)
+
+	posted, err := webhookDocument(recorded, time.Now().Unix(), true)
+	assert.Nil(t, err)
+	decoded := &documentRecord{}
+	assert.Nil(t, json.Unmarshal(posted, decoded))
+	assert.Nil(t, decoded.Shares)
+	assert.Nil(t, s.Verify(decoded.GetImageUrlsMap()[fuid], time.Now()))
+
+	_, err = webhookDocument([]byte("{"), 0, false)
+	assert.NotNil(t, err)
+}
+
+func TestRecordAttempt(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	config := conf.SubscriptionsConfig{MaxAttempts: 3, MinBackoff: time.Minute, MaxBackoff: time.Hour,
+		Retention: 24 * time.Hour}
+	unavailable := errors.New("webhook answered 503 Service Unavailable")
+
+	// A failed attempt is retried after the backoff
+	delivery := &deliveryRecord{}
+	assert.False(t, delivery.recordAttempt(503, unavailable, now, config))
+	assert.Equal(t, pbext.WebhookDeliveryState_PENDING, delivery.State)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, 503, delivery.ResponseCode)
+	assert.Equal(t, unavailable.Error(), delivery.LastError)
+	assert.Equal(t, now.Add(time.Minute).Unix(), delivery.NextAttemptTimestamp)
+	assert.Nil(t, delivery.ExpireAt)
+
+	assert.False(t, delivery.recordAttempt(0, unavailable, now, config))
+	assert.Equal(t, now.Add(2*time.Minute).Unix(), delivery.NextAttemptTimestamp)
+
+	// The last attempt fails the delivery for good
+	assert.True(t, delivery.recordAttempt(503, unavailable, now, config))
+	assert.Equal(t, pbext.WebhookDeliveryState_FAILED, delivery.State)
+	assert.Equal(t, int64(0), delivery.NextAttemptTimestamp)
+	assert.Equal(t, now.Add(24*time.Hour), *delivery.ExpireAt)
+
+	// A successful attempt delivers it
+	delivery = &deliveryRecord{Attempts: 1, LastError: unavailable.Error()}
+	assert.False(t, delivery.recordAttempt(204, nil, now, config))
+	assert.Equal(t, pbext.WebhookDeliveryState_DELIVERED, delivery.State)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Empty(t, delivery.LastError)
+	assert.Equal(t, now.Unix(), delivery.LastAttemptTimestamp)
+}
+
+func TestDeliveryLimit(t *testing.T) {
+	cases := []struct {
+		limit    int32
+		expected int64
+	}{
+		{0, defaultDeliveryLimit},
+		{1, 1},
+		{maxDeliveryLimit, maxDeliveryLimit},
+		{maxDeliveryLimit + 1, maxDeliveryLimit},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, deliveryLimit(c.limit))
+	}
+}
+
+func TestWebhookDispatcherConfig(t *testing.T) {
+	config := webhookDispatcherConfig(conf.SubscriptionsConfig{})
+	assert.Equal(t, defaultWebhookBatchSize, config.BatchSize)
+	assert.Equal(t, 1, config.Concurrency)
+
+	config = webhookDispatcherConfig(conf.SubscriptionsConfig{BatchSize: 10, Concurrency: 4})
+	assert.Equal(t, 10, config.BatchSize)
+	assert.Equal(t, 4, config.Concurrency)
+}
+
+func TestDispatchDeliveriesLeased(t *testing.T) {
+	assert.Nil(t, refreshMongoDBConnection(mongoDBWriter, &conf.DocumentDB.Writer))
+	db := mongoDBWriter.Database(conf.DocumentDB.Name)
+	subscription := &subscriptionRecord{
+		ID:    primitive.NewObjectID(),
+		Uuid:  ownerUUID,
+		URL:   "http://127.0.0.1:1/hooks",
+		State: pbext.WebhookSubscriptionState_ACTIVE,
+	}
+	_, err := db.Collection(conf.SubscriptionCollection).InsertOne(context.TODO(), subscription)
+	assert.Nil(t, err)
+	defer db.Collection(conf.SubscriptionCollection).DeleteOne(context.TODO(), bson.M{"_id": subscription.ID})
+	event, err := outbox.NewEvent(outbox.DocumentPublished, "duid", "", map[string]string{"duid": "duid"},
+		time.Now().UTC())
+	assert.Nil(t, err)
+	delivery := newDeliveryRecord(subscription, event, event.Document, time.Now().UTC())
+	_, err = db.Collection(conf.DeliveryCollection).InsertOne(context.TODO(), delivery)
+	assert.Nil(t, err)
+	defer db.Collection(conf.DeliveryCollection).DeleteOne(context.TODO(), bson.M{"_id": delivery.ID})
+
+	leases := db.Collection(conf.LeaseCollection)
+	held, err := acquireLease(context.TODO(), leases, webhookDispatcherLease, "other", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, held)
+	defer func() {
+		assert.Nil(t, releaseLease(context.TODO(), leases, webhookDispatcherLease, "other"))
+	}()
+
+	// Skipped while another instance holds the lease, the delivery is not attempted
+	config := webhookDispatcherConfig(conf.Subscriptions)
+	config.LeaseTTL = time.Minute
+	attempted, err := dispatchDeliveriesLeased(context.TODO(), http.DefaultClient, config)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, attempted)
+	stored := &deliveryRecord{}
+	assert.Nil(t, db.Collection(conf.DeliveryCollection).FindOne(context.TODO(),
+		bson.M{"_id": delivery.ID}).Decode(stored))
+	assert.Equal(t, 0, stored.Attempts)
+	assert.Equal(t, pbext.WebhookDeliveryState_PENDING, stored.State)
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	serviceStateLocker.currentServiceState = available
+	e := ExtensionService{}
+	ownerCtx := auth.NewContext(context.TODO(), owner)
+
+	created, err := e.CreateWebhookSubscription(ownerCtx, &pbext.CreateWebhookSubscriptionRequest{
+		Url:        "https://lab.org/hooks",
+		EventTypes: []string{outbox.DocumentPublished},
+	})
+	assert.Nil(t, err)
+	if !assert.NotNil(t, created) {
+		return
+	}
+	assert.NotEmpty(t, created.GetSecret())
+
+	// Secrets are not listed, and subscriptions of others only by admins
+	res, err := e.ListWebhookSubscriptions(ownerCtx, &pbext.ListWebhookSubscriptionsRequest{})
+	assert.Nil(t, err)
+	if assert.NotEmpty(t, res.GetSubscriptions()) {
+		listed := res.GetSubscriptions()[len(res.GetSubscriptions())-1]
+		assert.Equal(t, created.GetId(), listed.GetId())
+		assert.Empty(t, listed.GetSecret())
+	}
+	_, err = e.ListWebhookSubscriptions(auth.NewContext(context.TODO(), other),
+		&pbext.ListWebhookSubscriptionsRequest{Uuid: ownerUUID})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrNotSubscriptionOwner.Error()).Error())
+
+	paused, err := e.PauseWebhookSubscription(ownerCtx,
+		&pbext.PauseWebhookSubscriptionRequest{Id: created.GetId(), Paused: true})
+	assert.Nil(t, err)
+	assert.Equal(t, pbext.WebhookSubscriptionState_PAUSED, paused.GetState())
+	resumed, err := e.PauseWebhookSubscription(ownerCtx, &pbext.PauseWebhookSubscriptionRequest{Id: created.GetId()})
+	assert.Nil(t, err)
+	assert.Equal(t, pbext.WebhookSubscriptionState_ACTIVE, resumed.GetState())
+
+	deliveries, err := e.ListWebhookDeliveries(ownerCtx,
+		&pbext.ListWebhookDeliveriesRequest{SubscriptionId: created.GetId()})
+	assert.Nil(t, err)
+	assert.Empty(t, deliveries.GetDeliveries())
+
+	_, err = e.DeleteWebhookSubscription(auth.NewContext(context.TODO(), other),
+		&pbext.DeleteWebhookSubscriptionRequest{Id: created.GetId()})
+	assert.EqualError(t, err, status.Error(codes.PermissionDenied, consts.ErrNotSubscriptionOwner.Error()).Error())
+	_, err = e.DeleteWebhookSubscription(ownerCtx, &pbext.DeleteWebhookSubscriptionRequest{Id: created.GetId()})
+	assert.Nil(t, err)
+	_, err = e.PauseWebhookSubscription(ownerCtx, &pbext.PauseWebhookSubscriptionRequest{Id: created.GetId()})
+	assert.EqualError(t, err, status.Errorf(codes.InvalidArgument, "Webhook subscription not found, id: %s",
+		created.GetId()).Error())
+}
@@ -4,15 +4,23 @@ import (
 	"fmt"
 	"github.com/google/uuid"
 	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
-	log "github.com/hwsc-org/hwsc-lib/logger"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/metrics"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
 	"github.com/segmentio/ksuid"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"net/http"
-	"net/url"
+	"golang.org/x/net/context"
+	"math"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,6 +45,8 @@ const (
 	// Minimum timestamp in seconds (Jan 1, 1990)
 	minTimestamp = 631152000
 	// maxTimestamp = 32503680524 in MongoDB
+	// Longest embargo in seconds (10 years)
+	maxEmbargoDuration = 10 * 365 * 24 * 60 * 60
 )
 
 // TODO regex to point to the proper storage in Azure
@@ -95,9 +105,25 @@ func (d *fuidLocker) NewFUID() string {
 	return newUUID
 }
 
+// lockDUID gets the lock of the duid if it already exists, else makes it, and locks it.
+// Returns the lock, held by the caller.
+func lockDUID(duid string) *sync.RWMutex {
+	start := time.Now()
+	lock, _ := duidClientLocker.LoadOrStore(duid, &sync.RWMutex{})
+	lock.(*sync.RWMutex).Lock()
+	metrics.ObserveLockWait(time.Since(start))
+	return lock.(*sync.RWMutex)
+}
+
 // ValidateDocument validates the Document.
 // Returns an error if field fails validation.
 func ValidateDocument(meta *pbdoc.Document) error {
+	return validateDocument(context.Background(), meta, nil)
+}
+
+// validateDocument validates the Document, and records the inspected metadata of every file in files.
+// Returns an error if field fails validation.
+func validateDocument(ctx context.Context, meta *pbdoc.Document, files map[string]*pbext.FileMetadata) error {
 	if err := ValidateDUID(meta.GetDuid()); err != nil {
 		return err
 	}
@@ -139,18 +165,24 @@ func ValidateDocument(meta *pbdoc.Document) error {
 	if err := ValidateLongitude(meta.GetLongitude()); err != nil {
 		return err
 	}
-	if err := ValidateImageURLs(meta.GetImageUrlsMap()); err != nil {
+	if err := validateImageURLs(ctx, meta.GetImageUrlsMap(), files); err != nil {
 		return err
 	}
-	if err := ValidateAudioURLs(meta.GetAudioUrlsMap()); err != nil {
+	if err := validateAudioURLs(ctx, meta.GetAudioUrlsMap(), files); err != nil {
 		return err
 	}
-	if err := ValidateVideoURLs(meta.GetVideoUrlsMap()); err != nil {
+	if err := validateVideoURLs(ctx, meta.GetVideoUrlsMap(), files); err != nil {
 		return err
 	}
-	if err := ValidateFileURLs(meta.GetFileUrlsMap()); err != nil {
+	if err := validateFileURLs(ctx, meta.GetFileUrlsMap(), files); err != nil {
 		return err
 	}
+	if files != nil {
+		if err := applySamplingRate(meta, files, conf.Media.RejectSamplingRateMismatch); err != nil {
+			return err
+		}
+		applyCaptureChecks(meta, files, conf.Media.LocationTolerance, conf.Media.CaptureTimeTolerance)
+	}
 	if err := ValidateRecordTimestamp(meta.GetRecordTimestamp()); err != nil {
 		return err
 	}
@@ -386,6 +418,12 @@ func ValidateLongitude(longitude float32) error {
 // ValidateImageURLs validates image urls.
 // Returns an error if a url is an empty string, unsupported format, or unreachable.
 func ValidateImageURLs(imageURLs map[string]string) error {
+	return validateImageURLs(context.Background(), imageURLs, nil)
+}
+
+// validateImageURLs validates image urls, and records the inspected metadata of every file in files.
+// Returns an error if a url is an empty string, unsupported format, or unreachable.
+func validateImageURLs(ctx context.Context, imageURLs map[string]string, files map[string]*pbext.FileMetadata) error {
 	if imageURLs == nil {
 		return consts.ErrInvalidDocumentImageURLs
 	}
@@ -394,6 +432,9 @@ func ValidateImageURLs(imageURLs map[string]string) error {
 		if !fuidRegex.MatchString(k) {
 			return consts.ErrInvalidDocumentFUID
 		}
+		// A link returned by the service is stored as the url it was signed from
+		v = signer.Strip(v)
+		imageURLs[k] = v
 
 		if strings.TrimSpace(v) == "" {
 			return consts.ErrInvalidDocumentImageURL
@@ -401,9 +442,11 @@ func ValidateImageURLs(imageURLs map[string]string) error {
 		if !imageRegex.MatchString(strings.ToLower(v)) {
 			return fmt.Errorf("invalid Document image type ImageURL: %s", v)
 		}
-		if err := ValidateURL(v); err != nil {
-			return fmt.Errorf("invalid Document ImageURL: %s", v)
+		inspected, err := inspectURL(ctx, v)
+		if err != nil {
+			return invalidURLError("ImageURL", v, err)
 		}
+		mergeFileMetadata(files, k, inspected)
 	}
 	return nil
 }
@@ -411,6 +454,12 @@ func ValidateImageURLs(imageURLs map[string]string) error {
 // ValidateAudioURLs validates audio urls.
 // Returns an error if a url is an empty string, unsupported format, or unreachable.
 func ValidateAudioURLs(audioURLs map[string]string) error {
+	return validateAudioURLs(context.Background(), audioURLs, nil)
+}
+
+// validateAudioURLs validates audio urls, and records the inspected metadata of every file in files.
+// Returns an error if a url is an empty string, unsupported format, or unreachable.
+func validateAudioURLs(ctx context.Context, audioURLs map[string]string, files map[string]*pbext.FileMetadata) error {
 	if audioURLs == nil {
 		return consts.ErrInvalidDocumentAudioURLs
 	}
@@ -419,6 +468,9 @@ func ValidateAudioURLs(audioURLs map[string]string) error {
 		if !fuidRegex.MatchString(k) {
 			return consts.ErrInvalidDocumentFUID
 		}
+		// A link returned by the service is stored as the url it was signed from
+		v = signer.Strip(v)
+		audioURLs[k] = v
 
 		if strings.TrimSpace(v) == "" {
 			return consts.ErrInvalidDocumentAudioURL
@@ -426,9 +478,11 @@ func ValidateAudioURLs(audioURLs map[string]string) error {
 		if !audioRegex.MatchString(strings.ToLower(v)) {
 			return fmt.Errorf("invalid Document audio type AudioURL: %s", v)
 		}
-		if err := ValidateURL(v); err != nil {
-			return fmt.Errorf("invalid Document AudioURL: %s", v)
+		inspected, err := inspectURL(ctx, v)
+		if err != nil {
+			return invalidURLError("AudioURL", v, err)
 		}
+		mergeFileMetadata(files, k, inspected)
 	}
 	return nil
 }
@@ -436,6 +490,12 @@ func ValidateAudioURLs(audioURLs map[string]string) error {
 // ValidateVideoURLs validates video urls.
 // Returns an error if a url is an empty string, unsupported format, or unreachable.
 func ValidateVideoURLs(videoURLs map[string]string) error {
+	return validateVideoURLs(context.Background(), videoURLs, nil)
+}
+
+// validateVideoURLs validates video urls, and records the inspected metadata of every file in files.
+// Returns an error if a url is an empty string, unsupported format, or unreachable.
+func validateVideoURLs(ctx context.Context, videoURLs map[string]string, files map[string]*pbext.FileMetadata) error {
 	if videoURLs == nil {
 		return consts.ErrInvalidDocumentVideoURLs
 	}
@@ -444,6 +504,9 @@ func ValidateVideoURLs(videoURLs map[string]string) error {
 		if !fuidRegex.MatchString(k) {
 			return consts.ErrInvalidDocumentFUID
 		}
+		// A link returned by the service is stored as the url it was signed from
+		v = signer.Strip(v)
+		videoURLs[k] = v
 
 		if strings.TrimSpace(v) == "" {
 			return consts.ErrInvalidDocumentVideoURL
@@ -451,9 +514,11 @@ func ValidateVideoURLs(videoURLs map[string]string) error {
 		if !videoRegex.MatchString(strings.ToLower(v)) {
 			return fmt.Errorf("invalid Document video type VideoURL: %s", v)
 		}
-		if err := ValidateURL(v); err != nil {
-			return fmt.Errorf("invalid Document VideoURL: %s", v)
+		inspected, err := inspectURL(ctx, v)
+		if err != nil {
+			return invalidURLError("VideoURL", v, err)
 		}
+		mergeFileMetadata(files, k, inspected)
 	}
 	return nil
 }
@@ -461,6 +526,12 @@ func ValidateVideoURLs(videoURLs map[string]string) error {
 // ValidateFileURLs validates video urls.
 // Returns an error if a url is an empty string, or unreachable.
 func ValidateFileURLs(fileURLs map[string]string) error {
+	return validateFileURLs(context.Background(), fileURLs, nil)
+}
+
+// validateFileURLs validates file urls, and records the inspected metadata of every file in files.
+// Returns an error if a url is an empty string, unsupported format, or unreachable.
+func validateFileURLs(ctx context.Context, fileURLs map[string]string, files map[string]*pbext.FileMetadata) error {
 	if fileURLs == nil {
 		return consts.ErrInvalidDocumentFileURLs
 	}
@@ -469,13 +540,18 @@ func ValidateFileURLs(fileURLs map[string]string) error {
 		if !fuidRegex.MatchString(k) {
 			return consts.ErrInvalidDocumentFUID
 		}
+		// A link returned by the service is stored as the url it was signed from
+		v = signer.Strip(v)
+		fileURLs[k] = v
 
 		if strings.TrimSpace(v) == "" {
 			return consts.ErrInvalidDocumentFileURL
 		}
-		if err := ValidateURL(v); err != nil {
-			return fmt.Errorf("invalid Document FileURL: %s", v)
+		inspected, err := inspectURL(ctx, v)
+		if err != nil {
+			return invalidURLError("FileURL", v, err)
 		}
+		mergeFileMetadata(files, k, inspected)
 	}
 	return nil
 }
@@ -490,6 +566,24 @@ func ValidateRecordTimestamp(timestamp int64) error {
 	return nil
 }
 
+// ValidateEmbargoTimestamp validates embargo timestamp, 0 being no embargo.
+// Returns an error if the embargo is set before now, or more than 10 years after now.
+func ValidateEmbargoTimestamp(timestamp int64) error {
+	if timestamp == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC().Unix()
+	if timestamp <= now {
+		return consts.ErrEmbargoTimestampPassed
+	}
+	if timestamp > now+maxEmbargoDuration {
+		return consts.ErrEmbargoTimestampTooFar
+	}
+
+	return nil
+}
+
 // ValidateCreateTimestamp validates create timestamp.
 // Returns an error if create timestamp is set before record timestamp, or create timestamp is set after now.
 func ValidateCreateTimestamp(createTimestamp int64, recordTimeStamp int64) error {
@@ -518,13 +612,13 @@ func ValidateUpdateTimestamp(updateTimestamp int64, createTimestamp int64) error
 	return nil
 }
 
-func isStateAvailable() bool {
+func isStateAvailable(ctx context.Context) bool {
 	// Lock the state for reading
 	serviceStateLocker.lock.RLock()
 	// Unlock the state before function exits
 	defer serviceStateLocker.lock.RUnlock()
 
-	log.Info(consts.ServiceStateTag, serviceStateLocker.currentServiceState.String())
+	log.InfoContext(ctx, consts.ServiceStateTag, serviceStateLocker.currentServiceState.String())
 	if serviceStateLocker.currentServiceState != available {
 		return false
 	}
@@ -729,13 +823,277 @@ func areSlicesEqual(a, b []string) bool {
 // ValidateURL confirms if the address is reachable and valid.
 // Return an error if the address is unreachable and invalid.
 func ValidateURL(addr string) error {
-	if _, err := url.ParseRequestURI(addr); err != nil {
-		return consts.ErrUnreachableURI
+	_, err := inspectURL(context.Background(), addr)
+	return err
+}
+
+// inspectURL confirms if the address is reachable, valid, and allowed.
+// Returns the file metadata advertised by the storage, or an error if the address is unreachable, invalid,
+// or not allowed.
+func inspectURL(ctx context.Context, addr string) (*pbext.FileMetadata, error) {
+	ctx, span := tracing.StartVerifyURL(ctx, addr)
+	defer span.End()
+
+	start := time.Now()
+	info, err := urlVerifier.Verify(ctx, addr)
+	metrics.ObserveURLVerification(addr, time.Since(start), err)
+	tracing.EndVerifyURL(span, err)
+	if err != nil {
+		return nil, err
+	}
+	meta := fileMetadataFromInfo(info)
+	meta.LinkStatus = linkStatusOK
+	meta.LastCheckedTimestamp = time.Now().UTC().Unix()
+	return meta, nil
+}
+
+// applySamplingRate fills an unset Document SamplingRate from its audio files,
+// and flags the audio files whose sample rate disagrees with the Document.
+// Returns an error if a file disagrees, and reject is set.
+func applySamplingRate(meta *pbdoc.Document, files map[string]*pbext.FileMetadata, reject bool) error {
+	fuids := make([]string, 0, len(meta.GetAudioUrlsMap()))
+	for fuid := range meta.GetAudioUrlsMap() {
+		fuids = append(fuids, fuid)
+	}
+	sort.Strings(fuids)
+
+	if meta.GetSamplingRate() == 0 {
+		for _, fuid := range fuids {
+			if rate := files[fuid].GetSampleRate(); rate > 0 {
+				meta.SamplingRate = rate
+				break
+			}
+		}
+	}
+
+	for _, fuid := range fuids {
+		file := files[fuid]
+		if file.GetSampleRate() == 0 {
+			continue
+		}
+		if file.GetSampleRate() == meta.GetSamplingRate() {
+			clearFileWarning(file, samplingRateWarning)
+			continue
+		}
+		if reject {
+			return fmt.Errorf("%s AudioURL: %s", consts.ErrSamplingRateMismatch.Error(), meta.GetAudioUrlsMap()[fuid])
+		}
+		setFileWarning(file, samplingRateWarning, fmt.Sprintf("Document SamplingRate is %d Hz, the file is %d Hz",
+			meta.GetSamplingRate(), file.GetSampleRate()))
+	}
+	return nil
+}
+
+// applyCaptureChecks flags the images whose EXIF coordinates or capture time disagree with the Document
+// beyond the tolerances, distance in kilometers.
+func applyCaptureChecks(meta *pbdoc.Document, files map[string]*pbext.FileMetadata,
+	distance float64, duration time.Duration) {
+
+	for fuid := range meta.GetImageUrlsMap() {
+		file := files[fuid]
+		if file == nil {
+			continue
+		}
+
+		if loc := file.GetLocation(); loc != nil {
+			km := haversineDistance(float64(meta.GetLatitude()), float64(meta.GetLongitude()),
+				loc.GetLatitude(), loc.GetLongitude())
+			if km > distance {
+				setFileWarning(file, locationWarning, fmt.Sprintf(
+					"image taken at %.5f, %.5f, %.1f km from the Document coordinates",
+					loc.GetLatitude(), loc.GetLongitude(), km))
+			} else {
+				clearFileWarning(file, locationWarning)
+			}
+		}
+
+		if file.GetCaptureTimestamp() > 0 {
+			diff := time.Duration(file.GetCaptureTimestamp()-meta.GetRecordTimestamp()) * time.Second
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > duration {
+				setFileWarning(file, captureTimeWarning, fmt.Sprintf(
+					"image taken at %s, %s from the Document RecordTimestamp",
+					time.Unix(file.GetCaptureTimestamp(), 0).UTC().Format(time.RFC3339), diff))
+			} else {
+				clearFileWarning(file, captureTimeWarning)
+			}
+		}
 	}
-	resp, err := http.Get(addr)
-	if err != nil || resp.StatusCode >= 400 {
-		return consts.ErrUnreachableURI
+}
+
+// haversineDistance returns the great-circle distance in kilometers between two coordinates.
+func haversineDistance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const earthRadius = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(a))
+}
+
+// invalidURLError describes why the url of a Document field failed verification.
+// Returns an error naming the field and url, the cause is only kept when the content does not match the extension.
+func invalidURLError(field string, addr string, err error) error {
+	if err == consts.ErrContentTypeMismatch {
+		return fmt.Errorf("%s Document %s: %s", err.Error(), field, addr)
+	}
+	return fmt.Errorf("invalid Document %s: %s", field, addr)
+}
+
+// newURLVerifier builds the URL verifier from its configuration.
+func newURLVerifier(c conf.URLVerifierConfig) verifier.Verifier {
+	policy := verifier.Policy{
+		Schemes:      c.Schemes,
+		Hosts:        c.Hosts,
+		AllowPrivate: c.AllowPrivate,
+	}
+	if c.Offline {
+		log.Info(consts.DocumentServiceTag, "Verifying file urls offline")
+		return verifier.NewOffline(policy)
+	}
+	return verifier.NewHTTP(policy, c.Timeout)
+}
+
+// newURLSigner builds the URL signer from its configuration.
+// Returns nil if no key is configured, or an error if a key is malformed or the current key is unknown.
+func newURLSigner(c conf.URLSignerConfig) (*signer.Signer, error) {
+	if len(c.Keys) == 0 {
+		log.Info(consts.DocumentServiceTag, "Signing file urls disabled")
+		return nil, nil
+	}
+	keys := make([]signer.Key, 0, len(c.Keys))
+	for _, entry := range c.Keys {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, consts.ErrInvalidSigningKey
+		}
+		keys = append(keys, signer.Key{ID: parts[0], Secret: []byte(parts[1])})
+	}
+	current := c.CurrentKey
+	if current == "" {
+		current = keys[0].ID
+	}
+	return signer.New(keys, current)
+}
+
+// signDocumentURLs replaces the file urls of a Document that is not public with links expiring after ttl.
+// The url maps are replaced rather than written, as they may be shared with the stored record.
+// Public Documents and urls that can not be signed are left as is.
+func signDocumentURLs(doc *pbdoc.Document, public bool, s *signer.Signer, ttl time.Duration) {
+	if s == nil || doc == nil || public {
+		return
+	}
+	expires := time.Now().UTC().Add(ttl)
+	for _, urls := range []*map[string]string{&doc.ImageUrlsMap, &doc.AudioUrlsMap,
+		&doc.VideoUrlsMap, &doc.FileUrlsMap} {
+
+		if *urls == nil {
+			continue
+		}
+		signed := make(map[string]string, len(*urls))
+		for fuid, addr := range *urls {
+			signed[fuid] = addr
+			link, err := s.Sign(addr, expires)
+			if err != nil {
+				log.Error(consts.DocumentServiceTag, "Signing fuid:", fuid, "err:", err.Error())
+				continue
+			}
+			signed[fuid] = link
+		}
+		*urls = signed
+	}
+}
+
+// validateMediaURL checks a url against the pattern of its media type, any url is a valid file.
+// Returns the invalid url error of the media type, or consts.ErrMediaType for an unknown media type.
+func validateMediaURL(media pbdoc.FileType, url string) error {
+	url = strings.ToLower(url)
+	switch media {
+	case pbdoc.FileType_FILE:
+		return nil
+	case pbdoc.FileType_AUDIO:
+		if !audioRegex.MatchString(url) {
+			return consts.ErrInvalidDocumentAudioURL
+		}
+	case pbdoc.FileType_IMAGE:
+		if !imageRegex.MatchString(url) {
+			return consts.ErrInvalidDocumentImageURL
+		}
+	case pbdoc.FileType_VIDEO:
+		if !videoRegex.MatchString(url) {
+			return consts.ErrInvalidDocumentVideoURL
+		}
+	default:
+		return consts.ErrMediaType
+	}
+	return nil
+}
+
+// fileURLsMap returns the url map of the Document for the media type, made if missing.
+// Returns nil for an unknown media type.
+func fileURLsMap(doc *pbdoc.Document, media pbdoc.FileType) map[string]string {
+	switch media {
+	case pbdoc.FileType_FILE:
+		if doc.FileUrlsMap == nil {
+			doc.FileUrlsMap = make(map[string]string)
+		}
+		return doc.FileUrlsMap
+	case pbdoc.FileType_AUDIO:
+		if doc.AudioUrlsMap == nil {
+			doc.AudioUrlsMap = make(map[string]string)
+		}
+		return doc.AudioUrlsMap
+	case pbdoc.FileType_IMAGE:
+		if doc.ImageUrlsMap == nil {
+			doc.ImageUrlsMap = make(map[string]string)
+		}
+		return doc.ImageUrlsMap
+	case pbdoc.FileType_VIDEO:
+		if doc.VideoUrlsMap == nil {
+			doc.VideoUrlsMap = make(map[string]string)
+		}
+		return doc.VideoUrlsMap
 	}
-	_ = resp.Body.Close()
 	return nil
 }
+
+// findFileMedia returns the media type of the url map holding the fuid.
+func findFileMedia(doc *pbdoc.Document, fuid string) (pbdoc.FileType, bool) {
+	for _, media := range []pbdoc.FileType{pbdoc.FileType_FILE, pbdoc.FileType_AUDIO,
+		pbdoc.FileType_IMAGE, pbdoc.FileType_VIDEO} {
+
+		if _, ok := fileURLsMap(doc, media)[fuid]; ok {
+			return media, true
+		}
+	}
+	return pbdoc.FileType_FILE, false
+}
+
+// applyMediaChecks checks the files of the media type against the Document, like AddFileMetadata.
+// Returns an error if an audio file disagrees with the Document SamplingRate, and conf.Media rejects it.
+func applyMediaChecks(doc *pbdoc.Document, files map[string]*pbext.FileMetadata, media pbdoc.FileType) error {
+	switch media {
+	case pbdoc.FileType_AUDIO:
+		return applySamplingRate(doc, files, conf.Media.RejectSamplingRateMismatch)
+	case pbdoc.FileType_IMAGE:
+		applyCaptureChecks(doc, files, conf.Media.LocationTolerance, conf.Media.CaptureTimeTolerance)
+	}
+	return nil
+}
+
+// clearMediaWarnings removes the warnings of the checks of the media type from the file.
+func clearMediaWarnings(meta *pbext.FileMetadata, media pbdoc.FileType) {
+	if meta == nil {
+		return
+	}
+	switch media {
+	case pbdoc.FileType_AUDIO:
+		clearFileWarning(meta, samplingRateWarning)
+	case pbdoc.FileType_IMAGE:
+		clearFileWarning(meta, locationWarning)
+		clearFileWarning(meta, captureTimeWarning)
+	}
+}
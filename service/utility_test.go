@@ -2,9 +2,16 @@ package service
 
 import (
 	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
 	"github.com/hwsc-org/hwsc-document-svc/consts"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/hwsc-org/hwsc-document-svc/signer"
+	"github.com/hwsc-org/hwsc-document-svc/verifier"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
@@ -90,6 +97,32 @@ func TestNewFUID(t *testing.T) {
 	wg.Wait()
 }
 
+func TestLockDUID(t *testing.T) {
+	const duid = "0ujsszwN8NRY24YaXiTIE2VWDTS"
+	const count = 50
+	counter := 0
+
+	var wg sync.WaitGroup
+	wg.Add(count)
+	for i := 0; i < count; i++ {
+		go func() {
+			defer wg.Done()
+			lock := lockDUID(duid)
+			defer lock.Unlock()
+			counter++
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, count, counter)
+
+	// The lock of the duid is made once, and released by its holder
+	lock := lockDUID(duid)
+	stored, ok := duidClientLocker.Load(duid)
+	assert.True(t, ok)
+	assert.Equal(t, lock, stored)
+	lock.Unlock()
+}
+
 func TestValidateDocument(t *testing.T) {
 
 	cases := []struct {
@@ -1850,6 +1883,25 @@ func TestValidateRecordTimestamp(t *testing.T) {
 
 }
 
+func TestValidateEmbargoTimestamp(t *testing.T) {
+	now := time.Now().UTC().Unix()
+	cases := []struct {
+		input    int64
+		expected error
+	}{
+		{0, nil},
+		{now + 3600, nil},
+		{now + maxEmbargoDuration - 100, nil},
+		{now - 100, consts.ErrEmbargoTimestampPassed},
+		{minTimestamp, consts.ErrEmbargoTimestampPassed},
+		{now + maxEmbargoDuration + 100, consts.ErrEmbargoTimestampTooFar},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, ValidateEmbargoTimestamp(c.input), c.input)
+	}
+}
+
 func TestValidateCreateTimestamp(t *testing.T) {
 	cases := []struct {
 		inputCreateTimestamp int64
@@ -1911,14 +1963,14 @@ func TestIsStateAvailable(t *testing.T) {
 	serviceStateLocker.currentServiceState = unavailable
 	assert.Equal(t, unavailable, serviceStateLocker.currentServiceState)
 
-	ok := isStateAvailable()
+	ok := isStateAvailable(context.TODO())
 	assert.Equal(t, false, ok)
 
 	// test for availability
 	serviceStateLocker.currentServiceState = available
 	assert.Equal(t, available, serviceStateLocker.currentServiceState)
 
-	ok = isStateAvailable()
+	ok = isStateAvailable(context.TODO())
 	assert.Equal(t, true, ok)
 
 	// test race conditions
@@ -1933,7 +1985,7 @@ func TestIsStateAvailable(t *testing.T) {
 			<-start // blocks code below, until channel is closed
 
 			defer wg.Done()
-			_ = isStateAvailable()
+			_ = isStateAvailable(context.TODO())
 		}()
 	}
 
@@ -2437,3 +2489,304 @@ func TestValidateURL(t *testing.T) {
 	}
 
 }
+
+func TestInvalidURLError(t *testing.T) {
+	const addr = "https://hwscdevstorage.blob.core.windows.net/audios/error.wav"
+	cases := []struct {
+		err    error
+		errStr string
+	}{
+		{consts.ErrUnreachableURI, "invalid Document AudioURL: " + addr},
+		{consts.ErrContentTypeMismatch, "file content does not match the URL extension Document AudioURL: " + addr},
+	}
+
+	for _, c := range cases {
+		assert.EqualError(t, invalidURLError("AudioURL", addr, c.err), c.errStr)
+	}
+}
+
+func TestApplySamplingRate(t *testing.T) {
+	const (
+		fuid1 = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+		fuid2 = "4ff30392-8ec8-45a4-ba94-5e22c4a686df"
+	)
+	newFiles := func() map[string]*pbext.FileMetadata {
+		return map[string]*pbext.FileMetadata{
+			fuid1: {SampleRate: 8000},
+			fuid2: {SampleRate: 44100},
+		}
+	}
+	audioUrls := map[string]string{
+		fuid1: "https://hwscdevstorage.blob.core.windows.net/audios/tone_8000hz.wav",
+		fuid2: "https://hwscdevstorage.blob.core.windows.net/audios/tone_44100hz.flac",
+	}
+
+	// an unset SamplingRate is filled from the first audio file
+	doc := &pbdoc.Document{AudioUrlsMap: audioUrls}
+	files := newFiles()
+	assert.Nil(t, applySamplingRate(doc, files, false))
+	assert.Equal(t, uint32(8000), doc.GetSamplingRate())
+	assert.Nil(t, files[fuid1].GetWarnings())
+	assert.Equal(t, samplingRateWarning, files[fuid2].GetWarnings()[0].GetCode())
+
+	// fixing the SamplingRate clears the warning
+	doc.SamplingRate = 44100
+	assert.Nil(t, applySamplingRate(doc, files, false))
+	assert.Nil(t, files[fuid2].GetWarnings())
+	assert.Equal(t, 1, len(files[fuid1].GetWarnings()))
+
+	// a mismatch is rejected if configured
+	doc = &pbdoc.Document{AudioUrlsMap: audioUrls, SamplingRate: 100}
+	err := applySamplingRate(doc, newFiles(), true)
+	assert.EqualError(t, err, consts.ErrSamplingRateMismatch.Error()+" AudioURL: "+audioUrls[fuid1])
+
+	// files without a readable header are not compared
+	doc = &pbdoc.Document{AudioUrlsMap: audioUrls, SamplingRate: 100}
+	assert.Nil(t, applySamplingRate(doc, map[string]*pbext.FileMetadata{}, true))
+}
+
+func TestInspectURLAudio(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("test_fixtures/audio")))
+	defer server.Close()
+
+	defaultVerifier := urlVerifier
+	urlVerifier = verifier.NewHTTP(verifier.Policy{AllowPrivate: true}, time.Second)
+	defer func() { urlVerifier = defaultVerifier }()
+
+	cases := []struct {
+		file       string
+		sampleRate uint32
+		channels   uint32
+		bitDepth   uint32
+		duration   float64
+	}{
+		{"tone_8000hz.wav", 8000, 1, 16, 0.25},
+		{"tone_44100hz.flac", 44100, 2, 16, 2},
+		{"tone_48000hz.ogg", 48000, 2, 0, 0},
+	}
+
+	for _, c := range cases {
+		meta, err := inspectURL(context.Background(), server.URL+"/"+c.file)
+		assert.Nil(t, err, c.file)
+		assert.Equal(t, c.sampleRate, meta.GetSampleRate(), c.file)
+		assert.Equal(t, c.channels, meta.GetChannels(), c.file)
+		assert.Equal(t, c.bitDepth, meta.GetBitDepth(), c.file)
+		assert.Equal(t, c.duration, meta.GetDuration(), c.file)
+	}
+}
+
+func TestApplyCaptureChecks(t *testing.T) {
+	const fuid = "4ff30392-8ec8-45a4-ba94-5e22c4a686de"
+	imageUrls := map[string]string{
+		fuid: "https://hwscdevstorage.blob.core.windows.net/images/cabo_gps.jpg",
+	}
+	// Cabo San Lucas, 2014-03-13 18:23:13 UTC
+	file := &pbext.FileMetadata{
+		CaptureTimestamp: 1394734993,
+		Location:         &pbext.FileLocation{Latitude: 22.89, Longitude: -109.91},
+	}
+	files := map[string]*pbext.FileMetadata{fuid: file}
+
+	cases := []struct {
+		latitude        float32
+		longitude       float32
+		recordTimestamp int64
+		warnings        []string
+	}{
+		{22.89, -109.91, 1394734993, nil},
+		{22.9, -109.9, 1394734993 + 3600, nil},
+		{32.7157, -117.1611, 1394734993, []string{locationWarning}},
+		{22.89, -109.91, 1394734993 + 3*86400, []string{captureTimeWarning}},
+		{32.7157, -117.1611, 1394734993 - 3*86400, []string{captureTimeWarning, locationWarning}},
+		{22.89, -109.91, 1394734993, nil},
+	}
+
+	for _, c := range cases {
+		doc := &pbdoc.Document{
+			ImageUrlsMap:    imageUrls,
+			Latitude:        c.latitude,
+			Longitude:       c.longitude,
+			RecordTimestamp: c.recordTimestamp,
+		}
+		applyCaptureChecks(doc, files, 10, 24*time.Hour)
+
+		var codes []string
+		for _, w := range file.GetWarnings() {
+			codes = append(codes, w.GetCode())
+		}
+		assert.Equal(t, c.warnings, codes, c)
+	}
+}
+
+func TestHaversineDistance(t *testing.T) {
+	// Cabo San Lucas to San Diego
+	km := haversineDistance(22.89, -109.91, 32.7157, -117.1611)
+	assert.InDelta(t, 1307, km, 5)
+	assert.Equal(t, 0.0, haversineDistance(22.89, -109.91, 22.89, -109.91))
+}
+
+func TestInspectURLImage(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("test_fixtures/images")))
+	defer server.Close()
+
+	defaultVerifier := urlVerifier
+	urlVerifier = verifier.NewHTTP(verifier.Policy{AllowPrivate: true}, time.Second)
+	defer func() { urlVerifier = defaultVerifier }()
+
+	meta, err := inspectURL(context.Background(), server.URL+"/cabo_gps.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, "image/jpeg", meta.GetDetectedType())
+	assert.Equal(t, int64(1394734993), meta.GetCaptureTimestamp())
+	assert.InDelta(t, 22.89, meta.GetLocation().GetLatitude(), 1e-9)
+	assert.InDelta(t, -109.91, meta.GetLocation().GetLongitude(), 1e-9)
+	assert.Equal(t, linkStatusOK, meta.GetLinkStatus())
+	assert.NotZero(t, meta.GetLastCheckedTimestamp())
+}
+
+func TestNewURLSigner(t *testing.T) {
+	cases := []struct {
+		config   conf.URLSignerConfig
+		isNil    bool
+		expected error
+	}{
+		{conf.URLSignerConfig{}, true, nil},
+		{conf.URLSignerConfig{Keys: []string{"k1:secret1", "k2:secret:2"}}, false, nil},
+		{conf.URLSignerConfig{Keys: []string{"k1:secret1", "k2:secret2"}, CurrentKey: "k2"}, false, nil},
+		{conf.URLSignerConfig{Keys: []string{"k1:secret1"}, CurrentKey: "k2"}, true, consts.ErrSigningKeyUnknown},
+		{conf.URLSignerConfig{Keys: []string{"secret1"}}, true, consts.ErrInvalidSigningKey},
+	}
+
+	for _, c := range cases {
+		s, err := newURLSigner(c.config)
+		assert.Equal(t, c.expected, err, c.config)
+		assert.Equal(t, c.isNil, s == nil, c.config)
+	}
+}
+
+func TestSignDocumentURLs(t *testing.T) {
+	const addr = "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"
+	s, err := signer.New([]signer.Key{{ID: "k1", Secret: []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		public bool
+		signer *signer.Signer
+		signed bool
+	}{
+		{false, s, true},
+		{true, s, false},
+		{false, nil, false},
+	}
+
+	for _, c := range cases {
+		doc := &pbdoc.Document{
+			ImageUrlsMap: map[string]string{"a": addr},
+			FileUrlsMap:  map[string]string{"b": addr},
+		}
+		stored := doc.GetImageUrlsMap()
+		signDocumentURLs(doc, c.public, c.signer, time.Minute)
+		// The maps of the stored record are left as is
+		assert.Equal(t, addr, stored["a"])
+		for _, u := range []string{doc.GetImageUrlsMap()["a"], doc.GetFileUrlsMap()["b"]} {
+			if c.signed {
+				assert.NotEqual(t, addr, u)
+				assert.Nil(t, s.Verify(u, time.Now()))
+			} else {
+				assert.Equal(t, addr, u)
+			}
+		}
+	}
+}
+
+func TestValidateURLsStripSignature(t *testing.T) {
+	addr := testStorage + "/audios/Seger_Conga_CaboMexico_Tag_Acousonde_20140313_112313_8000_3_BreedingMigrating.wav"
+	s, err := signer.New([]signer.Key{{ID: "k1", Secret: []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	signed, err := s.Sign(addr, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+
+	urls := map[string]string{"4ff30392-8ec8-45a4-ba94-5e22c4a686de": signed}
+	assert.Nil(t, ValidateAudioURLs(urls))
+	assert.Equal(t, addr, urls["4ff30392-8ec8-45a4-ba94-5e22c4a686de"])
+}
+
+func TestValidateMediaURL(t *testing.T) {
+	cases := []struct {
+		media    pbdoc.FileType
+		url      string
+		expected error
+	}{
+		{pbdoc.FileType_FILE, "https://hwscdevstorage.blob.core.windows.net/files/a.tif", nil},
+		{pbdoc.FileType_IMAGE, "https://hwscdevstorage.blob.core.windows.net/files/a.tif", nil},
+		{pbdoc.FileType_AUDIO, "https://hwscdevstorage.blob.core.windows.net/files/a.tif", consts.ErrInvalidDocumentAudioURL},
+		{pbdoc.FileType_VIDEO, "https://hwscdevstorage.blob.core.windows.net/files/a.tif", consts.ErrInvalidDocumentVideoURL},
+		{pbdoc.FileType_IMAGE, "https://hwscdevstorage.blob.core.windows.net/audios/a.wav", consts.ErrInvalidDocumentImageURL},
+		{pbdoc.FileType_AUDIO, "https://hwscdevstorage.blob.core.windows.net/audios/a.wav", nil},
+		{pbdoc.FileType_VIDEO, "https://hwscdevstorage.blob.core.windows.net/videos/a.mp4", nil},
+		{pbdoc.FileType_AUDIO, "https://hwscdevstorage.blob.core.windows.net/Audios/A.WAV", nil},
+		{pbdoc.FileType_VIDEO, "https://hwscdevstorage.blob.core.windows.net/Videos/A.MP4", nil},
+		{4, "https://hwscdevstorage.blob.core.windows.net/files/a.tif", consts.ErrMediaType},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, validateMediaURL(c.media, c.url), c.url)
+	}
+}
+
+func TestFindFileMedia(t *testing.T) {
+	doc := &pbdoc.Document{
+		ImageUrlsMap: map[string]string{"a": "https://hwscdevstorage.blob.core.windows.net/images/a.jpg"},
+		AudioUrlsMap: map[string]string{"b": "https://hwscdevstorage.blob.core.windows.net/audios/b.wav"},
+		FileUrlsMap:  map[string]string{"c": "https://hwscdevstorage.blob.core.windows.net/files/c.tif"},
+	}
+
+	cases := []struct {
+		fuid     string
+		media    pbdoc.FileType
+		expFound bool
+	}{
+		{"a", pbdoc.FileType_IMAGE, true},
+		{"b", pbdoc.FileType_AUDIO, true},
+		{"c", pbdoc.FileType_FILE, true},
+		{"d", pbdoc.FileType_FILE, false},
+	}
+
+	for _, c := range cases {
+		media, ok := findFileMedia(doc, c.fuid)
+		assert.Equal(t, c.expFound, ok, c.fuid)
+		assert.Equal(t, c.media, media, c.fuid)
+	}
+
+	// the missing video map is made
+	fileURLsMap(doc, pbdoc.FileType_VIDEO)["d"] = "https://hwscdevstorage.blob.core.windows.net/videos/d.mp4"
+	media, ok := findFileMedia(doc, "d")
+	assert.True(t, ok)
+	assert.Equal(t, pbdoc.FileType_VIDEO, media)
+	assert.Nil(t, fileURLsMap(doc, 4))
+}
+
+func TestClearMediaWarnings(t *testing.T) {
+	cases := []struct {
+		media    pbdoc.FileType
+		expCodes []string
+	}{
+		{pbdoc.FileType_AUDIO, []string{exifWarning, locationWarning, captureTimeWarning}},
+		{pbdoc.FileType_IMAGE, []string{exifWarning, samplingRateWarning}},
+		{pbdoc.FileType_FILE, []string{exifWarning, samplingRateWarning, locationWarning, captureTimeWarning}},
+	}
+
+	for _, c := range cases {
+		meta := &pbext.FileMetadata{}
+		for _, code := range []string{exifWarning, samplingRateWarning, locationWarning, captureTimeWarning} {
+			setFileWarning(meta, code, code)
+		}
+		clearMediaWarnings(meta, c.media)
+
+		var codes []string
+		for _, w := range meta.GetWarnings() {
+			codes = append(codes, w.GetCode())
+		}
+		assert.Equal(t, c.expCodes, codes, c.media.String())
+	}
+	clearMediaWarnings(nil, pbdoc.FileType_AUDIO)
+}
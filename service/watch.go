@@ -0,0 +1,533 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	log "github.com/hwsc-org/hwsc-document-svc/logger"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/net/context"
+	"strings"
+	"time"
+)
+
+const (
+	// watchSummaryField is the field of the outbox events holding the summary of their Document
+	watchSummaryField = "fullDocument.summary."
+	// watchSettleLag delays the polled changes, so the records of slower concurrent calls are not skipped
+	watchSettleLag = 2 * time.Second
+	// watchPollBatch is the number of changes read by a poll
+	watchPollBatch = 100
+)
+
+// changeTypes are the changes notified for the types of events, every other type updates a Document
+var changeTypes = map[string]pbext.DocumentChangeType{
+	outbox.DocumentCreated: pbext.DocumentChangeType_CREATED,
+	outbox.DocumentDeleted: pbext.DocumentChangeType_DELETED,
+}
+
+// auditChangeTypes are the changes notified for the audited operations, every other operation updates a Document
+var auditChangeTypes = map[string]pbext.DocumentChangeType{
+	"CreateDocument": pbext.DocumentChangeType_CREATED,
+	"DeleteDocument": pbext.DocumentChangeType_DELETED,
+}
+
+// watchSummary is the state of a Document written with its events to the outbox, the watches selecting the
+// events by it. The fields are named as in the Document collection, so the QueryDocument parameters apply to them.
+type watchSummary struct {
+	Uuid                  string                 `bson:"uuid"`
+	PublisherName         *pbdoc.Publisher       `bson:"publisherName"`
+	StudySite             *pbdoc.StudySite       `bson:"studySite"`
+	CallTypeName          string                 `bson:"callTypeName"`
+	GroundType            string                 `bson:"groundType"`
+	SensorType            string                 `bson:"sensorType"`
+	SensorName            string                 `bson:"sensorName"`
+	RecordTimestamp       int64                  `bson:"recordTimestamp"`
+	IsPublic              bool                   `bson:"isPublic"`
+	EmbargoUntilTimestamp int64                  `bson:"embargoUntilTimestamp"`
+	Shares                []*pbext.DocumentShare `bson:"shares"`
+}
+
+// watchEvent is an outbox event as notified by the change stream of the outbox, or as polled without a resume token
+type watchEvent struct {
+	ResumeToken  bson.Raw     `bson:"_id"`
+	FullDocument outbox.Event `bson:"fullDocument"`
+}
+
+// watchCursor is the position of a watch after a change, sent to the clients as their resume token
+type watchCursor struct {
+	// Stream is the resume token of the change stream of the outbox, unset for a polled change
+	Stream bson.Raw `bson:"stream,omitempty"`
+	// After is the id of the outbox event, or of the audit record, of the change
+	After primitive.ObjectID `bson:"after"`
+}
+
+// auditWatchFilter selects the changes of the audit log notified to a watcher, if the outbox is disabled
+type auditWatchFilter struct {
+	caller *auth.Caller
+	uuid   string
+	duids  map[string]bool
+	query  *pbdoc.QueryTransaction
+}
+
+// summarizeForWatch returns the summary of the Document selecting its events.
+func summarizeForWatch(record *documentRecord) *watchSummary {
+	return &watchSummary{
+		Uuid:                  record.GetUuid(),
+		PublisherName:         record.GetPublisherName(),
+		StudySite:             record.GetStudySite(),
+		CallTypeName:          record.GetCallTypeName(),
+		GroundType:            record.GetGroundType(),
+		SensorType:            record.GetSensorType(),
+		SensorName:            record.GetSensorName(),
+		RecordTimestamp:       record.GetRecordTimestamp(),
+		IsPublic:              record.GetIsPublic(),
+		EmbargoUntilTimestamp: record.EmbargoUntilTimestamp,
+		Shares:                record.Shares,
+	}
+}
+
+// newWatchFilter returns the filter of the change stream of the outbox selecting the events of the request
+// the caller may read, by the summary of their Document as written, or as deleted.
+// Returns an error if the uuid, a duid, or the query of the request is invalid.
+func newWatchFilter(caller *auth.Caller, req *pbext.WatchDocumentsRequest) (bson.M, error) {
+	conditions := bson.A{bson.M{"operationType": "insert"}}
+	if req.GetUuid() != "" {
+		if err := ValidateUUID(req.GetUuid()); err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, bson.M{watchSummaryField + "uuid": req.GetUuid()})
+	}
+	if len(req.GetDuids()) > 0 {
+		duids := bson.A{}
+		for _, duid := range req.GetDuids() {
+			if err := ValidateDUID(duid); err != nil {
+				return nil, err
+			}
+			duids = append(duids, duid)
+		}
+		conditions = append(conditions, bson.M{"fullDocument.duid": bson.M{"$in": duids}})
+	}
+	if req.GetQuery() != nil {
+		if err := ValidateRecordTimestamp(req.GetQuery().GetMinRecordTimestamp()); err != nil {
+			return nil, err
+		}
+		if err := ValidateRecordTimestamp(req.GetQuery().GetMaxRecordTimestamp()); err != nil {
+			return nil, err
+		}
+		pipeline, err := buildAggregatePipeline(req.GetQuery())
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, prefixFilter(pipeline[0].(bson.M)["$match"].(bson.M), watchSummaryField))
+	}
+	if !isAdmin(caller) {
+		conditions = append(conditions, watchVisibilityFilter(caller))
+	}
+	return bson.M{"$and": conditions}, nil
+}
+
+// watchVisibilityFilter returns the filter of the outbox events whose Document the caller could read when they
+// were written: public and not embargoed, its own, or shared with it.
+func watchVisibilityFilter(caller *auth.Caller) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{
+			watchSummaryField + "isPublic": true,
+			"$expr": bson.M{"$lte": bson.A{
+				bson.M{"$ifNull": bson.A{"$" + watchSummaryField + "embargoUntilTimestamp", 0}},
+				"$fullDocument.timestamp",
+			}},
+		},
+		bson.M{watchSummaryField + "uuid": caller.UUID},
+		bson.M{watchSummaryField + "shares": bson.M{"$elemMatch": sharedWithFilter(caller)}},
+	}}
+}
+
+// prefixFilter returns the filter matching its fields under the prefix, down the $and, $or, and $nor of the filter.
+func prefixFilter(filter bson.M, prefix string) bson.M {
+	prefixed := bson.M{}
+	for key, value := range filter {
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			clauses := bson.A{}
+			for _, clause := range value.(bson.A) {
+				clauses = append(clauses, prefixFilter(clause.(bson.M), prefix))
+			}
+			prefixed[key] = clauses
+		case strings.HasPrefix(key, "$"):
+			prefixed[key] = value
+		default:
+			prefixed[prefix+key] = value
+		}
+	}
+	return prefixed
+}
+
+// newAuditWatchFilter returns the filter of the audit log selecting the changes of the request for the caller,
+// the request being validated by newWatchFilter.
+func newAuditWatchFilter(caller *auth.Caller, req *pbext.WatchDocumentsRequest) *auditWatchFilter {
+	filter := &auditWatchFilter{caller: caller, uuid: req.GetUuid(), query: req.GetQuery()}
+	if len(req.GetDuids()) > 0 {
+		filter.duids = make(map[string]bool, len(req.GetDuids()))
+		for _, duid := range req.GetDuids() {
+			filter.duids[duid] = true
+		}
+	}
+	return filter
+}
+
+// encodeResumeToken returns the cursor as sent to the clients.
+// Returns an error if the cursor fails to encode.
+func encodeResumeToken(cursor *watchCursor) (string, error) {
+	b, err := bson.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeResumeToken returns the cursor sent by a client, nil for an empty token.
+// Returns consts.ErrInvalidResumeToken if the token is malformed.
+func decodeResumeToken(token string) (*watchCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, consts.ErrInvalidResumeToken
+	}
+	cursor := &watchCursor{}
+	if err := bson.Unmarshal(b, cursor); err != nil || cursor.After.IsZero() {
+		return nil, consts.ErrInvalidResumeToken
+	}
+	if cursor.Stream != nil && cursor.Stream.Validate() != nil {
+		return nil, consts.ErrInvalidResumeToken
+	}
+	return cursor, nil
+}
+
+// pollAfter returns the id the polls of the cursor start after, the ids generated before now for a new watch.
+func pollAfter(cursor *watchCursor, now time.Time) primitive.ObjectID {
+	if cursor == nil {
+		return objectIDAt(now)
+	}
+	return cursor.After
+}
+
+// objectIDAt returns the lowest ObjectID generated at the time t.
+func objectIDAt(t time.Time) primitive.ObjectID {
+	var id primitive.ObjectID
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	return id
+}
+
+// lastObjectIDAt returns the highest ObjectID generated at the time t.
+func lastObjectIDAt(t time.Time) primitive.ObjectID {
+	id := objectIDAt(t)
+	for i := 4; i < len(id); i++ {
+		id[i] = 0xff
+	}
+	return id
+}
+
+// watchOutbox passes the outbox events of the filter written after the cursor to send, in order, until ctx is done.
+// Follows the change stream of the outbox, replaying the events written after a polled cursor first,
+// or polls the outbox every interval if the change stream can not be opened, as on a standalone MongoDB.
+// A nil cursor watches the events written from now.
+// Returns the error of send, or of MongoDB.
+func watchOutbox(ctx context.Context, filter bson.M, cursor *watchCursor, interval time.Duration,
+	send func(event *watchEvent) error) error {
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	option := options.ChangeStream()
+	if cursor != nil && cursor.Stream != nil {
+		option.SetResumeAfter(cursor.Stream)
+	}
+	stream, err := collection.Watch(ctx, bson.A{bson.M{"$match": filter}}, option)
+	if err != nil {
+		log.InfoContext(ctx, consts.WatchDocumentsTag, "Change streams unavailable, polling the outbox: "+err.Error())
+		return pollChanges(ctx, pollAfter(cursor, time.Now()), interval,
+			func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error) {
+				read, err := readOutbox(ctx, collection, bson.M{"$gt": after, "$lte": until}, filter, watchPollBatch,
+					func(event *watchEvent) error {
+						after = event.FullDocument.ID
+						return send(event)
+					})
+				return after, read, err
+			})
+	}
+	defer stream.Close(context.Background())
+
+	// The stream is opened first, so the events written during the replay are not missed
+	replayed := make(map[primitive.ObjectID]bool)
+	if cursor != nil && cursor.Stream == nil {
+		if _, err := readOutbox(ctx, collection, bson.M{"$gt": cursor.After}, filter, 0,
+			func(event *watchEvent) error {
+				replayed[event.FullDocument.ID] = true
+				return send(event)
+			}); err != nil {
+			return err
+		}
+	}
+
+	for stream.Next(ctx) {
+		event := &watchEvent{}
+		if err := stream.Decode(event); err != nil {
+			return err
+		}
+		if replayed[event.FullDocument.ID] {
+			continue
+		}
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
+
+// readOutbox passes the outbox events with the ids and of the filter to send, in order, at most limit if it is not 0.
+// The events are shaped as notified by the change stream, so the filter of the change stream selects them.
+// Returns the number of events passed, and the error of send, or of MongoDB.
+func readOutbox(ctx context.Context, collection *mongo.Collection, ids bson.M, filter bson.M, limit int64,
+	send func(event *watchEvent) error) (int, error) {
+
+	pipeline := bson.A{
+		bson.M{"$match": bson.M{"_id": ids}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$replaceRoot": bson.M{"newRoot": bson.M{"operationType": "insert", "fullDocument": "$$ROOT"}}},
+		bson.M{"$match": filter},
+	}
+	if limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": limit})
+	}
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(context.Background())
+
+	read := 0
+	for cur.Next(ctx) {
+		event := &watchEvent{}
+		if err := cur.Decode(event); err != nil {
+			return read, err
+		}
+		read++
+		if err := send(event); err != nil {
+			return read, err
+		}
+	}
+	return read, cur.Err()
+}
+
+// pollAuditLog passes the successful audit records written after the id to send, in order,
+// reading the audit log every interval until ctx is done.
+// Returns the error of send, or of MongoDB.
+func pollAuditLog(ctx context.Context, after primitive.ObjectID, interval time.Duration,
+	send func(record *auditRecord) error) error {
+
+	collection := mongoDBReader.Database(conf.DocumentDB.Name).Collection(conf.AuditCollection)
+	return pollChanges(ctx, after, interval,
+		func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error) {
+			cur, err := collection.Find(ctx, bson.M{"_id": bson.M{"$gt": after, "$lte": until}, "code": "OK"},
+				options.Find().SetSort(bson.M{"_id": 1}).SetLimit(watchPollBatch))
+			if err != nil {
+				return after, 0, err
+			}
+			defer cur.Close(context.Background())
+
+			read := 0
+			for cur.Next(ctx) {
+				record := &auditRecord{}
+				if err := cur.Decode(record); err != nil {
+					return after, read, err
+				}
+				read++
+				after = record.ID
+				if err := send(record); err != nil {
+					return after, read, err
+				}
+			}
+			return after, read, cur.Err()
+		})
+}
+
+// pollChanges reads the changes written after the id by read every interval, until ctx is done.
+// Each read is bounded by the ids generated watchSettleLag ago, and returns the id of the last change it read
+// and their number, the next read following at once if it read a full batch.
+// Returns the error of read.
+func pollChanges(ctx context.Context, after primitive.ObjectID, interval time.Duration,
+	read func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error)) error {
+
+	for {
+		until := lastObjectIDAt(time.Now().Add(-watchSettleLag))
+		last, n, err := read(after, until)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if n == watchPollBatch {
+			after = last
+			continue
+		}
+		if bytes.Compare(until[:], after[:]) > 0 {
+			after = until
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// changeOf returns the change notified for the outbox event, with the Document as written by the change
+// and its urls signed at the unix time now, or without a Document for a deletion.
+// Returns an error if the Document of the event fails to decode.
+func changeOf(event *watchEvent, now int64) (*pbext.DocumentChange, error) {
+	token, err := encodeResumeToken(&watchCursor{Stream: event.ResumeToken, After: event.FullDocument.ID})
+	if err != nil {
+		return nil, err
+	}
+	change := &pbext.DocumentChange{
+		Type:        pbext.DocumentChangeType_UPDATED,
+		Duid:        event.FullDocument.Duid,
+		Operation:   event.FullDocument.Type,
+		Timestamp:   event.FullDocument.Timestamp,
+		ResumeToken: token,
+	}
+	if changeType, ok := changeTypes[event.FullDocument.Type]; ok {
+		change.Type = changeType
+	}
+	if change.Type == pbext.DocumentChangeType_DELETED {
+		return change, nil
+	}
+
+	record := &documentRecord{}
+	if err := json.Unmarshal(event.FullDocument.Document, record); err != nil {
+		return nil, err
+	}
+	document, err := record.toDocument()
+	if err != nil {
+		return nil, err
+	}
+	signDocumentURLs(document, record.isPublicAt(now), urlSigner, conf.URLSigner.TTL)
+	change.Document = document
+	return change, nil
+}
+
+// changeOf returns the change notified for the audit record, reading the Document as it is now.
+// Returns nil if the filter does not select the change, or the caller may not read the Document.
+// Returns an error if the Document can not be read.
+func (f *auditWatchFilter) changeOf(ctx context.Context, collection *mongo.Collection,
+	record *auditRecord, now int64) (*pbext.DocumentChange, error) {
+
+	if record.Duid == "" || (f.duids != nil && !f.duids[record.Duid]) {
+		return nil, nil
+	}
+	token, err := encodeResumeToken(&watchCursor{After: record.ID})
+	if err != nil {
+		return nil, err
+	}
+	change := &pbext.DocumentChange{
+		Type:        pbext.DocumentChangeType_UPDATED,
+		Duid:        record.Duid,
+		Operation:   record.Operation,
+		Timestamp:   record.Timestamp,
+		ResumeToken: token,
+	}
+	if changeType, ok := auditChangeTypes[record.Operation]; ok {
+		change.Type = changeType
+	}
+
+	if change.Type == pbext.DocumentChangeType_DELETED {
+		// A deleted Document is known by its summary only
+		if record.Before == nil || (f.uuid != "" && record.Before.Uuid != f.uuid) ||
+			!summaryReadableBy(f.caller, record.Before, now) {
+			return nil, nil
+		}
+		return change, nil
+	}
+
+	stored, err := findDocumentRecord(ctx, collection, record.Duid)
+	if err == mongo.ErrNoDocuments {
+		// Deleted since, its deletion follows
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if (f.uuid != "" && stored.GetUuid() != f.uuid) || !readableBy(f.caller, stored, now) {
+		return nil, nil
+	}
+	if f.query != nil {
+		ok, err := matchesQuery(ctx, collection, f.query, record.Duid)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+
+	document, err := stored.toDocument()
+	if err != nil {
+		return nil, err
+	}
+	signDocumentURLs(document, stored.isPublicAt(now), urlSigner, conf.URLSigner.TTL)
+	change.Document = document
+	return change, nil
+}
+
+// matchesQuery returns true if the Document with the duid matches the QueryDocument parameters.
+// Returns an error if the Document can not be read.
+func matchesQuery(ctx context.Context, collection *mongo.Collection, query *pbdoc.QueryTransaction,
+	duid string) (bool, error) {
+
+	pipeline, err := buildAggregatePipeline(query)
+	if err != nil {
+		return false, err
+	}
+	pipeline = append(pipeline, bson.M{"$match": bson.M{"duid": duid}}, bson.M{"$limit": 1})
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(context.Background())
+	found := cur.Next(ctx)
+	return found, cur.Err()
+}
+
+// summaryReadableBy returns true if the caller could read the Document of the summary at the unix time now.
+func summaryReadableBy(caller *auth.Caller, summary *auditSummary, now int64) bool {
+	if isAdmin(caller) || caller.UUID == summary.Uuid {
+		return true
+	}
+	if summary.IsPublic && summary.EmbargoUntilTimestamp <= now {
+		return true
+	}
+	for _, share := range summary.Shares {
+		grantee := strings.SplitN(share, "=", 2)[0]
+		if grantee == "uuid:"+caller.UUID {
+			return true
+		}
+		for _, group := range caller.Groups {
+			if grantee == "group:"+group {
+				return true
+			}
+		}
+	}
+	return false
+}
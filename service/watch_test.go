@@ -0,0 +1,348 @@
+package service
+
+import (
+	"encoding/base64"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/hwsc-org/hwsc-document-svc/auth"
+	"github.com/hwsc-org/hwsc-document-svc/conf"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/outbox"
+	pbext "github.com/hwsc-org/hwsc-document-svc/protobuf/ext"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+// fakeWatchStream collects the changes sent on a watch
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	changes chan *pbext.DocumentChange
+}
+
+func (s *fakeWatchStream) Context() context.Context { return s.ctx }
+func (s *fakeWatchStream) Send(change *pbext.DocumentChange) error {
+	s.changes <- change
+	return nil
+}
+
+func TestNewWatchFilter(t *testing.T) {
+	cases := []struct {
+		caller     *auth.Caller
+		req        *pbext.WatchDocumentsRequest
+		conditions int
+		isErr      bool
+	}{
+		{owner, &pbext.WatchDocumentsRequest{}, 2, false},
+		{admin, &pbext.WatchDocumentsRequest{}, 1, false},
+		{owner, &pbext.WatchDocumentsRequest{Uuid: ownerUUID, Duids: []string{"1ChHfqAsJRZOlTsg2majVXK7wxT"}}, 4, false},
+		{owner, &pbext.WatchDocumentsRequest{Query: &pbdoc.QueryTransaction{MinRecordTimestamp: minTimestamp, MaxRecordTimestamp: 1546300800}}, 3, false},
+		{owner, &pbext.WatchDocumentsRequest{Uuid: "invalid"}, 0, true},
+		{owner, &pbext.WatchDocumentsRequest{Duids: []string{"invalid"}}, 0, true},
+		{owner, &pbext.WatchDocumentsRequest{Query: &pbdoc.QueryTransaction{MaxRecordTimestamp: -1}}, 0, true},
+	}
+
+	for _, c := range cases {
+		filter, err := newWatchFilter(c.caller, c.req)
+		assert.Equal(t, c.isErr, err != nil, c.req.String())
+		if err == nil {
+			assert.Len(t, filter["$and"], c.conditions, c.req.String())
+		}
+	}
+
+	// Deletions are selected by the query and the visibility of the Document as deleted too
+	filter, err := newWatchFilter(other, &pbext.WatchDocumentsRequest{
+		Query: &pbdoc.QueryTransaction{
+			SensorTypes:        []string{"hydrophone"},
+			MinRecordTimestamp: minTimestamp,
+			MaxRecordTimestamp: 1546300800,
+		},
+	})
+	assert.Nil(t, err)
+	conditions := filter["$and"].(bson.A)
+	assert.Equal(t, bson.M{"operationType": "insert"}, conditions[0])
+	query := conditions[1].(bson.M)["$and"].(bson.A)
+	assert.Contains(t, query, bson.M{watchSummaryField + "sensorType": bson.M{"$in": bson.A{"hydrophone"}}})
+	assert.Equal(t, watchVisibilityFilter(other), conditions[2])
+}
+
+func TestPrefixFilter(t *testing.T) {
+	filter := bson.M{
+		"uuid": ownerUUID,
+		"$or": bson.A{
+			bson.M{"isPublic": true},
+			bson.M{"$and": bson.A{bson.M{"shares": bson.M{"$elemMatch": bson.M{"uuid": otherUUID}}}}},
+		},
+		"$expr": bson.M{"$eq": bson.A{1, 1}},
+	}
+	assert.Equal(t, bson.M{
+		"p.uuid": ownerUUID,
+		"$or": bson.A{
+			bson.M{"p.isPublic": true},
+			bson.M{"$and": bson.A{bson.M{"p.shares": bson.M{"$elemMatch": bson.M{"uuid": otherUUID}}}}},
+		},
+		"$expr": bson.M{"$eq": bson.A{1, 1}},
+	}, prefixFilter(filter, "p."))
+}
+
+func TestResumeToken(t *testing.T) {
+	raw, err := bson.Marshal(bson.M{"_data": "825CA2A0A5000000012B022C0100296E5A1004"})
+	assert.Nil(t, err)
+	id := primitive.NewObjectID()
+
+	// Streamed and polled changes resume after their event, by the change stream or by the polls
+	for _, cursor := range []*watchCursor{{Stream: raw, After: id}, {After: id}} {
+		token, err := encodeResumeToken(cursor)
+		assert.Nil(t, err)
+		decoded, err := decodeResumeToken(token)
+		assert.Nil(t, err)
+		assert.Equal(t, cursor, decoded)
+	}
+
+	cursor, err := decodeResumeToken("")
+	assert.Nil(t, err)
+	assert.Nil(t, cursor)
+
+	noID, err := encodeResumeToken(&watchCursor{Stream: raw})
+	assert.Nil(t, err)
+	for _, invalid := range []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3}), noID} {
+		_, err = decodeResumeToken(invalid)
+		assert.Equal(t, consts.ErrInvalidResumeToken, err, invalid)
+	}
+}
+
+func TestPollAfter(t *testing.T) {
+	now := time.Unix(1546300800, 0)
+	id := primitive.NewObjectID()
+	assert.Equal(t, "5c2aad800000000000000000", pollAfter(nil, now).Hex())
+	assert.Equal(t, "5c2aad80ffffffffffffffff", lastObjectIDAt(now).Hex())
+	assert.Equal(t, id, pollAfter(&watchCursor{After: id}, now))
+}
+
+func TestPollChanges(t *testing.T) {
+	start := objectIDAt(time.Now().Add(-time.Hour))
+	last := objectIDAt(time.Now().Add(-time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	var afters, untils []primitive.ObjectID
+	err := pollChanges(ctx, start, time.Millisecond,
+		func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error) {
+			afters = append(afters, after)
+			untils = append(untils, until)
+			switch len(afters) {
+			case 1:
+				// A full batch is followed at once by the next
+				return last, watchPollBatch, nil
+			case 3:
+				cancel()
+			}
+			return after, 1, nil
+		})
+	assert.Nil(t, err)
+	if assert.Len(t, afters, 3) {
+		assert.Equal(t, []primitive.ObjectID{start, last, untils[1]}, afters)
+	}
+
+	// A cursor ahead of the settled changes is not moved back
+	ahead := objectIDAt(time.Now().Add(time.Hour))
+	ctx, cancel = context.WithCancel(context.Background())
+	afters = nil
+	err = pollChanges(ctx, ahead, time.Millisecond,
+		func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error) {
+			afters = append(afters, after)
+			if len(afters) == 2 {
+				cancel()
+			}
+			return after, 0, nil
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, []primitive.ObjectID{ahead, ahead}, afters)
+
+	err = pollChanges(context.Background(), start, time.Millisecond,
+		func(after primitive.ObjectID, until primitive.ObjectID) (primitive.ObjectID, int, error) {
+			return after, 0, consts.ErrServiceUnavailable
+		})
+	assert.Equal(t, consts.ErrServiceUnavailable, err)
+}
+
+func TestChangeOf(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	raw, err := bson.Marshal(bson.M{"_data": "825CA2A0A5000000012B022C0100296E5A1004"})
+	assert.Nil(t, err)
+	record := &documentRecord{Document: pbdoc.Document{Duid: duid, Uuid: ownerUUID, IsPublic: true}}
+
+	cases := []struct {
+		eventType   string
+		resumeToken bson.Raw
+		changeType  pbext.DocumentChangeType
+	}{
+		{outbox.DocumentCreated, raw, pbext.DocumentChangeType_CREATED},
+		{outbox.FileAdded, raw, pbext.DocumentChangeType_UPDATED},
+		{outbox.DocumentDeleted, raw, pbext.DocumentChangeType_DELETED},
+		{outbox.FileAdded, nil, pbext.DocumentChangeType_UPDATED},
+	}
+
+	for _, c := range cases {
+		event, err := outbox.NewEvent(c.eventType, duid, "", record, time.Unix(1546300800, 0))
+		assert.Nil(t, err)
+		change, err := changeOf(&watchEvent{ResumeToken: c.resumeToken, FullDocument: *event}, 1546300800)
+		assert.Nil(t, err)
+		assert.Equal(t, c.changeType, change.GetType())
+		assert.Equal(t, duid, change.GetDuid())
+		assert.Equal(t, c.eventType, change.GetOperation())
+		assert.Equal(t, int64(1546300800), change.GetTimestamp())
+		cursor, err := decodeResumeToken(change.GetResumeToken())
+		assert.Nil(t, err)
+		assert.Equal(t, &watchCursor{Stream: c.resumeToken, After: event.ID}, cursor)
+		if c.changeType == pbext.DocumentChangeType_DELETED {
+			assert.Nil(t, change.GetDocument())
+		} else {
+			assert.Equal(t, ownerUUID, change.GetDocument().GetUuid())
+		}
+	}
+}
+
+func TestSummaryReadableBy(t *testing.T) {
+	lab := &auth.Caller{UUID: otherUUID, Groups: []string{"lab-a"}}
+	cases := []struct {
+		caller   *auth.Caller
+		summary  *auditSummary
+		expected bool
+	}{
+		{owner, &auditSummary{Uuid: ownerUUID}, true},
+		{admin, &auditSummary{Uuid: ownerUUID}, true},
+		{other, &auditSummary{Uuid: ownerUUID}, false},
+		{other, &auditSummary{Uuid: ownerUUID, IsPublic: true}, true},
+		{other, &auditSummary{Uuid: ownerUUID, IsPublic: true, EmbargoUntilTimestamp: 200}, false},
+		{other, &auditSummary{Uuid: ownerUUID, Shares: []string{"uuid:" + otherUUID + "=READ"}}, true},
+		{other, &auditSummary{Uuid: ownerUUID, Shares: []string{"group:lab-a=WRITE"}}, false},
+		{lab, &auditSummary{Uuid: ownerUUID, Shares: []string{"group:lab-a=WRITE"}}, true},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, summaryReadableBy(c.caller, c.summary, 100))
+	}
+}
+
+func TestAuditChangeOfDeletion(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	record := &auditRecord{
+		ID:        primitive.NewObjectID(),
+		Duid:      duid,
+		Operation: "DeleteDocument",
+		Timestamp: 1546300800,
+		Code:      codes.OK.String(),
+		Before:    &auditSummary{Uuid: ownerUUID},
+	}
+	token, err := encodeResumeToken(&watchCursor{After: record.ID})
+	assert.Nil(t, err)
+	cases := []struct {
+		caller   *auth.Caller
+		req      *pbext.WatchDocumentsRequest
+		record   *auditRecord
+		notified bool
+	}{
+		{owner, &pbext.WatchDocumentsRequest{}, record, true},
+		{owner, &pbext.WatchDocumentsRequest{Duids: []string{duid}, Uuid: ownerUUID}, record, true},
+		{owner, &pbext.WatchDocumentsRequest{Duids: []string{"0ChHfqAsJRZOlTsg2majVXK7wxT"}}, record, false},
+		{owner, &pbext.WatchDocumentsRequest{Uuid: otherUUID}, record, false},
+		{other, &pbext.WatchDocumentsRequest{}, record, false},
+		{owner, &pbext.WatchDocumentsRequest{}, &auditRecord{Duid: duid, Operation: "DeleteDocument"}, false},
+	}
+
+	for _, c := range cases {
+		change, err := newAuditWatchFilter(c.caller, c.req).changeOf(context.TODO(), nil, c.record, 1546300800)
+		assert.Nil(t, err)
+		if !c.notified {
+			assert.Nil(t, change)
+			continue
+		}
+		assert.Equal(t, &pbext.DocumentChange{
+			Type:        pbext.DocumentChangeType_DELETED,
+			Duid:        duid,
+			Operation:   "DeleteDocument",
+			Timestamp:   1546300800,
+			ResumeToken: token,
+		}, change)
+	}
+}
+
+func TestWatchDocuments(t *testing.T) {
+	const duid = "1ChHfqAsJRZOlTsg2majVXK7wxT"
+	serviceStateLocker.currentServiceState = available
+	enabled, interval := conf.Outbox.Enabled, conf.Watch.PollInterval
+	defer func() { conf.Outbox.Enabled, conf.Watch.PollInterval = enabled, interval }()
+	conf.Watch.PollInterval = 100 * time.Millisecond
+	e := ExtensionService{}
+
+	err := e.WatchDocuments(&pbext.WatchDocumentsRequest{ResumeToken: "invalid"},
+		&fakeWatchStream{ctx: auth.NewContext(context.TODO(), owner)})
+	assert.EqualError(t, err, status.Error(codes.InvalidArgument, consts.ErrInvalidResumeToken.Error()).Error())
+
+	// Without the outbox, an audited change of the Document is polled from the audit log
+	conf.Outbox.Enabled = false
+	ctx, cancel := context.WithCancel(auth.NewContext(context.TODO(), owner))
+	stream := &fakeWatchStream{ctx: ctx, changes: make(chan *pbext.DocumentChange, 10)}
+	done := make(chan error)
+	go func() {
+		done <- e.WatchDocuments(&pbext.WatchDocumentsRequest{Duids: []string{duid}}, stream)
+	}()
+
+	_, err = AuditUnaryServerInterceptor()(auth.NewContext(context.TODO(), owner),
+		&pbext.ReorderFilesRequest{Duid: duid, Media: pbdoc.FileType_IMAGE},
+		&grpc.UnaryServerInfo{FullMethod: "/ext.DocumentExtensionService/ReorderFiles"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return e.ReorderFiles(ctx, req.(*pbext.ReorderFilesRequest))
+		})
+	assert.Nil(t, err)
+
+	select {
+	case change := <-stream.changes:
+		assert.Equal(t, pbext.DocumentChangeType_UPDATED, change.GetType())
+		assert.Equal(t, duid, change.GetDuid())
+		assert.Equal(t, "ReorderFiles", change.GetOperation())
+		assert.Equal(t, duid, change.GetDocument().GetDuid())
+		assert.NotEmpty(t, change.GetResumeToken())
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "no change notified")
+	}
+	cancel()
+	assert.Nil(t, <-done)
+
+	// With the outbox, a polled cursor resumes after its event, by the change stream or by the polls of a
+	// standalone MongoDB
+	conf.Outbox.Enabled = true
+	written := duidGenerator.NewDUID()
+	collection := mongoDBWriter.Database(conf.DocumentDB.Name).Collection(conf.OutboxCollection)
+	defer collection.DeleteMany(context.Background(), bson.M{"duid": written})
+	events, err := documentEvents(outbox.FileAdded,
+		&documentRecord{Document: pbdoc.Document{Duid: written, Uuid: ownerUUID}}, "")
+	assert.Nil(t, err)
+	assert.Nil(t, appendEvents(context.Background(), events))
+
+	token, err := encodeResumeToken(&watchCursor{After: objectIDAt(time.Now().Add(-time.Minute))})
+	assert.Nil(t, err)
+	ctx, cancel = context.WithCancel(auth.NewContext(context.TODO(), owner))
+	stream = &fakeWatchStream{ctx: ctx, changes: make(chan *pbext.DocumentChange, 10)}
+	go func() {
+		done <- e.WatchDocuments(&pbext.WatchDocumentsRequest{Duids: []string{written}, ResumeToken: token}, stream)
+	}()
+
+	select {
+	case change := <-stream.changes:
+		assert.Equal(t, written, change.GetDuid())
+		assert.Equal(t, outbox.FileAdded, change.GetOperation())
+		cursor, err := decodeResumeToken(change.GetResumeToken())
+		assert.Nil(t, err)
+		assert.Equal(t, events[0].ID, cursor.After)
+	case <-time.After(10 * time.Second):
+		assert.Fail(t, "no change notified")
+	}
+	cancel()
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,135 @@
+// Package signer makes time-limited download links of file urls, and checks them.
+// A storage proxy checks a link with Verify before serving the file.
+package signer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// Query parameters added to a signed url
+	ExpiresParam   = "hwsc_expires"
+	KeyIDParam     = "hwsc_kid"
+	SignatureParam = "hwsc_sig"
+)
+
+// Key is a secret signing urls, identified in the links it signs
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Signer signs urls with its current key, and checks links signed by any of its keys.
+// Keeping a retired key for as long as its links live lets the keys rotate without breaking links.
+type Signer struct {
+	current Key
+	keys    map[string][]byte
+}
+
+// New returns a Signer signing with the key named current.
+// Returns consts.ErrSigningKeyUnknown if no key is named current,
+// or consts.ErrInvalidSigningKey if a key has no id or no secret.
+func New(keys []Key, current string) (*Signer, error) {
+	s := &Signer{keys: make(map[string][]byte, len(keys))}
+	for _, k := range keys {
+		if k.ID == "" || len(k.Secret) == 0 {
+			return nil, consts.ErrInvalidSigningKey
+		}
+		s.keys[k.ID] = k.Secret
+		if k.ID == current {
+			s.current = k
+		}
+	}
+	if s.current.ID == "" {
+		return nil, consts.ErrSigningKeyUnknown
+	}
+	return s, nil
+}
+
+// Sign adds the expiry, key id, and signature of the url to its query.
+// Returns an error if the url can not be parsed.
+func (s *Signer) Sign(addr string, expires time.Time) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Del(SignatureParam)
+	query.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	query.Set(KeyIDParam, s.current.ID)
+	u.RawQuery = query.Encode()
+
+	query.Set(SignatureParam, signature(s.current.Secret, u.String()))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// Strip removes the expiry, key id, and signature from a signed url, returning the url it was signed from.
+// Urls without any of them, or that can not be parsed, are returned as is.
+func Strip(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return addr
+	}
+	query := u.Query()
+	signed := false
+	for _, param := range []string{ExpiresParam, KeyIDParam, SignatureParam} {
+		if _, ok := query[param]; ok {
+			signed = true
+			query.Del(param)
+		}
+	}
+	if !signed {
+		return addr
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Verify checks the signature and expiry of a signed url at the given time.
+// Returns consts.ErrSignatureMissing, consts.ErrSigningKeyUnknown, consts.ErrSignatureInvalid,
+// or consts.ErrSignatureExpired.
+func (s *Signer) Verify(addr string, now time.Time) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return consts.ErrSignatureInvalid
+	}
+	query := u.Query()
+	sig := query.Get(SignatureParam)
+	if sig == "" || query.Get(ExpiresParam) == "" {
+		return consts.ErrSignatureMissing
+	}
+	secret, ok := s.keys[query.Get(KeyIDParam)]
+	if !ok {
+		return consts.ErrSigningKeyUnknown
+	}
+
+	// The signature covers the url as signed, query parameters in order
+	query.Del(SignatureParam)
+	u.RawQuery = query.Encode()
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, u.String()))) {
+		return consts.ErrSignatureInvalid
+	}
+
+	expires, err := strconv.ParseInt(query.Get(ExpiresParam), 10, 64)
+	if err != nil {
+		return consts.ErrSignatureInvalid
+	}
+	if now.Unix() > expires {
+		return consts.ErrSignatureExpired
+	}
+	return nil
+}
+
+// signature returns the base64url HMAC-SHA256 of the url.
+func signature(secret []byte, addr string) string {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(addr))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package signer
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		keys     []Key
+		current  string
+		expected error
+	}{
+		{[]Key{{"k1", []byte("secret1")}}, "k1", nil},
+		{[]Key{{"k1", []byte("secret1")}, {"k2", []byte("secret2")}}, "k2", nil},
+		{[]Key{{"k1", []byte("secret1")}}, "k2", consts.ErrSigningKeyUnknown},
+		{nil, "", consts.ErrSigningKeyUnknown},
+		{[]Key{{"", []byte("secret1")}}, "", consts.ErrInvalidSigningKey},
+		{[]Key{{"k1", nil}}, "k1", consts.ErrInvalidSigningKey},
+	}
+
+	for _, c := range cases {
+		_, err := New(c.keys, c.current)
+		assert.Equal(t, c.expected, err, c.current)
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	const addr = "https://hwscdevstorage.blob.core.windows.net/audios/a.wav?v=2"
+	now := time.Unix(1546300800, 0)
+
+	s, err := New([]Key{{"k1", []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	signed, err := s.Sign(addr, now.Add(time.Minute))
+	assert.Nil(t, err)
+
+	u, err := url.Parse(signed)
+	assert.Nil(t, err)
+	assert.Equal(t, "/audios/a.wav", u.Path)
+	assert.Equal(t, "2", u.Query().Get("v"))
+	assert.Equal(t, "1546300860", u.Query().Get(ExpiresParam))
+	assert.Equal(t, "k1", u.Query().Get(KeyIDParam))
+
+	// the key signing new links rotates, links of the retired key still verify
+	rotated, err := New([]Key{{"k2", []byte("secret2")}, {"k1", []byte("secret1")}}, "k2")
+	assert.Nil(t, err)
+	retired, err := New([]Key{{"k2", []byte("secret2")}}, "k2")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		signer   *Signer
+		addr     string
+		now      time.Time
+		expected error
+	}{
+		{s, signed, now, nil},
+		{s, signed, now.Add(time.Minute), nil},
+		{s, signed, now.Add(time.Minute + time.Second), consts.ErrSignatureExpired},
+		{rotated, signed, now, nil},
+		{retired, signed, now, consts.ErrSigningKeyUnknown},
+		{s, addr, now, consts.ErrSignatureMissing},
+		{s, strings.Replace(signed, "a.wav", "b.wav", 1), now, consts.ErrSignatureInvalid},
+		{s, strings.Replace(signed, "1546300860", "1546400000", 1), now, consts.ErrSignatureInvalid},
+		{s, strings.Replace(signed, "v=2", "v=3", 1), now, consts.ErrSignatureInvalid},
+		{s, "%zz", now, consts.ErrSignatureInvalid},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, c.signer.Verify(c.addr, c.now), c.addr)
+	}
+
+	// signing a signed url replaces its signature
+	resigned, err := rotated.Sign(signed, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Nil(t, rotated.Verify(resigned, now.Add(30*time.Minute)))
+	assert.Equal(t, 1, strings.Count(resigned, SignatureParam))
+}
+
+func TestStrip(t *testing.T) {
+	const addr = "https://hwscdevstorage.blob.core.windows.net/audios/a.wav?v=2"
+	s, err := New([]Key{{"k1", []byte("secret1")}}, "k1")
+	assert.Nil(t, err)
+	signed, err := s.Sign(addr, time.Unix(1546300800, 0))
+	assert.Nil(t, err)
+
+	cases := []struct {
+		addr     string
+		expected string
+	}{
+		{signed, addr},
+		{addr, addr},
+		{"https://hwscdevstorage.blob.core.windows.net/audios/a.wav?hwsc_sig=x", "https://hwscdevstorage.blob.core.windows.net/audios/a.wav"},
+		{"https://hwscdevstorage.blob.core.windows.net/audios/a.wav?b=1&a=2", "https://hwscdevstorage.blob.core.windows.net/audios/a.wav?b=1&a=2"},
+		{"%zz", "%zz"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, Strip(c.addr), c.addr)
+	}
+}
@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// metadataCarrier reads and writes the trace context in gRPC metadata
+type metadataCarrier metadata.MD
+
+// Get returns the first value of the key.
+func (c metadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Set replaces the values of the key.
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys returns the keys of the metadata.
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// UnaryServerInterceptor records a span of every unary call, child of the trace context of its metadata,
+// and tags it with the duid and UUID of the request.
+// Returns the interceptor.
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		ctx, span := startServerSpan(ctx, info.FullMethod)
+		defer span.End()
+		span.SetAttributes(requestAttributes(req)...)
+
+		res, err := handler(ctx, req)
+		endServerSpan(span, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor records a span of every streaming call, child of the trace context of its metadata.
+// Returns the interceptor.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		ctx, span := startServerSpan(stream.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &tracedStream{ServerStream: stream, ctx: ctx})
+		endServerSpan(span, err)
+		return err
+	}
+}
+
+// tracedStream is a server stream whose context holds the span of the call
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the call, holding its span.
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
+
+// startServerSpan starts the span of a call of the full method name, child of the trace context of its metadata.
+// Returns the context holding the span, and the span.
+func startServerSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md.Copy()))
+
+	service, method := splitMethod(fullMethod)
+	return tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(method)),
+	)
+}
+
+// endServerSpan records the status code of the call, marking the span failed for an error.
+func endServerSpan(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+}
+
+// requestAttributes returns the duid and UUID of the request, or of the Document or file of a DocumentRequest.
+// The empty ones are left out.
+func requestAttributes(req interface{}) []attribute.KeyValue {
+	if r, ok := req.(interface{ GetData() *pbdoc.Document }); ok && r.GetData() != nil {
+		return requestAttributes(r.GetData())
+	}
+	if r, ok := req.(interface {
+		GetFileMetadataParameters() *pbdoc.FileMetadataTransaction
+	}); ok && r.GetFileMetadataParameters() != nil {
+		return requestAttributes(r.GetFileMetadataParameters())
+	}
+
+	var attributes []attribute.KeyValue
+	if r, ok := req.(interface{ GetDuid() string }); ok && r.GetDuid() != "" {
+		attributes = append(attributes, DUIDKey.String(r.GetDuid()))
+	}
+	if r, ok := req.(interface{ GetUuid() string }); ok && r.GetUuid() != "" {
+		attributes = append(attributes, UUIDKey.String(r.GetUuid()))
+	}
+	return attributes
+}
+
+// splitMethod returns the service and method of a full method name "/package.Service/Method".
+func splitMethod(fullMethod string) (string, string) {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
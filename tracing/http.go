@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// transport records a span of every request sent by its base transport
+type transport struct {
+	base http.RoundTripper
+}
+
+// Transport wraps the base transport, recording a span of every request, child of the span of its context.
+// The trace context is sent in the headers of the request.
+// Returns the transport.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+// RoundTrip sends the request in its span.
+// Returns the response of the base transport, or its error.
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The query of a url may hold a signature
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+
+	ctx, span := tracer().Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(u.String()),
+			semconv.ServerAddress(req.URL.Hostname()),
+		),
+	)
+	defer span.End()
+
+	// The request of a round trip is not modified, its clone carries the trace context
+	req = req.WithContext(ctx)
+	req.Header = req.Header.Clone()
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(otelcodes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
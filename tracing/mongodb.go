@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"go.mongodb.org/mongo-driver/event"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// CommandMonitor records a span of every MongoDB command, child of the span of the context of the call
+type CommandMonitor struct {
+	// Spans of the started commands by request id, until they finish
+	spans sync.Map
+}
+
+// NewCommandMonitor returns a monitor recording the commands of MongoDB clients.
+func NewCommandMonitor() *CommandMonitor {
+	return &CommandMonitor{}
+}
+
+// Monitor returns the command monitor to set on the options of a MongoDB client.
+func (m *CommandMonitor) Monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			if span, ok := m.finished(evt.RequestID); ok {
+				span.End()
+			}
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			if span, ok := m.finished(evt.RequestID); ok {
+				span.SetStatus(otelcodes.Error, evt.Failure)
+				span.End()
+			}
+		},
+	}
+}
+
+// started starts the span of a command, named after its operation and collection.
+func (m *CommandMonitor) started(ctx context.Context, evt *event.CommandStartedEvent) {
+	key := evt.CommandName
+	if key == "getMore" {
+		// The cursor id is the value of getMore
+		key = "collection"
+	}
+	collection, _ := evt.Command.Lookup(key).StringValueOK()
+
+	name := evt.CommandName
+	if collection != "" {
+		name += " " + collection
+	}
+	_, span := tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNamespace(evt.DatabaseName),
+			semconv.DBOperationName(evt.CommandName),
+			semconv.DBCollectionName(collection),
+		),
+	)
+	m.spans.Store(evt.RequestID, span)
+}
+
+// finished forgets the span of the command of the request id.
+// Returns the span, or false if the command was not started by this monitor.
+func (m *CommandMonitor) finished(requestID int64) (trace.Span, bool) {
+	span, ok := m.spans.Load(requestID)
+	if !ok {
+		return nil, false
+	}
+	m.spans.Delete(requestID)
+	return span.(trace.Span), true
+}
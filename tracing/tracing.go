@@ -0,0 +1,81 @@
+// Package tracing records OpenTelemetry spans of the gRPC calls, the MongoDB commands, and the outbound HTTP
+// requests verifying file urls. The trace context of a call is read from its metadata, so the spans of the
+// service join the trace of the caller.
+package tracing
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+const (
+	// ServiceName of the spans
+	ServiceName = "hwsc-document-svc"
+	// Name of the tracer of the service
+	instrumentationName = "github.com/hwsc-org/hwsc-document-svc/tracing"
+
+	// DUIDKey tags a span with the duid of its Document
+	DUIDKey = attribute.Key("hwsc.document.duid")
+	// UUIDKey tags a span with the UUID of the owner of its Document
+	UUIDKey = attribute.Key("hwsc.document.uuid")
+	// CallerKey tags a span with the UUID of its authenticated caller
+	CallerKey = attribute.Key("hwsc.caller.uuid")
+)
+
+// tracer returns the tracer of the service from the installed provider.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// NewOTLPExporter returns an exporter sending spans over OTLP/gRPC to the collector at the endpoint.
+// insecure sends them in plaintext.
+// Returns an error if the exporter can not be made.
+func NewOTLPExporter(ctx context.Context, endpoint string, insecure bool) (sdktrace.SpanExporter, error) {
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+	if insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(ctx, options...)
+}
+
+// Install makes the spans of the service sampled at the ratio, and exported in batches by the exporter.
+// A span whose caller sampled its trace is always sampled.
+// Returns the provider, to shut down and flush the last spans before exiting.
+func Install(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	return install(sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))))
+}
+
+// InstallInMemory makes every span of the service sampled, and exported as soon as it ends to memory.
+// Returns the exporter holding the ended spans, for tests.
+func InstallInMemory() *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	install(sdktrace.WithSyncer(exporter), sdktrace.WithSampler(sdktrace.AlwaysSample()))
+	return exporter
+}
+
+// install sets the provider of the options, and the propagation of the trace context, for the whole process.
+// Returns the provider.
+func install(options ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName))
+	provider := sdktrace.NewTracerProvider(append(options, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+	return provider
+}
+
+// Annotate tags the current span of ctx with the attributes.
+// Does nothing if ctx has no recording span.
+func Annotate(ctx context.Context, attributes ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attributes...)
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	pbsvc "github.com/hwsc-org/hwsc-api-blocks/protobuf/hwsc-document-svc/document"
+	pbdoc "github.com/hwsc-org/hwsc-api-blocks/protobuf/lib"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentSpanID  = "00f067aa0ba902b7"
+)
+
+// attributeOf returns the value of the attribute of the span, empty if the span has none.
+func attributeOf(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	exporter := InstallInMemory()
+	const method = "/document.DocumentService/DeleteDocument"
+
+	cases := []struct {
+		traceparent string
+		err         error
+		isRemote    bool
+		statusCode  otelcodes.Code
+	}{
+		{"00-" + parentTraceID + "-" + parentSpanID + "-01", nil, true, otelcodes.Unset},
+		{"", status.Error(codes.NotFound, "not found"), false, otelcodes.Error},
+	}
+
+	for _, c := range cases {
+		exporter.Reset()
+		ctx := context.Background()
+		if c.traceparent != "" {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("traceparent", c.traceparent))
+		}
+		req := &pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: "duid", Uuid: "uuid"}}
+
+		var inner trace.SpanContext
+		_, err := UnaryServerInterceptor()(ctx, req, &grpc.UnaryServerInfo{FullMethod: method},
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				inner = trace.SpanContextFromContext(ctx)
+				return nil, c.err
+			})
+		assert.Equal(t, c.err, err)
+
+		spans := exporter.GetSpans()
+		if !assert.Len(t, spans, 1) {
+			continue
+		}
+		span := spans[0]
+		assert.Equal(t, "document.DocumentService/DeleteDocument", span.Name)
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+		assert.Equal(t, inner.SpanID(), span.SpanContext.SpanID())
+		assert.Equal(t, c.isRemote, span.Parent.IsRemote())
+		if c.isRemote {
+			assert.Equal(t, parentTraceID, span.SpanContext.TraceID().String())
+			assert.Equal(t, parentSpanID, span.Parent.SpanID().String())
+		}
+		assert.Equal(t, "duid", attributeOf(span, DUIDKey).AsString())
+		assert.Equal(t, "uuid", attributeOf(span, UUIDKey).AsString())
+		assert.Equal(t, "DeleteDocument", attributeOf(span, "rpc.method").AsString())
+		assert.Equal(t, c.statusCode, span.Status.Code)
+		assert.Equal(t, int64(status.Code(c.err)), attributeOf(span, "rpc.grpc.status_code").AsInt64())
+	}
+}
+
+func TestRequestAttributes(t *testing.T) {
+	cases := []struct {
+		req  interface{}
+		duid string
+		uuid string
+	}{
+		{&pbsvc.DocumentRequest{Data: &pbdoc.Document{Duid: "duid", Uuid: "uuid"}}, "duid", "uuid"},
+		{&pbsvc.DocumentRequest{FileMetadataParameters: &pbdoc.FileMetadataTransaction{Duid: "duid"}}, "duid", ""},
+		{&pbdoc.Document{Uuid: "uuid"}, "", "uuid"},
+		{&pbsvc.DocumentRequest{}, "", ""},
+		{nil, "", ""},
+	}
+
+	for _, c := range cases {
+		attributes := map[attribute.Key]string{}
+		for _, kv := range requestAttributes(c.req) {
+			attributes[kv.Key] = kv.Value.AsString()
+		}
+		assert.Equal(t, c.duid, attributes[DUIDKey])
+		assert.Equal(t, c.uuid, attributes[UUIDKey])
+	}
+}
+
+func TestCommandMonitor(t *testing.T) {
+	exporter := InstallInMemory()
+	monitor := NewCommandMonitor().Monitor()
+
+	cases := []struct {
+		command    bson.D
+		name       string
+		collection string
+		failure    string
+	}{
+		{bson.D{{Key: "find", Value: "documents"}}, "find documents", "documents", ""},
+		{bson.D{{Key: "getMore", Value: int64(1)}, {Key: "collection", Value: "documents"}},
+			"getMore documents", "documents", ""},
+		{bson.D{{Key: "insert", Value: "documents"}}, "insert documents", "documents", "duplicate key"},
+		{bson.D{{Key: "ping", Value: 1}}, "ping", "", ""},
+	}
+
+	for i, c := range cases {
+		exporter.Reset()
+		raw, err := bson.Marshal(c.command)
+		assert.Nil(t, err)
+
+		ctx, parent := tracer().Start(context.Background(), "parent")
+		monitor.Started(ctx, &event.CommandStartedEvent{
+			Command:      raw,
+			DatabaseName: "test-document",
+			CommandName:  c.command[0].Key,
+			RequestID:    int64(i),
+		})
+		if c.failure == "" {
+			monitor.Succeeded(ctx, &event.CommandSucceededEvent{
+				CommandFinishedEvent: event.CommandFinishedEvent{RequestID: int64(i)},
+			})
+		} else {
+			monitor.Failed(ctx, &event.CommandFailedEvent{
+				CommandFinishedEvent: event.CommandFinishedEvent{RequestID: int64(i)},
+				Failure:              c.failure,
+			})
+		}
+		parent.End()
+
+		spans := exporter.GetSpans()
+		if !assert.Len(t, spans, 2, c.name) {
+			continue
+		}
+		span := spans[0]
+		assert.Equal(t, c.name, span.Name)
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+		assert.Equal(t, "mongodb", attributeOf(span, "db.system").AsString())
+		assert.Equal(t, "test-document", attributeOf(span, "db.namespace").AsString())
+		assert.Equal(t, c.collection, attributeOf(span, "db.collection.name").AsString())
+		if c.failure == "" {
+			assert.Equal(t, otelcodes.Unset, span.Status.Code, c.name)
+		} else {
+			assert.Equal(t, otelcodes.Error, span.Status.Code, c.name)
+			assert.Equal(t, c.failure, span.Status.Description, c.name)
+		}
+	}
+
+	// A finished command never started by the monitor is ignored
+	monitor.Succeeded(context.Background(), &event.CommandSucceededEvent{
+		CommandFinishedEvent: event.CommandFinishedEvent{RequestID: 99},
+	})
+}
+
+func TestTransport(t *testing.T) {
+	exporter := InstallInMemory()
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		if r.URL.Path == "/missing.jpg" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	cases := []struct {
+		path       string
+		statusCode otelcodes.Code
+	}{
+		{"/found.jpg?signature=secret", otelcodes.Unset},
+		{"/missing.jpg", otelcodes.Error},
+	}
+
+	for _, c := range cases {
+		exporter.Reset()
+		ctx, parent := StartVerifyURL(context.Background(), server.URL+c.path)
+		req, err := http.NewRequest(http.MethodHead, server.URL+c.path, nil)
+		assert.Nil(t, err)
+		resp, err := client.Do(req.WithContext(ctx))
+		assert.Nil(t, err)
+		resp.Body.Close()
+		EndVerifyURL(parent, nil)
+		parent.End()
+
+		spans := exporter.GetSpans()
+		if !assert.Len(t, spans, 2, c.path) {
+			continue
+		}
+		span, verify := spans[0], spans[1]
+		assert.Equal(t, "VerifyURL", verify.Name)
+		assert.NotContains(t, attributeOf(verify, "url.full").AsString(), "secret")
+		assert.Equal(t, http.MethodHead, span.Name)
+		assert.Equal(t, verify.SpanContext.SpanID(), span.Parent.SpanID())
+		assert.NotContains(t, attributeOf(span, "url.full").AsString(), "secret")
+		assert.Equal(t, int64(resp.StatusCode), attributeOf(span, "http.response.status_code").AsInt64())
+		assert.Equal(t, c.statusCode, span.Status.Code, c.path)
+		assert.Equal(t, "00-"+span.SpanContext.TraceID().String()+"-"+span.SpanContext.SpanID().String()+"-01",
+			traceparent)
+	}
+}
@@ -0,0 +1,30 @@
+package tracing
+
+import (
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+	"net/url"
+)
+
+// StartVerifyURL starts the span verifying the file url, child of the span of ctx.
+// The query and user of the url are left out, as they may hold a signature.
+// Returns the context holding the span, and the span.
+func StartVerifyURL(ctx context.Context, addr string) (context.Context, trace.Span) {
+	var attributes []attribute.KeyValue
+	if u, err := url.Parse(addr); err == nil {
+		u.RawQuery = ""
+		u.User = nil
+		attributes = append(attributes, semconv.URLFull(u.String()), semconv.ServerAddress(u.Hostname()))
+	}
+	return tracer().Start(ctx, "VerifyURL", trace.WithAttributes(attributes...))
+}
+
+// EndVerifyURL marks the span failed if the url was not verified.
+func EndVerifyURL(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(otelcodes.Error, err.Error())
+	}
+}
@@ -0,0 +1,47 @@
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// Fake is a Verifier for tests.
+// Only the urls registered in Files are reachable, every call is recorded.
+type Fake struct {
+	lock  sync.Mutex
+	Files map[string]*FileInfo
+	calls []string
+}
+
+// NewFake returns a Fake where the given urls are reachable.
+func NewFake(files map[string]*FileInfo) *Fake {
+	if files == nil {
+		files = make(map[string]*FileInfo)
+	}
+	return &Fake{Files: files}
+}
+
+// Verify returns the registered FileInfo of the url, or consts.ErrUnreachableURI.
+func (f *Fake) Verify(ctx context.Context, addr string) (*FileInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls = append(f.calls, addr)
+	info, ok := f.Files[addr]
+	if !ok {
+		return nil, consts.ErrUnreachableURI
+	}
+	copied := *info
+	return &copied, nil
+}
+
+// Calls returns the urls verified so far, in order.
+func (f *Fake) Calls() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	calls := make([]string, len(f.calls))
+	copy(calls, f.calls)
+	return calls
+}
@@ -0,0 +1,210 @@
+package verifier
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/hwsc-org/hwsc-document-svc/tracing"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a whole verification, including the fallback request
+	DefaultTimeout = 10 * time.Second
+	maxRedirects   = 5
+)
+
+// errPolicyViolation marks the errors of the policy checks made while dialing or redirecting,
+// so they are told apart from the network failures wrapping them
+var errPolicyViolation = errors.New("policy violation")
+
+// HTTP verifies urls with a HEAD request, then fetches the first bytes of the file with a ranged GET
+// to detect its media type. The rest of the file is never downloaded.
+type HTTP struct {
+	client *http.Client
+	policy Policy
+}
+
+// NewHTTP returns a Verifier contacting the storage over the network.
+// Every address dialed, including redirects, is checked against the policy.
+// A timeout of zero uses DefaultTimeout.
+func NewHTTP(policy Policy, timeout time.Duration) *HTTP {
+	return &HTTP{client: NewClient(policy, timeout), policy: policy}
+}
+
+// NewClient returns an HTTP client checking every address it dials, including redirects, against the policy.
+// A timeout of zero uses DefaultTimeout.
+func NewClient(policy Policy, timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		// Checks the resolved address, so a hostname resolving to a private address is blocked
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("%w: %w", errPolicyViolation, consts.ErrPrivateAddress)
+			}
+			if err := policy.CheckIP(ip); err != nil {
+				return fmt.Errorf("%w: %w", errPolicyViolation, err)
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: tracing.Transport(transport),
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("stopped after too many redirects")
+			}
+			if err := policy.CheckURL(req.URL); err != nil {
+				return fmt.Errorf("%w: %w", errPolicyViolation, err)
+			}
+			return nil
+		},
+	}
+}
+
+// Verify confirms the url is allowed, reachable, and serves content matching its extension.
+// Returns what the storage advertises about the file, or an error.
+func (h *HTTP) Verify(ctx context.Context, addr string) (*FileInfo, error) {
+	u, err := parseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.policy.CheckURL(u); err != nil {
+		return nil, err
+	}
+
+	headResp, _, err := h.do(ctx, http.MethodHead, addr)
+	if err != nil {
+		return nil, unwrapPolicyError(err)
+	}
+	if headResp.StatusCode >= 400 && headResp.StatusCode != http.StatusMethodNotAllowed &&
+		headResp.StatusCode != http.StatusNotImplemented {
+
+		return nil, consts.ErrUnreachableURI
+	}
+
+	getResp, head, err := h.do(ctx, http.MethodGet, addr)
+	if err != nil {
+		return nil, unwrapPolicyError(err)
+	}
+	if getResp.StatusCode >= 400 {
+		return nil, consts.ErrUnreachableURI
+	}
+
+	// Storages not supporting HEAD only advertise the file in the GET response
+	info := fileInfoFromResponse(getResp)
+	if headResp.StatusCode < 400 {
+		info = fileInfoFromResponse(headResp)
+	}
+	info.Head = head
+	info.DetectedType = DetectContentType(head)
+	if err := CheckContentType(u.Path, info.DetectedType); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// do sends a request, a GET only asks for the first HeadLength bytes of the file.
+// Returns the response with its body closed, and the bytes read from a GET.
+func (h *HTTP) do(ctx context.Context, method string, addr string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, addr, nil)
+	if err != nil {
+		return nil, nil, consts.ErrUnreachableURI
+	}
+	if method == http.MethodGet {
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", HeadLength-1))
+	}
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var head []byte
+	if method == http.MethodGet && resp.StatusCode < 400 {
+		// A storage ignoring the range sends the whole file, only the first bytes are read
+		head, err = ioutil.ReadAll(io.LimitReader(resp.Body, HeadLength))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return resp, head, nil
+}
+
+// fileInfoFromResponse extracts what the storage advertises in the response headers.
+// The size of a ranged response is taken from Content-Range.
+func fileInfoFromResponse(resp *http.Response) *FileInfo {
+	info := &FileInfo{}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		info.ContentType = mediaType
+	}
+	if resp.StatusCode == http.StatusPartialContent {
+		info.Size = sizeFromContentRange(resp.Header.Get("Content-Range"))
+	} else if resp.ContentLength > 0 {
+		info.Size = resp.ContentLength
+	}
+	if sum, err := base64.StdEncoding.DecodeString(resp.Header.Get("Content-MD5")); err == nil && len(sum) > 0 {
+		info.Checksum = hex.EncodeToString(sum)
+	}
+	return info
+}
+
+// sizeFromContentRange returns the complete length of "bytes 0-0/1234", or 0 if unknown.
+func sizeFromContentRange(contentRange string) int64 {
+	i := strings.LastIndex(contentRange, "/")
+	if i < 0 {
+		return 0
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+// unwrapPolicyError reports a policy violation found while dialing or redirecting,
+// any other failure means the url is unreachable.
+func unwrapPolicyError(err error) error {
+	if !errors.Is(err, errPolicyViolation) {
+		return consts.ErrUnreachableURI
+	}
+	for _, policyErr := range []error{consts.ErrPrivateAddress, consts.ErrURLHostNotAllowed,
+		consts.ErrURLSchemeNotAllowed} {
+
+		if errors.Is(err, policyErr) {
+			return policyErr
+		}
+	}
+	return consts.ErrUnreachableURI
+}
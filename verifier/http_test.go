@@ -0,0 +1,169 @@
+package verifier
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	wavHead  = []byte("RIFF\x24\x08\x00\x00WAVEfmt ")
+	jpegHead = []byte("\xFF\xD8\xFF\xE0\x00\x10JFIF\x00")
+)
+
+func TestHTTPVerify(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/head.wav", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav; charset=binary")
+		w.Header().Set("Content-MD5", "1B2M2Y8AsgTpgAmY7PhCfg==")
+		http.ServeContent(w, r, "head.wav", time.Time{}, bytes.NewReader(append(wavHead, make([]byte, 4080)...)))
+	})
+	mux.HandleFunc("/get-only.jpg", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		assert.Equal(t, "bytes=0-65535", r.Header.Get("Range"))
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Range", "bytes 0-11/2048")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write(jpegHead)
+	})
+	mux.HandleFunc("/no-range.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Content-Length", "8203")
+		_, _ = w.Write(append(jpegHead, make([]byte, 8192)...))
+	})
+	mux.HandleFunc("/error-page.wav", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav")
+		_, _ = w.Write([]byte("<!DOCTYPE html><html><body>Not Found</body></html>"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/head.wav", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	v := NewHTTP(Policy{AllowPrivate: true}, time.Second)
+
+	cases := []struct {
+		addr         string
+		contentType  string
+		size         int64
+		checksum     string
+		detectedType string
+		err          error
+	}{
+		{server.URL + "/head.wav", "audio/wav", 4096, "d41d8cd98f00b204e9800998ecf8427e", "audio/wav", nil},
+		{server.URL + "/get-only.jpg", "image/jpeg", 2048, "", "image/jpeg", nil},
+		{server.URL + "/no-range.jpg", "image/jpeg", 8203, "", "image/jpeg", nil},
+		{server.URL + "/redirect", "audio/wav", 4096, "d41d8cd98f00b204e9800998ecf8427e", "audio/wav", nil},
+		{server.URL + "/error-page.wav", "", 0, "", "", consts.ErrContentTypeMismatch},
+		{server.URL + "/missing.wav", "", 0, "", "", consts.ErrUnreachableURI},
+		{"not a url", "", 0, "", "", consts.ErrUnreachableURI},
+	}
+
+	for _, c := range cases {
+		info, err := v.Verify(context.Background(), c.addr)
+		assert.Equal(t, c.err, err, c.addr)
+		if c.err != nil {
+			assert.Nil(t, info, c.addr)
+			continue
+		}
+		assert.Equal(t, c.contentType, info.ContentType, c.addr)
+		assert.Equal(t, c.size, info.Size, c.addr)
+		assert.Equal(t, c.checksum, info.Checksum, c.addr)
+		assert.Equal(t, c.detectedType, info.DetectedType, c.addr)
+		assert.True(t, len(info.Head) > 0 && len(info.Head) <= HeadLength, c.addr)
+	}
+}
+
+func TestHTTPVerifyPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// httptest listens on loopback, which the default policy blocks
+	_, err := NewHTTP(Policy{}, time.Second).Verify(context.Background(), server.URL)
+	assert.Equal(t, consts.ErrPrivateAddress, err)
+
+	_, err = NewHTTP(Policy{AllowPrivate: true, Schemes: []string{"https"}}, time.Second).
+		Verify(context.Background(), server.URL)
+	assert.Equal(t, consts.ErrURLSchemeNotAllowed, err)
+
+	_, err = NewHTTP(Policy{AllowPrivate: true, Hosts: []string{"cdn.example.com"}}, time.Second).
+		Verify(context.Background(), server.URL)
+	assert.Equal(t, consts.ErrURLHostNotAllowed, err)
+}
+
+func TestNewClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// The dialed address is checked, whatever the url
+	_, err := NewClient(Policy{}, time.Second).Post(server.URL, "application/json", nil)
+	assert.True(t, errors.Is(err, consts.ErrPrivateAddress))
+
+	res, err := NewClient(Policy{AllowPrivate: true}, time.Second).Post(server.URL, "application/json", nil)
+	if assert.Nil(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	}
+}
+
+func TestHTTPVerifyTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	_, err := NewHTTP(Policy{AllowPrivate: true}, 50*time.Millisecond).Verify(context.Background(), server.URL)
+	assert.Equal(t, consts.ErrUnreachableURI, err)
+}
+
+func TestUnwrapPolicyError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected error
+	}{
+		{fmt.Errorf("dial: %w", fmt.Errorf("%w: %w", errPolicyViolation, consts.ErrPrivateAddress)),
+			consts.ErrPrivateAddress},
+		{fmt.Errorf("%w: %w", errPolicyViolation, consts.ErrURLHostNotAllowed), consts.ErrURLHostNotAllowed},
+		// Only the errors of the policy checks are reported, not a message mentioning them
+		{errors.New(consts.ErrPrivateAddress.Error()), consts.ErrUnreachableURI},
+		{consts.ErrURLSchemeNotAllowed, consts.ErrUnreachableURI},
+		{errors.New("connection refused"), consts.ErrUnreachableURI},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, unwrapPolicyError(c.err), c.err.Error())
+	}
+}
+
+func TestSizeFromContentRange(t *testing.T) {
+	cases := []struct {
+		contentRange string
+		expected     int64
+	}{
+		{"bytes 0-0/1234", 1234},
+		{"bytes 0-0/*", 0},
+		{"", 0},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, sizeFromContentRange(c.contentRange), c.contentRange)
+	}
+}
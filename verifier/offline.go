@@ -0,0 +1,28 @@
+package verifier
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Offline verifies urls without contacting the storage.
+// Only the syntax of a url and the policy are checked, and no file information is reported.
+type Offline struct {
+	policy Policy
+}
+
+// NewOffline returns a Verifier that never uses the network.
+func NewOffline(policy Policy) *Offline {
+	return &Offline{policy: policy}
+}
+
+// Verify confirms the url is well formed and allowed.
+func (o *Offline) Verify(ctx context.Context, addr string) (*FileInfo, error) {
+	u, err := parseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.policy.CheckURL(u); err != nil {
+		return nil, err
+	}
+	return &FileInfo{}, nil
+}
@@ -0,0 +1,48 @@
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"testing"
+)
+
+func TestOfflineVerify(t *testing.T) {
+	v := NewOffline(Policy{Hosts: []string{".example.com"}})
+
+	cases := []struct {
+		addr string
+		err  error
+	}{
+		{"https://cdn.example.com/does-not-exist.wav", nil},
+		{"https://evil.com/a.wav", consts.ErrURLHostNotAllowed},
+		{"file:///etc/passwd", consts.ErrUnreachableURI},
+		{"", consts.ErrUnreachableURI},
+	}
+
+	for _, c := range cases {
+		_, err := v.Verify(context.Background(), c.addr)
+		assert.Equal(t, c.err, err, c.addr)
+	}
+}
+
+func TestFakeVerify(t *testing.T) {
+	v := NewFake(map[string]*FileInfo{
+		"https://example.com/a.wav": {ContentType: "audio/wav", Size: 10},
+	})
+
+	info, err := v.Verify(context.Background(), "https://example.com/a.wav")
+	assert.Nil(t, err)
+	assert.Equal(t, "audio/wav", info.ContentType)
+
+	info.Size = 0
+	info, err = v.Verify(context.Background(), "https://example.com/a.wav")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), info.Size)
+
+	_, err = v.Verify(context.Background(), "https://example.com/b.wav")
+	assert.Equal(t, consts.ErrUnreachableURI, err)
+
+	assert.Equal(t, []string{"https://example.com/a.wav", "https://example.com/a.wav",
+		"https://example.com/b.wav"}, v.Calls())
+}
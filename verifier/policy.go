@@ -0,0 +1,115 @@
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	defaultSchemes = []string{"http", "https"}
+
+	// Loopback, private, link-local, shared, and unspecified ranges
+	privateNetworks = mustParseCIDRs(
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	)
+)
+
+// Policy restricts the urls a Verifier contacts
+type Policy struct {
+	// Schemes allowed, defaults to http and https
+	Schemes []string
+	// Hosts allowed, an entry starting with a dot allows every subdomain.
+	// Any host is allowed if empty.
+	Hosts []string
+	// AllowPrivate allows loopback, private, and link-local addresses
+	AllowPrivate bool
+}
+
+// CheckURL validates the scheme and host of a url against the policy.
+// Returns an error if either is not allowed.
+func (p Policy) CheckURL(u *url.URL) error {
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = defaultSchemes
+	}
+	if !containsFold(schemes, u.Scheme) {
+		return consts.ErrURLSchemeNotAllowed
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(p.Hosts) > 0 && !p.isHostAllowed(host) {
+		return consts.ErrURLHostNotAllowed
+	}
+
+	// Literal addresses are checked before dialing
+	if ip := net.ParseIP(host); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+// CheckIP validates a resolved address against the policy.
+// Returns an error if the address is private, and private addresses are not allowed.
+func (p Policy) CheckIP(ip net.IP) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return consts.ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+func (p Policy) isHostAllowed(host string) bool {
+	for _, h := range p.Hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h == "" {
+			continue
+		}
+		if strings.HasPrefix(h, ".") {
+			if strings.HasSuffix(host, h) {
+				return true
+			}
+			continue
+		}
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(elems []string, s string) bool {
+	for _, e := range elems {
+		if strings.EqualFold(strings.TrimSpace(e), s) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -0,0 +1,53 @@
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestPolicyCheckURL(t *testing.T) {
+	cases := []struct {
+		policy   Policy
+		addr     string
+		expected error
+	}{
+		{Policy{}, "https://example.com/a.wav", nil},
+		{Policy{}, "http://example.com/a.wav", nil},
+		{Policy{}, "ftp://example.com/a.wav", consts.ErrURLSchemeNotAllowed},
+		{Policy{Schemes: []string{"https"}}, "http://example.com/a.wav", consts.ErrURLSchemeNotAllowed},
+		{Policy{Hosts: []string{"cdn.example.com"}}, "https://cdn.example.com/a.wav", nil},
+		{Policy{Hosts: []string{"cdn.example.com"}}, "https://evil.com/a.wav", consts.ErrURLHostNotAllowed},
+		{Policy{Hosts: []string{".example.com"}}, "https://media.example.com/a.wav", nil},
+		{Policy{Hosts: []string{".example.com"}}, "https://example.com.evil.com/a.wav", consts.ErrURLHostNotAllowed},
+		{Policy{}, "http://127.0.0.1/a.wav", consts.ErrPrivateAddress},
+		{Policy{}, "http://192.168.1.10/a.wav", consts.ErrPrivateAddress},
+		{Policy{}, "http://[::1]/a.wav", consts.ErrPrivateAddress},
+		{Policy{AllowPrivate: true}, "http://127.0.0.1/a.wav", nil},
+	}
+
+	for _, c := range cases {
+		u, err := url.Parse(c.addr)
+		assert.Nil(t, err, c.addr)
+		assert.Equal(t, c.expected, c.policy.CheckURL(u), c.addr)
+	}
+}
+
+func TestPolicyCheckIP(t *testing.T) {
+	cases := []struct {
+		ip       string
+		expected error
+	}{
+		{"8.8.8.8", nil},
+		{"10.1.2.3", consts.ErrPrivateAddress},
+		{"169.254.169.254", consts.ErrPrivateAddress},
+		{"fe80::1", consts.ErrPrivateAddress},
+		{"2001:4860:4860::8888", nil},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, Policy{}.CheckIP(net.ParseIP(c.ip)), c.ip)
+	}
+}
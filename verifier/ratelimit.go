@@ -0,0 +1,74 @@
+package verifier
+
+import (
+	"golang.org/x/net/context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTrackedHosts bounds the hosts remembered before the idle ones are forgotten
+const maxTrackedHosts = 1024
+
+// RateLimited spaces the verifications of urls on the same host,
+// so a scan does not flood a single storage.
+type RateLimited struct {
+	verifier Verifier
+	interval time.Duration
+	lock     sync.Mutex
+	next     map[string]time.Time
+}
+
+// NewRateLimited returns a Verifier allowing at most perSecond verifications per host.
+// A rate of zero or less does not limit.
+func NewRateLimited(v Verifier, perSecond float64) *RateLimited {
+	var interval time.Duration
+	if perSecond > 0 {
+		interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	return &RateLimited{
+		verifier: v,
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// Verify waits for the turn of the url host, then verifies the url.
+// Returns the error of the context if it is done before the turn comes.
+func (r *RateLimited) Verify(ctx context.Context, addr string) (*FileInfo, error) {
+	if u, err := parseURL(addr); err == nil && r.interval > 0 {
+		if wait := r.reserve(strings.ToLower(u.Hostname())); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	return r.verifier.Verify(ctx, addr)
+}
+
+// reserve books the next turn of the host.
+// Returns how long to wait for the turn.
+func (r *RateLimited) reserve(host string) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	if len(r.next) >= maxTrackedHosts {
+		for h, t := range r.next {
+			if t.Before(now) {
+				delete(r.next, h)
+			}
+		}
+	}
+
+	turn := r.next[host]
+	if turn.Before(now) {
+		turn = now
+	}
+	r.next[host] = turn.Add(r.interval)
+	return turn.Sub(now)
+}
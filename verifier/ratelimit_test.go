@@ -0,0 +1,39 @@
+package verifier
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"testing"
+	"time"
+)
+
+func TestRateLimitedVerify(t *testing.T) {
+	fake := NewFake(map[string]*FileInfo{
+		"https://a.example.com/1.wav": {},
+		"https://a.example.com/2.wav": {},
+		"https://a.example.com/3.wav": {},
+		"https://b.example.com/1.wav": {},
+	})
+	v := NewRateLimited(fake, 20)
+
+	start := time.Now()
+	for _, addr := range []string{"https://a.example.com/1.wav", "https://b.example.com/1.wav",
+		"https://a.example.com/2.wav", "https://a.example.com/3.wav"} {
+
+		_, err := v.Verify(context.Background(), addr)
+		assert.Nil(t, err, addr)
+	}
+	// 3 urls on the same host at 20 per second wait for 2 turns of 50ms
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 100*time.Millisecond, elapsed.String())
+	assert.True(t, elapsed < time.Second, elapsed.String())
+
+	// the turn is not awaited past the context
+	limited := NewRateLimited(fake, 0.5)
+	_, err := limited.Verify(context.Background(), "https://a.example.com/1.wav")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	_, err = limited.Verify(ctx, "https://a.example.com/1.wav")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
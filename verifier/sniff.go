@@ -0,0 +1,155 @@
+package verifier
+
+import (
+	"bytes"
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// SniffLength is the number of leading bytes used to detect the media type of a file
+const SniffLength = 512
+
+// signature matches the magic number of a media type at a given offset
+type signature struct {
+	offset    int
+	magic     []byte
+	mediaType string
+}
+
+var (
+	signatures = []signature{
+		{0, []byte("\x89PNG\r\n\x1a\n"), "image/png"},
+		{0, []byte("\xFF\xD8\xFF"), "image/jpeg"},
+		{0, []byte("GIF87a"), "image/gif"},
+		{0, []byte("GIF89a"), "image/gif"},
+		{0, []byte("II*\x00"), "image/tiff"},
+		{0, []byte("MM\x00*"), "image/tiff"},
+		{0, []byte("BM"), "image/bmp"},
+		{0, []byte("OggS"), "audio/ogg"},
+		{0, []byte("fLaC"), "audio/flac"},
+		{0, []byte("ID3"), "audio/mpeg"},
+		{0, []byte("FLV\x01"), "video/x-flv"},
+		{0, []byte("\x1A\x45\xDF\xA3"), "video/webm"},
+		// Advanced Systems Format, the container of wma and wmv
+		{0, []byte("\x30\x26\xB2\x75\x8E\x66\xCF\x11\xA6\xD9\x00\xAA\x00\x62\xCE\x6C"), "video/x-ms-asf"},
+		{0, []byte("%PDF-"), "application/pdf"},
+	}
+
+	// Media types accepted for each url extension.
+	// Extensions not listed are accepted with any content.
+	extensionTypes = map[string][]string{
+		".jpg":  {"image/jpeg"},
+		".jpeg": {"image/jpeg"},
+		".png":  {"image/png"},
+		".bmp":  {"image/bmp"},
+		".gif":  {"image/gif"},
+		".tif":  {"image/tiff"},
+		".tiff": {"image/tiff"},
+		".wav":  {"audio/wav"},
+		".wma":  {"video/x-ms-asf"},
+		".ogg":  {"audio/ogg"},
+		".m4a":  {"audio/mp4", "video/mp4"},
+		".mp3":  {"audio/mpeg"},
+		".flv":  {"video/x-flv"},
+		".wmv":  {"video/x-ms-asf"},
+		".mov":  {"video/quicktime", "video/mp4"},
+		".avi":  {"video/x-msvideo"},
+		".mp4":  {"video/mp4", "video/quicktime", "audio/mp4"},
+	}
+)
+
+// DetectContentType detects the media type of a file from its first bytes.
+// Returns the media type without parameters, or "application/octet-stream" if unknown.
+func DetectContentType(head []byte) string {
+	if len(head) > SniffLength {
+		head = head[:SniffLength]
+	}
+	if mediaType := detectRIFF(head); mediaType != "" {
+		return mediaType
+	}
+	if mediaType := detectISOBMFF(head); mediaType != "" {
+		return mediaType
+	}
+	for _, s := range signatures {
+		if len(head) >= s.offset+len(s.magic) && bytes.Equal(head[s.offset:s.offset+len(s.magic)], s.magic) {
+			return s.mediaType
+		}
+	}
+	if isMPEGAudioFrame(head) {
+		return "audio/mpeg"
+	}
+
+	// Catches what storages serve instead of a file, like html error pages
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mediaType
+}
+
+// CheckContentType confirms the detected media type matches the extension of the url path.
+// Returns consts.ErrContentTypeMismatch if the extension expects another media type.
+func CheckContentType(urlPath string, detected string) error {
+	expected, ok := extensionTypes[strings.ToLower(path.Ext(urlPath))]
+	if !ok {
+		return nil
+	}
+	for _, e := range expected {
+		if e == detected {
+			return nil
+		}
+	}
+	return consts.ErrContentTypeMismatch
+}
+
+// detectRIFF detects the media types using the RIFF container: wav, avi, and webp.
+func detectRIFF(head []byte) string {
+	if len(head) < 12 || !bytes.Equal(head[:4], []byte("RIFF")) {
+		return ""
+	}
+	switch string(head[8:12]) {
+	case "WAVE":
+		return "audio/wav"
+	case "AVI ":
+		return "video/x-msvideo"
+	case "WEBP":
+		return "image/webp"
+	}
+	return ""
+}
+
+// detectISOBMFF detects the media types using the ISO base media file format: mp4, m4a, and mov.
+func detectISOBMFF(head []byte) string {
+	if len(head) < 12 {
+		return ""
+	}
+	switch string(head[4:8]) {
+	case "ftyp":
+		switch string(head[8:12]) {
+		case "qt  ":
+			return "video/quicktime"
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		}
+		return "video/mp4"
+	case "moov", "mdat", "wide", "free", "skip":
+		// QuickTime files written before ftyp existed
+		return "video/quicktime"
+	}
+	return ""
+}
+
+// isMPEGAudioFrame reports whether the bytes start with an MPEG audio frame header without ID3 tag.
+func isMPEGAudioFrame(head []byte) bool {
+	if len(head) < 3 || head[0] != 0xFF || head[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := (head[1] >> 3) & 0x03
+	layer := (head[1] >> 1) & 0x03
+	bitrate := head[2] >> 4
+	sampleRate := (head[2] >> 2) & 0x03
+	return version != 0x01 && layer != 0x00 && bitrate != 0x0F && sampleRate != 0x03
+}
@@ -0,0 +1,60 @@
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+	cases := []struct {
+		head     string
+		expected string
+	}{
+		{"RIFF\x24\x08\x00\x00WAVEfmt ", "audio/wav"},
+		{"RIFF\x24\x08\x00\x00AVI LIST", "video/x-msvideo"},
+		{"OggS\x00\x02\x00\x00", "audio/ogg"},
+		{"fLaC\x00\x00\x00\x22", "audio/flac"},
+		{"ID3\x03\x00\x00\x00", "audio/mpeg"},
+		{"\xFF\xFB\x90\x64\x00", "audio/mpeg"},
+		{"\x89PNG\r\n\x1a\n\x00\x00", "image/png"},
+		{"\xFF\xD8\xFF\xE0\x00\x10JFIF", "image/jpeg"},
+		{"GIF89a\x01\x00", "image/gif"},
+		{"II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "image/tiff"},
+		{"BM\x36\x00\x0c\x00", "image/bmp"},
+		{"\x00\x00\x00\x18ftypmp42\x00\x00", "video/mp4"},
+		{"\x00\x00\x00\x20ftypM4A \x00\x00", "audio/mp4"},
+		{"\x00\x00\x00\x14ftypqt  \x00\x00", "video/quicktime"},
+		{"FLV\x01\x05\x00", "video/x-flv"},
+		{"\x30\x26\xB2\x75\x8E\x66\xCF\x11\xA6\xD9\x00\xAA\x00\x62\xCE\x6C", "video/x-ms-asf"},
+		{"<!DOCTYPE html><html>", "text/html"},
+		{"\x00\x01\x02\x03", "application/octet-stream"},
+		{"", "text/plain"},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, DetectContentType([]byte(c.head)), c.head)
+	}
+}
+
+func TestCheckContentType(t *testing.T) {
+	cases := []struct {
+		path     string
+		detected string
+		expected error
+	}{
+		{"/audios/a.wav", "audio/wav", nil},
+		{"/audios/A.WAV", "audio/wav", nil},
+		{"/audios/a.wav", "text/html", consts.ErrContentTypeMismatch},
+		{"/audios/a.mp3", "audio/wav", consts.ErrContentTypeMismatch},
+		{"/videos/a.mp4", "video/quicktime", nil},
+		{"/images/a.jpg", "image/png", consts.ErrContentTypeMismatch},
+		{"/files/a.csv", "text/plain", nil},
+		{"/files/a", "application/octet-stream", nil},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, CheckContentType(c.path, c.detected), c.path)
+	}
+}
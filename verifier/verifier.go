@@ -0,0 +1,41 @@
+// Package verifier confirms that file urls are reachable before they are attached to a Document.
+package verifier
+
+import (
+	"github.com/hwsc-org/hwsc-document-svc/consts"
+	"golang.org/x/net/context"
+	"net/url"
+)
+
+// HeadLength is the number of leading bytes fetched from a file.
+// It covers the media type signature, audio headers, and an EXIF segment, at most 64 KiB.
+const HeadLength = 64 << 10
+
+// FileInfo is what the storage advertises about a file
+type FileInfo struct {
+	ContentType string
+	Size        int64
+	// Checksum is the hex encoded MD5 of the file, if the storage reports one
+	Checksum string
+	// DetectedType is the media type detected from the first bytes of the file
+	DetectedType string
+	// Head holds the first bytes of the file, up to HeadLength
+	Head []byte
+}
+
+// Verifier confirms a file url is reachable and allowed.
+type Verifier interface {
+	// Verify returns what the storage advertises about the file,
+	// or an error if the url is malformed, not allowed, or unreachable.
+	Verify(ctx context.Context, addr string) (*FileInfo, error)
+}
+
+// parseURL parses an absolute url.
+// Returns consts.ErrUnreachableURI if the url is malformed.
+func parseURL(addr string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(addr)
+	if err != nil || u.Host == "" {
+		return nil, consts.ErrUnreachableURI
+	}
+	return u, nil
+}